@@ -0,0 +1,98 @@
+package mqttconn
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// topicURLScheme is the scheme of the canonical TopicURL form
+const topicURLScheme = "mqtt+topic"
+
+// MarshalText implements encoding.TextMarshaler, the text form is the topic name
+func (addr TopicAddr) MarshalText() ([]byte, error) {
+	if err := validateTopic(string(addr)); err != nil {
+		return nil, err
+	}
+	return []byte(addr), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (addr *TopicAddr) UnmarshalText(text []byte) error {
+	if err := validateTopic(string(text)); err != nil {
+		return err
+	}
+	*addr = TopicAddr(text)
+	return nil
+}
+
+// TopicURL is a topic on a broker together with the QoS to use
+// its canonical form is mqtt+topic://host:port/topic?qos=1, the qos parameter is left out for QoS 0
+type TopicURL struct {
+	// Host is the broker address as host or host:port
+	Host  string
+	Topic string
+	QoS   int
+}
+
+// ParseTopicURL parses the canonical form of a TopicURL
+func ParseTopicURL(s string) (*TopicURL, error) {
+	parsedURL, err := url.Parse(s)
+	if err != nil {
+		return nil, errors.Wrap(ErrAddrInvalid, err.Error())
+	}
+	if parsedURL.Scheme != topicURLScheme {
+		return nil, errors.Wrap(ErrAddrInvalid, "scheme is not "+topicURLScheme)
+	}
+	topicURL := &TopicURL{
+		Host:  parsedURL.Host,
+		Topic: strings.TrimPrefix(parsedURL.Path, "/"),
+	}
+	if err := validateTopic(topicURL.Topic); err != nil {
+		return nil, err
+	}
+	if value := parsedURL.Query().Get("qos"); value != "" {
+		if value != "0" && value != "1" && value != "2" {
+			return nil, errors.Wrap(ErrAddrInvalid, "invalid qos")
+		}
+		topicURL.QoS = int(value[0] - '0')
+	}
+	return topicURL, nil
+}
+
+// Addr returns the topic as a TopicAddr for WriteTo
+func (topicURL *TopicURL) Addr() TopicAddr {
+	return TopicAddr(topicURL.Topic)
+}
+
+// String returns the canonical form
+func (topicURL *TopicURL) String() string {
+	u := url.URL{Scheme: topicURLScheme, Host: topicURL.Host, Path: "/" + topicURL.Topic}
+	if topicURL.QoS != 0 {
+		u.RawQuery = "qos=" + strconv.Itoa(topicURL.QoS)
+	}
+	return u.String()
+}
+
+// MarshalText implements encoding.TextMarshaler with the canonical form
+func (topicURL *TopicURL) MarshalText() ([]byte, error) {
+	if err := validateTopic(topicURL.Topic); err != nil {
+		return nil, err
+	}
+	if topicURL.QoS < 0 || topicURL.QoS > 2 {
+		return nil, errors.Wrap(ErrAddrInvalid, "invalid qos")
+	}
+	return []byte(topicURL.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, see ParseTopicURL
+func (topicURL *TopicURL) UnmarshalText(text []byte) error {
+	parsed, err := ParseTopicURL(string(text))
+	if err != nil {
+		return err
+	}
+	*topicURL = *parsed
+	return nil
+}
@@ -0,0 +1,54 @@
+package mqttconn
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTopicAddrText(t *testing.T) {
+	var config struct {
+		Addr TopicAddr
+		URL  TopicURL
+	}
+	input := `{"Addr":"sensors/1","URL":"mqtt+topic://broker:1883/devices/a%20b?qos=1"}`
+	if err := json.Unmarshal([]byte(input), &config); err != nil {
+		t.Error(err)
+		return
+	}
+	if config.Addr != "sensors/1" {
+		t.Error("expected sensors/1, got", config.Addr)
+		return
+	}
+	if config.URL != (TopicURL{Host: "broker:1883", Topic: "devices/a b", QoS: 1}) {
+		t.Error("unexpected url", config.URL)
+		return
+	}
+	output, err := json.Marshal(&config)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(output) != input {
+		t.Error("expected", input, "got", string(output))
+		return
+	}
+	if err := json.Unmarshal([]byte(`{"Addr":"sensors/#"}`), &config); err == nil {
+		t.Error("expected wildcards to be rejected")
+		return
+	}
+	for _, invalid := range []string{"mqtt://broker/topic", "mqtt+topic://broker/topic?qos=3", "mqtt+topic://broker/a/+"} {
+		if _, err := ParseTopicURL(invalid); err == nil {
+			t.Error("expected", invalid, "to be rejected")
+			return
+		}
+	}
+	topicURL, err := ParseTopicURL("mqtt+topic://broker//leading")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if topicURL.Addr() != "/leading" || topicURL.String() != "mqtt+topic://broker//leading" {
+		t.Error("unexpected url", topicURL.Addr(), topicURL.String())
+		return
+	}
+}
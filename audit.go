@@ -0,0 +1,108 @@
+package mqttconn
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// AuditFormat is the record format of WithAuditSink
+type AuditFormat int
+
+// audit formats
+const (
+	// AuditJSONL writes a line of JSON per message, with the fields time, dir, topic, qos, retained, size
+	// and with AuditPayload the base64 payload
+	AuditJSONL AuditFormat = iota
+	// AuditBinary writes compact records of the direction byte 'i' or 'o', the big endian int64 unix nanoseconds,
+	// the QoS byte, the retained byte, the uvarint topic length, the topic, the uvarint size,
+	// the uvarint payload length and the payload, which is empty without AuditPayload
+	AuditBinary
+)
+
+// AuditPayload is combined with a format to include payloads in the records, like AuditJSONL|AuditPayload
+const AuditPayload AuditFormat = 1 << 8
+
+// audit directions
+const (
+	auditIn  = 'i'
+	auditOut = 'o'
+)
+
+// WithAuditSink appends a record of every message published and received to w, for compliance logs and postmortems
+// published messages are recorded as sent to the broker, after encoding and with the broker topic,
+// received messages as they arrive, before filtering. Records are written synchronously and write errors are ignored,
+// so w should be buffered and fast
+func WithAuditSink(w io.Writer, format AuditFormat) Option {
+	return func(conn *MQTTConn) {
+		conn.audit = &auditSink{
+			w:       w,
+			binary:  format&^AuditPayload == AuditBinary,
+			payload: format&AuditPayload != 0,
+		}
+	}
+}
+
+// auditSink writes audit records
+type auditSink struct {
+	mu      sync.Mutex
+	w       io.Writer
+	binary  bool
+	payload bool
+	buf     []byte
+}
+
+// auditRecord is a JSONL audit record
+type auditRecord struct {
+	Time     time.Time `json:"time"`
+	Dir      string    `json:"dir"`
+	Topic    string    `json:"topic"`
+	QoS      byte      `json:"qos"`
+	Retained bool      `json:"retained"`
+	Size     int       `json:"size"`
+	Payload  []byte    `json:"payload,omitempty"`
+}
+
+// record writes the record of a message
+func (sink *auditSink) record(now time.Time, direction byte, topic string, qos byte, retained bool, payload []byte) {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	included := payload
+	if !sink.payload {
+		included = nil
+	}
+	if !sink.binary {
+		dir := "in"
+		if direction == auditOut {
+			dir = "out"
+		}
+		line, err := json.Marshal(&auditRecord{
+			Time:     now,
+			Dir:      dir,
+			Topic:    topic,
+			QoS:      qos,
+			Retained: retained,
+			Size:     len(payload),
+			Payload:  included,
+		})
+		if err == nil {
+			sink.w.Write(append(line, '\n'))
+		}
+		return
+	}
+	buf := append(sink.buf[:0], direction)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(now.UnixNano()))
+	buf = append(buf, qos, 0)
+	if retained {
+		buf[len(buf)-1] = 1
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(topic)))
+	buf = append(buf, topic...)
+	buf = binary.AppendUvarint(buf, uint64(len(payload)))
+	buf = binary.AppendUvarint(buf, uint64(len(included)))
+	buf = append(buf, included...)
+	sink.w.Write(buf)
+	sink.buf = buf
+}
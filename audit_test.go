@@ -0,0 +1,75 @@
+package mqttconn
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestAuditSink(t *testing.T) {
+	var jsonl, compact bytes.Buffer
+	for _, audit := range []struct {
+		buf    *bytes.Buffer
+		format AuditFormat
+	}{
+		{&jsonl, AuditJSONL | AuditPayload},
+		{&compact, AuditBinary},
+	} {
+		conn, err := DialMQTT("mqtt+memory://TestAuditSink/topic?qos=1", WithAuditSink(audit.buf, audit.format))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, _, err := conn.ReadFromTimeout(make([]byte, 16), time.Second); err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	}
+
+	scanner := bufio.NewScanner(&jsonl)
+	var records []auditRecord
+	for scanner.Scan() {
+		var record auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Error(err)
+			return
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 || records[0].Dir != "out" || records[1].Dir != "in" {
+		t.Error("expected a sent and a received record, got", records)
+		return
+	}
+	for _, record := range records {
+		if record.Topic != "topic" || record.QoS != 1 || record.Size != 5 || string(record.Payload) != "hello" {
+			t.Error("unexpected record", record)
+			return
+		}
+	}
+
+	record := compact.Bytes()
+	if len(record) < 11 || record[0] != auditOut || record[9] != 1 || record[10] != 0 {
+		t.Error("unexpected binary record", record)
+		return
+	}
+	topicLen, n := binary.Uvarint(record[11:])
+	rest := record[11+n:]
+	if string(rest[:topicLen]) != "topic" {
+		t.Error("unexpected binary record topic", rest)
+		return
+	}
+	size, n := binary.Uvarint(rest[topicLen:])
+	payloadLen, _ := binary.Uvarint(rest[int(topicLen)+n:])
+	if size != 5 || payloadLen != 0 {
+		t.Error("expected size 5 without payload, got", size, payloadLen)
+		return
+	}
+}
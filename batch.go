@@ -0,0 +1,168 @@
+package mqttconn
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// ReadBatch reads up to len(msgs) messages, like ipv4.PacketConn.ReadBatch
+// it waits for the first message until the read deadline, then takes the messages already pending
+// messages are acknowledged like with ReadFrom
+func (conn *MQTTConn) ReadBatch(msgs []Message) (int, error) {
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+	first, err := conn.queue.next(context.Background(), conn.readDeadline, true)
+	if err != nil {
+		return 0, err
+	}
+	received := append([]*Message{first}, conn.queue.take(len(msgs)-1)...)
+	for i, msg := range received {
+		msg.Ack()
+		msgs[i] = Message{
+			Topic:         msg.Topic,
+			Payload:       msg.Payload,
+			QoS:           msg.QoS,
+			Retained:      msg.Retained,
+			Duplicate:     msg.Duplicate,
+			MessageID:     msg.MessageID,
+			Received:      msg.Received,
+			Sent:          msg.Sent,
+			MatchedFilter: msg.MatchedFilter,
+			Local:         msg.Local,
+			ContentType:   msg.ContentType,
+			CorrelationID: msg.CorrelationID,
+			Headers:       msg.Headers,
+		}
+	}
+	return len(received), nil
+}
+
+// WriteBatch publishes msgs with their Topic, QoS and Retained, like ipv4.PacketConn.WriteBatch
+// an empty Topic means the default topic, ContentType, CorrelationID and Headers go out with WithEnvelope or WithMQTT5. All messages are sent before waiting for the broker,
+// without retries, so a failure may leave later messages published. Every message sent is waited for,
+// also after a failure, and the number of messages confirmed before the first failure is returned.
+// Like WriteTo, the circuit breaker applies and messages are held while the conn is paused
+func (conn *MQTTConn) WriteBatch(msgs []Message) (int, error) {
+	return conn.writeBatch(msgs, conn.defaults())
+}
+
+// writeBatch implements WriteBatch with the topic of defaults for messages without a Topic
+func (conn *MQTTConn) writeBatch(msgs []Message, defaults writeDefaults) (int, error) {
+	if atomic.LoadInt32(&conn.closed) != 0 {
+		return 0, ErrClosed
+	}
+	tokens := make([]mqtt.Token, 0, len(msgs))
+	sizes := make([]int, 0, len(msgs))
+	topics := make([]string, 0, len(msgs))
+	starts := make([]time.Time, 0, len(msgs))
+	// counts are the number of payloads published for each message, complete counts the messages sent in full
+	counts := make([]int, 0, len(msgs))
+	complete := 0
+	var err error
+	for i := range msgs {
+		topic := msgs[i].Topic
+		if topic == "" {
+			var addr TopicAddr
+			if addr, err = conn.defaultAddr(defaults); err != nil {
+				break
+			}
+			topic = string(addr)
+		}
+		if err = validateTopic(topic); err != nil {
+			break
+		}
+		if err = conn.validOutgoing(topic, msgs[i].Payload); err != nil {
+			break
+		}
+		payload, metadata := msgs[i].Payload, &msgs[i]
+		if conn.sendTime && len(payload) > 0 {
+			metadata = stampSent(metadata, conn.clock.Now())
+		}
+		if conn.envelope {
+			payload, metadata = wrapEnvelope(metadata, payload), nil
+		}
+		var payloads [][]byte
+		payloads, err = conn.encodePayload(topic, payload)
+		if err != nil {
+			break
+		}
+		for _, payload := range payloads {
+			if len(payload) > conn.payloadLimit(topic) {
+				err = ErrPayloadTooLarge
+			}
+		}
+		if err != nil {
+			break
+		}
+		var held bool
+		if held, err = conn.hold(topic, payloads, msgs[i].QoS, msgs[i].Retained, metadata); err != nil {
+			break
+		} else if held {
+			payloads = nil
+		}
+		issued := 0
+		for _, payload := range payloads {
+			if conn.breaker != nil && !conn.breaker.allow(conn.clock.Now()) {
+				err = ErrCircuitOpen
+				break
+			}
+			if conn.quota != nil {
+				if err = conn.quota.take(conn.clock, len(payload), conn.writeDeadline); err != nil {
+					break
+				}
+			}
+			if conn.pacer != nil {
+				if err = conn.pacer.wait(conn.clock, len(payload), conn.writeDeadline); err != nil {
+					break
+				}
+			}
+			if conn.audit != nil {
+				conn.audit.record(conn.clock.Now(), auditOut, conn.remoteTopic(topic), msgs[i].QoS, msgs[i].Retained, payload)
+			}
+			if conn.inFlight != nil {
+				if err = conn.inFlight.acquire(conn.clock, conn.writeDeadline); err != nil {
+					break
+				}
+			}
+			token := conn.clientPublish(topic, msgs[i].QoS, msgs[i].Retained, payload, metadata)
+			if conn.inFlight != nil {
+				conn.inFlight.release(token)
+			}
+			tokens = append(tokens, token)
+			sizes = append(sizes, len(payload))
+			topics = append(topics, topic)
+			starts = append(starts, conn.clock.Now())
+			issued++
+		}
+		counts = append(counts, issued)
+		if err != nil {
+			break
+		}
+		complete++
+	}
+	deadline := conn.writeDeadline
+	written := 0
+	failed := false
+	for i, count := range counts {
+		for j := range tokens[:count] {
+			waitErr := conn.waitPublish(tokens[j], topics[j], deadline, sizes[j])
+			if conn.breaker != nil {
+				end := conn.clock.Now()
+				conn.breaker.record(waitErr, end.Sub(starts[j]), end)
+			}
+			if waitErr != nil && !failed {
+				// waited messages were sent before any message that stopped the batch, so this failure came first
+				failed, err = true, waitErr
+			}
+		}
+		tokens, topics, sizes, starts = tokens[count:], topics[count:], sizes[count:], starts[count:]
+		if !failed && i < complete {
+			written++
+		}
+	}
+	return written, err
+}
@@ -0,0 +1,124 @@
+package mqttconn
+
+import (
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/pkg/errors"
+)
+
+func TestBatch(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestBatch/a")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	conn.queue = newMessageQueue(0, conn.clock)
+	if _, err = conn.Subscribe("b", 1); err != nil {
+		t.Error(err)
+		return
+	}
+	n, err := conn.WriteBatch([]Message{
+		{Payload: []byte("1")},
+		{Topic: "b", Payload: []byte("2"), QoS: 1},
+		{Topic: "b", Payload: []byte("3")},
+		{Topic: "b/#", Payload: []byte("4")},
+	})
+	if n != 3 || err != ErrTopicInvalid {
+		t.Error("expected 3 messages and ErrTopicInvalid, got", n, err)
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	received := make([]Message, 8)
+	total := 0
+	for total < 3 {
+		n, err = conn.ReadBatch(received[total:])
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		total += n
+	}
+	for i, expected := range []string{"1", "2", "3"} {
+		if string(received[i].Payload) != expected {
+			t.Error("expected", expected, "got", string(received[i].Payload))
+			return
+		}
+	}
+	if received[0].Topic != "a" || received[1].Topic != "b" {
+		t.Error("expected topics a and b, got", received[0].Topic, received[1].Topic)
+		return
+	}
+}
+
+func TestWriteToVec(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestWriteToVec/v")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	n, err := conn.WriteToVec([][]byte{[]byte("head:"), nil, []byte("body")}, TopicAddr("v"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if n != 9 {
+		t.Error("expected 9 bytes written, got", n)
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 16)
+	n, err = conn.Read(buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(buf[:n]) != "head:body" {
+		t.Error("expected head:body, got", string(buf[:n]))
+	}
+}
+
+func TestBatchWaitsAfterFailure(t *testing.T) {
+	client := &flakyClient{failures: 3}
+	clock := NewManualClock(time.Unix(0, 0))
+	conn, _ := CreateMQTTConn(client, WithClock(clock), WithPauseBuffer(2), WithCircuitBreaker(CircuitBreakerPolicy{
+		FailureThreshold: 2,
+		OpenDuration:     time.Second,
+	}))
+	batch := []Message{
+		{Topic: "a", Payload: []byte("1")},
+		{Topic: "a", Payload: []byte("2")},
+		{Topic: "a", Payload: []byte("3")},
+	}
+	n, err := conn.WriteBatch(batch)
+	if n != 0 || !errors.Is(err, mqtt.ErrNotConnected) {
+		t.Error("expected no messages and ErrNotConnected, got", n, err)
+		return
+	}
+	if client.attempts != 3 {
+		t.Error("expected every message to be sent, got", client.attempts, "attempts")
+		return
+	}
+	// the failures were recorded, so the circuit is open
+	if n, err = conn.WriteBatch(batch); n != 0 || err != ErrCircuitOpen {
+		t.Error("expected no messages and ErrCircuitOpen, got", n, err)
+		return
+	}
+	if client.attempts != 3 {
+		t.Error("expected open circuit not to publish, got", client.attempts, "attempts")
+		return
+	}
+	clock.Advance(time.Second)
+	conn.suspend.paused = true
+	if n, err = conn.WriteBatch(batch); n != 2 || err != ErrPauseBufferFull {
+		t.Error("expected 2 held messages and ErrPauseBufferFull, got", n, err)
+		return
+	}
+	if client.attempts != 3 || len(conn.suspend.held) != 2 {
+		t.Error("expected held messages not to publish, got", client.attempts, "attempts and", len(conn.suspend.held), "held")
+		return
+	}
+}
@@ -0,0 +1,112 @@
+package mqttconn
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// blobWindowSize is the number of chunks a writer may send ahead of the reader
+	blobWindowSize = 32
+	// blobCreditSuffix is appended to the topic of a blob for the credit frames of the reader
+	blobCreditSuffix = "/credit"
+)
+
+// OpenWriter streams data to topic as sequenced chunks, OpenReader reassembles them
+// every Write is published in chunks of at most 32KiB before it returns, Close marks the end of the data
+// chunks are not retained, so the reader has to be opened before writing starts.
+// Write blocks while the writer is 32 chunks ahead of what the reader has read, until the reader grants
+// more credit on topic/credit or the write deadline passes. With several readers the fastest one sets the pace
+func (conn *MQTTConn) OpenWriter(topic string) (io.WriteCloser, error) {
+	if topic == "" {
+		return nil, ErrTopicInvalid
+	}
+	if err := validateTopic(topic); err != nil {
+		return nil, err
+	}
+	sub, err := conn.subscribeQueue(topic+blobCreditSuffix, 1)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamConn{
+		conn:       conn,
+		writeTopic: topic,
+		window:     &blobWindow{sub: sub, credit: blobWindowSize},
+	}, nil
+}
+
+// OpenReader reads data written to topic with a single OpenWriter, Read returns io.EOF once the writer is closed
+// the reader holds at most two windows of chunks, it grants the writer credit as it reads
+func (conn *MQTTConn) OpenReader(topic string) (io.ReadCloser, error) {
+	if err := validateFilter(topic); err != nil {
+		return nil, err
+	}
+	sub, err := conn.subscribeQueueCapacity(topic, 1, 2*blobWindowSize)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamConn{
+		conn:        conn,
+		readTopic:   topic,
+		sub:         sub,
+		queue:       sub.queue,
+		pending:     make(map[uint32][]byte),
+		writeClosed: true,
+		granting:    true,
+	}, nil
+}
+
+// blobWindow is the flow control state of an OpenWriter
+type blobWindow struct {
+	sub *localSubscription
+	// credit is the first sequence number the reader has not granted yet
+	credit    uint32
+	closeOnce sync.Once
+}
+
+// close stops receiving credit, a Write waiting for it returns ErrClosed
+func (window *blobWindow) close() {
+	window.closeOnce.Do(func() {
+		window.sub.close()
+	})
+}
+
+// awaitCredit waits until the reader granted the next frame, must be called with writeMu held
+func (stream *StreamConn) awaitCredit(deadline time.Time) error {
+	window := stream.window
+	if window == nil {
+		return nil
+	}
+	for int32(stream.writeSeq-window.credit) >= 0 {
+		msg, err := window.sub.queue.next(context.Background(), deadline, true)
+		if err != nil {
+			return err
+		}
+		if len(msg.Payload) != streamHeaderSize || msg.Payload[0] != streamCredit {
+			continue
+		}
+		if credit := binary.BigEndian.Uint32(msg.Payload[1:]); int32(credit-window.credit) > 0 {
+			window.credit = credit
+		}
+	}
+	return nil
+}
+
+// grant gives the writer credit for a window past the frames read once half of it is read, must be called with readMu held
+// the credit is not waited for, a lost grant is made up for by the next one
+func (stream *StreamConn) grant() {
+	if !stream.granting || stream.grantTopic == "" || stream.readSeq%(blobWindowSize/2) != 0 {
+		return
+	}
+	frame := make([]byte, streamHeaderSize)
+	frame[0] = streamCredit
+	binary.BigEndian.PutUint32(frame[1:], stream.readSeq+blobWindowSize)
+	conn := stream.conn
+	if conn.audit != nil {
+		conn.audit.record(conn.clock.Now(), auditOut, conn.remoteTopic(stream.grantTopic), 1, false, frame)
+	}
+	conn.clientPublish(stream.grantTopic, 1, false, frame, nil)
+}
@@ -0,0 +1,97 @@
+package mqttconn
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestOpenWriter(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestOpenWriter")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	reader, err := conn.OpenReader("blobs/a")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer reader.Close()
+	writer, err := conn.OpenWriter("blobs/a")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := bytes.Repeat([]byte("0123456789"), 100000)
+	go func() {
+		for i := 0; i < len(expected); i += 100000 {
+			writer.Write(expected[i : i+100000])
+		}
+		writer.Close()
+	}()
+	received, err := io.ReadAll(reader)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !bytes.Equal(received, expected) {
+		t.Error("expected", len(expected), "bytes, got", len(received))
+		return
+	}
+	if _, err = conn.OpenWriter("blobs/+"); err != ErrTopicInvalid {
+		t.Error("expected ErrTopicInvalid, got", err)
+		return
+	}
+}
+
+func TestOpenWriterFlowControl(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestOpenWriterFlowControl")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	reader, err := conn.OpenReader("blobs/a")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer reader.Close()
+	writer, err := conn.OpenWriter("blobs/a")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer writer.Close()
+	// the reader does not read, the writer stops after a window
+	writer.(net.Conn).SetWriteDeadline(time.Now().Add(200 * time.Millisecond))
+	chunk := bytes.Repeat([]byte{1}, streamChunkSize)
+	written := 0
+	for ; written <= blobWindowSize; written++ {
+		if _, err = writer.Write(chunk); err != nil {
+			break
+		}
+	}
+	var timeout net.Error
+	if !errors.As(err, &timeout) || !timeout.Timeout() || written != blobWindowSize {
+		t.Error("expected a timeout after", blobWindowSize, "chunks, got", err, "after", written)
+		return
+	}
+	// reading grants more credit
+	buf := make([]byte, streamChunkSize)
+	for i := 0; i < blobWindowSize/2; i++ {
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	writer.(net.Conn).SetWriteDeadline(time.Now().Add(time.Second))
+	if _, err := writer.Write(chunk); err != nil {
+		t.Error("expected credit after reading, got", err)
+	}
+}
@@ -0,0 +1,154 @@
+// Package boltstore keeps mqttconn outbox entries and inbox keys in a bbolt database
+package boltstore
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	mqttconn "github.com/gyf304/go-mqttconn"
+	bolt "go.etcd.io/bbolt"
+)
+
+// outboxBucket holds the outbox entries keyed by big endian ID
+var outboxBucket = []byte("outbox")
+
+// Store is a mqttconn.OutboxStore in a bbolt database, it can share the database with other buckets
+type Store struct {
+	db *bolt.DB
+}
+
+var _ mqttconn.OutboxStore = (*Store)(nil)
+
+// New creates the buckets of the store in db
+func New(db *bolt.DB) (*Store, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(outboxBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+func key(id uint64) []byte {
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], id)
+	return key[:]
+}
+
+// Append implements mqttconn.OutboxStore
+func (store *Store) Append(entry *mqttconn.OutboxEntry) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(outboxBucket)
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		stored := *entry
+		stored.ID = id
+		data, err := json.Marshal(&stored)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(key(id), data); err != nil {
+			return err
+		}
+		// only set once the transaction commits
+		entry.ID = id
+		return nil
+	})
+}
+
+// Pending implements mqttconn.OutboxStore
+func (store *Store) Pending(limit int) ([]mqttconn.OutboxEntry, error) {
+	var entries []mqttconn.OutboxEntry
+	err := store.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(outboxBucket).Cursor()
+		for k, v := cursor.First(); k != nil && len(entries) < limit; k, v = cursor.Next() {
+			var entry mqttconn.OutboxEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// Delete implements mqttconn.OutboxStore
+func (store *Store) Delete(id uint64) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(outboxBucket).Delete(key(id))
+	})
+}
+
+// inboxBucket holds the processed keys of an inbox with the big endian nanosecond time they were recorded
+var inboxBucket = []byte("inbox")
+
+// InboxStore is a mqttconn.InboxStore in a bbolt database
+type InboxStore struct {
+	db *bolt.DB
+}
+
+var _ mqttconn.InboxStore = (*InboxStore)(nil)
+
+// NewInbox creates the bucket of the inbox store in db
+func NewInbox(db *bolt.DB) (*InboxStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(inboxBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &InboxStore{db: db}, nil
+}
+
+// Processed implements mqttconn.InboxStore
+func (store *InboxStore) Processed(key string) (bool, error) {
+	var processed bool
+	err := store.db.View(func(tx *bolt.Tx) error {
+		processed = tx.Bucket(inboxBucket).Get([]byte(key)) != nil
+		return nil
+	})
+	return processed, err
+}
+
+// Record implements mqttconn.InboxStore
+func (store *InboxStore) Record(key string, t time.Time) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(inboxBucket).Put([]byte(key), timestamp(t))
+	})
+}
+
+// Prune implements mqttconn.InboxStore
+func (store *InboxStore) Prune(before time.Time) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(inboxBucket)
+		var old [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			if len(v) == 8 && int64(binary.BigEndian.Uint64(v)) < before.UnixNano() {
+				old = append(old, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range old {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func timestamp(t time.Time) []byte {
+	var value [8]byte
+	binary.BigEndian.PutUint64(value[:], uint64(t.UnixNano()))
+	return value[:]
+}
@@ -0,0 +1,92 @@
+package boltstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mqttconn "github.com/gyf304/go-mqttconn"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := os.MkdirTemp("", "boltstore")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	db, err := bolt.Open(filepath.Join(dir, "outbox.db"), 0600, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+	store, err := New(db)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, payload := range []string{"a", "b", "c"} {
+		if err := store.Append(&mqttconn.OutboxEntry{Topic: "t", Payload: []byte(payload)}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if err := store.Delete(1); err != nil {
+		t.Error(err)
+		return
+	}
+	entries, err := store.Pending(1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(entries) != 1 || entries[0].ID != 2 || string(entries[0].Payload) != "b" {
+		t.Error("unexpected pending entries", entries)
+	}
+}
+
+func TestInboxStore(t *testing.T) {
+	dir, err := os.MkdirTemp("", "boltstore")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	db, err := bolt.Open(filepath.Join(dir, "inbox.db"), 0600, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+	store, err := NewInbox(db)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	now := time.Now()
+	if err := store.Record("old", now.Add(-time.Hour)); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := store.Record("new", now); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := store.Prune(now.Add(-time.Minute)); err != nil {
+		t.Error(err)
+		return
+	}
+	for key, expected := range map[string]bool{"old": false, "new": true, "unknown": false} {
+		processed, err := store.Processed(key)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if processed != expected {
+			t.Error("expected", key, "processed to be", expected)
+		}
+	}
+}
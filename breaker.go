@@ -0,0 +1,79 @@
+package mqttconn
+
+import (
+	"sync"
+	"time"
+)
+
+// CircuitBreakerPolicy controls when publishing fails fast
+type CircuitBreakerPolicy struct {
+	// FailureThreshold is the number of consecutive failed publishes that opens the circuit
+	FailureThreshold int
+	// LatencyThreshold counts publishes slower than this as failures, zero disables it
+	LatencyThreshold time.Duration
+	// OpenDuration is how long the circuit stays open before a single probe publish is let through
+	OpenDuration time.Duration
+}
+
+// WithCircuitBreaker makes WriteTo fail fast with ErrCircuitOpen after consecutive
+// publish failures or slow publishes, protecting the application and broker from piling up
+// after OpenDuration one publish probes the broker, closing the circuit if it succeeds
+func WithCircuitBreaker(policy CircuitBreakerPolicy) Option {
+	return func(conn *MQTTConn) {
+		conn.breaker = &circuitBreaker{policy: policy}
+	}
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker is the state of a CircuitBreakerPolicy
+type circuitBreaker struct {
+	policy CircuitBreakerPolicy
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+}
+
+// allow reports if a publish may be attempted at now
+func (breaker *circuitBreaker) allow(now time.Time) bool {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	switch breaker.state {
+	case circuitOpen:
+		if now.Sub(breaker.openedAt) < breaker.policy.OpenDuration {
+			return false
+		}
+		// let one probe through
+		breaker.state = circuitHalfOpen
+		return true
+	case circuitHalfOpen:
+		return false
+	}
+	return true
+}
+
+// record updates the breaker with the outcome of a publish finished at now
+func (breaker *circuitBreaker) record(err error, latency time.Duration, now time.Time) {
+	failed := err != nil ||
+		(breaker.policy.LatencyThreshold > 0 && latency > breaker.policy.LatencyThreshold)
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	if !failed {
+		breaker.state = circuitClosed
+		breaker.failures = 0
+		return
+	}
+	breaker.failures++
+	if breaker.state == circuitHalfOpen || breaker.failures >= breaker.policy.FailureThreshold {
+		breaker.state = circuitOpen
+		breaker.openedAt = now
+	}
+}
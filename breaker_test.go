@@ -0,0 +1,43 @@
+package mqttconn
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	client := &flakyClient{failures: 3}
+	clock := NewManualClock(time.Unix(0, 0))
+	conn, _ := CreateMQTTConn(client, WithClock(clock), WithCircuitBreaker(CircuitBreakerPolicy{
+		FailureThreshold: 2,
+		OpenDuration:     time.Second,
+	}))
+	for i := 0; i < 2; i++ {
+		conn.WriteTo([]byte("test"), TopicAddr("test"))
+	}
+	_, err := conn.WriteTo([]byte("test"), TopicAddr("test"))
+	if err != ErrCircuitOpen {
+		t.Error("expected ErrCircuitOpen, got", err)
+		return
+	}
+	if client.attempts != 2 {
+		t.Error("expected open circuit not to publish, got", client.attempts, "attempts")
+		return
+	}
+	clock.Advance(time.Second)
+	// the probe fails and reopens the circuit
+	conn.WriteTo([]byte("test"), TopicAddr("test"))
+	_, err = conn.WriteTo([]byte("test"), TopicAddr("test"))
+	if err != ErrCircuitOpen {
+		t.Error("expected ErrCircuitOpen after failed probe, got", err)
+		return
+	}
+	clock.Advance(time.Second)
+	for i := 0; i < 2; i++ {
+		_, err = conn.WriteTo([]byte("test"), TopicAddr("test"))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package mqttconn
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Overflow decides what happens to messages of a subscription whose buffer is full
+type Overflow int
+
+// overflow policies, see SubscribeBuffered
+const (
+	// OverflowBlock waits for room, which holds up the delivery of every subscription
+	OverflowBlock Overflow = iota
+	// OverflowDropNewest discards the message that doesn't fit
+	OverflowDropNewest
+	// OverflowDropOldest discards the oldest waiting message of the subscription to make room
+	OverflowDropOldest
+)
+
+// subscriptionBuffer is the share of the read queue of a subscription made with SubscribeBuffered
+type subscriptionBuffer struct {
+	limit    int
+	overflow Overflow
+	dropped  int64
+}
+
+// buffers are the subscription buffers of a conn by filter
+type buffers struct {
+	mu      sync.Mutex
+	byTopic map[string]*subscriptionBuffer
+}
+
+// SubscribeBuffered subscribes like Subscribe, with room for limit messages waiting to be read
+// that doesn't count towards the buffer of other subscriptions. Once the buffer is full, overflow applies,
+// dropped messages are acknowledged and counted in Dropped
+func (conn *MQTTConn) SubscribeBuffered(topic string, qos int, limit int, overflow Overflow) (*Subscription, error) {
+	conn.buffers.mu.Lock()
+	if conn.buffers.byTopic == nil {
+		conn.buffers.byTopic = make(map[string]*subscriptionBuffer)
+	}
+	buffer := &subscriptionBuffer{limit: limit, overflow: overflow}
+	// the counter survives subscribing again
+	if previous := conn.buffers.byTopic[topic]; previous != nil {
+		buffer.dropped = atomic.LoadInt64(&previous.dropped)
+	}
+	conn.buffers.byTopic[topic] = buffer
+	conn.buffers.mu.Unlock()
+	return conn.subscribe(topic, qos, func(msg *Message) {
+		msg.buffer = buffer
+		conn.deliver(msg)
+	})
+}
+
+// Dropped returns how many messages each SubscribeBuffered subscription dropped, by filter
+func (conn *MQTTConn) Dropped() map[string]int64 {
+	conn.buffers.mu.Lock()
+	defer conn.buffers.mu.Unlock()
+	dropped := make(map[string]int64, len(conn.buffers.byTopic))
+	for topic, buffer := range conn.buffers.byTopic {
+		dropped[topic] = atomic.LoadInt64(&buffer.dropped)
+	}
+	return dropped
+}
+
+// drop discards a message that didn't fit into buffer, it is called by messageQueue.unlock
+func (buffer *subscriptionBuffer) drop(msg *Message) {
+	atomic.AddInt64(&buffer.dropped, 1)
+	msg.conn.dropped(msg, DropBufferFull)
+	msg.Ack()
+}
+
+// occupancy counts the messages waiting in buffer, nil counts those of the shared buffer
+// must be called with mu held
+func (q *messageQueue) occupancy(buffer *subscriptionBuffer) int {
+	count := 0
+	for _, msg := range q.msgs {
+		if msg.buffer == buffer {
+			count++
+		}
+	}
+	return count
+}
+
+// makeRoom applies the overflow policy of the buffer of msg if it is full, must be called with mu held
+// it returns false if msg has to wait, and true if it can be queued or was dropped, which sets dropped
+func (q *messageQueue) makeRoom(msg *Message) (ok, dropped bool) {
+	limit, overflow := q.capacity, OverflowBlock
+	if msg.buffer != nil {
+		limit, overflow = msg.buffer.limit, msg.buffer.overflow
+	}
+	if limit <= 0 || q.occupancy(msg.buffer) < limit {
+		return true, false
+	}
+	switch overflow {
+	case OverflowDropNewest:
+		q.discarded = append(q.discarded, discard{msg: msg})
+		return true, true
+	case OverflowDropOldest:
+		for i, queued := range q.msgs {
+			if queued.buffer == msg.buffer {
+				q.msgs = append(q.msgs[:i], q.msgs[i+1:]...)
+				q.discarded = append(q.discarded, discard{msg: queued})
+				break
+			}
+		}
+		return true, false
+	}
+	return false, false
+}
@@ -0,0 +1,57 @@
+package mqttconn
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSubscribeBuffered(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestSubscribeBuffered")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.SubscribeBuffered("oldest", 0, 2, OverflowDropOldest); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := conn.SubscribeBuffered("newest", 0, 1, OverflowDropNewest); err != nil {
+		t.Error(err)
+		return
+	}
+	for i := 0; i < 5; i++ {
+		conn.WriteTo([]byte("oldest"+strconv.Itoa(i)), TopicAddr("oldest"))
+	}
+	for i := 0; i < 3; i++ {
+		conn.WriteTo([]byte("newest"+strconv.Itoa(i)), TopicAddr("newest"))
+	}
+	// the buffers don't take up the room of other subscriptions
+	if _, err := conn.Subscribe("shared", 0); err != nil {
+		t.Error(err)
+		return
+	}
+	conn.WriteTo([]byte("shared0"), TopicAddr("shared"))
+	conn.WriteTo([]byte("shared1"), TopicAddr("shared"))
+	for conn.Stats().MessagesReceived < 10 {
+		time.Sleep(time.Millisecond)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 16)
+	for _, expected := range []string{"oldest3", "oldest4", "newest0", "shared0", "shared1"} {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(buf[:n]) != expected {
+			t.Error("expected", expected, "got", string(buf[:n]))
+			return
+		}
+	}
+	if dropped := conn.Dropped(); dropped["oldest"] != 3 || dropped["newest"] != 2 {
+		t.Error("unexpected drop counts", dropped)
+	}
+}
@@ -0,0 +1,191 @@
+package mqttconn
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for deadlines, timeouts and backoffs of a MQTTConn
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a stoppable single-shot timer created by a Clock
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// WithClock replaces the real time clock, mostly useful for deterministic tests
+func WithClock(clock Clock) Option {
+	return func(conn *MQTTConn) {
+		conn.clock = clock
+	}
+}
+
+// realClock is the Clock backed by package time
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (timer realTimer) C() <-chan time.Time {
+	return timer.Timer.C
+}
+
+// sleep blocks for d according to clock
+func sleep(clock Clock, d time.Duration) {
+	timer := clock.NewTimer(d)
+	<-timer.C()
+}
+
+// ManualClock is a Clock that only moves when Advance is called
+type ManualClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*manualTimer
+}
+
+// NewManualClock creates a ManualClock starting at now
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+// Now implements Clock.Now
+func (clock *ManualClock) Now() time.Time {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	return clock.now
+}
+
+// NewTimer implements Clock.NewTimer
+func (clock *ManualClock) NewTimer(d time.Duration) Timer {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	timer := &manualTimer{
+		clock:    clock,
+		c:        make(chan time.Time, 1),
+		deadline: clock.now.Add(d),
+	}
+	if d <= 0 {
+		timer.c <- clock.now
+		return timer
+	}
+	clock.timers = append(clock.timers, timer)
+	return timer
+}
+
+// Advance moves the clock forward by d, firing every timer that became due
+func (clock *ManualClock) Advance(d time.Duration) {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	clock.now = clock.now.Add(d)
+	pending := clock.timers[:0]
+	for _, timer := range clock.timers {
+		if timer.deadline.After(clock.now) {
+			pending = append(pending, timer)
+		} else {
+			timer.c <- clock.now
+		}
+	}
+	clock.timers = pending
+}
+
+// Timers returns the number of timers that have not fired or been stopped yet,
+// tests can use it to wait until the code under test is blocked on the clock
+func (clock *ManualClock) Timers() int {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	return len(clock.timers)
+}
+
+type manualTimer struct {
+	clock    *ManualClock
+	c        chan time.Time
+	deadline time.Time
+}
+
+func (timer *manualTimer) C() <-chan time.Time {
+	return timer.c
+}
+
+func (timer *manualTimer) Stop() bool {
+	timer.clock.mu.Lock()
+	defer timer.clock.mu.Unlock()
+	for i, pending := range timer.clock.timers {
+		if pending == timer {
+			timer.clock.timers = append(timer.clock.timers[:i], timer.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// wallCheckInterval bounds the timers of deadlines without a monotonic clock reading, like credential expiry,
+// timers run on the monotonic clock, which stops while the system sleeps, so these are rechecked against the wall clock
+const wallCheckInterval = time.Minute
+
+// deadlineTimer fires once deadline passes on clock, a zero deadline never passes
+// deadlines from time.Now carry a monotonic reading and are immune to wall clock changes,
+// others, like a time.Unix from the broker, are compared with the wall clock at least every wallCheckInterval
+type deadlineTimer struct {
+	clock    Clock
+	deadline time.Time
+	timer    Timer
+}
+
+func newDeadlineTimer(clock Clock, deadline time.Time) *deadlineTimer {
+	timer := &deadlineTimer{clock: clock, deadline: deadline}
+	if !deadline.IsZero() {
+		timer.arm()
+	}
+	return timer
+}
+
+// arm starts the timer for the rest of the wait
+func (timer *deadlineTimer) arm() {
+	wait := timer.deadline.Sub(timer.clock.Now())
+	if timer.deadline == timer.deadline.Round(0) && wait > wallCheckInterval {
+		wait = wallCheckInterval
+	}
+	timer.timer = timer.clock.NewTimer(wait)
+}
+
+// C returns the channel the timer fires on, nil for a zero deadline
+func (timer *deadlineTimer) C() <-chan time.Time {
+	if timer.timer == nil {
+		return nil
+	}
+	return timer.timer.C()
+}
+
+// passed reports whether the deadline passed
+func (timer *deadlineTimer) passed() bool {
+	return !timer.deadline.IsZero() && !timer.clock.Now().Before(timer.deadline)
+}
+
+// expired is called after C fired, it reports whether the deadline passed and rearms the timer if not
+func (timer *deadlineTimer) expired() bool {
+	if timer.passed() {
+		return true
+	}
+	timer.arm()
+	return false
+}
+
+// stop stops the timer
+func (timer *deadlineTimer) stop() {
+	if timer.timer != nil {
+		timer.timer.Stop()
+	}
+}
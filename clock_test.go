@@ -0,0 +1,76 @@
+package mqttconn
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestManualClockReadDeadline(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	conn := newMQTTConn([]Option{WithClock(clock)})
+	conn.SetReadDeadline(clock.Now().Add(time.Second))
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := conn.ReadFrom(make([]byte, 16))
+		errs <- err
+	}()
+	for clock.Timers() == 0 {
+		runtime.Gosched()
+	}
+	clock.Advance(999 * time.Millisecond)
+	select {
+	case err := <-errs:
+		t.Error("expected read to block until the deadline, got", err)
+		return
+	default:
+	}
+	clock.Advance(time.Millisecond)
+	err := <-errs
+	if timeoutErr, ok := err.(*TimeoutError); !ok || !timeoutErr.Timeout() {
+		t.Error("expected timeout error, got", err)
+		return
+	}
+}
+
+func TestDeadlineTimerWallClock(t *testing.T) {
+	// time.Unix has no monotonic reading, like an expiry sent by a server
+	clock := NewManualClock(time.Unix(0, 0))
+	timer := newDeadlineTimer(clock, clock.Now().Add(time.Hour))
+	defer timer.stop()
+	clock.Advance(wallCheckInterval)
+	<-timer.C()
+	if timer.expired() {
+		t.Error("expected the deadline to be an hour away")
+		return
+	}
+	// the system sleeps for two hours, the next check finds the deadline passed
+	clock.Advance(2 * time.Hour)
+	<-timer.C()
+	if !timer.expired() {
+		t.Error("expected the deadline to pass after sleeping")
+	}
+}
+
+func TestReadDeadlineAfterSleep(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	conn := newMQTTConn([]Option{WithClock(clock)})
+	conn.SetReadDeadline(clock.Now().Add(10 * time.Minute))
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := conn.ReadFrom(make([]byte, 16))
+		errs <- err
+	}()
+	for clock.Timers() == 0 {
+		runtime.Gosched()
+	}
+	clock.Advance(3 * time.Hour)
+	select {
+	case err := <-errs:
+		if timeoutErr, ok := err.(*TimeoutError); !ok || !timeoutErr.Timeout() {
+			t.Error("expected timeout error, got", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("read still blocked after its deadline")
+	}
+}
@@ -0,0 +1,217 @@
+package mqttconn
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// coalescedMagic starts a message holding several coalesced writes
+	coalescedMagic = 0xc0a1
+	// coalescedHeaderSize is the magic and the CRC-32 of the writes after it
+	coalescedHeaderSize = headerMagicSize + headerCheckSize
+)
+
+// WithCoalescing holds back writes for up to delay, or until maxBytes are pending for a topic,
+// and publishes the writes to a topic in a single message, trading latency for fewer packets.
+// WriteTo returns before the message is published, a failed publish is returned by the next WriteTo or Flush.
+// Flush publishes pending writes at once, Close flushes too. The reader has to use WithCoalescing too,
+// to split the messages into the writes again
+func WithCoalescing(delay time.Duration, maxBytes int) Option {
+	return func(conn *MQTTConn) {
+		conn.coalescer = &coalescer{
+			delay:    delay,
+			maxBytes: maxBytes,
+			pending:  make(map[string]*coalescedBatch),
+		}
+	}
+}
+
+// coalescer collects the writes of each topic into batches
+type coalescer struct {
+	delay    time.Duration
+	maxBytes int
+
+	mu      sync.Mutex
+	pending map[string]*coalescedBatch
+	// err is the first error of a delayed publish, not returned yet
+	err error
+}
+
+// coalescedBatch is the pending writes to a topic
+type coalescedBatch struct {
+	qos      byte
+	retained bool
+	frames   []byte
+	// echoes are the writes to queue with WithLocalEcho once the batch is published
+	echoes  [][]byte
+	flushed chan struct{}
+}
+
+// add appends payloads to the batch of topic, publishing it if it is full
+// a non-nil echo is queued locally once the batch with the last payload is published
+func (coalescer *coalescer) add(conn *MQTTConn, topic string, payloads [][]byte, qos byte, retained bool, echo []byte) error {
+	coalescer.mu.Lock()
+	err := coalescer.err
+	coalescer.err = nil
+	coalescer.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	limit := conn.payloadLimit(topic)
+	for i, payload := range payloads {
+		frame := append(binary.AppendUvarint(nil, uint64(len(payload))), payload...)
+		coalescer.mu.Lock()
+		batch := coalescer.pending[topic]
+		// a batch has a single QoS and retain flag, and must fit into a packet
+		if batch != nil && (batch.qos != qos || batch.retained != retained || len(batch.frames)+len(frame) > limit) {
+			coalescer.mu.Unlock()
+			if err := coalescer.flush(conn, topic, batch); err != nil {
+				return err
+			}
+			coalescer.mu.Lock()
+			batch = coalescer.pending[topic]
+		}
+		if batch == nil {
+			frames := make([]byte, coalescedHeaderSize)
+			binary.BigEndian.PutUint16(frames, coalescedMagic)
+			batch = &coalescedBatch{qos: qos, retained: retained, frames: frames, flushed: make(chan struct{})}
+			coalescer.pending[topic] = batch
+			coalescer.schedule(conn, topic, batch)
+		}
+		batch.frames = append(batch.frames, frame...)
+		if echo != nil && i == len(payloads)-1 {
+			batch.echoes = append(batch.echoes, echo)
+		}
+		full := len(batch.frames) >= coalescer.maxBytes
+		coalescer.mu.Unlock()
+		if full {
+			if err := coalescer.flush(conn, topic, batch); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// schedule publishes batch once the delay passed
+func (coalescer *coalescer) schedule(conn *MQTTConn, topic string, batch *coalescedBatch) {
+	timer := conn.clock.NewTimer(coalescer.delay)
+	conn.goLabeled(func() {
+		defer timer.Stop()
+		select {
+		case <-timer.C():
+		case <-batch.flushed:
+			return
+		}
+		if err := coalescer.flush(conn, topic, batch); err != nil {
+			coalescer.mu.Lock()
+			if coalescer.err == nil {
+				coalescer.err = err
+			}
+			coalescer.mu.Unlock()
+		}
+	})
+}
+
+// flush publishes batch unless that happened already, while the conn is paused it is held like a write
+func (coalescer *coalescer) flush(conn *MQTTConn, topic string, batch *coalescedBatch) error {
+	coalescer.mu.Lock()
+	if coalescer.pending[topic] != batch {
+		coalescer.mu.Unlock()
+		return nil
+	}
+	delete(coalescer.pending, topic)
+	close(batch.flushed)
+	coalescer.mu.Unlock()
+	binary.BigEndian.PutUint32(batch.frames[headerMagicSize:], crc32.ChecksumIEEE(batch.frames[coalescedHeaderSize:]))
+	held, err := conn.hold(topic, [][]byte{batch.frames}, batch.qos, batch.retained, nil)
+	if !held {
+		err = conn.publishWithRetry(&outgoing{
+			topic:    topic,
+			qos:      batch.qos,
+			retained: batch.retained,
+			payload:  batch.frames,
+			deadline: conn.writeDeadline,
+		})
+	}
+	if err != nil {
+		return err
+	}
+	for _, echo := range batch.echoes {
+		conn.echo(topic, echo, batch.qos, batch.retained)
+	}
+	return nil
+}
+
+// Flush publishes the writes held back by WithCoalescing, returning the first error of a publish
+// since the last WriteTo or Flush
+func (conn *MQTTConn) Flush() error {
+	coalescer := conn.coalescer
+	if coalescer == nil {
+		return nil
+	}
+	if atomic.LoadInt32(&conn.closed) != 0 {
+		return ErrClosed
+	}
+	coalescer.mu.Lock()
+	err := coalescer.err
+	coalescer.err = nil
+	batches := make(map[string]*coalescedBatch, len(coalescer.pending))
+	for topic, batch := range coalescer.pending {
+		batches[topic] = batch
+	}
+	coalescer.mu.Unlock()
+	for topic, batch := range batches {
+		if flushErr := coalescer.flush(conn, topic, batch); err == nil {
+			err = flushErr
+		}
+	}
+	return err
+}
+
+// split returns the writes coalesced into msg, sharing its acknowledgement
+// messages that weren't coalesced are returned unchanged
+func (coalescer *coalescer) split(msg *Message) []*Message {
+	if len(msg.Payload) < coalescedHeaderSize || binary.BigEndian.Uint16(msg.Payload) != coalescedMagic ||
+		binary.BigEndian.Uint32(msg.Payload[headerMagicSize:]) != crc32.ChecksumIEEE(msg.Payload[coalescedHeaderSize:]) {
+		return []*Message{msg}
+	}
+	var payloads [][]byte
+	for data := msg.Payload[coalescedHeaderSize:]; len(data) > 0; {
+		size, n := binary.Uvarint(data)
+		if n <= 0 || size > uint64(len(data)-n) {
+			// a truncated batch, keep the writes before
+			break
+		}
+		payloads = append(payloads, data[n:n+int(size)])
+		data = data[n+int(size):]
+	}
+	// the original is acknowledged once every write is
+	remaining := int32(len(payloads))
+	msgs := make([]*Message, len(payloads))
+	for i, payload := range payloads {
+		msgs[i] = &Message{
+			Topic:         msg.Topic,
+			MatchedFilter: msg.MatchedFilter,
+			Payload:       payload,
+			QoS:           msg.QoS,
+			Retained:      msg.Retained,
+			Duplicate:     msg.Duplicate,
+			MessageID:     msg.MessageID,
+			Received:      msg.Received,
+			conn:          msg.conn,
+			priority:      msg.priority,
+			buffer:        msg.buffer,
+			ack: func() {
+				if atomic.AddInt32(&remaining, -1) == 0 {
+					msg.Ack()
+				}
+			},
+		}
+	}
+	return msgs
+}
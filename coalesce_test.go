@@ -0,0 +1,141 @@
+package mqttconn
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestCoalescing(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	reader, err := DialMQTT("mqtt+memory://TestCoalescing/t", WithCoalescing(time.Second, 100))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer reader.Close()
+	reader.queue = newMessageQueue(0, reader.clock)
+	writer, err := DialMQTT("mqtt+memory://TestCoalescing", WithClock(clock), WithCoalescing(10*time.Millisecond, 100))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer writer.Close()
+	writer.SetDefaultTopic("t")
+
+	reader.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 128)
+	expect := func(expected ...string) bool {
+		for _, payload := range expected {
+			n, err := reader.Read(buf)
+			if err != nil {
+				t.Error(err)
+				return false
+			}
+			if string(buf[:n]) != payload {
+				t.Error("expected", payload, "got", string(buf[:n]))
+				return false
+			}
+		}
+		return true
+	}
+	sent := func(expected int64) bool {
+		if writer.Stats().MessagesSent != expected {
+			t.Error("expected", expected, "publishes, got", writer.Stats().MessagesSent)
+			return false
+		}
+		return true
+	}
+
+	for _, payload := range []string{"a", "b", "c"} {
+		writer.Write([]byte(payload))
+	}
+	if !sent(0) {
+		return
+	}
+	if err := writer.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+	if !sent(1) || !expect("a", "b", "c") {
+		return
+	}
+
+	// filling the batch publishes at once
+	large := string(bytes.Repeat([]byte("x"), 60))
+	writer.Write([]byte(large))
+	writer.Write([]byte(large))
+	if !sent(2) || !expect(large, large) {
+		return
+	}
+
+	// otherwise the delay does
+	writer.Write([]byte("d"))
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(10 * time.Millisecond)
+	if !expect("d") {
+		return
+	}
+	sent(3)
+}
+
+func TestCoalescingPaused(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	reader, err := DialMQTT("mqtt+memory://TestCoalescingPaused/t", WithCoalescing(time.Second, 100))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer reader.Close()
+	writer, err := DialMQTT("mqtt+memory://TestCoalescingPaused", WithClock(clock), WithCoalescing(10*time.Millisecond, 100))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer writer.Close()
+	writer.SetDefaultTopic("t")
+	if err := writer.Pause(); err != nil {
+		t.Error(err)
+		return
+	}
+	for _, payload := range []string{"a", "b"} {
+		if _, err := writer.Write([]byte(payload)); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	// the delayed flush is held instead of published while paused
+	clock.Advance(10 * time.Millisecond)
+	for {
+		writer.suspend.mu.Lock()
+		held := len(writer.suspend.held)
+		writer.suspend.mu.Unlock()
+		if held == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if writer.Stats().MessagesSent != 0 {
+		t.Error("expected no publishes while paused, got", writer.Stats().MessagesSent)
+		return
+	}
+	if err := writer.Resume(); err != nil {
+		t.Error(err)
+		return
+	}
+	reader.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 128)
+	for _, expected := range []string{"a", "b"} {
+		n, err := reader.Read(buf)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(buf[:n]) != expected {
+			t.Error("expected", expected, "got", string(buf[:n]))
+			return
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package mqttconn
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+const (
+	// headerMagicSize is the size of the magic starting a codec header
+	headerMagicSize = 2
+	// headerCheckSize is the size of the CRC-32 ending a codec header
+	headerCheckSize = 4
+)
+
+// payloadCodec transforms the payloads of messages written with WriteTo and read with ReadFrom.
+// The codec options, WithSequencing, WithSigning, WithReplayProtection, WithEncryption, WithPadding,
+// WithDeltaEncoding and WithFEC, behave alike. Both ends need the same codecs in the same order,
+// messages read are decoded in reverse. Empty payloads pass every codec unchanged, so they still clear retained messages.
+// Codecs that only frame payloads read payloads without their header unchanged.
+// Messages a codec rejects are acknowledged, reported to WithOnDrop and handed to the callback of the option
+// instead of ReadFrom, or moved to the dead-letter topic of WithDeadLetter if the callback is nil,
+// WithSchema treats invalid messages the same way
+type payloadCodec interface {
+	// encode returns the payloads to publish to topic in place of payload
+	encode(topic string, payload []byte) ([][]byte, error)
+	// decode returns the messages to read in place of msg, none drops it
+	decode(msg *Message) []*Message
+}
+
+// encodePayload applies the codecs to an outgoing payload
+func (conn *MQTTConn) encodePayload(topic string, payload []byte) ([][]byte, error) {
+	return encodeCodecs(conn.codecs, topic, [][]byte{payload})
+}
+
+// encodeCodecs applies codecs to outgoing payloads, empty payloads are sent as they are
+// so a retained Write(nil) still clears the retained message
+func encodeCodecs(codecs []payloadCodec, topic string, payloads [][]byte) ([][]byte, error) {
+	for _, codec := range codecs {
+		var encoded [][]byte
+		for _, payload := range payloads {
+			if len(payload) == 0 {
+				encoded = append(encoded, payload)
+				continue
+			}
+			more, err := codec.encode(topic, payload)
+			if err != nil {
+				return nil, err
+			}
+			encoded = append(encoded, more...)
+		}
+		payloads = encoded
+	}
+	return payloads, nil
+}
+
+// decodePayload undoes the codecs on a received message in reverse order, empty payloads are read as they are
+func (conn *MQTTConn) decodePayload(msg *Message) []*Message {
+	msgs := []*Message{msg}
+	for i := len(conn.codecs) - 1; i >= 0; i-- {
+		var decoded []*Message
+		for _, msg := range msgs {
+			if len(msg.Payload) == 0 {
+				decoded = append(decoded, msg)
+				continue
+			}
+			decoded = append(decoded, conn.codecs[i].decode(msg)...)
+		}
+		msgs = decoded
+	}
+	return msgs
+}
+
+// newHeader returns a codec header of size bytes starting with magic, with room for capacity bytes.
+// The fields go between the magic and the check, which sealHeader writes once they are set,
+// so a plain payload that happens to start with the magic is not taken for a header
+func newHeader(magic uint16, size, capacity int) []byte {
+	header := make([]byte, size, capacity)
+	binary.BigEndian.PutUint16(header, magic)
+	return header
+}
+
+// sealHeader writes the check at the end of header
+func sealHeader(header []byte) {
+	n := len(header) - headerCheckSize
+	binary.BigEndian.PutUint32(header[n:], crc32.ChecksumIEEE(header[:n]))
+}
+
+// hasHeader reports whether payload starts with a header of size bytes with magic and a valid check
+func hasHeader(payload []byte, magic uint16, size int) bool {
+	if len(payload) < size || binary.BigEndian.Uint16(payload) != magic {
+		return false
+	}
+	n := size - headerCheckSize
+	return binary.BigEndian.Uint32(payload[n:]) == crc32.ChecksumIEEE(payload[:n])
+}
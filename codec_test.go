@@ -0,0 +1,55 @@
+package mqttconn
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCodecsClearRetained(t *testing.T) {
+	keyring, _ := NewKeyring(1, bytes.Repeat([]byte{1}, 32))
+	for name, codec := range map[string]Option{
+		"sequencing": WithSequencing(nil),
+		"padding":    WithPadding(64),
+		"fec":        WithFEC(2),
+		"delta":      WithDeltaEncoding(4),
+		"signing":    WithSigning("k", map[string][]byte{"k": []byte("key")}, nil),
+		"replay":     WithReplayProtection(time.Minute, nil),
+		"encryption": WithEncryption(keyring, nil),
+	} {
+		hub := "mqtt+memory://TestCodecsClearRetained" + name
+		writer, err := DialMQTT(hub, codec)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		view := writer.WithDefaults("state", 1, true)
+		if _, err := view.Write([]byte("state")); err != nil {
+			t.Error(name, err)
+			return
+		}
+		if _, err := view.Write(nil); err != nil {
+			t.Error(name, err)
+			return
+		}
+		writer.Close()
+
+		reader, err := DialMQTT(hub)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		sub, err := reader.subscribeQueue("state", 1)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		msg, err := sub.queue.next(context.Background(), time.Now().Add(50*time.Millisecond), true)
+		reader.Close()
+		if err == nil {
+			t.Error(name, "expected no retained message, got", len(msg.Payload), "bytes")
+			return
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package mqttconn
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// Consumer reads messages from a conn and fans them out to workers, partitioned by key
+// messages with the same key are handled in order by the same worker, messages of different partitions in parallel
+type Consumer struct {
+	// Workers is the number of partitions, zero means runtime.GOMAXPROCS(0)
+	Workers int
+	// Key returns the partition key of a message, nil partitions by topic
+	Key func(msg *Message) string
+	// Buffer is the number of messages waiting for each worker, zero means 16
+	// reading blocks while the partition of the next message is full
+	Buffer int
+	// Handler handles the messages, each message is acknowledged when it returns
+	Handler func(msg *Message)
+}
+
+// Consume reads from conn and handles the messages until ctx is done or conn is closed,
+// it returns once the messages already read are handled
+func (consumer *Consumer) Consume(ctx context.Context, conn *MQTTConn) error {
+	workers := consumer.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	buffer := consumer.Buffer
+	if buffer <= 0 {
+		buffer = 16
+	}
+	partitions := make([]chan *Message, workers)
+	var wg sync.WaitGroup
+	for i := range partitions {
+		partition := make(chan *Message, buffer)
+		partitions[i] = partition
+		wg.Add(1)
+		conn.goLabeled(func() {
+			defer wg.Done()
+			for msg := range partition {
+				consumer.Handler(msg)
+				msg.Ack()
+			}
+		})
+	}
+	defer func() {
+		for _, partition := range partitions {
+			close(partition)
+		}
+		wg.Wait()
+	}()
+	for {
+		msg, err := conn.ReadMsg(ctx)
+		if err != nil {
+			return err
+		}
+		key := msg.Topic
+		if consumer.Key != nil {
+			key = consumer.Key(msg)
+		}
+		partitions[hashTopic(key)%uint32(workers)] <- msg
+	}
+}
@@ -0,0 +1,70 @@
+package mqttconn
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConsumer(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestConsumer")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Subscribe("devices/+", 0); err != nil {
+		t.Error(err)
+		return
+	}
+	var mu sync.Mutex
+	received := make(map[string][]int)
+	count := 0
+	done := make(chan struct{})
+	consumer := &Consumer{
+		Workers: 4,
+		Handler: func(msg *Message) {
+			n, _ := strconv.Atoi(string(msg.Payload))
+			mu.Lock()
+			defer mu.Unlock()
+			received[msg.Topic] = append(received[msg.Topic], n)
+			if count++; count == 100 {
+				close(done)
+			}
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	consumed := make(chan error, 1)
+	go func() {
+		consumed <- consumer.Consume(ctx, conn)
+	}()
+	for i := 0; i < 100; i++ {
+		topic := TopicAddr("devices/" + strconv.Itoa(i%5))
+		if _, err := conn.WriteTo([]byte(strconv.Itoa(i)), topic); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Error("expected 100 messages, got", count)
+		return
+	}
+	cancel()
+	if err := <-consumed; err != context.Canceled {
+		t.Error("expected context.Canceled, got", err)
+		return
+	}
+	for topic, values := range received {
+		for i := 1; i < len(values); i++ {
+			if values[i] <= values[i-1] {
+				t.Error("expected messages of", topic, "in order, got", values)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package mqttconn
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Credentials are the username and password to connect with, valid until Expiry
+// a zero Expiry means they don't expire
+type Credentials struct {
+	Username string
+	Password string
+	Expiry   time.Time
+}
+
+// CredentialsProvider supplies credentials for every connection attempt, see WithCredentialsProvider
+type CredentialsProvider interface {
+	Credentials(ctx context.Context) (Credentials, error)
+}
+
+// WithCredentialsProvider asks provider for credentials on every connect and reconnect, instead of the url
+// once the credentials expire, the conn reconnects with fresh ones. If provider fails,
+// the last credentials are used again. Only DialMQTT supports WithCredentialsProvider
+func WithCredentialsProvider(provider CredentialsProvider) Option {
+	return func(conn *MQTTConn) {
+		state := &credentialsState{
+			provider: provider,
+			renewed:  make(chan struct{}, 1),
+			done:     make(chan struct{}),
+		}
+		conn.credentials = state
+		conn.clientOptions = append(conn.clientOptions, func(opts *mqtt.ClientOptions) {
+			opts.SetCredentialsProvider(state.fetch)
+		})
+	}
+}
+
+// credentialsState tracks the credentials of a conn
+type credentialsState struct {
+	provider CredentialsProvider
+
+	mu       sync.Mutex
+	last     Credentials
+	renewed  chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// fetch implements mqtt.CredentialsProvider
+func (state *credentialsState) fetch() (username, password string) {
+	credentials, err := state.provider.Credentials(context.Background())
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if err == nil {
+		state.last = credentials
+		select {
+		case state.renewed <- struct{}{}:
+		default:
+		}
+	}
+	return state.last.Username, state.last.Password
+}
+
+// expiry returns when the current credentials expire
+func (state *credentialsState) expiry() time.Time {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return state.last.Expiry
+}
+
+// watch reconnects conn whenever its credentials expire, until stop
+func (state *credentialsState) watch(conn *MQTTConn) {
+	conn.goLabeled(func() {
+		var retry time.Time
+		for {
+			deadline := state.expiry()
+			if !retry.IsZero() {
+				deadline = retry
+			}
+			expired, stopped := state.wait(conn, deadline)
+			if stopped {
+				return
+			}
+			retry = time.Time{}
+			if expired && conn.Reconnect() != nil {
+				// the broker may be unreachable, don't spin on the expired credentials
+				retry = conn.clock.Now().Add(time.Second)
+			}
+		}
+	})
+}
+
+// wait blocks until deadline, new credentials or stop, a zero deadline never passes
+func (state *credentialsState) wait(conn *MQTTConn, deadline time.Time) (expired, stopped bool) {
+	// expiry is usually a wall clock time, a suspended system finds it passed on wakeup
+	timer := newDeadlineTimer(conn.clock, deadline)
+	defer timer.stop()
+	for {
+		select {
+		case <-timer.C():
+			if timer.expired() {
+				return true, false
+			}
+		case <-state.renewed:
+			return false, false
+		case <-state.done:
+			return false, true
+		}
+	}
+}
+
+// stop ends watching
+func (state *credentialsState) stop() {
+	state.stopOnce.Do(func() {
+		close(state.done)
+	})
+}
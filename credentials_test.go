@@ -0,0 +1,71 @@
+package mqttconn
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rotatingCredentials hands out new credentials valid for a minute on every call
+type rotatingCredentials struct {
+	clock *ManualClock
+	mu    sync.Mutex
+	calls int
+}
+
+func (provider *rotatingCredentials) Credentials(ctx context.Context) (Credentials, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	provider.calls++
+	return Credentials{
+		Username: "user" + strconv.Itoa(provider.calls),
+		Expiry:   provider.clock.Now().Add(time.Minute),
+	}, nil
+}
+
+func (provider *rotatingCredentials) count() int {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	return provider.calls
+}
+
+func TestCredentialsProvider(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	provider := &rotatingCredentials{clock: clock}
+	conn, err := DialMQTT("mqtt+memory://TestCredentialsProvider/topic", WithClock(clock), WithCredentialsProvider(provider))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	if provider.count() != 1 {
+		t.Error("expected credentials for the first connection, got", provider.count())
+		return
+	}
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Minute)
+	for deadline := time.Now().Add(time.Second); provider.count() < 2 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if provider.count() != 2 {
+		t.Error("expected a reconnect with fresh credentials, got", provider.count())
+		return
+	}
+	// the subscription survives the reconnect
+	for conn.Write([]byte("after")); ; {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 16)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(buf[:n]) == "after" {
+			break
+		}
+	}
+}
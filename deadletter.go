@@ -0,0 +1,69 @@
+package mqttconn
+
+import (
+	"encoding/json"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// DeadLetter is the payload published to the dead-letter topic, encoded as JSON
+type DeadLetter struct {
+	Topic    string    `json:"topic"`
+	Reason   string    `json:"reason"`
+	Attempts int       `json:"attempts"`
+	Time     time.Time `json:"time"`
+	Payload  []byte    `json:"payload"`
+}
+
+// WithDeadLetter republishes messages that are nacked more than maxNacks times to topic,
+// wrapped in a DeadLetter, and acknowledges the original so it can't wedge the consumer.
+// Messages rejected by WithSigning, WithReplayProtection, WithEncryption or WithSchema without a callback go there too
+func WithDeadLetter(topic string, maxNacks int) Option {
+	return func(conn *MQTTConn) {
+		conn.deadLetterTopic = topic
+		conn.deadLetterMaxNacks = maxNacks
+	}
+}
+
+// deadLetter publishes msg to the dead-letter topic with QoS 1 and waits for the broker
+func (conn *MQTTConn) deadLetter(msg *Message, reason string) error {
+	token, size, err := conn.publishDeadLetter(msg, reason)
+	if err != nil {
+		return err
+	}
+	return conn.waitPublish(token, conn.deadLetterTopic, time.Time{}, size)
+}
+
+// deadLetterAsync is deadLetter for the message handlers of the client, which must not wait for a PUBACK
+// the client can only deliver once they return. The result goes to the OnPublish hook
+func (conn *MQTTConn) deadLetterAsync(msg *Message, reason string) {
+	token, size, err := conn.publishDeadLetter(msg, reason)
+	if err != nil {
+		if hooks := conn.loadHooks(); hooks.OnPublish != nil {
+			hooks.OnPublish(conn.deadLetterTopic, 0, err)
+		}
+		return
+	}
+	conn.goLabeled(func() {
+		conn.waitPublish(token, conn.deadLetterTopic, time.Time{}, size)
+	})
+}
+
+// publishDeadLetter starts publishing msg to the dead-letter topic, returning the token and the payload size
+func (conn *MQTTConn) publishDeadLetter(msg *Message, reason string) (mqtt.Token, int, error) {
+	payload, err := json.Marshal(&DeadLetter{
+		Topic:    msg.Topic,
+		Reason:   reason,
+		Attempts: msg.nacks + 1,
+		Time:     conn.clock.Now(),
+		Payload:  msg.Payload,
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if conn.audit != nil {
+		conn.audit.record(conn.clock.Now(), auditOut, conn.remoteTopic(conn.deadLetterTopic), 1, false, payload)
+	}
+	return conn.Client.Publish(conn.remoteTopic(conn.deadLetterTopic), 1, false, payload), len(payload), nil
+}
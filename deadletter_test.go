@@ -0,0 +1,130 @@
+package mqttconn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// publishRecorder is a mqtt.Client that records publishes
+type publishRecorder struct {
+	mqtt.Client
+	topics   []string
+	payloads [][]byte
+}
+
+func (client *publishRecorder) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	client.topics = append(client.topics, topic)
+	client.payloads = append(client.payloads, payload.([]byte))
+	return &mqtt.DummyToken{}
+}
+
+func TestDeadLetter(t *testing.T) {
+	client := &publishRecorder{}
+	conn, _ := CreateMQTTConn(client, WithDeadLetter("dead", 1))
+	acked := false
+	conn.queue.push(&Message{
+		Topic:   "test",
+		Payload: []byte("poison"),
+		ack:     func() { acked = true },
+		conn:    conn,
+	})
+	for i := 0; i < 2; i++ {
+		msg, err := conn.ReadMsg(context.Background())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		msg.Nack(true)
+	}
+	if !acked {
+		t.Error("expected dead-lettered message to be acked")
+		return
+	}
+	if len(client.topics) != 1 || client.topics[0] != "dead" {
+		t.Error("expected one publish to dead, got", client.topics)
+		return
+	}
+	var deadLetter DeadLetter
+	err := json.Unmarshal(client.payloads[0], &deadLetter)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if deadLetter.Topic != "test" || deadLetter.Attempts != 2 || string(deadLetter.Payload) != "poison" {
+		t.Error("unexpected dead letter", deadLetter)
+		return
+	}
+}
+
+// pendingToken is a mqtt.Token that completes with err once done is closed
+type pendingToken struct {
+	done chan struct{}
+	err  error
+}
+
+func (token *pendingToken) Wait() bool {
+	<-token.done
+	return true
+}
+
+func (token *pendingToken) WaitTimeout(d time.Duration) bool {
+	select {
+	case <-token.done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func (token *pendingToken) Done() <-chan struct{} { return token.done }
+func (token *pendingToken) Error() error          { return token.err }
+
+// pendingPublisher is a mqtt.Client whose publishes complete once released
+type pendingPublisher struct {
+	mqtt.Client
+	token *pendingToken
+}
+
+func (client *pendingPublisher) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	return client.token
+}
+
+func TestDeadLetterFromHandler(t *testing.T) {
+	client := &pendingPublisher{token: &pendingToken{done: make(chan struct{}), err: errors.New("lost")}}
+	published := make(chan error, 1)
+	conn, _ := CreateMQTTConn(client, WithDeadLetter("dead", 1), WithSchema("#", func(payload []byte) error {
+		return errors.New("invalid")
+	}, nil), WithHooks(Hooks{
+		OnPublish: func(topic string, payloadLen int, err error) {
+			if topic == "dead" {
+				published <- err
+			}
+		},
+	}))
+	// the handler of the client can't wait for the PUBACK
+	handled := make(chan struct{})
+	go func() {
+		conn.validIncoming(&Message{Topic: "test", Payload: []byte("poison"), conn: conn})
+		close(handled)
+	}()
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Error("expected the handler not to wait for the dead letter")
+		return
+	}
+	close(client.token.done)
+	select {
+	case err := <-published:
+		if err == nil || err.Error() != "lost" {
+			t.Error("expected the publish error, got", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected the dead letter to be reported to OnPublish")
+	}
+}
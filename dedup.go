@@ -0,0 +1,81 @@
+package mqttconn
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DedupPolicy configures dropping of duplicate received messages
+type DedupPolicy struct {
+	// Window is how long a message is remembered, zero means until evicted by Size
+	Window time.Duration
+	// Size is the maximum number of messages remembered, zero means no limit
+	Size int
+	// Key returns the identity of a message, messages with the same key are delivered once
+	// nil means QoS 1 redeliveries (DUP flag set) of a message ID already seen on the same topic are dropped,
+	// message IDs are reused by brokers, so messages without the DUP flag are never dropped then
+	Key func(*Message) string
+}
+
+// WithDedup filters duplicate messages, such as QoS 1 redeliveries, before they reach ReadFrom
+func WithDedup(policy DedupPolicy) Option {
+	return func(conn *MQTTConn) {
+		conn.dedup = &dedupFilter{
+			policy: policy,
+			seen:   make(map[string]time.Time),
+		}
+	}
+}
+
+// dedupFilter is the state of a DedupPolicy
+type dedupFilter struct {
+	policy DedupPolicy
+
+	mu    sync.Mutex
+	seen  map[string]time.Time
+	order []string
+}
+
+// duplicate records msg and reports if it was seen before
+func (filter *dedupFilter) duplicate(msg *Message, now time.Time) bool {
+	var key string
+	if filter.policy.Key != nil {
+		key = filter.policy.Key(msg)
+	} else {
+		if msg.QoS == 0 {
+			return false
+		}
+		key = strconv.Itoa(int(msg.MessageID)) + "/" + msg.Topic
+	}
+	filter.mu.Lock()
+	defer filter.mu.Unlock()
+	filter.evict(now)
+	seenAt, seen := filter.seen[key]
+	if seen && (filter.policy.Window <= 0 || now.Sub(seenAt) < filter.policy.Window) {
+		if filter.policy.Key != nil || msg.Duplicate {
+			return true
+		}
+	}
+	if !seen {
+		filter.order = append(filter.order, key)
+	}
+	filter.seen[key] = now
+	filter.evict(now)
+	return false
+}
+
+// evict forgets messages outside of the window, must be called with mu held
+func (filter *dedupFilter) evict(now time.Time) {
+	expired := 0
+	for _, key := range filter.order {
+		full := filter.policy.Size > 0 && len(filter.order)-expired > filter.policy.Size
+		old := filter.policy.Window > 0 && now.Sub(filter.seen[key]) >= filter.policy.Window
+		if !full && !old {
+			break
+		}
+		delete(filter.seen, key)
+		expired++
+	}
+	filter.order = filter.order[expired:]
+}
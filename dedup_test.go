@@ -0,0 +1,43 @@
+package mqttconn
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDedup(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	conn := newMQTTConn([]Option{WithClock(clock), WithDedup(DedupPolicy{Window: time.Minute})})
+	conn.queue = newMessageQueue(0, clock)
+	conn.enqueue(&Message{Topic: "a", QoS: 1, MessageID: 1})
+	conn.enqueue(&Message{Topic: "a", QoS: 1, MessageID: 1, Duplicate: true})
+	// message IDs are reused, only redeliveries are dropped
+	conn.enqueue(&Message{Topic: "a", QoS: 1, MessageID: 1})
+	if len(conn.queue.msgs) != 2 {
+		t.Error("expected 2 messages, got", len(conn.queue.msgs))
+		return
+	}
+	clock.Advance(time.Minute)
+	conn.enqueue(&Message{Topic: "a", QoS: 1, MessageID: 1, Duplicate: true})
+	if len(conn.queue.msgs) != 3 {
+		t.Error("expected redelivery outside the window to be kept")
+		return
+	}
+
+	conn = newMQTTConn([]Option{WithDedup(DedupPolicy{
+		Size: 2,
+		Key:  func(msg *Message) string { return string(msg.Payload) },
+	})})
+	conn.queue = newMessageQueue(0, conn.clock)
+	for _, payload := range []string{"1", "2", "1", "3", "1"} {
+		conn.enqueue(&Message{Payload: []byte(payload)})
+	}
+	var received []string
+	for _, msg := range conn.queue.msgs {
+		received = append(received, string(msg.Payload))
+	}
+	if len(received) != 4 || received[3] != "1" {
+		t.Error("expected [1 2 3 1], got", received)
+		return
+	}
+}
@@ -0,0 +1,162 @@
+package mqttconn
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+)
+
+const (
+	// deltaMagic starts the delta header of a payload
+	deltaMagic = 0xde17
+	// deltaHeaderSize is the magic, the sender id, the kind, the version and the check
+	deltaHeaderSize = headerMagicSize + 4 + 1 + 4 + headerCheckSize
+)
+
+// delta payload kinds
+const (
+	deltaFull byte = iota
+	deltaDiff
+)
+
+// WithDeltaEncoding sends the difference to the previous payload written to the same topic,
+// with a full payload every snapshotEvery messages. A diff keeps the common prefix and suffix,
+// which suits state documents where few fields change between updates.
+// The reader needs WithDeltaEncoding as well and drops diffs after a lost message until the next full payload
+func WithDeltaEncoding(snapshotEvery int) Option {
+	return func(conn *MQTTConn) {
+		if snapshotEvery < 1 {
+			snapshotEvery = 1
+		}
+		codec := &deltaCodec{
+			snapshotEvery: uint32(snapshotEvery),
+			sent:          make(map[string]*deltaState),
+			received:      make(map[deltaSource]*deltaState),
+		}
+		rand.Read(codec.id[:])
+		conn.codecs = append(conn.codecs, codec)
+	}
+}
+
+// deltaSource identifies the payloads of one sender on one topic
+type deltaSource struct {
+	topic  string
+	sender [4]byte
+}
+
+// deltaState is the last payload of a topic
+type deltaState struct {
+	version uint32
+	payload []byte
+}
+
+// deltaCodec is the state of WithDeltaEncoding
+type deltaCodec struct {
+	id            [4]byte
+	snapshotEvery uint32
+
+	mu       sync.Mutex
+	sent     map[string]*deltaState
+	received map[deltaSource]*deltaState
+}
+
+// encode implements payloadCodec
+func (codec *deltaCodec) encode(topic string, payload []byte) ([][]byte, error) {
+	codec.mu.Lock()
+	defer codec.mu.Unlock()
+	previous := codec.sent[topic]
+	version := uint32(0)
+	if previous != nil {
+		version = previous.version + 1
+	}
+	header := newHeader(deltaMagic, deltaHeaderSize, deltaHeaderSize+len(payload))
+	copy(header[2:], codec.id[:])
+	binary.BigEndian.PutUint32(header[7:], version)
+	var encoded []byte
+	if previous == nil || version%codec.snapshotEvery == 0 {
+		header[6] = deltaFull
+		sealHeader(header)
+		encoded = append(header, payload...)
+	} else {
+		header[6] = deltaDiff
+		sealHeader(header)
+		encoded = appendDiff(header, previous.payload, payload)
+	}
+	codec.sent[topic] = &deltaState{version: version, payload: append([]byte(nil), payload...)}
+	return [][]byte{encoded}, nil
+}
+
+// decode implements payloadCodec
+func (codec *deltaCodec) decode(msg *Message) []*Message {
+	if !hasHeader(msg.Payload, deltaMagic, deltaHeaderSize) {
+		return []*Message{msg}
+	}
+	source := deltaSource{topic: msg.Topic}
+	copy(source.sender[:], msg.Payload[2:])
+	kind := msg.Payload[6]
+	version := binary.BigEndian.Uint32(msg.Payload[7:])
+	data := msg.Payload[deltaHeaderSize:]
+
+	codec.mu.Lock()
+	defer codec.mu.Unlock()
+	previous := codec.received[source]
+	switch kind {
+	case deltaFull:
+		msg.Payload = data
+	case deltaDiff:
+		if previous != nil && int32(version-previous.version) <= 0 {
+			// a duplicate
+			return nil
+		}
+		if previous == nil || previous.version+1 != version {
+			// the base of the diff was lost
+			delete(codec.received, source)
+			return nil
+		}
+		payload, ok := applyDiff(previous.payload, data)
+		if !ok {
+			delete(codec.received, source)
+			return nil
+		}
+		msg.Payload = payload
+	default:
+		return nil
+	}
+	codec.received[source] = &deltaState{version: version, payload: msg.Payload}
+	return []*Message{msg}
+}
+
+// appendDiff appends the diff from previous to payload to b,
+// the lengths of the common prefix and suffix followed by the bytes in between
+func appendDiff(b, previous, payload []byte) []byte {
+	prefix := 0
+	for prefix < len(previous) && prefix < len(payload) && previous[prefix] == payload[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(previous)-prefix && suffix < len(payload)-prefix &&
+		previous[len(previous)-1-suffix] == payload[len(payload)-1-suffix] {
+		suffix++
+	}
+	b = binary.AppendUvarint(b, uint64(prefix))
+	b = binary.AppendUvarint(b, uint64(suffix))
+	return append(b, payload[prefix:len(payload)-suffix]...)
+}
+
+// applyDiff rebuilds the payload from previous and a diff made by appendDiff
+func applyDiff(previous, diff []byte) ([]byte, bool) {
+	prefix, n := binary.Uvarint(diff)
+	if n <= 0 {
+		return nil, false
+	}
+	diff = diff[n:]
+	suffix, n := binary.Uvarint(diff)
+	if n <= 0 || prefix+suffix > uint64(len(previous)) {
+		return nil, false
+	}
+	diff = diff[n:]
+	payload := make([]byte, 0, int(prefix)+len(diff)+int(suffix))
+	payload = append(payload, previous[:prefix]...)
+	payload = append(payload, diff...)
+	return append(payload, previous[uint64(len(previous))-suffix:]...), true
+}
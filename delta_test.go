@@ -0,0 +1,82 @@
+package mqttconn
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestDeltaEncoding(t *testing.T) {
+	reader, err := DialMQTT("mqtt+memory://TestDeltaEncoding/state", WithDeltaEncoding(3))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer reader.Close()
+	writer, err := DialMQTT("mqtt+memory://TestDeltaEncoding", WithDeltaEncoding(3))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer writer.Close()
+	documents := []string{
+		`{"temperature":20,"unit":"C"}`,
+		`{"temperature":21,"unit":"C"}`,
+		`{"temperature":21,"unit":"C","humidity":40}`,
+		`{"temperature":22,"unit":"C","humidity":40}`,
+		`{"temperature":23,"unit":"C","humidity":40}`,
+		`{}`,
+	}
+	var sizes []int
+	for i, document := range documents {
+		payloads, err := writer.encodePayload("state", []byte(document))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		sizes = append(sizes, len(payloads[0]))
+		// lose the second document, breaking the chain until the snapshot of the fourth
+		if i != 1 {
+			writer.Client.Publish("state", 0, false, payloads[0]).Wait()
+		}
+	}
+	if sizes[1] >= len(documents[1]) {
+		t.Error("expected a diff smaller than the document, got", sizes[1])
+		return
+	}
+	reader.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 64)
+	for _, i := range []int{0, 3, 4, 5} {
+		n, err := reader.Read(buf)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(buf[:n]) != documents[i] {
+			t.Error("expected", documents[i], "got", string(buf[:n]))
+			return
+		}
+	}
+}
+
+func TestDeltaHeaderCheck(t *testing.T) {
+	reader, err := DialMQTT("mqtt+memory://TestDeltaHeaderCheck/t", WithDeltaEncoding(4))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer reader.Close()
+	// a plain payload that starts like a header
+	plain := append([]byte{0xde, 0x17}, bytes.Repeat([]byte{1}, 20)...)
+	reader.Client.Publish("t", 0, false, plain).Wait()
+	reader.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 64)
+	n, err := reader.Read(buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !bytes.Equal(buf[:n], plain) {
+		t.Error("expected the payload unchanged, got", buf[:n])
+	}
+}
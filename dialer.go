@@ -0,0 +1,123 @@
+package mqttconn
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net/url"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Dialer holds configuration shared by many conns, like net.Dialer
+// the zero value dials like DialMQTT, a Dialer may be used concurrently
+type Dialer struct {
+	// TLSConfig is the TLS configuration of mqtts:// and wss:// connections, see WithTLSConfig
+	TLSConfig *tls.Config
+	// Credentials supplies the username and password instead of the url, see WithCredentialsProvider
+	Credentials CredentialsProvider
+	// KeepAlive is the interval of keepalive pings, zero means the paho default of 30 seconds
+	KeepAlive time.Duration
+	// ReadBuffer is the number of received messages waiting for a read, zero means 2
+	ReadBuffer int
+	// Logger logs connection losses and reconnects if set
+	Logger *slog.Logger
+	// Options are applied after the fields
+	Options []Option
+}
+
+// Dial connects to the broker of uri like DialMQTT, giving up once ctx is done
+func (dialer *Dialer) Dial(ctx context.Context, uri string) (*MQTTConn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	options := dialer.options()
+	if deadline, ok := ctx.Deadline(); ok {
+		options = append(options, func(conn *MQTTConn) {
+			conn.clientOptions = append(conn.clientOptions, func(opts *mqtt.ClientOptions) {
+				opts.SetConnectTimeout(time.Until(deadline))
+			})
+		})
+	}
+	type result struct {
+		conn *MQTTConn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := DialMQTT(uri, options...)
+		done <- result{conn, err}
+	}()
+	select {
+	case dialed := <-done:
+		return dialed.conn, dialed.err
+	case <-ctx.Done():
+		go func() {
+			// the connect can't be interrupted, close the conn once it is done
+			if dialed := <-done; dialed.conn != nil {
+				dialed.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// DialTopic connects to the broker of uri, which has no topic, and subscribes to topic like DialMQTT does
+// for the topic in the url
+func (dialer *Dialer) DialTopic(ctx context.Context, uri, topic string) (*MQTTConn, error) {
+	parsedURL, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	parsedURL.Path = "/" + topic
+	parsedURL.RawPath = ""
+	return dialer.Dial(ctx, parsedURL.String())
+}
+
+// options converts the fields into options
+func (dialer *Dialer) options() []Option {
+	var options []Option
+	if dialer.TLSConfig != nil {
+		options = append(options, WithTLSConfig(dialer.TLSConfig))
+	}
+	if dialer.Credentials != nil {
+		options = append(options, WithCredentialsProvider(dialer.Credentials))
+	}
+	if dialer.KeepAlive != 0 {
+		keepAlive := dialer.KeepAlive
+		options = append(options, func(conn *MQTTConn) {
+			conn.clientOptions = append(conn.clientOptions, func(opts *mqtt.ClientOptions) {
+				opts.SetKeepAlive(keepAlive)
+			})
+		})
+	}
+	if dialer.ReadBuffer != 0 {
+		readBuffer := dialer.ReadBuffer
+		options = append(options, func(conn *MQTTConn) {
+			conn.readBuffer = readBuffer
+		})
+	}
+	if dialer.Logger != nil {
+		options = append(options, withLogger(dialer.Logger))
+	}
+	return append(options, dialer.Options...)
+}
+
+// withLogger logs connection losses and reconnects of the client to logger
+func withLogger(logger *slog.Logger) Option {
+	return func(conn *MQTTConn) {
+		conn.clientOptions = append(conn.clientOptions, func(opts *mqtt.ClientOptions) {
+			opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+				logger.Warn("mqtt connection lost", slog.String("error", err.Error()))
+			})
+			opts.SetReconnectingHandler(func(client mqtt.Client, opts *mqtt.ClientOptions) {
+				logger.Info("mqtt reconnecting")
+			})
+			opts.SetOnConnectHandler(func(client mqtt.Client) {
+				reader := client.OptionsReader()
+				logger.Info("mqtt connected", slog.String("client", reader.ClientID()))
+			})
+		})
+	}
+}
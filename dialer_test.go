@@ -0,0 +1,44 @@
+package mqttconn
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDialer(t *testing.T) {
+	dialer := &Dialer{ReadBuffer: 8, KeepAlive: time.Minute}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	conn, err := dialer.DialTopic(ctx, "mqtt+memory://TestDialer", "room 1/temperature")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	if conn.defaultTopic != "room 1/temperature" {
+		t.Error("expected the default topic room 1/temperature, got", conn.defaultTopic)
+		return
+	}
+	// more messages than the default read buffer fit without reading
+	for i := 0; i < 5; i++ {
+		if _, err := conn.Write([]byte("21")); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	for deadline := time.Now().Add(time.Second); conn.Stats().MessagesReceived < 5 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if received := conn.Stats().MessagesReceived; received != 5 {
+		t.Error("expected 5 queued messages, got", received)
+		return
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := dialer.Dial(canceled, "mqtt+memory://TestDialer"); err != context.Canceled {
+		t.Error("expected context.Canceled, got", err)
+		return
+	}
+}
@@ -0,0 +1,132 @@
+package mqttconn
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+// ServiceInstance is an announced instance of a service
+type ServiceInstance struct {
+	Name     string
+	ID       string
+	Metadata map[string]string
+}
+
+// ServiceEvent is an instance of a browsed service being announced, updated or removed
+type ServiceEvent struct {
+	Instance ServiceInstance
+	// Removed is set when the instance withdrew or its connection was lost, Metadata is nil then
+	Removed bool
+}
+
+// Discovery announces and browses services under a topic prefix, like mDNS service discovery
+// instance id of service name is the retained record prefix/name/id holding its metadata as JSON.
+// Dial with DiscoveryWill so the broker removes the record of an instance that crashed
+type Discovery struct {
+	kv *KV
+	id string
+}
+
+// DiscoveryWill returns the option removing the announcement of service name by instance id under prefix
+// when the connection is lost. A connection has a single will, so it covers one service
+func DiscoveryWill(prefix, name, id string) Option {
+	return WithWill(prefix+"/"+name+"/"+id, nil, 1, true)
+}
+
+// NewDiscovery opens discovery under prefix, announcing as instance id
+// it returns once the current records have been loaded or ctx is done
+func NewDiscovery(ctx context.Context, conn *MQTTConn, prefix, id string) (*Discovery, error) {
+	if err := validateSegment(id); err != nil {
+		return nil, err
+	}
+	kv, err := NewKV(ctx, conn, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return &Discovery{kv: kv, id: id}, nil
+}
+
+// validateSegment checks a service name or instance id, which are single topic levels
+func validateSegment(segment string) error {
+	if segment == "" || strings.ContainsAny(segment, "/+#") {
+		return ErrTopicInvalid
+	}
+	return validateTopic(segment)
+}
+
+// Announce publishes the instance of service name with metadata, announcing again updates the metadata
+func (discovery *Discovery) Announce(name string, metadata map[string]string) error {
+	if err := validateSegment(name); err != nil {
+		return err
+	}
+	if metadata == nil {
+		// an empty record would delete the announcement
+		metadata = map[string]string{}
+	}
+	record, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	return discovery.kv.Set(name+"/"+discovery.id, record)
+}
+
+// Withdraw removes the instance of service name
+func (discovery *Discovery) Withdraw(name string) error {
+	if err := validateSegment(name); err != nil {
+		return err
+	}
+	return discovery.kv.Delete(name + "/" + discovery.id)
+}
+
+// Browse returns the current instances of service name, and a channel receiving the changes after them
+// the channel is closed once ctx is done or discovery is closed
+func (discovery *Discovery) Browse(ctx context.Context, name string) ([]ServiceInstance, <-chan ServiceEvent, error) {
+	if err := validateSegment(name); err != nil {
+		return nil, nil, err
+	}
+	snapshot, changes, err := discovery.kv.watchSnapshot(ctx, name+"/+")
+	if err != nil {
+		return nil, nil, err
+	}
+	instances := make([]ServiceInstance, 0, len(snapshot))
+	for key, record := range snapshot {
+		if instance, ok := parseInstance(key, record); ok {
+			instances = append(instances, instance)
+		}
+	}
+	events := make(chan ServiceEvent, 16)
+	discovery.kv.conn.goLabeled(func() {
+		defer close(events)
+		for change := range changes {
+			instance, ok := parseInstance(change.Key, change.Value)
+			if !ok && !change.Deleted {
+				continue
+			}
+			select {
+			case events <- ServiceEvent{Instance: instance, Removed: change.Deleted}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+	return instances, events, nil
+}
+
+// parseInstance decodes the record of key name/id, ok is false for malformed records
+func parseInstance(key string, record []byte) (instance ServiceInstance, ok bool) {
+	slash := strings.IndexByte(key, '/')
+	instance.Name, instance.ID = key[:slash], key[slash+1:]
+	if len(record) == 0 {
+		return instance, false
+	}
+	if err := json.Unmarshal(record, &instance.Metadata); err != nil {
+		return instance, false
+	}
+	return instance, true
+}
+
+// Close stops discovery, announcements stay until they are withdrawn or the will removes them
+func (discovery *Discovery) Close() error {
+	return discovery.kv.Close()
+}
@@ -0,0 +1,78 @@
+package mqttconn
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDiscovery(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestDiscovery")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	printer, err := NewDiscovery(ctx, conn, "services", "printer1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer printer.Close()
+	if err := printer.Announce("ipp", map[string]string{"location": "lobby"}); err != nil {
+		t.Error(err)
+		return
+	}
+	// a client joining later sees the retained announcement
+	client, err := NewDiscovery(ctx, conn, "services", "client")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer client.Close()
+	instances, events, err := client.Browse(ctx, "ipp")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(instances) != 1 || instances[0].ID != "printer1" || instances[0].Metadata["location"] != "lobby" {
+		t.Error("expected printer1 in the lobby, got", instances)
+		return
+	}
+
+	second, err := NewDiscovery(ctx, conn, "services", "printer2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer second.Close()
+	if err := second.Announce("ipp", nil); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := printer.Withdraw("ipp"); err != nil {
+		t.Error(err)
+		return
+	}
+	for _, expected := range []ServiceEvent{
+		{Instance: ServiceInstance{Name: "ipp", ID: "printer2"}},
+		{Instance: ServiceInstance{Name: "ipp", ID: "printer1"}, Removed: true},
+	} {
+		select {
+		case event := <-events:
+			if event.Instance.ID != expected.Instance.ID || event.Removed != expected.Removed {
+				t.Error("expected", expected, "got", event)
+				return
+			}
+		case <-ctx.Done():
+			t.Error("expected", expected)
+			return
+		}
+	}
+	if err := client.Announce("ipp/x", nil); err != ErrTopicInvalid {
+		t.Error("expected ErrTopicInvalid, got", err)
+		return
+	}
+}
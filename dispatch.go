@@ -0,0 +1,65 @@
+package mqttconn
+
+import (
+	"sync"
+)
+
+// WithDispatchWorkers hands received messages from paho to workers goroutines through a buffer of buffer messages
+// paho calls its handlers one message at a time, so a slow consumer delays every subscription.
+// With workers, messages of different subscriptions are delivered in parallel and a full buffer blocks paho,
+// pushing back on the broker. Messages are no longer guaranteed to be delivered in order
+func WithDispatchWorkers(workers, buffer int) Option {
+	return func(conn *MQTTConn) {
+		if workers <= 0 {
+			conn.workers = nil
+			return
+		}
+		conn.workers = &workerPool{
+			size: workers,
+			jobs: make(chan func(), buffer),
+			done: make(chan struct{}),
+		}
+	}
+}
+
+// workerPool runs jobs on a fixed number of goroutines
+type workerPool struct {
+	size      int
+	jobs      chan func()
+	done      chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+// submit queues job, blocking while the buffer is full
+// jobs submitted after stop are dropped
+func (pool *workerPool) submit(conn *MQTTConn, job func()) {
+	pool.startOnce.Do(func() {
+		for i := 0; i < pool.size; i++ {
+			conn.goLabeled(pool.work)
+		}
+	})
+	select {
+	case pool.jobs <- job:
+	case <-pool.done:
+	}
+}
+
+// work runs jobs until stop
+func (pool *workerPool) work() {
+	for {
+		select {
+		case job := <-pool.jobs:
+			job()
+		case <-pool.done:
+			return
+		}
+	}
+}
+
+// stop ends the workers
+func (pool *workerPool) stop() {
+	pool.stopOnce.Do(func() {
+		close(pool.done)
+	})
+}
@@ -0,0 +1,41 @@
+package mqttconn
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDispatchWorkers(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestDispatchWorkers", WithDispatchWorkers(2, 0))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	slow := make(chan struct{})
+	if _, err = conn.subscribeLocal("slow", 0, func(*Message) { <-slow }); err != nil {
+		t.Error(err)
+		return
+	}
+	fast, err := conn.subscribeQueue("fast", 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.WriteTo([]byte("blocked"), TopicAddr("slow"))
+	conn.WriteTo([]byte("delivered"), TopicAddr("fast"))
+	// the slow consumer occupies one worker, the other one keeps delivering
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	msg, err := fast.queue.next(ctx, time.Time{}, true)
+	close(slow)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(msg.Payload) != "delivered" {
+		t.Error("expected delivered, got", string(msg.Payload))
+		return
+	}
+}
@@ -0,0 +1,55 @@
+package mqttconn
+
+// DropReason tells why an inbound message was dropped, see WithOnDrop
+type DropReason int
+
+// drop reasons
+const (
+	// DropBufferFull is a message that didn't fit into the buffer of SubscribeBuffered
+	DropBufferFull DropReason = iota
+	// DropExpired is a message older than the ttl of WithMessageTTL
+	DropExpired
+	// DropInvalid is a message rejected by the Validator of WithSchema
+	DropInvalid
+	// DropDuplicate is a message filtered by WithDedup
+	DropDuplicate
+	// DropRejected is a message failing the checks of WithSigning, WithReplayProtection or WithEncryption
+	DropRejected
+)
+
+func (reason DropReason) String() string {
+	switch reason {
+	case DropBufferFull:
+		return "buffer full"
+	case DropExpired:
+		return "expired"
+	case DropInvalid:
+		return "invalid"
+	case DropDuplicate:
+		return "duplicate"
+	case DropRejected:
+		return "rejected"
+	}
+	return "unknown"
+}
+
+// WithOnDrop calls onDrop whenever a received message is dropped instead of read, with its topic and payload size
+// it is called in addition to the callbacks of the individual options and must return quickly
+func WithOnDrop(onDrop func(topic string, payloadLen int, reason DropReason)) Option {
+	return func(conn *MQTTConn) {
+		conn.onDrop = onDrop
+	}
+}
+
+// dropped reports a dropped message to the WithOnDrop callback
+func (conn *MQTTConn) dropped(msg *Message, reason DropReason) {
+	if conn == nil {
+		return
+	}
+	if conn.onDrop != nil {
+		conn.onDrop(msg.Topic, len(msg.Payload), reason)
+	}
+	if hooks := conn.loadHooks(); hooks.OnDrop != nil {
+		hooks.OnDrop(msg.Topic, len(msg.Payload), reason)
+	}
+}
@@ -0,0 +1,98 @@
+package mqttconn
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOnDrop(t *testing.T) {
+	type drop struct {
+		topic  string
+		size   int
+		reason DropReason
+	}
+	drops := make(chan drop, 8)
+	conn, err := DialMQTT("mqtt+memory://TestOnDrop",
+		WithOnDrop(func(topic string, payloadLen int, reason DropReason) {
+			drops <- drop{topic, payloadLen, reason}
+		}),
+		WithSchema("valid", func(payload []byte) error {
+			if len(payload) == 0 {
+				return errors.New("empty")
+			}
+			return nil
+		}, func(msg *Message, err error) {}))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.SubscribeBuffered("full", 0, 1, OverflowDropNewest); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := conn.Subscribe("valid", 0); err != nil {
+		t.Error(err)
+		return
+	}
+	conn.WriteTo([]byte("kept"), TopicAddr("full"))
+	conn.WriteTo([]byte("dropped"), TopicAddr("full"))
+	conn.Client.Publish("valid", 0, false, []byte{}).Wait()
+
+	for _, expected := range []drop{{"full", 7, DropBufferFull}, {"valid", 0, DropInvalid}} {
+		select {
+		case got := <-drops:
+			if got != expected {
+				t.Error("expected", expected, "got", got)
+				return
+			}
+		case <-time.After(time.Second):
+			t.Error("expected", expected.reason, "drop")
+			return
+		}
+	}
+	if DropBufferFull.String() != "buffer full" {
+		t.Error("unexpected", DropBufferFull.String())
+	}
+}
+
+func TestOnDropUsesConn(t *testing.T) {
+	drops := make(chan DropReason, 4)
+	var conn *MQTTConn
+	conn, err := DialMQTT("mqtt+memory://TestOnDropUsesConn",
+		WithMessageTTL(time.Millisecond),
+		WithOnDrop(func(topic string, payloadLen int, reason DropReason) {
+			// the read queue is not locked anymore
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			conn.PeekMessage(ctx)
+			drops <- reason
+		}))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.SubscribeBuffered("full", 0, 1, OverflowDropNewest); err != nil {
+		t.Error(err)
+		return
+	}
+	conn.WriteTo([]byte("kept"), TopicAddr("full"))
+	conn.WriteTo([]byte("dropped"), TopicAddr("full"))
+	time.Sleep(10 * time.Millisecond)
+	go conn.PeekMessage(context.Background())
+	for _, expected := range []DropReason{DropBufferFull, DropExpired} {
+		select {
+		case got := <-drops:
+			if got != expected {
+				t.Error("expected", expected, "got", got)
+				return
+			}
+		case <-time.After(time.Second):
+			t.Error("expected", expected, "drop")
+			return
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package mqttconn
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// electionCounter is the key of the fencing token counter under the prefix of an Election
+const electionCounter = "fencing/counter"
+
+// Election elects a leader among candidates sharing a topic prefix
+// each candidate keeps a retained candidacy message on prefix/<id> holding its fencing token,
+// the candidate with the lowest token leads. Dial with ElectionWill so the broker withdraws
+// the candidacy of a crashed candidate, making the next one leader
+//
+// fencing tokens are taken from a counter kept as retained message on prefix/fencing/counter,
+// so every new leader has a higher token than the previous one and resources guarded by
+// the election can reject stale leaders
+type Election struct {
+	kv    *KV
+	id    string
+	token uint64
+
+	mu          sync.Mutex
+	leader      string
+	leaderToken uint64
+	observers   []func(leader string, token uint64)
+	changed     chan struct{}
+	cancel      context.CancelFunc
+}
+
+// ElectionWill returns the option withdrawing the candidacy of id under prefix when the connection is lost
+func ElectionWill(prefix, id string) Option {
+	return WithWill(prefix+"/"+id, nil, 1, true)
+}
+
+// NewElection registers id as candidate under prefix
+func NewElection(ctx context.Context, conn *MQTTConn, prefix, id string) (*Election, error) {
+	if err := validateTopic(prefix + "/" + id); err != nil {
+		return nil, err
+	}
+	kv, err := NewKV(ctx, conn, prefix)
+	if err != nil {
+		return nil, err
+	}
+	watchCtx, cancel := context.WithCancel(context.Background())
+	election := &Election{
+		kv:      kv,
+		id:      id,
+		changed: make(chan struct{}),
+		cancel:  cancel,
+	}
+	// keep an earlier candidacy, e.g. after reconnecting, so the token stays the same
+	if value, ok := kv.Get(id); ok {
+		if token, err := strconv.ParseUint(string(value), 10, 64); err == nil {
+			election.token = token
+		}
+	}
+	if election.token == 0 {
+		election.token, err = election.claimToken(ctx)
+		if err != nil {
+			election.Resign()
+			return nil, err
+		}
+	}
+	// the candidacies are only watched once the token is claimed, and drained from then on,
+	// so a slow claim can't fill the watch and stall the delivery of the counter
+	events, err := kv.Watch(watchCtx, "+")
+	if err != nil {
+		election.Resign()
+		return nil, err
+	}
+	conn.goLabeled(func() {
+		for range events {
+			election.update()
+		}
+	})
+	err = kv.Set(id, []byte(strconv.FormatUint(election.token, 10)))
+	if err != nil {
+		election.Resign()
+		return nil, err
+	}
+	election.update()
+	return election, nil
+}
+
+// claimToken takes the next value of the fencing token counter. Every candidate sees the claims
+// in the order the broker received them, the first claim of a value wins and the others try the next value
+func (election *Election) claimToken(ctx context.Context) (uint64, error) {
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events, err := election.kv.Watch(watchCtx, electionCounter)
+	if err != nil {
+		return 0, err
+	}
+	value, _ := election.kv.Get(electionCounter)
+	next, _ := parseTokenClaim(value)
+	for {
+		next++
+		claim := strconv.FormatUint(next, 10) + " " + election.id
+		if err := election.kv.Set(electionCounter, []byte(claim)); err != nil {
+			return 0, err
+		}
+		for lost := false; !lost; {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					if ctx.Err() != nil {
+						return 0, ctx.Err()
+					}
+					return 0, ErrClosed
+				}
+				token, claimer := parseTokenClaim(event.Value)
+				if token == next && claimer == election.id {
+					return next, nil
+				}
+				if token >= next {
+					next, lost = token, true
+				}
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			}
+		}
+	}
+}
+
+// parseTokenClaim splits a claim of the fencing token counter into the token and the claiming candidate
+func parseTokenClaim(claim []byte) (uint64, string) {
+	fields := strings.SplitN(string(claim), " ", 2)
+	token, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil || len(fields) < 2 {
+		return 0, ""
+	}
+	return token, fields[1]
+}
+
+// update recomputes the leader and notifies observers if it changed
+func (election *Election) update() {
+	leader, leaderToken := "", uint64(0)
+	for _, id := range election.kv.Keys() {
+		value, _ := election.kv.Get(id)
+		token, err := strconv.ParseUint(string(value), 10, 64)
+		if err != nil {
+			continue
+		}
+		if leader == "" || token < leaderToken || (token == leaderToken && id < leader) {
+			leader, leaderToken = id, token
+		}
+	}
+	election.mu.Lock()
+	if leader == election.leader && leaderToken == election.leaderToken {
+		election.mu.Unlock()
+		return
+	}
+	election.leader, election.leaderToken = leader, leaderToken
+	close(election.changed)
+	election.changed = make(chan struct{})
+	observers := append([]func(string, uint64){}, election.observers...)
+	election.mu.Unlock()
+	for _, observer := range observers {
+		observer(leader, leaderToken)
+	}
+}
+
+// Leader returns the current leader and its fencing token, leader is empty if there are no candidates
+func (election *Election) Leader() (leader string, token uint64) {
+	election.mu.Lock()
+	defer election.mu.Unlock()
+	return election.leader, election.leaderToken
+}
+
+// IsLeader reports if this candidate currently leads
+func (election *Election) IsLeader() bool {
+	leader, token := election.Leader()
+	return leader == election.id && token == election.token
+}
+
+// Token returns the fencing token of this candidate
+func (election *Election) Token() uint64 {
+	return election.token
+}
+
+// OnChange registers an observer called with the new leader whenever it changes
+// observers are called in order on the goroutine applying updates, so they should return quickly
+func (election *Election) OnChange(observer func(leader string, token uint64)) {
+	election.mu.Lock()
+	defer election.mu.Unlock()
+	election.observers = append(election.observers, observer)
+}
+
+// WaitLeader blocks until this candidate leads or ctx is done, which makes an Election usable as a lock
+func (election *Election) WaitLeader(ctx context.Context) error {
+	for {
+		election.mu.Lock()
+		leading := election.leader == election.id && election.leaderToken == election.token
+		changed := election.changed
+		election.mu.Unlock()
+		if leading {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Resign withdraws the candidacy, releasing leadership if held
+func (election *Election) Resign() error {
+	err := election.kv.Delete(election.id)
+	election.cancel()
+	election.kv.Close()
+	return err
+}
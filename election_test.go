@@ -0,0 +1,81 @@
+package mqttconn
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestElection(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestElection")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	first, err := NewElection(ctx, conn, "election", "first")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err = first.WaitLeader(ctx); err != nil {
+		t.Error(err)
+		return
+	}
+	second, err := NewElection(ctx, conn, "election", "second")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer second.Resign()
+	if second.IsLeader() {
+		t.Error("expected second candidate not to lead")
+		return
+	}
+	first.Resign()
+	if err = second.WaitLeader(ctx); err != nil {
+		t.Error(err)
+		return
+	}
+	if second.Token() <= first.Token() {
+		t.Error("expected fencing token to increase")
+		return
+	}
+}
+
+func TestElectionTokens(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestElectionTokens")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	elections := make(chan *Election, 4)
+	for _, id := range []string{"a", "b", "c", "d"} {
+		go func(id string) {
+			election, err := NewElection(ctx, conn, "election", id)
+			if err != nil {
+				t.Error(err)
+			}
+			elections <- election
+		}(id)
+	}
+	// candidates registering at once get distinct tokens
+	tokens := make(map[uint64]bool)
+	for i := 0; i < 4; i++ {
+		election := <-elections
+		if election == nil {
+			return
+		}
+		defer election.Resign()
+		if tokens[election.Token()] {
+			t.Error("expected distinct tokens, got", election.Token(), "twice")
+			return
+		}
+		tokens[election.Token()] = true
+	}
+}
@@ -0,0 +1,291 @@
+package mqttconn
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// encryptionMagic starts the header of an encrypted payload
+	encryptionMagic = 0xe5
+	// encryptionHeaderSize is the magic, the key epoch and the nonce
+	encryptionHeaderSize = 1 + 4 + 12
+	// kinds of plaintexts, the first byte after decryption
+	plaintextData        = 0
+	plaintextKeyAnnounce = 1
+)
+
+// Keyring holds the AES keys of WithEncryption by epoch, it is safe for concurrent use
+// messages are encrypted with the current epoch and decrypted with whichever epoch they name,
+// so messages in flight during a rotation can still be read
+type Keyring struct {
+	mu      sync.RWMutex
+	keys    map[uint32]keyringEntry
+	current uint32
+}
+
+// keyringEntry is a key of a Keyring
+type keyringEntry struct {
+	aead cipher.AEAD
+	raw  []byte
+}
+
+// NewKeyring returns a Keyring using key as epoch, the key is 16, 24 or 32 bytes long
+func NewKeyring(epoch uint32, key []byte) (*Keyring, error) {
+	ring := &Keyring{keys: make(map[uint32]keyringEntry)}
+	if err := ring.Add(epoch, key); err != nil {
+		return nil, err
+	}
+	ring.current = epoch
+	return ring, nil
+}
+
+// Add makes key available for decryption as epoch, for keys distributed out of band.
+// It fails with ErrKeyExists if epoch already has a different key
+func (ring *Keyring) Add(epoch uint32, key []byte) error {
+	return ring.add(epoch, key, false)
+}
+
+// Replace is Add, replacing the key epoch already has
+func (ring *Keyring) Replace(epoch uint32, key []byte) error {
+	return ring.add(epoch, key, true)
+}
+
+// add adds key as epoch
+func (ring *Keyring) add(epoch uint32, key []byte, replace bool) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	if existing, ok := ring.keys[epoch]; ok && !replace {
+		if bytes.Equal(existing.raw, key) {
+			return nil
+		}
+		return errors.Wrapf(ErrKeyExists, "epoch %d", epoch)
+	}
+	ring.keys[epoch] = keyringEntry{aead: aead, raw: append([]byte(nil), key...)}
+	return nil
+}
+
+// has reports whether epoch has exactly key
+func (ring *Keyring) has(epoch uint32, key []byte) bool {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+	existing, ok := ring.keys[epoch]
+	return ok && bytes.Equal(existing.raw, key)
+}
+
+// Use encrypts from now on with the key of epoch
+func (ring *Keyring) Use(epoch uint32) error {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	if _, ok := ring.keys[epoch]; !ok {
+		return errors.Wrapf(ErrDecryptionFailed, "unknown key epoch %d", epoch)
+	}
+	ring.current = epoch
+	return nil
+}
+
+// Remove forgets the key of epoch, messages encrypted with it can't be read anymore
+// the current epoch is not removed
+func (ring *Keyring) Remove(epoch uint32) {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	if epoch != ring.current {
+		delete(ring.keys, epoch)
+	}
+}
+
+// Epoch returns the current epoch
+func (ring *Keyring) Epoch() uint32 {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+	return ring.current
+}
+
+// key returns the key of epoch
+func (ring *Keyring) key(epoch uint32) (cipher.AEAD, bool) {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+	entry, ok := ring.keys[epoch]
+	return entry.aead, ok
+}
+
+// currentKey returns the current epoch and its key
+func (ring *Keyring) currentKey() (uint32, cipher.AEAD) {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+	return ring.current, ring.keys[ring.current].aead
+}
+
+// WithEncryption encrypts messages written with AES-GCM under the current key of keyring and decrypts messages read,
+// the topic is authenticated along with the payload, messages that can't be decrypted go to onInvalid.
+// Keys are rotated out of band with Keyring.Add and Keyring.Use, or in band with RotateKey
+func WithEncryption(keyring *Keyring, onInvalid func(msg *Message, err error)) Option {
+	return func(conn *MQTTConn) {
+		conn.codecs = append(conn.codecs, &encrypter{
+			conn:      conn,
+			keyring:   keyring,
+			onInvalid: onInvalid,
+		})
+	}
+}
+
+// encrypter encrypts outgoing and decrypts incoming payloads
+type encrypter struct {
+	conn      *MQTTConn
+	keyring   *Keyring
+	onInvalid func(*Message, error)
+}
+
+// seal encrypts a plaintext of kind for topic under the current key
+func (encrypter *encrypter) seal(topic string, kind byte, payload []byte) ([]byte, error) {
+	epoch, aead := encrypter.keyring.currentKey()
+	sealed := make([]byte, encryptionHeaderSize, encryptionHeaderSize+1+len(payload)+aead.Overhead())
+	sealed[0] = encryptionMagic
+	binary.BigEndian.PutUint32(sealed[1:], epoch)
+	nonce := sealed[5:encryptionHeaderSize]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	plaintext := append([]byte{kind}, payload...)
+	return aead.Seal(sealed, nonce, plaintext, []byte(topic)), nil
+}
+
+// encode implements payloadCodec
+func (encrypter *encrypter) encode(topic string, payload []byte) ([][]byte, error) {
+	sealed, err := encrypter.seal(topic, plaintextData, payload)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{sealed}, nil
+}
+
+// decode implements payloadCodec
+func (encrypter *encrypter) decode(msg *Message) []*Message {
+	epoch, kind, plaintext, err := encrypter.open(msg)
+	if err == nil && kind == plaintextKeyAnnounce {
+		err = encrypter.announced(epoch, plaintext)
+		if err == nil {
+			return nil
+		}
+	}
+	if err != nil {
+		encrypter.conn.dropped(msg, DropRejected)
+		if encrypter.onInvalid != nil {
+			encrypter.onInvalid(msg, err)
+		} else if encrypter.conn.deadLetterTopic != "" {
+			encrypter.conn.deadLetterAsync(msg, err.Error())
+		}
+		return nil
+	}
+	msg.Payload = plaintext
+	return []*Message{msg}
+}
+
+// open decrypts msg, returning the epoch it was encrypted under
+func (encrypter *encrypter) open(msg *Message) (uint32, byte, []byte, error) {
+	if len(msg.Payload) < encryptionHeaderSize || msg.Payload[0] != encryptionMagic {
+		return 0, 0, nil, errors.Wrap(ErrDecryptionFailed, "not encrypted")
+	}
+	epoch := binary.BigEndian.Uint32(msg.Payload[1:])
+	aead, ok := encrypter.keyring.key(epoch)
+	if !ok {
+		return 0, 0, nil, errors.Wrapf(ErrDecryptionFailed, "unknown key epoch %d", epoch)
+	}
+	plaintext, err := aead.Open(nil, msg.Payload[5:encryptionHeaderSize], msg.Payload[encryptionHeaderSize:], []byte(msg.Topic))
+	if err != nil || len(plaintext) == 0 {
+		return 0, 0, nil, ErrDecryptionFailed
+	}
+	return epoch, plaintext[0], plaintext[1:], nil
+}
+
+// announced switches to a key announced by a peer under sealedEpoch. Only the epoch after the current one
+// announced under the current key is accepted, so a replayed announcement can't roll the keyring back
+// or replace a key, redeliveries of an accepted announcement are ignored
+func (encrypter *encrypter) announced(sealedEpoch uint32, announcement []byte) error {
+	if len(announcement) < 4 {
+		return errors.Wrap(ErrDecryptionFailed, "invalid key announcement")
+	}
+	epoch, key := binary.BigEndian.Uint32(announcement), announcement[4:]
+	if encrypter.keyring.has(epoch, key) {
+		return nil
+	}
+	current := encrypter.keyring.Epoch()
+	if sealedEpoch != current || epoch != current+1 {
+		return errors.Wrapf(ErrDecryptionFailed, "unexpected key announcement of epoch %d under epoch %d", epoch, sealedEpoch)
+	}
+	if err := encrypter.keyring.Add(epoch, key); err != nil {
+		return errors.Wrap(ErrDecryptionFailed, "invalid key announcement: "+err.Error())
+	}
+	return encrypter.keyring.Use(epoch)
+}
+
+// RotateKey generates the key of the next epoch, announces it to the readers of topic encrypted under
+// the current key and then encrypts with it. Readers with WithEncryption switch to the announced key,
+// older keys stay in the keyring until removed with Keyring.Remove.
+// A leaked key exposes the keys announced under it, rotate out of band to recover from that
+func (conn *MQTTConn) RotateKey(topic string) (uint32, error) {
+	index := -1
+	for i, codec := range conn.codecs {
+		if _, ok := codec.(*encrypter); ok {
+			index = i
+		}
+	}
+	if index < 0 {
+		return 0, errors.New("RotateKey needs WithEncryption")
+	}
+	if atomic.LoadInt32(&conn.closed) != 0 {
+		return 0, ErrClosed
+	}
+	if err := validateTopic(topic); err != nil {
+		return 0, err
+	}
+	encrypter := conn.codecs[index].(*encrypter)
+	epoch := encrypter.keyring.Epoch() + 1
+	announcement := make([]byte, 4+32)
+	binary.BigEndian.PutUint32(announcement, epoch)
+	key := announcement[4:]
+	if _, err := rand.Read(key); err != nil {
+		return 0, err
+	}
+	// the key is added before publishing so this conn ignores its own announcement,
+	// replacing the key of an earlier rotation that failed to publish
+	if err := encrypter.keyring.Replace(epoch, key); err != nil {
+		return 0, err
+	}
+	sealed, err := encrypter.seal(topic, plaintextKeyAnnounce, announcement)
+	if err != nil {
+		return 0, err
+	}
+	// the codecs after the encryption still apply, like signing
+	payloads, err := encodeCodecs(conn.codecs[index+1:], topic, [][]byte{sealed})
+	if err != nil {
+		return 0, err
+	}
+	for _, payload := range payloads {
+		err := conn.publishWithRetry(&outgoing{
+			topic:    topic,
+			qos:      byte(conn.defaults().qos),
+			payload:  payload,
+			deadline: conn.writeDeadline,
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return epoch, encrypter.keyring.Use(epoch)
+}
@@ -0,0 +1,126 @@
+package mqttconn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEncryption(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	invalid := make(chan error, 4)
+	readerKeys, _ := NewKeyring(1, key)
+	reader, err := DialMQTT("mqtt+memory://TestEncryption/t", WithEncryption(readerKeys, func(msg *Message, err error) {
+		invalid <- err
+	}))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer reader.Close()
+	writerKeys, _ := NewKeyring(1, key)
+	writer, err := DialMQTT("mqtt+memory://TestEncryption", WithEncryption(writerKeys, nil))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer writer.Close()
+	writer.SetDefaultTopic("t")
+
+	reader.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 16)
+	expect := func(expected string) bool {
+		n, err := reader.Read(buf)
+		if err != nil {
+			t.Error(err)
+			return false
+		}
+		if string(buf[:n]) != expected {
+			t.Error("expected", expected, "got", string(buf[:n]))
+			return false
+		}
+		return true
+	}
+
+	sealed, _ := writer.encodePayload("t", []byte("secret"))
+	if bytes.Contains(sealed[0], []byte("secret")) {
+		t.Error("expected an encrypted payload")
+		return
+	}
+	writer.Client.Publish("t", 0, false, []byte("plain")).Wait()
+	writer.Write([]byte("before"))
+	if !expect("before") {
+		return
+	}
+	if err := <-invalid; !errors.Is(err, ErrDecryptionFailed) {
+		t.Error("expected ErrDecryptionFailed, got", err)
+		return
+	}
+
+	epoch, err := writer.RotateKey("t")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	writer.Write([]byte("after"))
+	if !expect("after") {
+		return
+	}
+	if epoch != 2 || readerKeys.Epoch() != 2 {
+		t.Error("expected both ends at epoch 2, got", epoch, readerKeys.Epoch())
+		return
+	}
+	// messages of the old epoch are still readable until it is removed
+	writer.Client.Publish("t", 0, false, sealed[0]).Wait()
+	if !expect("secret") {
+		return
+	}
+	readerKeys.Remove(1)
+	writer.Client.Publish("t", 0, false, sealed[0]).Wait()
+	if err := <-invalid; !errors.Is(err, ErrDecryptionFailed) {
+		t.Error("expected ErrDecryptionFailed, got", err)
+	}
+}
+
+func TestKeyAnnouncement(t *testing.T) {
+	ring, _ := NewKeyring(1, bytes.Repeat([]byte{1}, 32))
+	encrypter := &encrypter{keyring: ring}
+	announcement := func(epoch uint32, key byte) []byte {
+		announcement := make([]byte, 4+32)
+		binary.BigEndian.PutUint32(announcement, epoch)
+		copy(announcement[4:], bytes.Repeat([]byte{key}, 32))
+		return announcement
+	}
+	if err := encrypter.announced(1, announcement(3, 3)); !errors.Is(err, ErrDecryptionFailed) {
+		t.Error("expected skipping an epoch to fail, got", err)
+		return
+	}
+	if err := encrypter.announced(1, announcement(2, 2)); err != nil || ring.Epoch() != 2 {
+		t.Error("expected epoch 2, got", ring.Epoch(), err)
+		return
+	}
+	// redelivered
+	if err := encrypter.announced(1, announcement(2, 2)); err != nil {
+		t.Error(err)
+		return
+	}
+	// replayed under an old key, or replacing the current key
+	if err := encrypter.announced(1, announcement(3, 3)); !errors.Is(err, ErrDecryptionFailed) || ring.Epoch() != 2 {
+		t.Error("expected an announcement under an old key to fail, got", err)
+		return
+	}
+	if err := encrypter.announced(2, announcement(2, 9)); !errors.Is(err, ErrDecryptionFailed) {
+		t.Error("expected replacing a key to fail, got", err)
+		return
+	}
+
+	if err := ring.Add(2, bytes.Repeat([]byte{9}, 32)); !errors.Is(err, ErrKeyExists) {
+		t.Error("expected ErrKeyExists, got", err)
+		return
+	}
+	if err := ring.Replace(2, bytes.Repeat([]byte{9}, 32)); err != nil || !ring.has(2, bytes.Repeat([]byte{9}, 32)) {
+		t.Error("expected the key to be replaced", err)
+	}
+}
@@ -0,0 +1,110 @@
+package mqttconn
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"sort"
+)
+
+// envelopeMagic starts a payload wrapped by WithEnvelope
+const envelopeMagic = 0xe75a
+
+// headers carrying Message.ContentType and Message.CorrelationID in the envelope
+const (
+	headerContentType   = "content-type"
+	headerCorrelationID = "correlation-id"
+)
+
+// WithEnvelope wraps payloads in an envelope carrying Message.ContentType, CorrelationID and Headers,
+// for MQTT 3.1.1, which has no properties for them. Set the fields for WriteBatch,
+// WriteTo sends those of SetDefaultContentType and SetDefaultHeaders.
+// With WithMQTT5 they travel as MQTT 5 properties unless WithEnvelope is used too,
+// which suits readers still on 3.1.1 and keeps them under WithSigning and WithEncryption.
+// The envelope is the bytes 0xe7 0x5a, the uvarint number of headers, every header as uvarint length prefixed
+// name and value, the big endian CRC-32 of the envelope so far, and the payload.
+// It is applied before the codecs, so WithSigning and WithEncryption cover it.
+// Empty payloads are sent as they are so they still clear retained messages,
+// received payloads without an envelope are read as they are
+func WithEnvelope() Option {
+	return func(conn *MQTTConn) {
+		conn.envelope = true
+	}
+}
+
+// wrapEnvelope wraps payload with the metadata of msg, msg may be nil
+func wrapEnvelope(msg *Message, payload []byte) []byte {
+	headers := make(map[string]string)
+	if msg != nil {
+		for name, value := range msg.Headers {
+			headers[name] = value
+		}
+		if msg.ContentType != "" {
+			headers[headerContentType] = msg.ContentType
+		}
+		if msg.CorrelationID != "" {
+			headers[headerCorrelationID] = msg.CorrelationID
+		}
+	}
+	if len(payload) == 0 && len(headers) == 0 {
+		return payload
+	}
+	names := make([]string, 0, len(headers))
+	size := headerMagicSize + binary.MaxVarintLen64 + headerCheckSize + len(payload)
+	for name, value := range headers {
+		names = append(names, name)
+		size += 2*binary.MaxVarintLen64 + len(name) + len(value)
+	}
+	sort.Strings(names)
+	wrapped := make([]byte, headerMagicSize, size)
+	binary.BigEndian.PutUint16(wrapped, envelopeMagic)
+	wrapped = binary.AppendUvarint(wrapped, uint64(len(names)))
+	for _, name := range names {
+		wrapped = binary.AppendUvarint(wrapped, uint64(len(name)))
+		wrapped = append(wrapped, name...)
+		wrapped = binary.AppendUvarint(wrapped, uint64(len(headers[name])))
+		wrapped = append(wrapped, headers[name]...)
+	}
+	wrapped = binary.BigEndian.AppendUint32(wrapped, crc32.ChecksumIEEE(wrapped))
+	return append(wrapped, payload...)
+}
+
+// unwrapEnvelope moves the metadata of a received envelope into the fields of msg
+// malformed envelopes are left as they are
+func unwrapEnvelope(msg *Message) {
+	payload := msg.Payload
+	if len(payload) < headerMagicSize || binary.BigEndian.Uint16(payload) != envelopeMagic {
+		return
+	}
+	payload = payload[headerMagicSize:]
+	count, n := binary.Uvarint(payload)
+	if n <= 0 || count > uint64(len(payload)) {
+		return
+	}
+	payload = payload[n:]
+	headers := make(map[string]string, count)
+	for i := uint64(0); i < count; i++ {
+		var fields [2]string
+		for j := range fields {
+			length, n := binary.Uvarint(payload)
+			if n <= 0 || length > uint64(len(payload)-n) {
+				return
+			}
+			fields[j] = string(payload[n : n+int(length)])
+			payload = payload[n+int(length):]
+		}
+		headers[fields[0]] = fields[1]
+	}
+	end := len(msg.Payload) - len(payload)
+	if len(payload) < headerCheckSize || binary.BigEndian.Uint32(payload) != crc32.ChecksumIEEE(msg.Payload[:end]) {
+		return
+	}
+	payload = payload[headerCheckSize:]
+	msg.ContentType = headers[headerContentType]
+	msg.CorrelationID = headers[headerCorrelationID]
+	delete(headers, headerContentType)
+	delete(headers, headerCorrelationID)
+	if len(headers) > 0 {
+		msg.Headers = headers
+	}
+	msg.Payload = payload
+}
@@ -0,0 +1,63 @@
+package mqttconn
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestEnvelope(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestEnvelope/topic", WithEnvelope())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	_, err = conn.WriteBatch([]Message{{
+		Payload:       []byte(`{"temperature":21}`),
+		ContentType:   "application/json",
+		CorrelationID: "42",
+		Headers:       map[string]string{"trace": "abc"},
+	}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := conn.Write([]byte("plain")); err != nil {
+		t.Error(err)
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	msgs := make([]Message, 2)
+	for read := 0; read < 2; {
+		n, err := conn.ReadBatch(msgs[read:])
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		read += n
+	}
+	if string(msgs[0].Payload) != `{"temperature":21}` || msgs[0].ContentType != "application/json" ||
+		msgs[0].CorrelationID != "42" || msgs[0].Headers["trace"] != "abc" || len(msgs[0].Headers) != 1 {
+		t.Error("unexpected message", string(msgs[0].Payload), msgs[0].ContentType, msgs[0].CorrelationID, msgs[0].Headers)
+		return
+	}
+	if string(msgs[1].Payload) != "plain" || msgs[1].ContentType != "" || msgs[1].Headers != nil {
+		t.Error("unexpected message", string(msgs[1].Payload), msgs[1].ContentType, msgs[1].Headers)
+		return
+	}
+
+	// payloads that aren't envelopes, and malformed ones, are read as they are
+	for _, payload := range [][]byte{[]byte("raw"), {0xe7, 0x5a, 5, 1}, {0xe7, 0x5a, 0, 0, 0, 0, 0, 'x'}} {
+		msg := &Message{Payload: payload}
+		unwrapEnvelope(msg)
+		if !bytes.Equal(msg.Payload, payload) {
+			t.Error("expected", payload, "to be left alone, got", msg.Payload)
+			return
+		}
+	}
+	if wrapped := wrapEnvelope(nil, nil); len(wrapped) != 0 {
+		t.Error("expected empty payloads to stay empty, got", wrapped)
+		return
+	}
+}
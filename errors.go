@@ -0,0 +1,107 @@
+package mqttconn
+
+import (
+	"net"
+	"os"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Error is the type of the errors exported by this package, it implements net.Error
+type Error struct {
+	msg       string
+	temporary bool
+	cause     error
+}
+
+var (
+	// ErrClosed is returned when using a closed conn, it matches net.ErrClosed
+	ErrClosed = &Error{msg: "use of closed connection", cause: net.ErrClosed}
+	// ErrNotConnected is returned when the client is not connected to the broker, reconnecting may fix it
+	ErrNotConnected = &Error{msg: "not connected", temporary: true, cause: mqtt.ErrNotConnected}
+	// ErrPayloadTooLarge is returned by WriteTo when the payload doesn't fit in a MQTT packet
+	ErrPayloadTooLarge = &Error{msg: "payload too large"}
+	// ErrTopicInvalid is returned for topic names containing wildcards or NUL characters, or that are too long,
+	// and for malformed topic filters
+	ErrTopicInvalid = &Error{msg: "invalid topic"}
+	// ErrAddrInvalid is returned by WriteTo when addr is not a TopicAddr
+	ErrAddrInvalid = &Error{msg: "unexpected net.Addr.Network() value"}
+	// ErrNoDefaultTopic is returned by Write when no default topic was set, see WithStrictDefaultTopic
+	ErrNoDefaultTopic = &Error{msg: "no default topic"}
+	// ErrNoTopicTemplate is returned by WriteTemplate without WithTopicTemplate
+	ErrNoTopicTemplate = &Error{msg: "no topic template"}
+	// ErrCircuitOpen is returned by WriteTo while the circuit breaker is open
+	ErrCircuitOpen = &Error{msg: "circuit breaker open", temporary: true}
+	// ErrQuotaExceeded is returned by WriteTo when a write doesn't fit in the WithQuota limit
+	ErrQuotaExceeded = &Error{msg: "quota exceeded", temporary: true}
+	// ErrRouterClosed is returned by Router.Serve after Router.Shutdown
+	ErrRouterClosed = &Error{msg: "router shut down"}
+	// ErrSignatureInvalid is passed to the onTampered callback of WithSigning for messages that fail verification
+	ErrSignatureInvalid = &Error{msg: "invalid message signature"}
+	// ErrReplayed is passed to the onReplay callback of WithReplayProtection for messages that were seen before or are too old
+	ErrReplayed = &Error{msg: "replayed message"}
+	// ErrDecryptionFailed is passed to the onInvalid callback of WithEncryption for messages that can't be decrypted
+	ErrDecryptionFailed = &Error{msg: "message decryption failed"}
+	// ErrKeyExists is returned by Keyring.Add for an epoch that already has a different key, see Keyring.Replace
+	ErrKeyExists = &Error{msg: "key epoch exists"}
+	// ErrBadCredentials matches a *ReasonCodeError for a broker rejecting the username, password or authentication method
+	ErrBadCredentials = &Error{msg: "bad credentials"}
+	// ErrNotAuthorized matches a *ReasonCodeError for a client that is not allowed to connect or is banned
+	ErrNotAuthorized = &Error{msg: "not authorized"}
+	// ErrServerUnavailable matches a *ReasonCodeError for a broker that is unavailable, busy or moved, retrying may succeed
+	ErrServerUnavailable = &Error{msg: "server unavailable", temporary: true}
+	// ErrProtocol matches a *ReasonCodeError for a rejected protocol version, client identifier or packet
+	ErrProtocol = &Error{msg: "protocol error"}
+	// ErrFrameInvalid is returned by StreamConn for a frame that can't be decoded, like compressed data that doesn't decompress
+	ErrFrameInvalid = &Error{msg: "invalid stream frame"}
+	// ErrPauseBufferFull is returned by WriteTo while the conn is paused and the WithPauseBuffer limit is reached
+	ErrPauseBufferFull = &Error{msg: "pause buffer full", temporary: true}
+)
+
+func (err *Error) Error() string {
+	return err.msg
+}
+
+// Timeout implements net.Error.Timeout, see TimeoutError for timeouts
+func (err *Error) Timeout() bool {
+	return false
+}
+
+// Temporary implements net.Error.Temporary, it is true if retrying the operation later may succeed
+func (err *Error) Temporary() bool {
+	return err.temporary
+}
+
+// Unwrap returns the equivalent paho or net error, if any
+func (err *Error) Unwrap() error {
+	return err.cause
+}
+
+// TimeoutError is returned when a deadline passes, it implements net.Error
+type TimeoutError struct {
+	Err error
+}
+
+func (err *TimeoutError) Error() string {
+	return err.Err.Error()
+}
+
+// Timeout implements net.Error.Timeout
+func (err *TimeoutError) Timeout() bool {
+	return true
+}
+
+// Temporary implements net.Error.Temporary, the operation can be retried with a later deadline
+func (err *TimeoutError) Temporary() bool {
+	return true
+}
+
+// Unwrap returns the underlying error
+func (err *TimeoutError) Unwrap() error {
+	return err.Err
+}
+
+// Is makes timeout errors match os.ErrDeadlineExceeded, like the net package does
+func (err *TimeoutError) Is(target error) bool {
+	return target == os.ErrDeadlineExceeded
+}
@@ -0,0 +1,51 @@
+package mqttconn
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestErrors(t *testing.T) {
+	// compile time test: errors conform to net.Error
+	var netErr net.Error
+	netErr = ErrNotConnected
+	netErr = &TimeoutError{}
+	netErr = &ReasonCodeError{}
+	_ = netErr
+	if !errors.Is(ErrNotConnected, mqtt.ErrNotConnected) {
+		t.Error("expected ErrNotConnected to match mqtt.ErrNotConnected")
+		return
+	}
+	if !errors.Is(ErrClosed, net.ErrClosed) {
+		t.Error("expected ErrClosed to match net.ErrClosed")
+		return
+	}
+	if !ErrNotConnected.Temporary() || ErrClosed.Temporary() {
+		t.Error("expected only ErrNotConnected to be temporary")
+		return
+	}
+	conn, err := DialMQTT("mqtt+memory://TestErrors")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = conn.WriteTo([]byte("test"), TopicAddr("a/+"))
+	if err != ErrTopicInvalid {
+		t.Error("expected ErrTopicInvalid, got", err)
+		return
+	}
+	conn.Close()
+	_, err = conn.WriteTo([]byte("test"), TopicAddr("test"))
+	if err != ErrClosed {
+		t.Error("expected ErrClosed, got", err)
+		return
+	}
+	_, err = conn.Read(make([]byte, 16))
+	if err != ErrClosed {
+		t.Error("expected ErrClosed, got", err)
+		return
+	}
+}
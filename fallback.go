@@ -0,0 +1,73 @@
+package mqttconn
+
+import (
+	"context"
+	"crypto/tls"
+	"runtime/pprof"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/pkg/errors"
+)
+
+// CredentialSource is one way to authenticate with the broker, see WithFallbackCredentials
+type CredentialSource struct {
+	// Name identifies the source, it is what CredentialSource reports
+	Name     string
+	Username string
+	Password string
+	// TLSConfig replaces the TLS configuration if set, for sources that authenticate with a client certificate
+	TLSConfig *tls.Config
+}
+
+// apply configures opts to authenticate with source
+func (source *CredentialSource) apply(opts *mqtt.ClientOptions) {
+	opts.SetUsername(source.Username)
+	opts.SetPassword(source.Password)
+	if source.TLSConfig != nil {
+		opts.SetTLSConfig(source.TLSConfig)
+	}
+}
+
+// WithFallbackCredentials tries sources in order, moving on to the next one
+// while the broker rejects the connect with ErrBadCredentials or ErrNotAuthorized,
+// like a rotated password first and a bootstrap certificate last.
+// The first source replaces the credentials in the url, other connect errors are returned right away.
+// Only DialMQTT supports WithFallbackCredentials
+func WithFallbackCredentials(sources ...CredentialSource) Option {
+	return func(conn *MQTTConn) {
+		conn.fallbackCredentials = sources
+	}
+}
+
+// CredentialSource returns the name of the source from WithFallbackCredentials the broker accepted
+// it is "" without WithFallbackCredentials
+func (conn *MQTTConn) CredentialSource() string {
+	return conn.credentialSource
+}
+
+// connectClient creates a client for opts with newClient and connects it,
+// trying the fallback credential sources in order while the broker rejects the credentials
+func (conn *MQTTConn) connectClient(opts *mqtt.ClientOptions, newClient func(*mqtt.ClientOptions) mqtt.Client) (mqtt.Client, error) {
+	sources := conn.fallbackCredentials
+	for i := 0; ; i++ {
+		if i < len(sources) {
+			sources[i].apply(opts)
+		}
+		client := newClient(opts)
+		var token mqtt.Token
+		pprof.Do(context.Background(), conn.labels, func(context.Context) {
+			token = client.Connect()
+		})
+		token.Wait()
+		err := connectError(token)
+		if err == nil {
+			if i < len(sources) {
+				conn.credentialSource = sources[i].Name
+			}
+			return client, nil
+		}
+		if i+1 >= len(sources) || !(errors.Is(err, ErrBadCredentials) || errors.Is(err, ErrNotAuthorized)) {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package mqttconn
+
+import (
+	"testing"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// rejectingClient is a mqtt.Client whose broker only accepts the password "bootstrap"
+type rejectingClient struct {
+	*memoryClient
+}
+
+func (client rejectingClient) Connect() mqtt.Token {
+	if client.options.Password != "bootstrap" {
+		return &memoryToken{err: &ReasonCodeError{Op: "connect", Code: ReasonBadUserNameOrPassword}}
+	}
+	return client.memoryClient.Connect()
+}
+
+func TestFallbackCredentials(t *testing.T) {
+	conn := newMQTTConn([]Option{WithFallbackCredentials(
+		CredentialSource{Name: "rotated", Username: "user", Password: "new"},
+		CredentialSource{Name: "previous", Username: "user", Password: "old"},
+		CredentialSource{Name: "bootstrap", Username: "device", Password: "bootstrap"},
+	)})
+	attempts := 0
+	newClient := func(opts *mqtt.ClientOptions) mqtt.Client {
+		attempts++
+		return rejectingClient{newMemoryClient("TestFallbackCredentials", opts)}
+	}
+	client, err := conn.connectClient(mqtt.NewClientOptions(), newClient)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer client.Disconnect(0)
+	if attempts != 3 {
+		t.Error("expected 3 connect attempts, got", attempts)
+		return
+	}
+	if conn.CredentialSource() != "bootstrap" {
+		t.Error("expected the bootstrap source to succeed, got", conn.CredentialSource())
+		return
+	}
+
+	// the last rejection is returned once every source failed
+	conn = newMQTTConn([]Option{WithFallbackCredentials(CredentialSource{Name: "rotated", Password: "new"})})
+	_, err = conn.connectClient(mqtt.NewClientOptions(), newClient)
+	if err == nil || conn.CredentialSource() != "" {
+		t.Error("expected the connect to fail")
+		return
+	}
+}
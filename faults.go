@@ -0,0 +1,219 @@
+package mqttconn
+
+import (
+	"crypto/tls"
+	"math/rand"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/pkg/errors"
+)
+
+// defaultReorderTimeout is how long a message is held back for reordering without a ReorderTimeout
+const defaultReorderTimeout = time.Second
+
+// FaultInjection describes misbehavior injected into received messages and the broker connection
+// probabilities are in the range [0, 1] and evaluated per received message
+type FaultInjection struct {
+	// Drop is the probability a message is silently discarded
+	Drop float64
+	// Duplicate is the probability a message is delivered twice
+	Duplicate float64
+	// Delay is the probability a message is delivered after a random delay up to MaxDelay
+	Delay    float64
+	MaxDelay time.Duration
+	// Reorder is the probability a message is held back and delivered after the next one,
+	// or after ReorderTimeout if no next one arrives, zero means one second
+	Reorder        float64
+	ReorderTimeout time.Duration
+	// Disconnect is the probability the network connection to the broker is dropped
+	Disconnect float64
+	// DisconnectEvery drops the network connection on a fixed schedule, zero disables it
+	DisconnectEvery time.Duration
+	// Seed seeds the random source, making runs reproducible
+	Seed int64
+}
+
+// WithFaultInjection wraps the conn in a chaos layer for resilience testing
+// message faults apply to every received message, disconnects only work with DialMQTT
+// since the conn needs to own the network connection to drop it.
+// DisconnectEvery starts once the conn is connected and stops when it is closed
+func WithFaultInjection(faults FaultInjection) Option {
+	return func(conn *MQTTConn) {
+		injector := &faultInjector{
+			conn:   conn,
+			faults: faults,
+			rand:   rand.New(rand.NewSource(faults.Seed)),
+			done:   make(chan struct{}),
+		}
+		conn.faults = injector
+		conn.clientOptions = append(conn.clientOptions, func(opts *mqtt.ClientOptions) {
+			// connections opened for websockets are wrapped, not replaced
+			injector.open = opts.CustomOpenConnectionFn
+			opts.SetCustomOpenConnectionFn(injector.openConnection)
+		})
+	}
+}
+
+// faultInjector is the state of a FaultInjection
+type faultInjector struct {
+	conn   *MQTTConn
+	faults FaultInjection
+	// open is the CustomOpenConnectionFn of the client options, if any
+	open mqtt.OpenConnectionFunc
+
+	mu       sync.Mutex
+	rand     *rand.Rand
+	held     *Message
+	netConn  net.Conn
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// chance reports true with probability p
+func (injector *faultInjector) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	injector.mu.Lock()
+	defer injector.mu.Unlock()
+	return injector.rand.Float64() < p
+}
+
+// inject delivers msg through push, applying faults
+func (injector *faultInjector) inject(msg *Message, push func(*Message) bool) {
+	if injector.chance(injector.faults.Disconnect) {
+		injector.disconnect()
+	}
+	if injector.chance(injector.faults.Drop) {
+		return
+	}
+	copies := 1
+	if injector.chance(injector.faults.Duplicate) {
+		copies = 2
+	}
+	if injector.faults.MaxDelay > 0 && injector.chance(injector.faults.Delay) {
+		injector.mu.Lock()
+		delay := time.Duration(injector.rand.Int63n(int64(injector.faults.MaxDelay)))
+		injector.mu.Unlock()
+		injector.conn.goLabeled(func() {
+			sleep(injector.conn.clock, delay)
+			for i := 0; i < copies; i++ {
+				push(msg)
+			}
+		})
+		return
+	}
+	if injector.chance(injector.faults.Reorder) {
+		injector.mu.Lock()
+		held := injector.held
+		injector.held = msg
+		injector.mu.Unlock()
+		injector.release(msg, push)
+		if held != nil {
+			push(held)
+		}
+		return
+	}
+	for i := 0; i < copies; i++ {
+		push(msg)
+	}
+	injector.mu.Lock()
+	held := injector.held
+	injector.held = nil
+	injector.mu.Unlock()
+	if held != nil {
+		push(held)
+	}
+}
+
+// release delivers msg through push if it is still held back after the ReorderTimeout, counting it in Stats
+func (injector *faultInjector) release(msg *Message, push func(*Message) bool) {
+	timeout := injector.faults.ReorderTimeout
+	if timeout <= 0 {
+		timeout = defaultReorderTimeout
+	}
+	timer := injector.conn.clock.NewTimer(timeout)
+	injector.conn.goLabeled(func() {
+		defer timer.Stop()
+		select {
+		case <-timer.C():
+		case <-injector.done:
+			return
+		}
+		injector.mu.Lock()
+		held := injector.held == msg
+		if held {
+			injector.held = nil
+		}
+		injector.mu.Unlock()
+		if held {
+			atomic.AddInt64(&injector.conn.stats.reorderTimeouts, 1)
+			push(msg)
+		}
+	})
+}
+
+// openConnection dials the broker and remembers the connection so it can be dropped
+func (injector *faultInjector) openConnection(uri *url.URL, options mqtt.ClientOptions) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: options.ConnectTimeout}
+	var netConn net.Conn
+	var err error
+	switch {
+	case injector.open != nil:
+		netConn, err = injector.open(uri, options)
+	case uri.Scheme == "tcp" || uri.Scheme == "mqtt":
+		netConn, err = dialer.Dial("tcp", uri.Host)
+	case uri.Scheme == "ssl" || uri.Scheme == "tls" || uri.Scheme == "mqtts" || uri.Scheme == "tcps":
+		netConn, err = tls.DialWithDialer(dialer, "tcp", uri.Host, options.TLSConfig)
+	default:
+		return nil, errors.New("fault injection does not support scheme " + uri.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	injector.mu.Lock()
+	injector.netConn = netConn
+	injector.mu.Unlock()
+	return netConn, nil
+}
+
+// disconnect drops the current network connection, paho sees it as a lost connection
+func (injector *faultInjector) disconnect() {
+	injector.mu.Lock()
+	netConn := injector.netConn
+	injector.netConn = nil
+	injector.mu.Unlock()
+	if netConn != nil {
+		netConn.Close()
+	}
+}
+
+// watch drops the network connection every DisconnectEvery until stop
+func (injector *faultInjector) watch(conn *MQTTConn) {
+	if injector.faults.DisconnectEvery <= 0 {
+		return
+	}
+	conn.goLabeled(func() {
+		for {
+			timer := conn.clock.NewTimer(injector.faults.DisconnectEvery)
+			select {
+			case <-timer.C():
+				injector.disconnect()
+			case <-injector.done:
+				timer.Stop()
+				return
+			}
+		}
+	})
+}
+
+func (injector *faultInjector) stop() {
+	injector.stopOnce.Do(func() {
+		close(injector.done)
+	})
+}
@@ -0,0 +1,120 @@
+package mqttconn
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestFaultInjection(t *testing.T) {
+	conn := newMQTTConn([]Option{WithFaultInjection(FaultInjection{Duplicate: 1})})
+	conn.deliver(&Message{Topic: "a"})
+	for i := 0; i < 2; i++ {
+		msg, err := conn.ReadMsg(context.Background())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if msg.Topic != "a" {
+			t.Error("expected duplicated message a, got", msg.Topic)
+			return
+		}
+	}
+
+	conn = newMQTTConn([]Option{WithFaultInjection(FaultInjection{Drop: 1})})
+	conn.deliver(&Message{Topic: "a"})
+	if len(conn.queue.msgs) != 0 {
+		t.Error("expected message to be dropped")
+		return
+	}
+
+	injector := &faultInjector{conn: conn, faults: FaultInjection{Reorder: 1}, rand: conn.faults.rand}
+	var order []string
+	push := func(msg *Message) bool {
+		order = append(order, msg.Topic)
+		return true
+	}
+	injector.inject(&Message{Topic: "a"}, push)
+	injector.faults.Reorder = 0
+	injector.inject(&Message{Topic: "b"}, push)
+	if len(order) != 2 || order[0] != "b" || order[1] != "a" {
+		t.Error("expected order [b a], got", order)
+		return
+	}
+}
+
+func TestFaultInjectionReorderTimeout(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	conn := newMQTTConn([]Option{WithClock(clock), WithFaultInjection(FaultInjection{Reorder: 1, ReorderTimeout: time.Second})})
+	conn.deliver(&Message{Topic: "a"})
+	if len(conn.queue.msgs) != 0 {
+		t.Error("expected message to be held back")
+		return
+	}
+	// no next message arrives, the held one is released after the timeout
+	clock.Advance(time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	msg, err := conn.ReadMsg(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if msg.Topic != "a" {
+		t.Error("expected released message a, got", msg.Topic)
+		return
+	}
+	if conn.Stats().ReorderTimeouts != 1 {
+		t.Error("expected 1 reorder timeout, got", conn.Stats().ReorderTimeouts)
+	}
+}
+
+func TestFaultInjectionDisconnectEvery(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	conn := newMQTTConn([]Option{WithClock(clock), WithFaultInjection(FaultInjection{DisconnectEvery: time.Second})})
+	// the injector wraps the custom connection of the client options
+	opts := mqtt.NewClientOptions()
+	closed := make(chan struct{})
+	opts.SetCustomOpenConnectionFn(func(uri *url.URL, options mqtt.ClientOptions) (net.Conn, error) {
+		local, remote := net.Pipe()
+		go func() {
+			remote.Read(make([]byte, 1))
+			close(closed)
+		}()
+		return local, nil
+	})
+	for _, configure := range conn.clientOptions {
+		configure(opts)
+	}
+	uri, _ := url.Parse("ws://broker")
+	if _, err := opts.CustomOpenConnectionFn(uri, *opts); err != nil {
+		t.Error(err)
+		return
+	}
+	if clock.Timers() != 0 {
+		t.Error("expected no disconnects before connecting")
+		return
+	}
+	conn.faults.watch(conn)
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(time.Second)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Error("expected the connection to be dropped")
+		return
+	}
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	conn.faults.stop()
+	for clock.Timers() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
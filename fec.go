@@ -0,0 +1,185 @@
+package mqttconn
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+)
+
+const (
+	// fecMagic starts the FEC header of a payload
+	fecMagic = 0xfc3e
+	// fecHeaderSize is the magic, the sender id, the group number, the index in the group, the group size and the check
+	fecHeaderSize = headerMagicSize + 4 + 4 + 1 + 1 + headerCheckSize
+	// fecMaxGroups is the number of incomplete groups kept for recovery
+	fecMaxGroups = 256
+)
+
+// WithFEC adds a parity message after every groupSize messages written to a topic, from 2 to 255,
+// so a reader using WithFEC as well can reconstruct one lost message per group without retransmission.
+// This suits high rate QoS 0 streams, it costs one message per group and a 16 byte header per message.
+// Recovered messages are read after the rest of their group
+func WithFEC(groupSize int) Option {
+	return func(conn *MQTTConn) {
+		if groupSize < 2 {
+			groupSize = 2
+		} else if groupSize > 255 {
+			groupSize = 255
+		}
+		codec := &fecCodec{
+			size:    groupSize,
+			sending: make(map[string]*fecGroup),
+			groups:  make(map[fecGroupKey]*fecGroup),
+		}
+		rand.Read(codec.id[:])
+		conn.codecs = append(conn.codecs, codec)
+	}
+}
+
+// fecGroupKey identifies a group of one sender on one topic
+type fecGroupKey struct {
+	topic  string
+	sender [4]byte
+	group  uint32
+}
+
+// fecGroup accumulates a group of messages
+type fecGroup struct {
+	number uint32
+	// size is the number of messages written so far, or the group size of a received group
+	size int
+	// parity is the XOR of the length prefixed payloads of the group
+	parity   []byte
+	received map[byte]bool
+	complete bool
+}
+
+// add XORs a length prefixed payload into the parity
+func (group *fecGroup) add(payload []byte) {
+	block := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(block, uint32(len(payload)))
+	copy(block[4:], payload)
+	group.xor(block)
+}
+
+// xor XORs block into the parity, growing it as needed
+func (group *fecGroup) xor(block []byte) {
+	for len(group.parity) < len(block) {
+		group.parity = append(group.parity, 0)
+	}
+	for i, b := range block {
+		group.parity[i] ^= b
+	}
+}
+
+// fecCodec is the state of WithFEC
+type fecCodec struct {
+	id   [4]byte
+	size int
+
+	mu      sync.Mutex
+	sending map[string]*fecGroup
+	groups  map[fecGroupKey]*fecGroup
+	order   []fecGroupKey
+}
+
+// header returns the FEC header for index of group
+func (codec *fecCodec) header(group *fecGroup, index int) []byte {
+	header := newHeader(fecMagic, fecHeaderSize, fecHeaderSize)
+	copy(header[2:], codec.id[:])
+	binary.BigEndian.PutUint32(header[6:], group.number)
+	header[10] = byte(index)
+	header[11] = byte(codec.size)
+	sealHeader(header)
+	return header
+}
+
+// encode implements payloadCodec
+func (codec *fecCodec) encode(topic string, payload []byte) ([][]byte, error) {
+	codec.mu.Lock()
+	defer codec.mu.Unlock()
+	group := codec.sending[topic]
+	if group == nil {
+		group = &fecGroup{}
+		codec.sending[topic] = group
+	}
+	payloads := [][]byte{append(codec.header(group, group.size), payload...)}
+	group.add(payload)
+	group.size++
+	if group.size == codec.size {
+		// the parity takes the index after the last message
+		payloads = append(payloads, append(codec.header(group, codec.size), group.parity...))
+		codec.sending[topic] = &fecGroup{number: group.number + 1}
+	}
+	return payloads, nil
+}
+
+// decode implements payloadCodec
+func (codec *fecCodec) decode(msg *Message) []*Message {
+	payload := msg.Payload
+	if !hasHeader(payload, fecMagic, fecHeaderSize) {
+		return []*Message{msg}
+	}
+	key := fecGroupKey{topic: msg.Topic, group: binary.BigEndian.Uint32(payload[6:])}
+	copy(key.sender[:], payload[2:])
+	index, size := payload[10], int(payload[11])
+	data := payload[fecHeaderSize:]
+
+	codec.mu.Lock()
+	defer codec.mu.Unlock()
+	group := codec.groups[key]
+	if group == nil {
+		group = &fecGroup{size: size, received: make(map[byte]bool)}
+		codec.groups[key] = group
+		codec.order = append(codec.order, key)
+		if len(codec.order) > fecMaxGroups {
+			delete(codec.groups, codec.order[0])
+			codec.order = codec.order[1:]
+		}
+	}
+	if group.complete || group.received[index] {
+		// a duplicate, a message already recovered or a parity no longer needed
+		return nil
+	}
+	group.received[index] = true
+	var msgs []*Message
+	if int(index) < size {
+		group.add(data)
+		msg.Payload = data
+		msgs = append(msgs, msg)
+	} else {
+		group.xor(data)
+	}
+	if len(group.received) < size {
+		return msgs
+	}
+	group.complete = true
+	if !group.received[byte(size)] {
+		// every message arrived, the parity is not needed
+		return msgs
+	}
+	// all but one message and the parity arrived, the parity now holds the missing message
+	for missing := 0; missing < size; missing++ {
+		if group.received[byte(missing)] {
+			continue
+		}
+		group.received[byte(missing)] = true
+		if len(group.parity) < 4 {
+			break
+		}
+		length := binary.BigEndian.Uint32(group.parity)
+		if int(length) > len(group.parity)-4 {
+			break
+		}
+		recovered := &Message{
+			Topic:         msg.Topic,
+			MatchedFilter: msg.MatchedFilter,
+			Payload:       append([]byte(nil), group.parity[4:4+length]...),
+			QoS:           msg.QoS,
+			Received:      msg.Received,
+			conn:          msg.conn,
+		}
+		msgs = append(msgs, recovered)
+	}
+	return msgs
+}
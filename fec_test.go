@@ -0,0 +1,58 @@
+package mqttconn
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFEC(t *testing.T) {
+	reader, err := DialMQTT("mqtt+memory://TestFEC/t", WithFEC(3))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer reader.Close()
+	writer, err := DialMQTT("mqtt+memory://TestFEC", WithFEC(3))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer writer.Close()
+	var payloads [][]byte
+	for _, data := range []string{"one", "two", "three!", "4", "5", "6"} {
+		encoded, err := writer.encodePayload("t", []byte(data))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		payloads = append(payloads, encoded...)
+	}
+	if len(payloads) != 8 {
+		t.Error("expected 6 messages and 2 parities, got", len(payloads))
+		return
+	}
+	for i, payload := range payloads {
+		// lose "two"
+		if i != 1 {
+			writer.Client.Publish("t", 0, false, payload).Wait()
+		}
+	}
+	reader.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 16)
+	for _, expected := range []string{"one", "three!", "two", "4", "5", "6"} {
+		n, err := reader.Read(buf)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(buf[:n]) != expected {
+			t.Error("expected", expected, "got", string(buf[:n]))
+			return
+		}
+	}
+	reader.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err = reader.Read(buf); err == nil {
+		t.Error("expected the unneeded parity to be dropped, read", string(buf))
+		return
+	}
+}
@@ -0,0 +1,120 @@
+package mqttconn
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// heartbeat statuses
+const (
+	HeartbeatOnline  = "online"
+	HeartbeatOffline = "offline"
+)
+
+// HeartbeatStatus is the retained JSON payload published by a Heartbeat
+type HeartbeatStatus struct {
+	Status string `json:"status"`
+	// Uptime is the number of seconds since the heartbeat started
+	Uptime  int64                  `json:"uptime"`
+	Version string                 `json:"version,omitempty"`
+	Time    time.Time              `json:"time"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// HeartbeatConfig configures StartHeartbeat
+type HeartbeatConfig struct {
+	Topic    string
+	Interval time.Duration
+	Version  string
+	// Fields, if set, is called before each beat to fill HeartbeatStatus.Fields
+	Fields func() map[string]interface{}
+}
+
+// Heartbeat periodically publishes the liveness of a device
+type Heartbeat struct {
+	conn    *MQTTConn
+	config  HeartbeatConfig
+	started time.Time
+
+	stopOnce sync.Once
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+// HeartbeatWill returns the option publishing an offline status to topic when the connection is lost,
+// so monitoring sees crashed devices as offline instead of with a stale online status
+func HeartbeatWill(topic string) Option {
+	payload, _ := json.Marshal(&HeartbeatStatus{Status: HeartbeatOffline})
+	return WithWill(topic, payload, 1, true)
+}
+
+// StartHeartbeat publishes an online status right away and then every config.Interval
+func StartHeartbeat(conn *MQTTConn, config HeartbeatConfig) (*Heartbeat, error) {
+	if err := validateTopic(config.Topic); err != nil {
+		return nil, err
+	}
+	heartbeat := &Heartbeat{
+		conn:    conn,
+		config:  config,
+		started: conn.clock.Now(),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if err := heartbeat.beat(HeartbeatOnline); err != nil {
+		return nil, err
+	}
+	conn.goLabeled(heartbeat.run)
+	return heartbeat, nil
+}
+
+func (heartbeat *Heartbeat) run() {
+	defer close(heartbeat.stopped)
+	for {
+		timer := heartbeat.conn.clock.NewTimer(heartbeat.config.Interval)
+		select {
+		case <-timer.C():
+			// a failed beat is retried on the next interval
+			heartbeat.beat(HeartbeatOnline)
+		case <-heartbeat.done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// beat publishes the retained status
+func (heartbeat *Heartbeat) beat(status string) error {
+	now := heartbeat.conn.clock.Now()
+	beat := &HeartbeatStatus{
+		Status:  status,
+		Uptime:  int64(now.Sub(heartbeat.started) / time.Second),
+		Version: heartbeat.config.Version,
+		Time:    now,
+	}
+	if heartbeat.config.Fields != nil {
+		beat.Fields = heartbeat.config.Fields()
+	}
+	payload, err := json.Marshal(beat)
+	if err != nil {
+		return err
+	}
+	return heartbeat.conn.publishWithRetry(&outgoing{
+		topic:    heartbeat.config.Topic,
+		qos:      1,
+		retained: true,
+		payload:  payload,
+	})
+}
+
+// Stop stops the heartbeat and publishes an offline status,
+// which is needed since a clean disconnect doesn't trigger the will
+func (heartbeat *Heartbeat) Stop() error {
+	var err error
+	heartbeat.stopOnce.Do(func() {
+		close(heartbeat.done)
+		<-heartbeat.stopped
+		err = heartbeat.beat(HeartbeatOffline)
+	})
+	return err
+}
@@ -0,0 +1,64 @@
+package mqttconn
+
+import (
+	"context"
+	"encoding/json"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestHeartbeat(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	conn, err := DialMQTT("mqtt+memory://TestHeartbeat", WithClock(clock))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	heartbeat, err := StartHeartbeat(conn, HeartbeatConfig{
+		Topic:    "devices/1/status",
+		Interval: time.Minute,
+		Version:  "1.0",
+		Fields:   func() map[string]interface{} { return map[string]interface{}{"battery": 80} },
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for clock.Timers() == 0 {
+		runtime.Gosched()
+	}
+	clock.Advance(time.Minute)
+	for clock.Timers() == 0 {
+		runtime.Gosched()
+	}
+	var status HeartbeatStatus
+	payload, _ := conn.retainedNow("devices/1/status")
+	json.Unmarshal(payload, &status)
+	if status.Status != HeartbeatOnline || status.Uptime != 60 || status.Fields["battery"] != 80.0 {
+		t.Error("unexpected status", status)
+		return
+	}
+	heartbeat.Stop()
+	payload, _ = conn.retainedNow("devices/1/status")
+	json.Unmarshal(payload, &status)
+	if status.Status != HeartbeatOffline {
+		t.Error("expected offline status, got", status.Status)
+		return
+	}
+}
+
+// retainedNow reads the retained message of topic from a memory hub, without waiting on the clock
+func (conn *MQTTConn) retainedNow(topic string) ([]byte, error) {
+	sub, err := conn.subscribeQueue(topic, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer sub.close()
+	msg, err := sub.queue.next(context.Background(), time.Time{}, true)
+	if err != nil {
+		return nil, err
+	}
+	return msg.Payload, nil
+}
@@ -0,0 +1,72 @@
+package mqttconn
+
+import (
+	"sync/atomic"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Hooks are callbacks for the life of a conn, one place to attach metrics, auditing and debugging
+// nil callbacks are skipped. They are called synchronously from the goroutine doing the work,
+// so they must return quickly and not use the conn
+type Hooks struct {
+	// OnPublish is called after every publish with its topic, payload size and result
+	OnPublish func(topic string, payloadLen int, err error)
+	// OnReceive is called for every message from the broker, before it is filtered or decoded
+	OnReceive func(msg *Message)
+	// OnDrop is called for every received message that is dropped instead of read, like WithOnDrop
+	OnDrop func(topic string, payloadLen int, reason DropReason)
+	// OnSubscribe is called after Subscribe, SubscribePriority and SubscribeBuffered with the result
+	OnSubscribe func(filter string, qos int, err error)
+	// OnReconnect is called after Reconnect and Resume with the result, including reconnects for expired credentials,
+	// and with nil once the client reconnected on its own after losing the connection
+	OnReconnect func(err error)
+	// OnClose is called at the end of Close
+	OnClose func()
+}
+
+// noHooks are the hooks of a conn without SetHooks
+var noHooks = &Hooks{}
+
+// WithHooks sets the hooks of the conn, see SetHooks
+func WithHooks(hooks Hooks) Option {
+	return func(conn *MQTTConn) {
+		conn.SetHooks(hooks)
+	}
+}
+
+// SetHooks replaces the hooks of the conn, it is safe to call while the conn is in use
+func (conn *MQTTConn) SetHooks(hooks Hooks) {
+	conn.hooks.Store(&hooks)
+}
+
+// loadHooks returns the current hooks, never nil
+func (conn *MQTTConn) loadHooks() *Hooks {
+	hooks, _ := conn.hooks.Load().(*Hooks)
+	if hooks == nil {
+		return noHooks
+	}
+	return hooks
+}
+
+// watchReconnects reports the automatic reconnects of the client to OnReconnect, keeping the handlers opts has
+func (conn *MQTTConn) watchReconnects(opts *mqtt.ClientOptions) {
+	onLost, onConnect := opts.OnConnectionLost, opts.OnConnect
+	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		atomic.StoreInt32(&conn.connectionLost, 1)
+		if onLost != nil {
+			onLost(client, err)
+		}
+	})
+	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		if onConnect != nil {
+			onConnect(client)
+		}
+		if !atomic.CompareAndSwapInt32(&conn.connectionLost, 1, 0) {
+			return
+		}
+		if hooks := conn.loadHooks(); hooks.OnReconnect != nil {
+			hooks.OnReconnect(nil)
+		}
+	})
+}
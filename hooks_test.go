@@ -0,0 +1,76 @@
+package mqttconn
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHooks(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+	conn, err := DialMQTT("mqtt+memory://TestHooks/topic", WithMessageTTL(time.Nanosecond), WithHooks(Hooks{
+		OnPublish: func(topic string, payloadLen int, err error) {
+			record("publish " + topic)
+		},
+		OnReceive: func(msg *Message) {
+			record("receive " + msg.Topic)
+		},
+		OnDrop: func(topic string, payloadLen int, reason DropReason) {
+			record("drop " + reason.String())
+		},
+		OnSubscribe: func(filter string, qos int, err error) {
+			record("subscribe " + filter)
+		},
+		OnReconnect: func(err error) {
+			if err == nil {
+				record("reconnect")
+			}
+		},
+		OnClose: func() {
+			record("close")
+		},
+	}))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Error(err)
+		return
+	}
+	for conn.Stats().MessagesReceived == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// the message expires before it is read
+	time.Sleep(time.Millisecond)
+	conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	conn.Read(make([]byte, 16))
+	if err := conn.Reconnect(); err != nil {
+		t.Error(err)
+		return
+	}
+	conn.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	// delivery is asynchronous, so publish and receive may be reported in any order
+	seen := make(map[string]int)
+	for _, event := range events {
+		seen[event]++
+	}
+	for _, event := range []string{"subscribe topic", "publish topic", "receive topic", "drop expired", "reconnect", "close"} {
+		if seen[event] != 1 {
+			t.Error("expected event", event, "once, got", events)
+			return
+		}
+	}
+	if events[len(events)-1] != "close" {
+		t.Error("expected close last, got", events)
+		return
+	}
+}
@@ -0,0 +1,70 @@
+package mqttconn
+
+import (
+	"sync"
+	"time"
+)
+
+// WithIdleTimeout closes the conn if no message is sent or received for d, then calls onIdle if it is not nil
+// this lets pools and servers reap abandoned connections
+func WithIdleTimeout(d time.Duration, onIdle func(*MQTTConn)) Option {
+	return func(conn *MQTTConn) {
+		conn.idle = &idleTimer{
+			timeout: d,
+			onIdle:  onIdle,
+			stopped: make(chan struct{}),
+		}
+	}
+}
+
+// idleTimer watches the activity of a MQTTConn
+type idleTimer struct {
+	timeout  time.Duration
+	onIdle   func(*MQTTConn)
+	mu       sync.Mutex
+	last     time.Time
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// touch records activity
+func (idle *idleTimer) touch(now time.Time) {
+	// the time keeps its monotonic reading, so changing the system clock doesn't count as idling
+	idle.mu.Lock()
+	idle.last = now
+	idle.mu.Unlock()
+}
+
+// watch closes conn once it has been idle for the timeout, until stop is called
+func (idle *idleTimer) watch(conn *MQTTConn) {
+	idle.touch(conn.clock.Now())
+	conn.goLabeled(func() {
+		for {
+			idle.mu.Lock()
+			last := idle.last
+			idle.mu.Unlock()
+			remaining := idle.timeout - conn.clock.Now().Sub(last)
+			if remaining <= 0 {
+				conn.Close()
+				if idle.onIdle != nil {
+					idle.onIdle(conn)
+				}
+				return
+			}
+			timer := conn.clock.NewTimer(remaining)
+			select {
+			case <-timer.C():
+			case <-idle.stopped:
+				timer.Stop()
+				return
+			}
+		}
+	})
+}
+
+// stop ends watching
+func (idle *idleTimer) stop() {
+	idle.stopOnce.Do(func() {
+		close(idle.stopped)
+	})
+}
@@ -0,0 +1,52 @@
+package mqttconn
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	idle := make(chan *MQTTConn, 1)
+	conn, err := DialMQTT("mqtt+memory://TestIdleTimeout", WithClock(clock), WithIdleTimeout(time.Minute, func(conn *MQTTConn) {
+		idle <- conn
+	}))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(30 * time.Second)
+	if _, err = conn.WriteTo([]byte("active"), TopicAddr("topic")); err != nil {
+		t.Error(err)
+		return
+	}
+	clock.Advance(45 * time.Second)
+	select {
+	case <-idle:
+		t.Error("expected activity to extend the timeout")
+		return
+	case <-time.After(50 * time.Millisecond):
+	}
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	clock.Advance(15 * time.Second)
+	select {
+	case closed := <-idle:
+		if closed != conn {
+			t.Error("expected the idle conn")
+			return
+		}
+	case <-time.After(time.Second):
+		t.Error("expected idle timeout")
+		return
+	}
+	if _, err = conn.WriteTo([]byte("closed"), TopicAddr("topic")); err != ErrClosed {
+		t.Error("expected ErrClosed, got", err)
+		return
+	}
+}
@@ -0,0 +1,257 @@
+package mqttconn
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// InboxStore records the keys of the messages an Inbox processed, it must be safe for concurrent use
+type InboxStore interface {
+	// Processed reports whether key was recorded
+	Processed(key string) (bool, error)
+	// Record durably stores key as processed at t
+	Record(key string, t time.Time) error
+	// Prune forgets the keys recorded before t
+	Prune(before time.Time) error
+}
+
+// InboxConfig configures NewInbox
+type InboxConfig struct {
+	// Key returns the identity of a message, nil means its CorrelationID, see WithEnvelope
+	// MQTT message IDs are reused by brokers and don't identify a message across redeliveries
+	Key func(msg *Message) string
+	// Retention is how long keys are kept, it has to cover the time a message can be redelivered
+	// zero keeps them forever
+	Retention time.Duration
+}
+
+// Inbox processes received messages once, recording their keys in a store before acknowledging them,
+// so a consumer restarted mid-stream skips the redeliveries of messages it already processed and,
+// with WithManualAck and QoS 1, gets the ones it didn't acknowledge again. A message is processed twice
+// only if the process stops between the handler returning and the key being recorded.
+// Messages without a key are processed every time they are delivered
+type Inbox struct {
+	store  InboxStore
+	config InboxConfig
+	clock  Clock
+
+	mu         sync.Mutex
+	processing map[string]chan struct{}
+	pruned     time.Time
+}
+
+// NewInbox creates an Inbox recording processed messages in store
+func NewInbox(store InboxStore, config InboxConfig) *Inbox {
+	return &Inbox{
+		store:      store,
+		config:     config,
+		clock:      realClock{},
+		processing: make(map[string]chan struct{}),
+	}
+}
+
+// key returns the identity of msg, empty if it has none
+func (inbox *Inbox) key(msg *Message) string {
+	if inbox.config.Key != nil {
+		return inbox.config.Key(msg)
+	}
+	return msg.CorrelationID
+}
+
+// Process calls handle with msg unless it was processed before, then records and acknowledges it
+// a failing handle nacks the message without requeueing, so the broker redelivers it, and returns the error
+func (inbox *Inbox) Process(msg *Message, handle func(msg *Message) error) error {
+	key := inbox.key(msg)
+	if key == "" {
+		if err := handle(msg); err != nil {
+			msg.Nack(false)
+			return err
+		}
+		msg.Ack()
+		return nil
+	}
+	// a redelivery arriving while the first delivery is handled waits for its outcome
+	for {
+		inbox.mu.Lock()
+		done, busy := inbox.processing[key]
+		if !busy {
+			inbox.processing[key] = make(chan struct{})
+			inbox.mu.Unlock()
+			break
+		}
+		inbox.mu.Unlock()
+		<-done
+	}
+	defer func() {
+		inbox.mu.Lock()
+		close(inbox.processing[key])
+		delete(inbox.processing, key)
+		inbox.mu.Unlock()
+	}()
+	processed, err := inbox.store.Processed(key)
+	if err != nil {
+		msg.Nack(false)
+		return err
+	}
+	if !processed {
+		if err := handle(msg); err != nil {
+			msg.Nack(false)
+			return err
+		}
+		now := inbox.clock.Now()
+		if err := inbox.store.Record(key, now); err != nil {
+			msg.Nack(false)
+			return err
+		}
+		inbox.prune(now)
+	}
+	msg.Ack()
+	return nil
+}
+
+// prune forgets old keys at most once per retention period, so keys live up to twice the retention
+func (inbox *Inbox) prune(now time.Time) {
+	if inbox.config.Retention <= 0 {
+		return
+	}
+	inbox.mu.Lock()
+	if inbox.pruned.IsZero() {
+		inbox.pruned = now
+	}
+	due := now.Sub(inbox.pruned) >= inbox.config.Retention
+	if due {
+		inbox.pruned = now
+	}
+	inbox.mu.Unlock()
+	if due {
+		// a failed prune is retried after the next retention period
+		inbox.store.Prune(now.Add(-inbox.config.Retention))
+	}
+}
+
+// FileInboxStore is an InboxStore keeping the keys in memory and in an append-only journal file
+type FileInboxStore struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+	keys map[string]time.Time
+}
+
+// NewFileInboxStore opens the journal at path, creating it if needed
+func NewFileInboxStore(path string) (*FileInboxStore, error) {
+	store := &FileInboxStore{path: path, keys: make(map[string]time.Time)}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// lines are the nanosecond timestamp and the quoted key, a partial last line is ignored
+		line := scanner.Text()
+		space := strings.IndexByte(line, ' ')
+		if space < 0 {
+			continue
+		}
+		nanos, err := strconv.ParseInt(line[:space], 10, 64)
+		if err != nil {
+			continue
+		}
+		key, err := strconv.Unquote(line[space+1:])
+		if err != nil {
+			continue
+		}
+		store.keys[key] = time.Unix(0, nanos)
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	// ends a partial line left by a crash, so the next key starts on a line of its own
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			file.Write([]byte("\n"))
+		}
+	}
+	store.file = file
+	return store, nil
+}
+
+// Processed implements InboxStore
+func (store *FileInboxStore) Processed(key string) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	_, ok := store.keys[key]
+	return ok, nil
+}
+
+// Record implements InboxStore, the key is synced to disk before it returns
+func (store *FileInboxStore) Record(key string, t time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, err := fmt.Fprintf(store.file, "%d %s\n", t.UnixNano(), strconv.Quote(key)); err != nil {
+		return err
+	}
+	if err := store.file.Sync(); err != nil {
+		return err
+	}
+	store.keys[key] = t
+	return nil
+}
+
+// Prune implements InboxStore, it rewrites the journal with the remaining keys
+func (store *FileInboxStore) Prune(before time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	tmp := store.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	for key, t := range store.keys {
+		if t.Before(before) {
+			continue
+		}
+		fmt.Fprintf(writer, "%d %s\n", t.UnixNano(), strconv.Quote(key))
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, store.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	reopened, err := os.OpenFile(store.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	store.file.Close()
+	store.file = reopened
+	for key, t := range store.keys {
+		if t.Before(before) {
+			delete(store.keys, key)
+		}
+	}
+	return nil
+}
+
+// Close closes the journal
+func (store *FileInboxStore) Close() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.file.Close()
+}
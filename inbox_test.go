@@ -0,0 +1,94 @@
+package mqttconn
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInbox(t *testing.T) {
+	dir, err := os.MkdirTemp("", "inbox")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "inbox")
+	store, err := NewFileInboxStore(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	config := InboxConfig{Key: func(msg *Message) string { return string(msg.Payload) }}
+	inbox := NewInbox(store, config)
+	var handled []string
+	handle := func(msg *Message) error {
+		handled = append(handled, string(msg.Payload))
+		return nil
+	}
+	if err := inbox.Process(&Message{Payload: []byte("a")}, handle); err != nil {
+		t.Error(err)
+		return
+	}
+	failed := errors.New("failed")
+	if err := inbox.Process(&Message{Payload: []byte("b")}, func(*Message) error { return failed }); err != failed {
+		t.Error("expected the handler error, got", err)
+		return
+	}
+	store.Close()
+
+	// after a restart the redelivery of a is skipped, b wasn't processed and is handled
+	store, err = NewFileInboxStore(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer store.Close()
+	inbox = NewInbox(store, config)
+	for _, payload := range []string{"a", "b", "b"} {
+		if err := inbox.Process(&Message{Payload: []byte(payload), Duplicate: true}, handle); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if len(handled) != 2 || handled[0] != "a" || handled[1] != "b" {
+		t.Error("unexpected messages handled", handled)
+	}
+}
+
+func TestFileInboxStorePrune(t *testing.T) {
+	dir, err := os.MkdirTemp("", "inbox")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "inbox")
+	store, err := NewFileInboxStore(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	now := time.Now()
+	store.Record("old", now.Add(-time.Hour))
+	store.Record("new", now)
+	if err := store.Prune(now.Add(-time.Minute)); err != nil {
+		t.Error(err)
+		return
+	}
+	store.Record("newer", now)
+	store.Close()
+	store, err = NewFileInboxStore(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer store.Close()
+	for key, expected := range map[string]bool{"old": false, "new": true, "newer": true} {
+		if processed, _ := store.Processed(key); processed != expected {
+			t.Error("expected", key, "processed to be", expected)
+		}
+	}
+}
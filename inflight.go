@@ -0,0 +1,54 @@
+package mqttconn
+
+import (
+	"errors"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// WithMaxInFlight limits the publishes the broker has not acknowledged yet to n, so a slow broker can't make
+// unsent messages pile up in memory. Writes over the limit block until an acknowledgement frees a slot,
+// failing with a TimeoutError if that is after the write deadline
+func WithMaxInFlight(n int) Option {
+	return func(conn *MQTTConn) {
+		conn.inFlight = &inFlight{slots: make(chan struct{}, n)}
+	}
+}
+
+// inFlight is a semaphore of publishes waiting for their acknowledgement
+type inFlight struct {
+	slots chan struct{}
+}
+
+// acquire takes a slot for a publish, waiting until deadline, zero means no deadline
+func (inFlight *inFlight) acquire(clock Clock, deadline time.Time) error {
+	select {
+	case inFlight.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	timer := newDeadlineTimer(clock, deadline)
+	defer timer.stop()
+	if timer.passed() {
+		return &TimeoutError{errors.New("publish timed out waiting for the in-flight limit")}
+	}
+	for {
+		select {
+		case inFlight.slots <- struct{}{}:
+			return nil
+		case <-timer.C():
+			if timer.expired() {
+				return &TimeoutError{errors.New("publish timed out waiting for the in-flight limit")}
+			}
+		}
+	}
+}
+
+// release frees the slot of a publish once token completes, even if nobody waits for it anymore
+func (inFlight *inFlight) release(token mqtt.Token) {
+	go func() {
+		<-token.Done()
+		<-inFlight.slots
+	}()
+}
@@ -0,0 +1,86 @@
+package mqttconn
+
+import (
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// slowAckClient is a mqtt.Client whose publishes complete once acked is called
+type slowAckClient struct {
+	mqtt.Client
+	tokens chan *heldToken
+}
+
+func (client *slowAckClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	token := &heldToken{done: make(chan struct{})}
+	client.tokens <- token
+	return token
+}
+
+// heldToken is a mqtt.Token completing when done is closed
+type heldToken struct {
+	mqtt.Token
+	done chan struct{}
+}
+
+func (token *heldToken) Wait() bool {
+	<-token.done
+	return true
+}
+
+func (token *heldToken) WaitTimeout(d time.Duration) bool {
+	select {
+	case <-token.done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func (token *heldToken) Done() <-chan struct{} {
+	return token.done
+}
+
+func (token *heldToken) Error() error {
+	return nil
+}
+
+func TestMaxInFlight(t *testing.T) {
+	client := &slowAckClient{Client: newMemoryClient("TestMaxInFlight", mqtt.NewClientOptions()), tokens: make(chan *heldToken, 4)}
+	client.Connect()
+	conn, _ := CreateMQTTConn(client, WithMaxInFlight(1))
+	defer conn.Close()
+	conn.SetDefaultTopic("t")
+	conn.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+	// the first write waits for its acknowledgement until the deadline, but keeps the slot
+	if _, err := conn.Write([]byte("a")); err == nil {
+		t.Error("expected the unacknowledged write to time out")
+		return
+	}
+	first := <-client.tokens
+	conn.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err := conn.Write([]byte("b"))
+	if timeoutErr, ok := err.(*TimeoutError); !ok || !timeoutErr.Timeout() {
+		t.Error("expected a timeout waiting for the in-flight limit, got", err)
+		return
+	}
+	select {
+	case <-client.tokens:
+		t.Error("expected no publish over the in-flight limit")
+		return
+	default:
+	}
+	conn.SetWriteDeadline(time.Time{})
+	written := make(chan error, 1)
+	go func() {
+		_, err := conn.Write([]byte("c"))
+		written <- err
+	}()
+	close(first.done)
+	close((<-client.tokens).done)
+	if err := <-written; err != nil {
+		t.Error(err)
+	}
+}
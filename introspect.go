@@ -0,0 +1,62 @@
+package mqttconn
+
+import (
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// ServerProperties are the properties a MQTT v5 broker reports in CONNACK
+type ServerProperties struct {
+	ReceiveMaximum        uint16
+	MaximumQoS            byte
+	RetainAvailable       bool
+	MaximumPacketSize     uint32
+	AssignedClientID      string
+	TopicAliasMaximum     uint16
+	WildcardAvailable     bool
+	SubIDAvailable        bool
+	SharedSubAvailable    bool
+	ServerKeepAlive       uint16
+	ResponseInformation   string
+	ServerReference       string
+	ReasonString          string
+	UserProperties        map[string]string
+	SessionExpiryInterval uint32
+}
+
+// UnderlyingClient returns the mqtt.Client the connection publishes and subscribes with
+func (conn *MQTTConn) UnderlyingClient() mqtt.Client {
+	return conn.Client
+}
+
+// BrokerURL returns the url of the broker the client was configured with, or "" if there is none
+// when several brokers are configured it is the first one, paho does not tell which one it connected to
+func (conn *MQTTConn) BrokerURL() string {
+	options := conn.Client.OptionsReader()
+	servers := options.Servers()
+	if len(servers) == 0 {
+		return ""
+	}
+	return servers[0].String()
+}
+
+// ClientID returns the client identifier sent to the broker
+func (conn *MQTTConn) ClientID() string {
+	options := conn.Client.OptionsReader()
+	return options.ClientID()
+}
+
+// NegotiatedProtocolVersion returns the MQTT protocol version in use, 4 for 3.1.1, 3 for 3.1 and 5 with WithMQTT5
+// paho falls back to 3 if the broker rejects 3.1.1, it is 0 before the first connect
+func (conn *MQTTConn) NegotiatedProtocolVersion() uint {
+	options := conn.Client.OptionsReader()
+	return options.ProtocolVersion()
+}
+
+// ServerProperties returns the properties the broker sent in CONNACK
+// they only exist in MQTT v5, so it is nil unless the conn was dialed with WithMQTT5
+func (conn *MQTTConn) ServerProperties() *ServerProperties {
+	if client, ok := conn.Client.(*mqtt5Client); ok {
+		return client.serverProperties()
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package mqttconn
+
+import (
+	"testing"
+)
+
+func TestIntrospection(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestIntrospection/topic")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	if conn.UnderlyingClient() == nil || !conn.UnderlyingClient().IsConnected() {
+		t.Error("expected a connected underlying client")
+		return
+	}
+	if url := conn.BrokerURL(); url != "mqtt+memory://TestIntrospection" {
+		t.Error("expected broker url mqtt+memory://TestIntrospection, got", url)
+		return
+	}
+	if conn.ClientID() == "" {
+		t.Error("expected a client id")
+		return
+	}
+	if version := conn.NegotiatedProtocolVersion(); version != 4 {
+		t.Error("expected protocol version 4, got", version)
+		return
+	}
+	if conn.ServerProperties() != nil {
+		t.Error("expected no server properties without MQTT v5")
+		return
+	}
+}
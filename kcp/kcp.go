@@ -0,0 +1,122 @@
+// Package kcp runs mqttconn streams over KCP, an ARQ tuned for latency, instead of relying on QoS 1
+// the Profile selects how eagerly lost packets are retransmitted, so it can be matched to the link
+package kcp
+
+import (
+	"net"
+	"time"
+
+	mqttconn "github.com/gyf304/go-mqttconn"
+	"github.com/xtaci/kcp-go/v5"
+)
+
+// Profile tunes the retransmission behavior of KCP
+type Profile struct {
+	// NoDelay retransmits on timeouts of 1.5 instead of 2 times the RTO
+	NoDelay bool
+	// Interval between KCP updates
+	Interval time.Duration
+	// Resend retransmits after this many acknowledgements of later packets, 0 disables fast retransmit
+	Resend int
+	// NoCongestion disables congestion control
+	NoCongestion bool
+	// SendWindow and ReceiveWindow are the window sizes in packets
+	SendWindow, ReceiveWindow int
+}
+
+var (
+	// Fast retransmits aggressively, for short links with little loss like a LAN
+	Fast = Profile{NoDelay: true, Interval: 10 * time.Millisecond, Resend: 2, NoCongestion: true, SendWindow: 128, ReceiveWindow: 128}
+	// Normal behaves like TCP, with congestion control
+	Normal = Profile{Interval: 40 * time.Millisecond, SendWindow: 32, ReceiveWindow: 32}
+	// LongDelay keeps a large window in flight, for links with a long round trip like satellite
+	LongDelay = Profile{Interval: 100 * time.Millisecond, Resend: 3, SendWindow: 1024, ReceiveWindow: 1024}
+)
+
+// apply sets up session with profile
+func (profile Profile) apply(session *kcp.UDPSession) {
+	session.SetStreamMode(true)
+	session.SetNoDelay(btoi(profile.NoDelay), int(profile.Interval/time.Millisecond), profile.Resend, btoi(profile.NoCongestion))
+	session.SetWindowSize(profile.SendWindow, profile.ReceiveWindow)
+}
+
+// btoi returns 1 for true
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Dial opens a stream to the Listener with the inbox remote, receiving on inbox
+// the stream takes over conn and closes it on Close, conn is best dialed with QoS 0
+func Dial(conn *mqttconn.MQTTConn, inbox, remote string, profile Profile) (net.Conn, error) {
+	packetConn, err := mqttconn.NewPacketConn(conn, inbox)
+	if err != nil {
+		return nil, err
+	}
+	session, err := kcp.NewConn2(mqttconn.TopicAddr(remote), nil, 0, 0, packetConn)
+	if err != nil {
+		packetConn.Close()
+		return nil, err
+	}
+	profile.apply(session)
+	return &ownedSession{UDPSession: session, packetConn: packetConn}, nil
+}
+
+// ownedSession closes the PacketConn of a dialed session with it
+type ownedSession struct {
+	*kcp.UDPSession
+	packetConn *mqttconn.PacketConn
+}
+
+// Close implements net.Conn.Close
+func (session *ownedSession) Close() error {
+	err := session.UDPSession.Close()
+	session.packetConn.Close()
+	return err
+}
+
+// Listener accepts streams dialed with Dial, it implements net.Listener
+type Listener struct {
+	listener *kcp.Listener
+	profile  Profile
+}
+
+// Listen accepts streams sent to inbox, it takes over conn and closes it on Close
+func Listen(conn *mqttconn.MQTTConn, inbox string, profile Profile) (*Listener, error) {
+	packetConn, err := mqttconn.NewPacketConn(conn, inbox)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := kcp.ServeConn(nil, 0, 0, packetConn)
+	if err != nil {
+		packetConn.Close()
+		return nil, err
+	}
+	return &Listener{listener: listener, profile: profile}, nil
+}
+
+// Accept implements net.Listener.Accept
+func (listener *Listener) Accept() (net.Conn, error) {
+	session, err := listener.listener.AcceptKCP()
+	if err != nil {
+		return nil, err
+	}
+	listener.profile.apply(session)
+	return session, nil
+}
+
+// Close implements net.Listener.Close
+func (listener *Listener) Close() error {
+	err := listener.listener.Close()
+	listener.listener.Control(func(conn net.PacketConn) error {
+		return conn.Close()
+	})
+	return err
+}
+
+// Addr implements net.Listener.Addr, it is the inbox
+func (listener *Listener) Addr() net.Addr {
+	return listener.listener.Addr()
+}
@@ -0,0 +1,69 @@
+package kcp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	mqttconn "github.com/gyf304/go-mqttconn"
+)
+
+func TestKCP(t *testing.T) {
+	for name, profile := range map[string]Profile{"Fast": Fast, "Normal": Normal, "LongDelay": LongDelay} {
+		t.Run(name, func(t *testing.T) {
+			serverConn, err := mqttconn.DialMQTT("mqtt+memory://TestKCP" + name + "?qos=0")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			listener, err := Listen(serverConn, "kcp/server", profile)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer listener.Close()
+			clientConn, err := mqttconn.DialMQTT("mqtt+memory://TestKCP" + name + "?qos=0")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			stream, err := Dial(clientConn, "kcp/client", "kcp/server", profile)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer stream.Close()
+
+			payload := bytes.Repeat([]byte("kcp"), 10000)
+			done := make(chan error, 1)
+			go func() {
+				accepted, err := listener.Accept()
+				if err != nil {
+					done <- err
+					return
+				}
+				defer accepted.Close()
+				accepted.SetReadDeadline(time.Now().Add(5 * time.Second))
+				received := make([]byte, len(payload))
+				if _, err := io.ReadFull(accepted, received); err != nil {
+					done <- err
+					return
+				}
+				if !bytes.Equal(received, payload) {
+					done <- errors.New("unexpected payload")
+					return
+				}
+				done <- nil
+			}()
+			if _, err := stream.Write(payload); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := <-done; err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package mqttconn
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KVEvent is a change of a key seen by KV.Watch
+type KVEvent struct {
+	Key   string
+	Value []byte
+	// Deleted is set when the key was removed, Value is nil then
+	Deleted bool
+}
+
+// KV is a key-value store backed by retained messages under a topic prefix,
+// key k is stored as the retained message of prefix/k and deleted with an empty retained message
+// KV keeps a local copy of every key, kept up to date by a prefix/# subscription.
+// Stores on one conn share the subscription, retained messages the broker resends for another store are ignored
+type KV struct {
+	conn   *MQTTConn
+	prefix string
+	sub    *localSubscription
+
+	mu       sync.Mutex
+	values   map[string][]byte
+	watchers map[*kvWatcher]struct{}
+	done     chan struct{}
+	// sending is held while events are sent, so watch channels are never closed mid-send
+	sending sync.Mutex
+}
+
+type kvWatcher struct {
+	filter string
+	events chan KVEvent
+	done   <-chan struct{}
+}
+
+// NewKV opens the store under prefix, it returns once the current retained values
+// have been loaded or ctx is done
+func NewKV(ctx context.Context, conn *MQTTConn, prefix string) (*KV, error) {
+	sub, err := conn.subscribeQueue(prefix+"/#", 1)
+	if err != nil {
+		return nil, err
+	}
+	kv := &KV{
+		conn:     conn,
+		prefix:   prefix,
+		sub:      sub,
+		values:   make(map[string][]byte),
+		watchers: make(map[*kvWatcher]struct{}),
+		done:     make(chan struct{}),
+	}
+	// retained messages arrive right after subscribing, wait until they stop
+	if err := conn.loadRetained(ctx, sub, kv.update); err != nil {
+		kv.Close()
+		return nil, err
+	}
+	conn.goLabeled(kv.run)
+	return kv, nil
+}
+
+// run applies updates until the store is closed
+func (kv *KV) run() {
+	for {
+		msg, err := kv.sub.queue.next(context.Background(), time.Time{}, true)
+		if err != nil {
+			return
+		}
+		kv.update(msg)
+	}
+}
+
+// update applies a received message and notifies watchers
+func (kv *KV) update(msg *Message) {
+	key := strings.TrimPrefix(msg.Topic, kv.prefix+"/")
+	event := KVEvent{Key: key, Value: msg.Payload, Deleted: len(msg.Payload) == 0}
+	kv.mu.Lock()
+	previous, existed := kv.values[key]
+	unchanged := !existed && event.Deleted || existed && !event.Deleted && bytes.Equal(previous, msg.Payload)
+	if unchanged {
+		// retained messages are resent whenever the prefix is subscribed to again
+		kv.mu.Unlock()
+		return
+	}
+	if event.Deleted {
+		event.Value = nil
+		delete(kv.values, key)
+	} else {
+		kv.values[key] = msg.Payload
+	}
+	var watchers []*kvWatcher
+	for watcher := range kv.watchers {
+		if MatchTopic(watcher.filter, key) {
+			watchers = append(watchers, watcher)
+		}
+	}
+	kv.mu.Unlock()
+	kv.sending.Lock()
+	defer kv.sending.Unlock()
+	for _, watcher := range watchers {
+		select {
+		case watcher.events <- event:
+		case <-watcher.done:
+		case <-kv.done:
+		}
+	}
+}
+
+// Get returns the value of key from the local copy
+func (kv *KV) Get(key string) ([]byte, bool) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	value, ok := kv.values[key]
+	return value, ok
+}
+
+// Keys returns every key currently in the store
+func (kv *KV) Keys() []string {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	keys := make([]string, 0, len(kv.values))
+	for key := range kv.values {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Set stores value under key, the local copy is updated once the broker echoes it back
+// an empty value deletes the key
+func (kv *KV) Set(key string, value []byte) error {
+	topic := kv.prefix + "/" + key
+	if err := validateTopic(topic); err != nil {
+		return err
+	}
+	return kv.conn.publishWithRetry(&outgoing{
+		topic:    topic,
+		qos:      1,
+		retained: true,
+		payload:  value,
+	})
+}
+
+// Delete removes key
+func (kv *KV) Delete(key string) error {
+	return kv.Set(key, nil)
+}
+
+// Watch returns a channel receiving changes of keys matching filter, which may use wildcards,
+// e.g. "#" for every key. The channel is closed once ctx is done or the store is closed
+// events are delivered in order, a watcher that stops reading holds up every other watcher
+func (kv *KV) Watch(ctx context.Context, filter string) (<-chan KVEvent, error) {
+	_, events, err := kv.watchSnapshot(ctx, filter)
+	return events, err
+}
+
+// watchSnapshot is Watch also returning the current values of keys matching filter,
+// events start right after the snapshot
+func (kv *KV) watchSnapshot(ctx context.Context, filter string) (map[string][]byte, <-chan KVEvent, error) {
+	if err := validateFilter(filter); err != nil {
+		return nil, nil, err
+	}
+	watcher := &kvWatcher{
+		filter: filter,
+		events: make(chan KVEvent, 16),
+		done:   ctx.Done(),
+	}
+	snapshot := make(map[string][]byte)
+	kv.mu.Lock()
+	for key, value := range kv.values {
+		if MatchTopic(filter, key) {
+			snapshot[key] = value
+		}
+	}
+	kv.watchers[watcher] = struct{}{}
+	kv.mu.Unlock()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-kv.done:
+		}
+		kv.mu.Lock()
+		delete(kv.watchers, watcher)
+		kv.mu.Unlock()
+		kv.sending.Lock()
+		close(watcher.events)
+		kv.sending.Unlock()
+	}()
+	return snapshot, watcher.events, nil
+}
+
+// Close stops updating the store and closes every watch channel
+func (kv *KV) Close() error {
+	kv.mu.Lock()
+	select {
+	case <-kv.done:
+		kv.mu.Unlock()
+		return nil
+	default:
+		close(kv.done)
+	}
+	kv.mu.Unlock()
+	return kv.sub.close()
+}
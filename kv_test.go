@@ -0,0 +1,93 @@
+package mqttconn
+
+import (
+	"context"
+	"testing"
+)
+
+func TestKV(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestKV")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	conn.Client.Publish("shadow/device1", 1, true, "on").Wait()
+	kv, err := NewKV(context.Background(), conn, "shadow")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer kv.Close()
+	value, ok := kv.Get("device1")
+	if !ok || string(value) != "on" {
+		t.Error("expected device1 to be on, got", string(value))
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := kv.Watch(ctx, "#")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	kv.Set("device2", []byte("off"))
+	event := <-events
+	if event.Key != "device2" || string(event.Value) != "off" {
+		t.Error("unexpected event", event)
+		return
+	}
+	kv.Delete("device1")
+	event = <-events
+	if event.Key != "device1" || !event.Deleted {
+		t.Error("expected device1 to be deleted, got", event)
+		return
+	}
+	if _, ok := kv.Get("device1"); ok {
+		t.Error("expected device1 to be gone")
+		return
+	}
+	cancel()
+	for range events {
+	}
+}
+
+func TestKVSharedPrefix(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestKVSharedPrefix")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	conn.Client.Publish("shadow/device1", 1, true, "on").Wait()
+	first, err := NewKV(context.Background(), conn, "shadow")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer first.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := first.Watch(ctx, "#")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// the second store resubscribes, which resends device1 to the first one
+	second, err := NewKV(context.Background(), conn, "shadow")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer second.Close()
+	second.Set("device2", []byte("off"))
+	if event := <-events; event.Key != "device2" {
+		t.Error("expected only the change of device2, got", event)
+		return
+	}
+	second.Close()
+	first.Set("device3", []byte("on"))
+	if event := <-events; event.Key != "device3" {
+		t.Error("expected the first store to keep receiving, got", event)
+	}
+}
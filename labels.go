@@ -0,0 +1,19 @@
+package mqttconn
+
+import (
+	"context"
+	"runtime/pprof"
+)
+
+// pprof label keys of goroutines working for a MQTTConn
+const (
+	labelClient = "mqttconn.client"
+	labelTopic  = "mqttconn.topic"
+)
+
+// goLabeled runs f in a new goroutine carrying the pprof labels of conn
+func (conn *MQTTConn) goLabeled(f func()) {
+	go pprof.Do(context.Background(), conn.labels, func(context.Context) {
+		f()
+	})
+}
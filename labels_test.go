@@ -0,0 +1,24 @@
+package mqttconn
+
+import (
+	"bytes"
+	"runtime/pprof"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPprofLabels(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestPprofLabels/labeled", WithIdleTimeout(time.Hour, nil))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	var profile bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&profile, 1)
+	if !strings.Contains(profile.String(), `"mqttconn.topic":"labeled"`) {
+		t.Error("expected labeled goroutines")
+		return
+	}
+}
@@ -0,0 +1,76 @@
+package mqttconn
+
+import (
+	"container/list"
+	"sync"
+)
+
+// WithLatestCache remembers the latest payload received on every topic for Latest,
+// up to maxTopics topics, forgetting the least recently updated first, zero means no limit.
+// An empty payload clears the topic, like an empty retained message clears it at the broker.
+// Brokers don't flag retained messages to existing subscribers, so every empty message counts
+func WithLatestCache(maxTopics int) Option {
+	return func(conn *MQTTConn) {
+		conn.latest = &latestCache{
+			max:     maxTopics,
+			byTopic: make(map[string]*list.Element),
+			order:   list.New(),
+		}
+	}
+}
+
+// latestEntry is the latest payload of a topic
+type latestEntry struct {
+	topic   string
+	payload []byte
+}
+
+// latestCache holds the latest payloads, order lists the topics from the least to the most recently updated
+type latestCache struct {
+	max int
+
+	mu      sync.Mutex
+	byTopic map[string]*list.Element
+	order   *list.List
+}
+
+// store remembers the payload of msg
+func (cache *latestCache) store(msg *Message) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	element, ok := cache.byTopic[msg.Topic]
+	if len(msg.Payload) == 0 {
+		if ok {
+			cache.order.Remove(element)
+			delete(cache.byTopic, msg.Topic)
+		}
+		return
+	}
+	if ok {
+		element.Value.(*latestEntry).payload = msg.Payload
+		cache.order.MoveToBack(element)
+		return
+	}
+	cache.byTopic[msg.Topic] = cache.order.PushBack(&latestEntry{topic: msg.Topic, payload: msg.Payload})
+	if cache.max > 0 && cache.order.Len() > cache.max {
+		oldest := cache.order.Front()
+		cache.order.Remove(oldest)
+		delete(cache.byTopic, oldest.Value.(*latestEntry).topic)
+	}
+}
+
+// Latest returns the latest payload received on topic, with WithLatestCache
+// ok is false if nothing was received on topic, or it was forgotten
+func (conn *MQTTConn) Latest(topic string) (payload []byte, ok bool) {
+	if conn.latest == nil {
+		return nil, false
+	}
+	cache := conn.latest
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	element, ok := cache.byTopic[topic]
+	if !ok {
+		return nil, false
+	}
+	return element.Value.(*latestEntry).payload, true
+}
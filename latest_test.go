@@ -0,0 +1,46 @@
+package mqttconn
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatestCache(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestLatestCache", WithLatestCache(2))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	conn.queue = newMessageQueue(0, conn.clock)
+	if _, err := conn.Subscribe("state/#", 0); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, ok := conn.Latest("state/a"); ok {
+		t.Error("expected nothing cached yet")
+		return
+	}
+	conn.WriteTo([]byte("a1"), TopicAddr("state/a"))
+	conn.WriteTo([]byte("a2"), TopicAddr("state/a"))
+	conn.WriteTo([]byte("b1"), TopicAddr("state/b"))
+	conn.WriteTo([]byte("c1"), TopicAddr("state/c"))
+	for conn.Stats().MessagesReceived < 4 {
+		time.Sleep(time.Millisecond)
+	}
+	// a is the least recently updated, so it was forgotten
+	for topic, expected := range map[string]string{"state/a": "", "state/b": "b1", "state/c": "c1"} {
+		payload, ok := conn.Latest(topic)
+		if ok != (expected != "") || string(payload) != expected {
+			t.Error("expected", expected, "for", topic, "got", string(payload), ok)
+			return
+		}
+	}
+	conn.Client.Publish("state/b", 0, true, []byte{}).Wait()
+	for conn.Stats().MessagesReceived < 5 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := conn.Latest("state/b"); ok {
+		t.Error("expected the empty message to clear the topic")
+	}
+}
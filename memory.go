@@ -0,0 +1,343 @@
+package mqttconn
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/pkg/errors"
+)
+
+// memoryScheme is the DialMQTT scheme connecting to an in-process hub
+// as in mqtt+memory://hubname/topic
+const memoryScheme = "mqtt+memory"
+
+// memoryHubs holds every named in-process hub, hubs live as long as the process
+var memoryHubs = struct {
+	sync.Mutex
+	hubs map[string]*memoryHub
+}{hubs: make(map[string]*memoryHub)}
+
+// getMemoryHub returns the hub with name, creating it if necessary
+func getMemoryHub(name string) *memoryHub {
+	memoryHubs.Lock()
+	defer memoryHubs.Unlock()
+	hub, ok := memoryHubs.hubs[name]
+	if !ok {
+		hub = &memoryHub{
+			subscriptions: make(map[*memoryClient]map[string]byte),
+			retained:      make(map[string]*memoryMessage),
+		}
+		memoryHubs.hubs[name] = hub
+	}
+	return hub
+}
+
+// memoryHub is a minimal in-process broker
+type memoryHub struct {
+	mu            sync.Mutex
+	subscriptions map[*memoryClient]map[string]byte
+	retained      map[string]*memoryMessage
+	lastID        uint16
+}
+
+// publish routes msg to every matching subscription and updates retained messages
+func (hub *memoryHub) publish(msg *memoryMessage) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if msg.retained {
+		if len(msg.payload) == 0 {
+			delete(hub.retained, msg.topic)
+		} else {
+			hub.retained[msg.topic] = msg
+		}
+	}
+	for client, filters := range hub.subscriptions {
+		for filter, qos := range filters {
+			if MatchTopic(filter, msg.topic) {
+				// the retained flag is only set for messages sent because of a new subscription
+				client.dispatch(filter, hub.forSubscriber(msg, qos, false))
+			}
+		}
+	}
+}
+
+// subscribe adds a subscription and sends the matching retained messages
+func (hub *memoryHub) subscribe(client *memoryClient, filter string, qos byte) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	filters, ok := hub.subscriptions[client]
+	if !ok {
+		filters = make(map[string]byte)
+		hub.subscriptions[client] = filters
+	}
+	filters[filter] = qos
+	for topic, msg := range hub.retained {
+		if MatchTopic(filter, topic) {
+			client.dispatch(filter, hub.forSubscriber(msg, qos, true))
+		}
+	}
+}
+
+func (hub *memoryHub) unsubscribe(client *memoryClient, filters ...string) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if len(filters) == 0 {
+		delete(hub.subscriptions, client)
+		return
+	}
+	for _, filter := range filters {
+		delete(hub.subscriptions[client], filter)
+	}
+}
+
+// forSubscriber copies msg with the QoS downgraded to the subscription QoS, must be called with mu held
+func (hub *memoryHub) forSubscriber(msg *memoryMessage, qos byte, retained bool) *memoryMessage {
+	if msg.qos < qos {
+		qos = msg.qos
+	}
+	var messageID uint16
+	if qos > 0 {
+		hub.lastID++
+		messageID = hub.lastID
+	}
+	return &memoryMessage{
+		topic:     msg.topic,
+		payload:   msg.payload,
+		qos:       qos,
+		retained:  retained,
+		messageID: messageID,
+	}
+}
+
+// memoryMessage implements mqtt.Message
+type memoryMessage struct {
+	topic     string
+	payload   []byte
+	qos       byte
+	retained  bool
+	messageID uint16
+}
+
+func (msg *memoryMessage) Duplicate() bool   { return false }
+func (msg *memoryMessage) Qos() byte         { return msg.qos }
+func (msg *memoryMessage) Retained() bool    { return msg.retained }
+func (msg *memoryMessage) Topic() string     { return msg.topic }
+func (msg *memoryMessage) MessageID() uint16 { return msg.messageID }
+func (msg *memoryMessage) Payload() []byte   { return msg.payload }
+func (msg *memoryMessage) Ack()              {}
+
+// memoryToken is an already completed mqtt.Token
+type memoryToken struct {
+	err error
+}
+
+func (token *memoryToken) Wait() bool                     { return true }
+func (token *memoryToken) WaitTimeout(time.Duration) bool { return true }
+func (token *memoryToken) Error() error                   { return token.err }
+func (token *memoryToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+// memoryClient is a mqtt.Client connected to a memoryHub
+// messages are handed to callbacks in order on a dedicated goroutine, like paho does
+// lock order is memoryHub.mu before memoryClient.mu
+type memoryClient struct {
+	hub     *memoryHub
+	options *mqtt.ClientOptions
+
+	mu        sync.Mutex
+	connected bool
+	handlers  map[string]mqtt.MessageHandler
+	pending   []func()
+	wake      chan struct{}
+	done      chan struct{}
+}
+
+func newMemoryClient(hubName string, options *mqtt.ClientOptions) *memoryClient {
+	return &memoryClient{
+		hub:      getMemoryHub(hubName),
+		options:  options,
+		handlers: make(map[string]mqtt.MessageHandler),
+	}
+}
+
+// IsConnected implements mqtt.Client.IsConnected
+func (client *memoryClient) IsConnected() bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.connected
+}
+
+// IsConnectionOpen implements mqtt.Client.IsConnectionOpen
+func (client *memoryClient) IsConnectionOpen() bool {
+	return client.IsConnected()
+}
+
+// Connect implements mqtt.Client.Connect
+func (client *memoryClient) Connect() mqtt.Token {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if !client.connected {
+		// there is no authentication, but credentials are requested like paho does
+		if client.options.CredentialsProvider != nil {
+			client.options.CredentialsProvider()
+		}
+		if client.options.ProtocolVersion == 0 {
+			// the hub has 3.1.1 semantics, paho settles on 4 the same way
+			client.options.ProtocolVersion = 4
+		}
+		client.connected = true
+		client.wake = make(chan struct{}, 1)
+		client.done = make(chan struct{})
+		go client.run(client.wake, client.done)
+		if client.options.OnConnect != nil {
+			go client.options.OnConnect(client)
+		}
+	}
+	return &memoryToken{}
+}
+
+// Disconnect implements mqtt.Client.Disconnect
+// sessions are not persisted, so all subscriptions are removed
+func (client *memoryClient) Disconnect(quiesce uint) {
+	client.mu.Lock()
+	if !client.connected {
+		client.mu.Unlock()
+		return
+	}
+	client.connected = false
+	client.pending = nil
+	close(client.done)
+	client.mu.Unlock()
+	client.hub.unsubscribe(client)
+}
+
+// Publish implements mqtt.Client.Publish
+func (client *memoryClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	if !client.IsConnected() {
+		return &memoryToken{mqtt.ErrNotConnected}
+	}
+	data, err := payloadBytes(payload)
+	if err != nil {
+		return &memoryToken{err}
+	}
+	client.hub.publish(&memoryMessage{
+		topic:    topic,
+		payload:  data,
+		qos:      qos,
+		retained: retained,
+	})
+	return &memoryToken{}
+}
+
+// payloadBytes copies a payload passed to mqtt.Client.Publish, accepting the types paho does
+func payloadBytes(payload interface{}) ([]byte, error) {
+	switch p := payload.(type) {
+	case string:
+		return []byte(p), nil
+	case []byte:
+		return append([]byte(nil), p...), nil
+	case bytes.Buffer:
+		return append([]byte(nil), p.Bytes()...), nil
+	case *bytes.Buffer:
+		return append([]byte(nil), p.Bytes()...), nil
+	}
+	return nil, errors.New("unknown payload type")
+}
+
+// Subscribe implements mqtt.Client.Subscribe
+func (client *memoryClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	return client.SubscribeMultiple(map[string]byte{topic: qos}, callback)
+}
+
+// SubscribeMultiple implements mqtt.Client.SubscribeMultiple
+func (client *memoryClient) SubscribeMultiple(filters map[string]byte, callback mqtt.MessageHandler) mqtt.Token {
+	if !client.IsConnected() {
+		return &memoryToken{mqtt.ErrNotConnected}
+	}
+	for filter, qos := range filters {
+		if callback != nil {
+			client.AddRoute(filter, callback)
+		}
+		client.hub.subscribe(client, filter, qos)
+	}
+	return &memoryToken{}
+}
+
+// Unsubscribe implements mqtt.Client.Unsubscribe
+func (client *memoryClient) Unsubscribe(topics ...string) mqtt.Token {
+	if !client.IsConnected() {
+		return &memoryToken{mqtt.ErrNotConnected}
+	}
+	client.mu.Lock()
+	for _, topic := range topics {
+		delete(client.handlers, topic)
+	}
+	client.mu.Unlock()
+	if len(topics) > 0 {
+		client.hub.unsubscribe(client, topics...)
+	}
+	return &memoryToken{}
+}
+
+// AddRoute implements mqtt.Client.AddRoute
+func (client *memoryClient) AddRoute(topic string, callback mqtt.MessageHandler) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.handlers[topic] = callback
+}
+
+// OptionsReader implements mqtt.Client.OptionsReader
+func (client *memoryClient) OptionsReader() mqtt.ClientOptionsReader {
+	return mqtt.NewOptionsReader(client.options)
+}
+
+// dispatch queues msg for the handler of filter
+func (client *memoryClient) dispatch(filter string, msg *memoryMessage) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if !client.connected {
+		return
+	}
+	client.pending = append(client.pending, func() {
+		client.mu.Lock()
+		handler := client.handlers[filter]
+		client.mu.Unlock()
+		if handler == nil {
+			handler = client.options.DefaultPublishHandler
+		}
+		if handler != nil {
+			handler(client, msg)
+		}
+	})
+	select {
+	case client.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run calls handlers for pending messages until done is closed
+func (client *memoryClient) run(wake <-chan struct{}, done <-chan struct{}) {
+	for {
+		select {
+		case <-wake:
+		case <-done:
+			return
+		}
+		for {
+			client.mu.Lock()
+			if len(client.pending) == 0 || client.done != done {
+				client.mu.Unlock()
+				break
+			}
+			deliver := client.pending[0]
+			client.pending = client.pending[1:]
+			client.mu.Unlock()
+			deliver()
+		}
+	}
+}
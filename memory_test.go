@@ -0,0 +1,69 @@
+package mqttconn
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryHub(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestMemoryHub/test")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	other, err := DialMQTT("mqtt+memory://TestMemoryHub")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer other.Close()
+	other.Subscribe("test/#", 1)
+	expectedRead := "mqttconn test"
+	for i := 0; i < 3; i++ {
+		_, err = conn.Write([]byte(expectedRead))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	readBuf := make([]byte, 64)
+	for _, reader := range []*MQTTConn{conn, other} {
+		reader.SetReadDeadline(time.Now().Add(time.Second))
+		n, addr, err := reader.ReadFrom(readBuf)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if addr.String() != "test" {
+			t.Error("expected addr test, got", addr.String())
+			return
+		}
+		if string(readBuf[:n]) != expectedRead {
+			t.Error("expected read", expectedRead, "actual read", string(readBuf[:n]))
+			return
+		}
+	}
+}
+
+func TestMemoryHubRetained(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestMemoryHubRetained")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	conn.Client.Publish("state", 1, true, []byte("on")).Wait()
+	conn.Subscribe("state", 1)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	msg, err := conn.queue.next(context.Background(), conn.readDeadline, true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !msg.Retained || string(msg.Payload) != "on" {
+		t.Error("expected retained message on, got", msg.Retained, string(msg.Payload))
+		return
+	}
+}
@@ -0,0 +1,149 @@
+package mqttconn
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Message is a message received by a MQTTConn
+type Message struct {
+	Topic     string
+	Payload   []byte
+	QoS       byte
+	Retained  bool
+	Duplicate bool
+	MessageID uint16
+	// MatchedFilter is the filter of the subscription the message arrived on, see MatchTopic
+	MatchedFilter string
+	// Received is the local time the message arrived, differences to the time it is read are queueing delays
+	Received time.Time
+	// Sent is the time a writer using WithSendTime published the message, zero if unknown,
+	// Received - Sent is the end-to-end latency, give or take the clock offset of the writer
+	Sent time.Time
+	// Local is set for copies of own writes made by WithLocalEcho, they never went through the broker
+	Local bool
+	// ContentType, CorrelationID and Headers are the metadata of WithEnvelope, or the MQTT 5 properties with WithMQTT5
+	ContentType   string
+	CorrelationID string
+	Headers       map[string]string
+
+	// settled is set atomically by the first Ack or Nack
+	settled  int32
+	ack      func()
+	conn     *MQTTConn
+	nacks    int
+	priority Priority
+	// buffer is set for messages of SubscribeBuffered
+	buffer *subscriptionBuffer
+}
+
+// newMessage converts a message delivered by paho for the subscription to filter
+func (conn *MQTTConn) newMessage(msg mqtt.Message, filter string) *Message {
+	received := &Message{
+		Topic:         conn.localTopic(msg.Topic()),
+		MatchedFilter: filter,
+		Payload:       msg.Payload(),
+		QoS:           msg.Qos(),
+		Retained:      msg.Retained(),
+		Duplicate:     msg.Duplicate(),
+		MessageID:     msg.MessageID(),
+		ack:           msg.Ack,
+		conn:          conn,
+		Received:      conn.clock.Now(),
+	}
+	if properties, ok := msg.(metadataMessage); ok {
+		properties.metadata(received)
+		takeSent(received)
+	}
+	return received
+}
+
+// headerSentAt is the header, or MQTT 5 user property, carrying Message.Sent in RFC 3339 format
+const headerSentAt = "sent-at"
+
+// stampSent returns a copy of metadata with the send time t in the headers, metadata may be nil
+func stampSent(metadata *Message, t time.Time) *Message {
+	stamped := &Message{Headers: map[string]string{headerSentAt: t.UTC().Format(time.RFC3339Nano)}}
+	if metadata != nil {
+		stamped.ContentType, stamped.CorrelationID = metadata.ContentType, metadata.CorrelationID
+		for name, value := range metadata.Headers {
+			if name != headerSentAt {
+				stamped.Headers[name] = value
+			}
+		}
+	}
+	return stamped
+}
+
+// takeSent moves the send time from the headers of a received message to Sent
+func takeSent(msg *Message) {
+	value, ok := msg.Headers[headerSentAt]
+	if !ok {
+		return
+	}
+	if sent, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		msg.Sent = sent
+	}
+	delete(msg.Headers, headerSentAt)
+	if len(msg.Headers) == 0 {
+		msg.Headers = nil
+	}
+}
+
+// echo queues a local copy of a payload written to topic, see WithLocalEcho
+func (conn *MQTTConn) echo(topic string, payload []byte, qos byte, retained bool) {
+	conn.queue.pushBack(&Message{
+		Topic:    topic,
+		Payload:  append([]byte(nil), payload...),
+		QoS:      qos,
+		Retained: retained,
+		Received: conn.clock.Now(),
+		Local:    true,
+		conn:     conn,
+	})
+}
+
+// Ack acknowledges the message to the broker
+// this is only needed with WithManualAck, otherwise paho acknowledges on receipt
+// only the first call of Ack or Nack has any effect
+func (msg *Message) Ack() {
+	if atomic.CompareAndSwapInt32(&msg.settled, 0, 1) && msg.ack != nil {
+		msg.ack()
+	}
+}
+
+// Nack rejects the message without acknowledging it
+// if requeue is set the message is put back at the head of the read queue and delivered again,
+// otherwise it is left unacknowledged so the broker redelivers it on the next session
+// with WithDeadLetter, a message nacked too often is moved to the dead-letter topic instead
+// only the first call of Ack or Nack has any effect
+func (msg *Message) Nack(requeue bool) {
+	if atomic.CompareAndSwapInt32(&msg.settled, 0, 1) && msg.conn != nil {
+		msg.conn.nack(msg, requeue)
+	}
+}
+
+// nack implements Message.Nack
+func (conn *MQTTConn) nack(msg *Message, requeue bool) {
+	nacks := msg.nacks + 1
+	if conn.deadLetterTopic != "" && nacks > conn.deadLetterMaxNacks {
+		err := conn.deadLetter(msg, fmt.Sprintf("nacked %d times", nacks))
+		if err == nil {
+			if msg.ack != nil {
+				msg.ack()
+			}
+			return
+		}
+	}
+	if requeue {
+		// the copy keeps Received, so WithMessageTTL counts from the first arrival, and the other metadata
+		requeued := *msg
+		requeued.settled = 0
+		requeued.nacks = nacks
+		requeued.Duplicate = true
+		conn.queue.pushFront(&requeued)
+	}
+}
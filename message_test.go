@@ -0,0 +1,180 @@
+package mqttconn
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMessageNack(t *testing.T) {
+	conn := newMQTTConn(nil)
+	acked := 0
+	conn.queue.push(&Message{
+		Topic:   "test",
+		Payload: []byte("payload"),
+		ack:     func() { acked++ },
+		conn:    conn,
+	})
+	msg, err := conn.ReadMsg(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	msg.Nack(true)
+	msg.Ack()
+	if acked != 0 {
+		t.Error("expected settled message not to be acked")
+		return
+	}
+	redelivered, err := conn.ReadMsg(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !redelivered.Duplicate || string(redelivered.Payload) != "payload" {
+		t.Error("expected redelivered duplicate message")
+		return
+	}
+	redelivered.Ack()
+	redelivered.Ack()
+	if acked != 1 {
+		t.Error("expected 1 ack, got", acked)
+		return
+	}
+}
+
+func TestMessageReceived(t *testing.T) {
+	clock := NewManualClock(time.Unix(100, 0))
+	conn, err := DialMQTT("mqtt+memory://TestMessageReceived/topic", WithClock(clock))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte("now"))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	msg, err := conn.ReadMsg(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !msg.Received.Equal(clock.Now()) {
+		t.Error("expected receive time", clock.Now(), "got", msg.Received)
+		return
+	}
+}
+
+func TestLocalEcho(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestLocalEcho", WithLocalEcho())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	// nobody reads yet, the writer must not block on the read queue
+	for _, payload := range []string{"one", "two", "three"} {
+		if _, err := conn.WriteTo([]byte(payload), TopicAddr("echo")); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	for _, expected := range []string{"one", "two", "three"} {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		msg, err := conn.ReadMsg(ctx)
+		cancel()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(msg.Payload) != expected || msg.Topic != "echo" || !msg.Local {
+			t.Error("expected local", expected, "got", msg.Topic, string(msg.Payload), msg.Local)
+			return
+		}
+	}
+}
+
+func TestLocalEchoCoalesced(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestLocalEchoCoalesced", WithLocalEcho(), WithCoalescing(time.Hour, 1024))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.WriteTo([]byte("one"), TopicAddr("echo")); err != nil {
+		t.Error(err)
+		return
+	}
+	// nothing is echoed before the coalesced message is published
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	_, err = conn.ReadMsg(ctx)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Error("expected no echo before the flush, got", err)
+		return
+	}
+	if err := conn.Flush(); err != nil {
+		t.Error(err)
+		return
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	msg, err := conn.ReadMsg(ctx)
+	cancel()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(msg.Payload) != "one" || !msg.Local {
+		t.Error("expected local one, got", string(msg.Payload), msg.Local)
+	}
+}
+
+func TestMessageNackKeepsReceived(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	conn := newMQTTConn([]Option{WithClock(clock), WithMessageTTL(time.Minute)})
+	conn.queue.push(&Message{Topic: "test", Received: clock.Now(), conn: conn})
+	msg, err := conn.ReadMsg(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	msg.Nack(true)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	redelivered, err := conn.ReadMsg(ctx)
+	if err != nil {
+		t.Error("expected the requeued message to be within its TTL, got", err)
+		return
+	}
+	if !redelivered.Received.Equal(msg.Received) {
+		t.Error("expected the requeued message to keep its receive time")
+	}
+}
+
+func TestMessageNackKeepsMetadata(t *testing.T) {
+	conn := newMQTTConn(nil)
+	conn.queue.push(&Message{
+		Topic:         "sensors/1",
+		MatchedFilter: "sensors/+",
+		ContentType:   "application/json",
+		CorrelationID: "42",
+		Headers:       map[string]string{"source": "test"},
+		priority:      PriorityHigh,
+		conn:          conn,
+	})
+	msg, err := conn.ReadMsg(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	msg.Nack(true)
+	redelivered, err := conn.ReadMsg(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if redelivered.MatchedFilter != "sensors/+" || redelivered.ContentType != "application/json" ||
+		redelivered.CorrelationID != "42" || redelivered.Headers["source"] != "test" || redelivered.priority != PriorityHigh {
+		t.Error("expected the requeued message to keep its metadata, got", redelivered)
+	}
+}
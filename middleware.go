@@ -0,0 +1,141 @@
+package mqttconn
+
+import (
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Middleware wraps the handler of a route, see Router.Use
+type Middleware func(Handler) Handler
+
+// Use appends middlewares to the chain wrapping every route, the first one is the outermost
+func (router *Router) Use(middlewares ...Middleware) {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	router.middlewares = append(router.middlewares, middlewares...)
+}
+
+// Recover stops panics of handlers from taking down Serve, calling onPanic with the recovered value if it is not nil
+// the message is acknowledged like after a handler returning normally
+func Recover(onPanic func(req *Request, recovered interface{})) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(req *Request) {
+			defer func() {
+				if recovered := recover(); recovered != nil && onPanic != nil {
+					onPanic(req, recovered)
+				}
+			}()
+			next.ServeMQTT(req)
+		})
+	}
+}
+
+// Logging logs every handled message with its topic, route, size and handling time at info level
+func Logging(logger *slog.Logger) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(req *Request) {
+			start := time.Now()
+			next.ServeMQTT(req)
+			logger.LogAttrs(req.Context(), slog.LevelInfo, "mqtt message handled",
+				slog.String("topic", req.Topic),
+				slog.String("route", req.Pattern),
+				slog.Int("size", len(req.Payload)),
+				slog.Duration("duration", time.Since(start)),
+			)
+		})
+	}
+}
+
+// RouteStats are the counters of a route collected by RouteMetrics
+type RouteStats struct {
+	Messages int64
+	Panics   int64
+	// Duration is the total handling time
+	Duration time.Duration
+}
+
+// RouteMetrics collects RouteStats per route, see Middleware
+type RouteMetrics struct {
+	mu     sync.Mutex
+	routes map[string]*RouteStats
+}
+
+// NewRouteMetrics creates an empty RouteMetrics
+func NewRouteMetrics() *RouteMetrics {
+	return &RouteMetrics{routes: make(map[string]*RouteStats)}
+}
+
+// Middleware counts messages, panics and handling time of every route
+// panics are passed on, so it belongs inside Recover
+func (metrics *RouteMetrics) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(req *Request) {
+			start := time.Now()
+			panicked := true
+			defer func() {
+				metrics.mu.Lock()
+				stats := metrics.routes[req.Pattern]
+				if stats == nil {
+					stats = &RouteStats{}
+					metrics.routes[req.Pattern] = stats
+				}
+				stats.Messages++
+				stats.Duration += time.Since(start)
+				if panicked {
+					stats.Panics++
+				}
+				metrics.mu.Unlock()
+			}()
+			next.ServeMQTT(req)
+			panicked = false
+		})
+	}
+}
+
+// Routes returns the routes seen so far in order
+func (metrics *RouteMetrics) Routes() []string {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	routes := make([]string, 0, len(metrics.routes))
+	for route := range metrics.routes {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	return routes
+}
+
+// Stats returns the counters of route
+func (metrics *RouteMetrics) Stats(route string) RouteStats {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	if stats := metrics.routes[route]; stats != nil {
+		return *stats
+	}
+	return RouteStats{}
+}
+
+// Concurrent handles messages in their own goroutines, at most limit at a time
+// Serve blocks while limit handlers are running, and each message is acknowledged when its handler returns.
+// Messages are no longer handled in order, middlewares after Concurrent run in the new goroutines,
+// so Recover has to come after it to cover them
+func Concurrent(limit int) Middleware {
+	if limit < 1 {
+		limit = 1
+	}
+	running := make(chan struct{}, limit)
+	return func(next Handler) Handler {
+		return HandlerFunc(func(req *Request) {
+			running <- struct{}{}
+			req.detached = true
+			go func() {
+				defer func() {
+					req.done()
+					<-running
+				}()
+				next.ServeMQTT(req)
+			}()
+		})
+	}
+}
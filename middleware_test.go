@@ -0,0 +1,87 @@
+package mqttconn
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRouterMiddleware(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestRouterMiddleware")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	var logs bytes.Buffer
+	var logsMu sync.Mutex
+	logger := slog.New(slog.NewTextHandler(lockedWriter{&logs, &logsMu}, nil))
+	metrics := NewRouteMetrics()
+	panics := make(chan interface{}, 1)
+	done := make(chan struct{}, 2)
+	router := NewRouter()
+	router.Use(Concurrent(2), Recover(func(req *Request, recovered interface{}) {
+		panics <- recovered
+		done <- struct{}{}
+	}), Logging(logger), metrics.Middleware())
+	router.HandleFunc("jobs/+", func(req *Request) {
+		if req.Params[0] == "bad" {
+			panic("bad job")
+		}
+		done <- struct{}{}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go router.Serve(ctx, conn)
+	for subscribed := 0; subscribed < 1; time.Sleep(time.Millisecond) {
+		conn.subsMu.Lock()
+		subscribed = len(conn.subscribed)
+		conn.subsMu.Unlock()
+	}
+	conn.WriteTo([]byte("x"), TopicAddr("jobs/bad"))
+	conn.WriteTo([]byte("x"), TopicAddr("jobs/good"))
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error("expected both jobs to be handled")
+			return
+		}
+	}
+	if recovered := <-panics; recovered != "bad job" {
+		t.Error("expected recovered panic, got", recovered)
+		return
+	}
+	// the deferred metrics update may still be running
+	deadline := time.Now().Add(time.Second)
+	for metrics.Stats("jobs/+").Messages < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if stats := metrics.Stats("jobs/+"); stats.Messages != 2 || stats.Panics != 1 {
+		t.Error("unexpected", stats)
+		return
+	}
+	logsMu.Lock()
+	defer logsMu.Unlock()
+	if !strings.Contains(logs.String(), "topic=jobs/good route=jobs/+") {
+		t.Error("expected log line, got", logs.String())
+		return
+	}
+}
+
+// lockedWriter serializes writes from concurrent handlers
+type lockedWriter struct {
+	buf *bytes.Buffer
+	mu  *sync.Mutex
+}
+
+func (writer lockedWriter) Write(p []byte) (int, error) {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	return writer.buf.Write(p)
+}
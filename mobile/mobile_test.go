@@ -0,0 +1,56 @@
+package mobile
+
+import (
+	"testing"
+	"time"
+)
+
+type testHandler struct {
+	messages chan string
+	errors   chan error
+}
+
+func (handler *testHandler) OnMessage(topic string, payload []byte) {
+	handler.messages <- topic + " " + string(payload)
+}
+
+func (handler *testHandler) OnError(err error) {
+	handler.errors <- err
+}
+
+func TestConn(t *testing.T) {
+	conn, err := Dial("mqtt+memory://TestMobile/mobile/test")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	handler := &testHandler{messages: make(chan string, 1), errors: make(chan error, 1)}
+	if err = conn.Listen(handler); err != nil {
+		t.Error(err)
+		return
+	}
+	if err = conn.Subscribe("mobile/other", 1); err != nil {
+		t.Error(err)
+		return
+	}
+	if err = conn.Publish("mobile/other", []byte("hello"), 1, false); err != nil {
+		t.Error(err)
+		return
+	}
+	select {
+	case msg := <-handler.messages:
+		if msg != "mobile/other hello" {
+			t.Error("unexpected message", msg)
+		}
+	case <-time.After(time.Second):
+		t.Error("no message received")
+	}
+	if err = conn.Close(); err != nil {
+		t.Error(err)
+	}
+	select {
+	case err := <-handler.errors:
+		t.Error("unexpected error after Close", err)
+	default:
+	}
+}
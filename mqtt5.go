@@ -0,0 +1,712 @@
+package mqttconn
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/eclipse/paho.golang/paho/session/state"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/pkg/errors"
+)
+
+// mqtt5Schemes are the DialMQTT schemes connecting with MQTT 5, as in mqtt5://server:port/topic
+// mqtt5s connects over TLS
+var mqtt5Schemes = map[string]struct {
+	protocol string
+	port     string
+}{
+	"mqtt5":  {"tcp", "1883"},
+	"mqtt5s": {"ssl", "8883"},
+}
+
+// WithMQTT5 connects with MQTT 5 using eclipse/paho.golang instead of paho's 3.1.1 client,
+// like the mqtt5:// and mqtt5s:// schemes. The MQTTConn API stays the same, so a program can move to
+// a MQTT 5 broker by changing the url. Reason codes and reason strings of the broker are reported
+// in *ReasonCodeError and ServerProperties returns the CONNACK properties.
+// The session state lives in memory, in-flight QoS 1 and 2 messages are resent after the connection is lost
+// until Disconnect. It only applies to DialMQTT and Dialer, the memory hub ignores it
+func WithMQTT5() Option {
+	return func(conn *MQTTConn) {
+		conn.clientOptions = append(conn.clientOptions, func(opts *mqtt.ClientOptions) {
+			opts.ProtocolVersion = 5
+		})
+	}
+}
+
+// metadataPublisher is implemented by clients sending the ContentType, CorrelationID and Headers of a message
+// as MQTT 5 properties, the content type, correlation data and user properties
+type metadataPublisher interface {
+	publishMetadata(topic string, qos byte, retained bool, payload []byte, metadata *Message) mqtt.Token
+}
+
+// metadataMessage is implemented by received messages with MQTT 5 properties, metadata sets them on msg
+type metadataMessage interface {
+	metadata(msg *Message)
+}
+
+// mqtt5Token is a mqtt.Token completed by a goroutine
+type mqtt5Token struct {
+	done chan struct{}
+	err  error
+}
+
+func newMQTT5Token() *mqtt5Token {
+	return &mqtt5Token{done: make(chan struct{})}
+}
+
+// complete sets the error and wakes up waiters, it must be called once
+func (token *mqtt5Token) complete(err error) *mqtt5Token {
+	token.err = err
+	close(token.done)
+	return token
+}
+
+func (token *mqtt5Token) Wait() bool {
+	<-token.done
+	return true
+}
+
+func (token *mqtt5Token) WaitTimeout(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-token.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (token *mqtt5Token) Done() <-chan struct{} { return token.done }
+
+func (token *mqtt5Token) Error() error {
+	select {
+	case <-token.done:
+		return token.err
+	default:
+		return nil
+	}
+}
+
+// mqtt5SubscribeToken is the token of SubscribeMultiple, with the reason codes of the SUBACK by filter
+type mqtt5SubscribeToken struct {
+	*mqtt5Token
+	reasons map[string]byte
+}
+
+// Result returns the granted QoS or the failure reason code of every filter, like mqtt.SubscribeToken.Result
+func (token *mqtt5SubscribeToken) Result() map[string]byte {
+	select {
+	case <-token.done:
+		return token.reasons
+	default:
+		return nil
+	}
+}
+
+// mqtt5Message implements mqtt.Message for a received PUBLISH
+type mqtt5Message struct {
+	client *paho.Client
+	packet *paho.Publish
+	manual bool
+	once   sync.Once
+}
+
+func (msg *mqtt5Message) Duplicate() bool   { return msg.packet.Duplicate() }
+func (msg *mqtt5Message) Qos() byte         { return msg.packet.QoS }
+func (msg *mqtt5Message) Retained() bool    { return msg.packet.Retain }
+func (msg *mqtt5Message) Topic() string     { return msg.packet.Topic }
+func (msg *mqtt5Message) MessageID() uint16 { return msg.packet.PacketID }
+func (msg *mqtt5Message) Payload() []byte   { return msg.packet.Payload }
+
+// metadata implements metadataMessage
+func (msg *mqtt5Message) metadata(received *Message) {
+	properties := msg.packet.Properties
+	if properties == nil {
+		return
+	}
+	received.ContentType = properties.ContentType
+	received.CorrelationID = string(properties.CorrelationData)
+	if len(properties.User) > 0 {
+		received.Headers = make(map[string]string, len(properties.User))
+		for _, property := range properties.User {
+			received.Headers[property.Key] = property.Value
+		}
+	}
+}
+
+// Ack sends the acknowledgement when automatic acknowledgement is disabled, paho.golang sends them in order
+func (msg *mqtt5Message) Ack() {
+	if !msg.manual {
+		return
+	}
+	msg.once.Do(func() {
+		msg.client.Ack(msg.packet)
+	})
+}
+
+// mqtt5Route is a handler added with Subscribe or AddRoute
+type mqtt5Route struct {
+	filter  string
+	handler mqtt.MessageHandler
+}
+
+// mqtt5Client is a mqtt.Client speaking MQTT 5 through eclipse/paho.golang
+// every connection gets a new paho.Client, the session state is shared between them until Disconnect
+// handlers run in order on the paho.golang goroutine routing publishes, like paho does with OrderMatters
+type mqtt5Client struct {
+	options *mqtt.ClientOptions
+
+	mu         sync.Mutex
+	client     *paho.Client
+	session    *state.State
+	connected  bool
+	routes     []mqtt5Route
+	properties *ServerProperties
+	// stop is closed by Disconnect to end reconnecting
+	stop chan struct{}
+	// inflight counts the publishes waiting for the broker, drained is closed when it drops to zero
+	inflight int
+	drained  chan struct{}
+}
+
+func newMQTT5Client(options *mqtt.ClientOptions) *mqtt5Client {
+	return &mqtt5Client{options: options}
+}
+
+// IsConnected implements mqtt.Client.IsConnected
+func (client *mqtt5Client) IsConnected() bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.connected
+}
+
+// IsConnectionOpen implements mqtt.Client.IsConnectionOpen
+func (client *mqtt5Client) IsConnectionOpen() bool {
+	return client.IsConnected()
+}
+
+// Connect implements mqtt.Client.Connect, trying every server in turn
+// a broker rejecting the connection gives a *ReasonCodeError
+func (client *mqtt5Client) Connect() mqtt.Token {
+	token := newMQTT5Token()
+	client.mu.Lock()
+	if client.connected {
+		client.mu.Unlock()
+		return token.complete(nil)
+	}
+	if client.session == nil {
+		client.session = state.NewInMemory()
+	}
+	client.stop = make(chan struct{})
+	stop := client.stop
+	client.mu.Unlock()
+	go func() {
+		token.complete(client.connect(stop))
+	}()
+	return token
+}
+
+// connect connects to the first server accepting the connection
+func (client *mqtt5Client) connect(stop chan struct{}) error {
+	if len(client.options.Servers) == 0 {
+		return errors.New("no servers defined to connect to")
+	}
+	var err error
+	for _, server := range client.options.Servers {
+		err = client.connectServer(server, stop)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// connectServer dials server and sends CONNECT
+func (client *mqtt5Client) connectServer(server *url.URL, stop chan struct{}) error {
+	options := client.options
+	ctx := context.Background()
+	if options.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.ConnectTimeout)
+		defer cancel()
+	}
+	netConn, err := client.dial(ctx, server)
+	if err != nil {
+		return err
+	}
+	client.mu.Lock()
+	session := client.session
+	client.mu.Unlock()
+	config := paho.ClientConfig{
+		Conn:                       packets.NewThreadSafeConn(netConn),
+		Session:                    session,
+		EnableManualAcknowledgment: options.AutoAckDisabled,
+	}
+	var pahoClient *paho.Client
+	config.OnPublishReceived = []func(paho.PublishReceived) (bool, error){
+		func(received paho.PublishReceived) (bool, error) {
+			client.route(&mqtt5Message{client: received.Client, packet: received.Packet, manual: options.AutoAckDisabled})
+			return true, nil
+		},
+	}
+	config.OnClientError = func(err error) {
+		client.lost(pahoClient, err)
+	}
+	config.OnServerDisconnect = func(disconnect *paho.Disconnect) {
+		err := &ReasonCodeError{Op: "disconnect", Code: ReasonCode(disconnect.ReasonCode)}
+		if disconnect.Properties != nil {
+			err.Reason = disconnect.Properties.ReasonString
+		}
+		client.lost(pahoClient, err)
+	}
+	pahoClient = paho.NewClient(config)
+
+	connect := &paho.Connect{
+		ClientID:   options.ClientID,
+		KeepAlive:  uint16(options.KeepAlive),
+		CleanStart: options.CleanSession,
+		Properties: &paho.ConnectProperties{},
+	}
+	if !options.CleanSession {
+		// a persistent 3.1.1 session never expires
+		expiry := uint32(0xFFFFFFFF)
+		connect.Properties.SessionExpiryInterval = &expiry
+	}
+	username, password := options.Username, options.Password
+	if options.CredentialsProvider != nil {
+		username, password = options.CredentialsProvider()
+	}
+	if username != "" {
+		connect.Username = username
+		connect.UsernameFlag = true
+	}
+	if password != "" {
+		connect.Password = []byte(password)
+		connect.PasswordFlag = true
+	}
+	if options.WillEnabled {
+		connect.WillMessage = &paho.WillMessage{
+			Topic:   options.WillTopic,
+			Payload: options.WillPayload,
+			QoS:     options.WillQos,
+			Retain:  options.WillRetained,
+		}
+	}
+	connack, err := pahoClient.Connect(ctx, connect)
+	if err != nil {
+		if connack != nil && connack.ReasonCode >= byte(ReasonUnspecifiedError) {
+			reasonErr := &ReasonCodeError{Op: "connect", Code: ReasonCode(connack.ReasonCode), Err: err}
+			if connack.Properties != nil {
+				reasonErr.Reason = connack.Properties.ReasonString
+			}
+			return reasonErr
+		}
+		return err
+	}
+
+	client.mu.Lock()
+	select {
+	case <-stop:
+		// disconnected while connecting
+		client.mu.Unlock()
+		pahoClient.Disconnect(&paho.Disconnect{})
+		return mqtt.ErrNotConnected
+	default:
+	}
+	client.client = pahoClient
+	client.connected = true
+	client.properties = connackProperties(connack)
+	client.mu.Unlock()
+	options.ProtocolVersion = 5
+	if options.OnConnect != nil {
+		go options.OnConnect(client)
+	}
+	return nil
+}
+
+// dial opens the network connection to server
+// websockets need mqtt.ClientOptions.SetCustomOpenConnectionFn, DialMQTT only sets one under js/wasm
+func (client *mqtt5Client) dial(ctx context.Context, server *url.URL) (net.Conn, error) {
+	options := client.options
+	if options.CustomOpenConnectionFn != nil {
+		return options.CustomOpenConnectionFn(server, *options)
+	}
+	dialer := options.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	switch server.Scheme {
+	case "tcp", "mqtt":
+		return dialer.DialContext(ctx, "tcp", server.Host)
+	case "ssl", "tls", "mqtts", "tcps":
+		config := &tls.Config{}
+		if options.TLSConfig != nil {
+			config = options.TLSConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = server.Hostname()
+		}
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: config}
+		return tlsDialer.DialContext(ctx, "tcp", server.Host)
+	}
+	return nil, errors.New("unsupported scheme for mqtt5: " + server.Scheme)
+}
+
+// lost handles the loss of the connection of pahoClient, reconnecting if enabled
+func (client *mqtt5Client) lost(pahoClient *paho.Client, err error) {
+	client.mu.Lock()
+	if !client.connected || client.client != pahoClient {
+		// already handled, or disconnected on purpose
+		client.mu.Unlock()
+		return
+	}
+	client.connected = false
+	stop := client.stop
+	client.mu.Unlock()
+	if client.options.OnConnectionLost != nil {
+		go client.options.OnConnectionLost(client, err)
+	}
+	if client.options.AutoReconnect {
+		go client.reconnect(stop)
+	}
+}
+
+// reconnect connects again with exponential backoff until it succeeds or stop is closed
+func (client *mqtt5Client) reconnect(stop chan struct{}) {
+	backoff := time.Second
+	for {
+		if client.options.OnReconnecting != nil {
+			client.options.OnReconnecting(client, client.options)
+		}
+		if client.connect(stop) == nil {
+			return
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if limit := client.options.MaxReconnectInterval; limit > 0 && backoff > limit {
+			backoff = limit
+		}
+	}
+}
+
+// Disconnect implements mqtt.Client.Disconnect, waiting up to quiesce milliseconds
+// for in-flight publishes to complete, those still in flight then are dropped with the session
+func (client *mqtt5Client) Disconnect(quiesce uint) {
+	client.mu.Lock()
+	drained := client.drained
+	waiting := client.connected && client.inflight > 0 && quiesce > 0
+	client.mu.Unlock()
+	if waiting {
+		timer := time.NewTimer(time.Duration(quiesce) * time.Millisecond)
+		select {
+		case <-drained:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+	client.mu.Lock()
+	pahoClient, session := client.client, client.session
+	connected := client.connected
+	if client.stop != nil {
+		close(client.stop)
+		client.stop = nil
+	}
+	client.client = nil
+	client.session = nil
+	client.connected = false
+	client.mu.Unlock()
+	if connected {
+		pahoClient.Disconnect(&paho.Disconnect{})
+	}
+	if session != nil {
+		// fails publishes waiting for a response
+		session.Close()
+	}
+}
+
+// current returns the paho.Client of the connection, or nil if not connected
+func (client *mqtt5Client) current() *paho.Client {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if !client.connected {
+		return nil
+	}
+	return client.client
+}
+
+// Publish implements mqtt.Client.Publish
+// a broker rejecting a QoS 1 message gives a *ReasonCodeError
+func (client *mqtt5Client) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	data, err := payloadBytes(payload)
+	if err != nil {
+		return newMQTT5Token().complete(err)
+	}
+	return client.publishMetadata(topic, qos, retained, data, nil)
+}
+
+// publishMetadata implements metadataPublisher
+func (client *mqtt5Client) publishMetadata(topic string, qos byte, retained bool, payload []byte, metadata *Message) mqtt.Token {
+	token := newMQTT5Token()
+	pahoClient := client.current()
+	if pahoClient == nil {
+		return token.complete(mqtt.ErrNotConnected)
+	}
+	publish := &paho.Publish{
+		Topic:      topic,
+		QoS:        qos,
+		Retain:     retained,
+		Payload:    payload,
+		Properties: publishProperties(metadata),
+	}
+	// brokers disconnect clients sending packets larger than their maximum
+	if properties := client.serverProperties(); properties != nil && properties.MaximumPacketSize > 0 &&
+		publishSize(publish) > int(properties.MaximumPacketSize) {
+		return token.complete(ErrPayloadTooLarge)
+	}
+	client.mu.Lock()
+	if client.inflight == 0 {
+		client.drained = make(chan struct{})
+	}
+	client.inflight++
+	client.mu.Unlock()
+	go func() {
+		defer func() {
+			client.mu.Lock()
+			client.inflight--
+			if client.inflight == 0 {
+				close(client.drained)
+			}
+			client.mu.Unlock()
+		}()
+		response, err := pahoClient.Publish(context.Background(), publish)
+		if err != nil && response != nil && response.ReasonCode >= byte(ReasonUnspecifiedError) {
+			reasonErr := &ReasonCodeError{Op: "publish", Topic: topic, Code: ReasonCode(response.ReasonCode), Err: err}
+			if response.Properties != nil {
+				reasonErr.Reason = response.Properties.ReasonString
+			}
+			err = reasonErr
+		}
+		token.complete(err)
+	}()
+	return token
+}
+
+// Subscribe implements mqtt.Client.Subscribe
+func (client *mqtt5Client) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	return client.SubscribeMultiple(map[string]byte{topic: qos}, callback)
+}
+
+// SubscribeMultiple implements mqtt.Client.SubscribeMultiple
+// a rejected filter gives a *ReasonCodeError
+func (client *mqtt5Client) SubscribeMultiple(filters map[string]byte, callback mqtt.MessageHandler) mqtt.Token {
+	token := &mqtt5SubscribeToken{mqtt5Token: newMQTT5Token(), reasons: make(map[string]byte)}
+	pahoClient := client.current()
+	if pahoClient == nil {
+		token.complete(mqtt.ErrNotConnected)
+		return token
+	}
+	subscribe := &paho.Subscribe{}
+	for filter, qos := range filters {
+		if callback != nil {
+			client.AddRoute(filter, callback)
+		}
+		subscribe.Subscriptions = append(subscribe.Subscriptions, paho.SubscribeOptions{Topic: filter, QoS: qos})
+	}
+	go func() {
+		suback, err := pahoClient.Subscribe(context.Background(), subscribe)
+		if suback != nil {
+			for i, code := range suback.Reasons {
+				if i < len(subscribe.Subscriptions) {
+					token.reasons[subscribe.Subscriptions[i].Topic] = code
+				}
+			}
+			for i, code := range suback.Reasons {
+				if code >= byte(ReasonUnspecifiedError) && i < len(subscribe.Subscriptions) {
+					reasonErr := &ReasonCodeError{Op: "subscribe", Topic: subscribe.Subscriptions[i].Topic, Code: ReasonCode(code), Err: err}
+					if suback.Properties != nil {
+						reasonErr.Reason = suback.Properties.ReasonString
+					}
+					err = reasonErr
+					break
+				}
+			}
+		}
+		token.complete(err)
+	}()
+	return token
+}
+
+// Unsubscribe implements mqtt.Client.Unsubscribe
+func (client *mqtt5Client) Unsubscribe(topics ...string) mqtt.Token {
+	token := newMQTT5Token()
+	client.mu.Lock()
+	for _, topic := range topics {
+		client.removeRoute(topic)
+	}
+	client.mu.Unlock()
+	pahoClient := client.current()
+	if pahoClient == nil {
+		return token.complete(mqtt.ErrNotConnected)
+	}
+	go func() {
+		_, err := pahoClient.Unsubscribe(context.Background(), &paho.Unsubscribe{Topics: topics})
+		token.complete(err)
+	}()
+	return token
+}
+
+// AddRoute implements mqtt.Client.AddRoute, adding a route for a filter again replaces its handler
+func (client *mqtt5Client) AddRoute(topic string, callback mqtt.MessageHandler) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	for i := range client.routes {
+		if client.routes[i].filter == topic {
+			client.routes[i].handler = callback
+			return
+		}
+	}
+	client.routes = append(client.routes, mqtt5Route{filter: topic, handler: callback})
+}
+
+// removeRoute removes the route of filter, must be called with mu held
+func (client *mqtt5Client) removeRoute(filter string) {
+	for i := range client.routes {
+		if client.routes[i].filter == filter {
+			client.routes = append(client.routes[:i], client.routes[i+1:]...)
+			return
+		}
+	}
+}
+
+// OptionsReader implements mqtt.Client.OptionsReader
+func (client *mqtt5Client) OptionsReader() mqtt.ClientOptionsReader {
+	return mqtt.NewOptionsReader(client.options)
+}
+
+// route hands msg to every route matching its topic, or to the default handler if none does
+func (client *mqtt5Client) route(msg *mqtt5Message) {
+	var handlers []mqtt.MessageHandler
+	client.mu.Lock()
+	for _, route := range client.routes {
+		if MatchTopic(route.filter, msg.Topic()) {
+			handlers = append(handlers, route.handler)
+		}
+	}
+	client.mu.Unlock()
+	if len(handlers) == 0 && client.options.DefaultPublishHandler != nil {
+		handlers = append(handlers, client.options.DefaultPublishHandler)
+	}
+	for _, handler := range handlers {
+		handler(client, msg)
+	}
+	if len(handlers) == 0 {
+		// nobody will ack it
+		msg.Ack()
+	}
+}
+
+// serverProperties returns the properties of the last CONNACK
+func (client *mqtt5Client) serverProperties() *ServerProperties {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.properties
+}
+
+// connackProperties converts the CONNACK properties, filling in the defaults of the specification
+func connackProperties(connack *paho.Connack) *ServerProperties {
+	properties := &ServerProperties{
+		ReceiveMaximum:     65535,
+		MaximumQoS:         2,
+		RetainAvailable:    true,
+		WildcardAvailable:  true,
+		SubIDAvailable:     true,
+		SharedSubAvailable: true,
+	}
+	p := connack.Properties
+	if p == nil {
+		return properties
+	}
+	properties.RetainAvailable = p.RetainAvailable
+	properties.WildcardAvailable = p.WildcardSubAvailable
+	properties.SubIDAvailable = p.SubIDAvailable
+	properties.SharedSubAvailable = p.SharedSubAvailable
+	properties.AssignedClientID = p.AssignedClientID
+	properties.ResponseInformation = p.ResponseInfo
+	properties.ServerReference = p.ServerReference
+	properties.ReasonString = p.ReasonString
+	if p.ReceiveMaximum != nil {
+		properties.ReceiveMaximum = *p.ReceiveMaximum
+	}
+	if p.MaximumQoS != nil {
+		properties.MaximumQoS = *p.MaximumQoS
+	}
+	if p.MaximumPacketSize != nil {
+		properties.MaximumPacketSize = *p.MaximumPacketSize
+	}
+	if p.TopicAliasMaximum != nil {
+		properties.TopicAliasMaximum = *p.TopicAliasMaximum
+	}
+	if p.ServerKeepAlive != nil {
+		properties.ServerKeepAlive = *p.ServerKeepAlive
+	}
+	if p.SessionExpiryInterval != nil {
+		properties.SessionExpiryInterval = *p.SessionExpiryInterval
+	}
+	if len(p.User) > 0 {
+		properties.UserProperties = make(map[string]string, len(p.User))
+		for _, property := range p.User {
+			properties.UserProperties[property.Key] = property.Value
+		}
+	}
+	return properties
+}
+
+// publishProperties converts the metadata of a message to PUBLISH properties, nil for none
+// headers are sent sorted by name, so the packet doesn't depend on map order
+func publishProperties(metadata *Message) *paho.PublishProperties {
+	if metadata == nil || metadata.ContentType == "" && metadata.CorrelationID == "" && len(metadata.Headers) == 0 {
+		return nil
+	}
+	properties := &paho.PublishProperties{ContentType: metadata.ContentType}
+	if metadata.CorrelationID != "" {
+		properties.CorrelationData = []byte(metadata.CorrelationID)
+	}
+	names := make([]string, 0, len(metadata.Headers))
+	for name := range metadata.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		properties.User.Add(name, metadata.Headers[name])
+	}
+	return properties
+}
+
+// publishSize returns the size of publish on the wire
+func publishSize(publish *paho.Publish) int {
+	packet := publish.Packet()
+	remaining := 0
+	for _, buf := range packet.Buffers() {
+		remaining += len(buf)
+	}
+	// packet type and remaining length, which takes one byte per 7 bits
+	size := 1 + remaining
+	for n := remaining; ; n >>= 7 {
+		size++
+		if n < 128 {
+			return size
+		}
+	}
+}
@@ -0,0 +1,410 @@
+package mqttconn
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+)
+
+// fakeMQTT5Broker is a single client MQTT 5 broker on a local port, sending every publish back to subscribers
+// the user "bad" is rejected with ReasonBadUserNameOrPassword, the user "small" gets a Maximum Packet Size of 64
+// and the user "slow" gets its PUBACKs 100ms late. Filters starting with "downgraded" are granted QoS 0. A publish to "disconnect" drops the connection
+type fakeMQTT5Broker struct {
+	listener net.Listener
+}
+
+func newFakeMQTT5Broker() (*fakeMQTT5Broker, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	broker := &fakeMQTT5Broker{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go broker.serve(conn)
+		}
+	}()
+	return broker, nil
+}
+
+func (broker *fakeMQTT5Broker) serve(conn net.Conn) {
+	defer conn.Close()
+	var mu sync.Mutex
+	write := func(packet *packets.ControlPacket) {
+		mu.Lock()
+		packet.WriteTo(conn)
+		mu.Unlock()
+	}
+	var filters []string
+	slow := false
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		switch content := packet.Content.(type) {
+		case *packets.Connect:
+			connack := packets.NewControlPacket(packets.CONNACK)
+			receiveMaximum := uint16(10)
+			connack.Content.(*packets.Connack).Properties = &packets.Properties{ReceiveMaximum: &receiveMaximum}
+			if content.Username == "bad" {
+				connack.Content.(*packets.Connack).ReasonCode = byte(ReasonBadUserNameOrPassword)
+				connack.Content.(*packets.Connack).Properties = &packets.Properties{ReasonString: "who are you"}
+				write(connack)
+				return
+			}
+			slow = content.Username == "slow"
+			if content.Username == "small" {
+				maximumPacketSize := uint32(64)
+				connack.Content.(*packets.Connack).Properties.MaximumPacketSize = &maximumPacketSize
+			}
+			write(connack)
+		case *packets.Subscribe:
+			suback := packets.NewControlPacket(packets.SUBACK)
+			suback.Content.(*packets.Suback).PacketID = content.PacketID
+			for _, subscription := range content.Subscriptions {
+				code := subscription.QoS
+				if subscription.Topic == "forbidden" {
+					code = byte(ReasonNotAuthorized)
+				} else {
+					if strings.HasPrefix(subscription.Topic, "downgraded") {
+						code = 0
+					}
+					filters = append(filters, subscription.Topic)
+				}
+				suback.Content.(*packets.Suback).Reasons = append(suback.Content.(*packets.Suback).Reasons, code)
+			}
+			write(suback)
+		case *packets.Unsubscribe:
+			unsuback := packets.NewControlPacket(packets.UNSUBACK)
+			unsuback.Content.(*packets.Unsuback).PacketID = content.PacketID
+			for range content.Topics {
+				unsuback.Content.(*packets.Unsuback).Reasons = append(unsuback.Content.(*packets.Unsuback).Reasons, 0)
+			}
+			write(unsuback)
+		case *packets.Publish:
+			if content.Topic == "disconnect" {
+				return
+			}
+			if content.QoS == 1 {
+				puback := packets.NewControlPacket(packets.PUBACK)
+				puback.Content.(*packets.Puback).PacketID = content.PacketID
+				if slow {
+					time.AfterFunc(100*time.Millisecond, func() { write(puback) })
+				} else {
+					write(puback)
+				}
+			}
+			for _, filter := range filters {
+				if MatchTopic(filter, content.Topic) {
+					forward := packets.NewControlPacket(packets.PUBLISH)
+					forward.Content.(*packets.Publish).Topic = content.Topic
+					forward.Content.(*packets.Publish).Payload = content.Payload
+					forward.Content.(*packets.Publish).Properties = content.Properties
+					write(forward)
+					break
+				}
+			}
+		case *packets.Pingreq:
+			write(packets.NewControlPacket(packets.PINGRESP))
+		case *packets.Disconnect:
+			return
+		}
+	}
+}
+
+func (broker *fakeMQTT5Broker) Close() error {
+	return broker.listener.Close()
+}
+
+func TestMQTT5(t *testing.T) {
+	broker, err := newFakeMQTT5Broker()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer broker.Close()
+	conn, err := DialMQTT("mqtt5://" + broker.listener.Addr().String() + "/mqtt5/test?qos=1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	if version := conn.NegotiatedProtocolVersion(); version != 5 {
+		t.Error("expected protocol version 5, got", version)
+	}
+	properties := conn.ServerProperties()
+	if properties == nil || properties.ReceiveMaximum != 10 || properties.MaximumQoS != 2 {
+		t.Error("unexpected server properties", properties)
+	}
+	_, err = conn.Write([]byte("hello"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 16)
+	n, addr, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(buf[:n]) != "hello" || addr.String() != "mqtt5/test" {
+		t.Error("unexpected message", string(buf[:n]), "from", addr)
+	}
+	_, err = conn.Subscribe("forbidden", 0)
+	if !errors.Is(err, ErrNotAuthorized) {
+		t.Error("expected ErrNotAuthorized, got", err)
+	}
+}
+
+func TestMQTT5Rejected(t *testing.T) {
+	broker, err := newFakeMQTT5Broker()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer broker.Close()
+	_, err = DialMQTT("mqtt://bad:password@"+broker.listener.Addr().String(), WithMQTT5())
+	var reasonErr *ReasonCodeError
+	if !errors.As(err, &reasonErr) || reasonErr.Code != ReasonBadUserNameOrPassword || reasonErr.Reason != "who are you" {
+		t.Error("expected a rejected connect, got", err)
+	}
+	if !errors.Is(err, ErrBadCredentials) {
+		t.Error("expected ErrBadCredentials, got", err)
+	}
+}
+
+func TestMQTT5Metadata(t *testing.T) {
+	broker, err := newFakeMQTT5Broker()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer broker.Close()
+	conn, err := DialMQTT("mqtt5://" + broker.listener.Addr().String() + "/mqtt5/metadata")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	_, err = conn.WriteBatch([]Message{{
+		Payload:       []byte(`{"on":true}`),
+		ContentType:   "application/json",
+		CorrelationID: "42",
+		Headers:       map[string]string{"trace": "abc"},
+	}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	msgs := make([]Message, 1)
+	if _, err := conn.ReadBatch(msgs); err != nil {
+		t.Error(err)
+		return
+	}
+	msg := msgs[0]
+	if string(msg.Payload) != `{"on":true}` || msg.ContentType != "application/json" || msg.CorrelationID != "42" ||
+		len(msg.Headers) != 1 || msg.Headers["trace"] != "abc" {
+		t.Error("unexpected message", string(msg.Payload), msg.ContentType, msg.CorrelationID, msg.Headers)
+	}
+}
+
+func TestMQTT5MaximumPacketSize(t *testing.T) {
+	broker, err := newFakeMQTT5Broker()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer broker.Close()
+	conn, err := DialMQTT("mqtt5://small:password@" + broker.listener.Addr().String() + "/t")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	if size := conn.MaxPayloadSize(); size != 56 {
+		t.Error("expected 56 bytes left of a 64 byte packet, got", size)
+		return
+	}
+	if _, err := conn.Write(make([]byte, 56)); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := conn.Write(make([]byte, 57)); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Error("expected ErrPayloadTooLarge, got", err)
+		return
+	}
+	// the properties count too
+	_, err = conn.WriteBatch([]Message{{Payload: make([]byte, 50), Headers: map[string]string{"trace": "abc"}}})
+	if !errors.Is(err, ErrPayloadTooLarge) {
+		t.Error("expected ErrPayloadTooLarge, got", err)
+	}
+}
+
+func TestMQTT5DefaultMetadata(t *testing.T) {
+	broker, err := newFakeMQTT5Broker()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer broker.Close()
+	conn, err := DialMQTT("mqtt5://" + broker.listener.Addr().String() + "/mqtt5/defaults")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	conn.SetDefaultContentType("text/plain")
+	headers := map[string]string{"source": "test"}
+	conn.SetDefaultHeaders(headers)
+	headers["source"] = "changed"
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Error(err)
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	msgs := make([]Message, 1)
+	if _, err := conn.ReadBatch(msgs); err != nil {
+		t.Error(err)
+		return
+	}
+	if msgs[0].ContentType != "text/plain" || len(msgs[0].Headers) != 1 || msgs[0].Headers["source"] != "test" {
+		t.Error("unexpected metadata", msgs[0].ContentType, msgs[0].Headers)
+	}
+}
+
+func TestMQTT5SendTime(t *testing.T) {
+	broker, err := newFakeMQTT5Broker()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer broker.Close()
+	conn, err := DialMQTT("mqtt5://"+broker.listener.Addr().String()+"/mqtt5/sent", WithSendTime())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	before := time.Now()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Error(err)
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	msgs := make([]Message, 1)
+	if _, err := conn.ReadBatch(msgs); err != nil {
+		t.Error(err)
+		return
+	}
+	if msgs[0].Sent.Before(before.Add(-time.Millisecond)) || msgs[0].Sent.After(msgs[0].Received) || msgs[0].Headers != nil {
+		t.Error("unexpected send time", msgs[0].Sent, "received", msgs[0].Received, "headers", msgs[0].Headers)
+	}
+}
+
+func TestMQTT5DisconnectQuiesce(t *testing.T) {
+	broker, err := newFakeMQTT5Broker()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer broker.Close()
+	conn, err := DialMQTT("mqtt5://slow:password@" + broker.listener.Addr().String() + "/t?qos=1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	token := conn.Client.Publish("t", 1, false, []byte("last words"))
+	// the publish waiting for its PUBACK completes within quiesce
+	conn.Client.Disconnect(1000)
+	if token.Wait(); token.Error() != nil {
+		t.Error("expected the publish to complete, got", token.Error())
+	}
+}
+
+func TestMQTT5AutomaticReconnect(t *testing.T) {
+	broker, err := newFakeMQTT5Broker()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer broker.Close()
+	reconnects := make(chan error, 4)
+	conn, err := DialMQTT("mqtt5://"+broker.listener.Addr().String(), WithHooks(Hooks{
+		OnReconnect: func(err error) {
+			reconnects <- err
+		},
+	}))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	conn.Client.Publish("disconnect", 0, false, []byte{})
+	select {
+	case err := <-reconnects:
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expected OnReconnect after reconnecting automatically")
+		return
+	}
+	if err := conn.Reconnect(); err != nil {
+		t.Error(err)
+		return
+	}
+	<-reconnects
+	select {
+	case <-reconnects:
+		t.Error("expected a single OnReconnect for Reconnect")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMQTT5GrantedQoS(t *testing.T) {
+	broker, err := newFakeMQTT5Broker()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer broker.Close()
+	conn, err := DialMQTT("mqtt5://" + broker.listener.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	subscription, err := conn.Subscribe("downgraded", 1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if subscription.GrantedQoS() != 0 {
+		t.Error("expected QoS 0 to be granted, got", subscription.GrantedQoS())
+		return
+	}
+	if err := conn.SetSubscriptions(map[string]SubOptions{"downgraded/set": {QoS: 1}, "kept": {QoS: 1}}); err != nil {
+		t.Error(err)
+		return
+	}
+	conn.subsMu.Lock()
+	downgraded, kept := conn.subscribed["downgraded/set"].granted, conn.subscribed["kept"].granted
+	conn.subsMu.Unlock()
+	if downgraded != 0 || kept != 1 {
+		t.Error("expected QoS 0 and 1 to be granted, got", downgraded, kept)
+	}
+}
@@ -1,9 +1,12 @@
 package mqttconn
 
 import (
+	"context"
 	"testing"
 	"net"
+	"time"
 	"github.com/google/uuid"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
 func TestBasic(t *testing.T) {
@@ -44,3 +47,267 @@ func TestBasic(t *testing.T) {
 		return
 	}
 }
+
+func TestReadFromTimeout(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestReadFromTimeout/topic")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	buf := make([]byte, 16)
+	_, _, err = conn.ReadFromTimeout(buf, 10*time.Millisecond)
+	if err, ok := err.(*TimeoutError); !ok || !err.Timeout() {
+		t.Error("expected timeout, got", err)
+		return
+	}
+	// the timeout does not stick to later reads
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		conn.Write([]byte("late"))
+	}()
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(buf[:n]) != "late" {
+		t.Error("expected late, got", string(buf[:n]))
+		return
+	}
+}
+
+func TestSetDefaultRetain(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestSetDefaultRetain")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	conn.SetDefaultTopic("state")
+	conn.SetDefaultRetain(true)
+	conn.Write([]byte("on"))
+	// a later subscriber gets the retained message
+	if _, err = conn.Subscribe("state", 0); err != nil {
+		t.Error(err)
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 16)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(buf[:n]) != "on" {
+		t.Error("expected on, got", string(buf[:n]))
+		return
+	}
+}
+
+func TestDialQoS(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestDialQoS/topic?subqos=1&pubqos=2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	if conn.defaultQoS != 2 {
+		t.Error("expected publish QoS 2, got", conn.defaultQoS)
+		return
+	}
+	if qos := conn.subscriptions["topic"].qos; qos != 1 {
+		t.Error("expected subscribe QoS 1, got", qos)
+		return
+	}
+	if _, err = DialMQTT("mqtt+memory://TestDialQoS/topic?qos=3"); err == nil {
+		t.Error("expected invalid QoS to fail")
+		return
+	}
+}
+
+// stuckClient is a mqtt.Client whose unsubscribes never complete
+type stuckClient struct {
+	mqtt.Client
+	disconnected chan uint
+}
+
+func (client *stuckClient) Unsubscribe(topics ...string) mqtt.Token {
+	return &stuckToken{}
+}
+
+func (client *stuckClient) Disconnect(quiesce uint) {
+	client.disconnected <- quiesce
+}
+
+// stuckToken is a mqtt.Token that never completes
+type stuckToken struct {
+	mqtt.Token
+}
+
+func (token *stuckToken) Wait() bool {
+	select {}
+}
+
+func TestCloseContext(t *testing.T) {
+	client := &stuckClient{Client: newMemoryClient("TestCloseContext", mqtt.NewClientOptions()), disconnected: make(chan uint, 1)}
+	client.Connect()
+	conn, _ := CreateMQTTConn(client, WithUnsubscribeOnClose())
+	if _, err := conn.Subscribe("topic", 0); err != nil {
+		t.Error(err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := conn.CloseContext(ctx); err != context.DeadlineExceeded {
+		t.Error("expected context.DeadlineExceeded, got", err)
+		return
+	}
+	if time.Since(start) > time.Second {
+		t.Error("expected CloseContext to give up, took", time.Since(start))
+		return
+	}
+	if quiesce := <-client.disconnected; quiesce != 0 {
+		t.Error("expected a forced disconnect, got quiesce", quiesce)
+		return
+	}
+	if _, err := conn.Write([]byte("late")); err != ErrClosed {
+		t.Error("expected ErrClosed, got", err)
+		return
+	}
+}
+
+func TestReadMsgBytes(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestReadMsgBytes/topic")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	expected := make([]byte, 100000)
+	for i := range expected {
+		expected[i] = byte(i)
+	}
+	if _, err := conn.Write(expected); err != nil {
+		t.Error(err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	payload, addr, err := conn.ReadMsgBytes(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(payload) != len(expected) || cap(payload) != len(expected) || payload[len(payload)-1] != expected[len(expected)-1] {
+		t.Error("expected the whole payload, got", len(payload), "bytes")
+		return
+	}
+	if addr.String() != "topic" {
+		t.Error("expected topic, got", addr)
+		return
+	}
+}
+
+func TestDialMQTTMulti(t *testing.T) {
+	publisher, err := DialMQTT("mqtt+memory://TestDialMQTTMulti")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer publisher.Close()
+	publisher.SetDefaultRetain(true)
+	publisher.WriteTo([]byte("1"), TopicAddr("a/1"))
+	publisher.WriteTo([]byte("2"), TopicAddr("b/x/2"))
+	conn, err := DialMQTTMulti("mqtt+memory://TestDialMQTTMulti/out?qos=1", []string{"a/+", "b/#"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	received := make(map[string]string)
+	buf := make([]byte, 16)
+	for len(received) < 2 {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		received[addr.String()] = string(buf[:n])
+	}
+	if received["a/1"] != "1" || received["b/x/2"] != "2" {
+		t.Error("unexpected messages", received)
+		return
+	}
+	conn.subsMu.Lock()
+	subscribed := len(conn.subscribed)
+	conn.subsMu.Unlock()
+	if subscribed != 3 {
+		t.Error("expected the filters and the default topic to be subscribed, got", subscribed)
+		return
+	}
+	if _, err := conn.Write([]byte("3")); err != nil {
+		t.Error(err)
+		return
+	}
+	n, addr, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if addr.String() != "out" || string(buf[:n]) != "3" {
+		t.Error("expected 3 on out, got", string(buf[:n]), "on", addr)
+	}
+}
+
+func TestSetDefaultsConcurrently(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestSetDefaultsConcurrently/a")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			conn.SetDefaultTopic([]string{"a", "b"}[i%2])
+			conn.SetDefaultQoS(i % 2)
+			conn.SetDefaultRetain(i%2 == 0)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if _, err := conn.Write([]byte("x")); err != nil {
+			t.Error(err)
+			break
+		}
+	}
+	<-done
+}
+
+func TestStrictDefaultTopic(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestStrictDefaultTopic", WithStrictDefaultTopic())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("x")); err != ErrNoDefaultTopic {
+		t.Error("expected ErrNoDefaultTopic, got", err)
+		return
+	}
+	if _, err := conn.WriteBatch([]Message{{Payload: []byte("x")}}); err != ErrNoDefaultTopic {
+		t.Error("expected ErrNoDefaultTopic from WriteBatch, got", err)
+		return
+	}
+	if _, err := conn.WithDefaults("", 0, false).Write([]byte("x")); err != ErrNoDefaultTopic {
+		t.Error("expected ErrNoDefaultTopic from a view without a topic, got", err)
+		return
+	}
+	conn.SetDefaultTopic("t")
+	if _, err := conn.Write([]byte("x")); err != nil {
+		t.Error(err)
+	}
+}
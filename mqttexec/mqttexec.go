@@ -0,0 +1,212 @@
+// Package mqttexec runs whitelisted commands on a device for remote clients, over mqttconn streams
+// a client sends a Request, the Agent streams stdout and stderr back in chunks as the command writes them,
+// followed by the exit code. Every message is a line of JSON
+package mqttexec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os/exec"
+	"sync"
+	"time"
+
+	mqttconn "github.com/gyf304/go-mqttconn"
+)
+
+var (
+	// ErrNotAllowed is returned by Run for commands the agent doesn't run
+	ErrNotAllowed = errors.New("mqttexec: command not allowed")
+	// ErrTimeout is returned by Run when the agent killed the command after its timeout
+	ErrTimeout = errors.New("mqttexec: command timed out")
+)
+
+// error codes in the final frame
+const (
+	codeNotAllowed = "not allowed"
+	codeTimeout    = "timeout"
+)
+
+// Command is a command the Agent runs
+type Command struct {
+	// Path is the program to run
+	Path string
+	// Args are always passed to the program, before the arguments of the request
+	Args []string
+	// AllowArgs allows requests to pass more arguments
+	AllowArgs bool
+	// Timeout kills the command after this long, zero means the default timeout of the agent
+	Timeout time.Duration
+}
+
+// Agent runs the commands whitelisted by name for clients
+type Agent struct {
+	Commands map[string]Command
+	// Timeout is the timeout of commands without one, zero means a minute.
+	// A request may ask for a shorter timeout, never for a longer one
+	Timeout time.Duration
+}
+
+// Request asks the agent to run a command
+type Request struct {
+	Command string        `json:"command"`
+	Args    []string      `json:"args,omitempty"`
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+// frame is a chunk of output or the final frame with the exit code
+type frame struct {
+	Stdout []byte `json:"stdout,omitempty"`
+	Stderr []byte `json:"stderr,omitempty"`
+	Exit   *int   `json:"exit,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Serve runs the requests of every stream accepted from listener, like one from mqttconn.ListenStream,
+// until Accept fails
+func (agent *Agent) Serve(listener net.Listener) error {
+	for {
+		stream, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go agent.serve(stream)
+	}
+}
+
+// serve runs the request of one stream
+func (agent *Agent) serve(stream net.Conn) {
+	defer stream.Close()
+	decoder := json.NewDecoder(stream)
+	var request Request
+	if err := decoder.Decode(&request); err != nil {
+		return
+	}
+	writer := &frameWriter{encoder: json.NewEncoder(stream)}
+	command, ok := agent.Commands[request.Command]
+	if !ok || len(request.Args) > 0 && !command.AllowArgs {
+		writer.exit(-1, codeNotAllowed)
+		return
+	}
+	timeout := command.Timeout
+	if timeout == 0 {
+		timeout = agent.Timeout
+	}
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+	if request.Timeout > 0 && request.Timeout < timeout {
+		timeout = request.Timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	// the client closing the stream kills the command
+	go func() {
+		io.Copy(io.Discard, stream)
+		cancel()
+	}()
+	cmd := exec.CommandContext(ctx, command.Path, append(append([]string{}, command.Args...), request.Args...)...)
+	cmd.Stdout = &outputWriter{frames: writer, stderr: false}
+	cmd.Stderr = &outputWriter{frames: writer, stderr: true}
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		writer.exit(-1, codeTimeout)
+	case err == nil:
+		writer.exit(0, "")
+	case errors.As(err, &exitErr):
+		writer.exit(exitErr.ExitCode(), "")
+	default:
+		writer.exit(-1, err.Error())
+	}
+}
+
+// frameWriter sends frames, the output of stdout and stderr is copied concurrently
+type frameWriter struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+func (writer *frameWriter) send(f *frame) error {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	return writer.encoder.Encode(f)
+}
+
+func (writer *frameWriter) exit(code int, message string) {
+	writer.send(&frame{Exit: &code, Error: message})
+}
+
+// outputWriter sends everything written as stdout or stderr frames
+type outputWriter struct {
+	frames *frameWriter
+	stderr bool
+}
+
+func (writer *outputWriter) Write(p []byte) (int, error) {
+	chunk := &frame{Stdout: p}
+	if writer.stderr {
+		chunk = &frame{Stderr: p}
+	}
+	if err := writer.frames.send(chunk); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Run runs the request on the agent listening under prefix, copying its output to stdout and stderr
+// as it arrives, either may be nil to discard it. It returns the exit code of the command,
+// ErrNotAllowed and ErrTimeout come with the exit code -1. Cancelling ctx kills the command
+func Run(ctx context.Context, conn *mqttconn.MQTTConn, prefix string, request Request, stdout, stderr io.Writer) (int, error) {
+	stream, err := mqttconn.DialStream(ctx, conn, prefix)
+	if err != nil {
+		return -1, err
+	}
+	defer stream.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			stream.Close()
+		case <-done:
+		}
+	}()
+	if err := json.NewEncoder(stream).Encode(&request); err != nil {
+		return -1, err
+	}
+	decoder := json.NewDecoder(stream)
+	for {
+		var f frame
+		if err := decoder.Decode(&f); err != nil {
+			if ctx.Err() != nil {
+				return -1, ctx.Err()
+			}
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return -1, err
+		}
+		if f.Stdout != nil && stdout != nil {
+			stdout.Write(f.Stdout)
+		}
+		if f.Stderr != nil && stderr != nil {
+			stderr.Write(f.Stderr)
+		}
+		if f.Exit == nil {
+			continue
+		}
+		switch f.Error {
+		case "":
+			return *f.Exit, nil
+		case codeNotAllowed:
+			return *f.Exit, ErrNotAllowed
+		case codeTimeout:
+			return *f.Exit, ErrTimeout
+		}
+		return *f.Exit, errors.New("mqttexec: " + f.Error)
+	}
+}
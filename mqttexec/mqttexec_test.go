@@ -0,0 +1,68 @@
+package mqttexec
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	mqttconn "github.com/gyf304/go-mqttconn"
+)
+
+func TestRun(t *testing.T) {
+	conn, err := mqttconn.DialMQTT("mqtt+memory://TestRun")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	listener, err := mqttconn.ListenStream(conn, "device/exec")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer listener.Close()
+	agent := &Agent{Commands: map[string]Command{
+		"echo":   {Path: "/bin/echo", AllowArgs: true},
+		"status": {Path: "/bin/sh", Args: []string{"-c", "echo out; echo err >&2; exit 3"}},
+		"hang":   {Path: "/bin/sleep", Args: []string{"5"}, Timeout: 50 * time.Millisecond},
+	}}
+	go agent.Serve(listener)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	code, err := Run(ctx, conn, "device/exec", Request{Command: "echo", Args: []string{"hello"}}, &stdout, &stderr)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if code != 0 || stdout.String() != "hello\n" {
+		t.Error("expected hello with exit code 0, got", stdout.String(), code)
+		return
+	}
+
+	stdout.Reset()
+	code, err = Run(ctx, conn, "device/exec", Request{Command: "status"}, &stdout, &stderr)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if code != 3 || stdout.String() != "out\n" || stderr.String() != "err\n" {
+		t.Error("expected exit code 3 with output, got", code, stdout.String(), stderr.String())
+		return
+	}
+
+	if _, err = Run(ctx, conn, "device/exec", Request{Command: "status", Args: []string{"x"}}, nil, nil); err != ErrNotAllowed {
+		t.Error("expected ErrNotAllowed for arguments, got", err)
+		return
+	}
+	if _, err = Run(ctx, conn, "device/exec", Request{Command: "rm"}, nil, nil); err != ErrNotAllowed {
+		t.Error("expected ErrNotAllowed, got", err)
+		return
+	}
+	if _, err = Run(ctx, conn, "device/exec", Request{Command: "hang"}, nil, nil); err != ErrTimeout {
+		t.Error("expected ErrTimeout, got", err)
+		return
+	}
+}
@@ -0,0 +1,70 @@
+package mqttconn
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNetworkRegistry(t *testing.T) {
+	SetDefaultBroker("mqtt+memory://TestNetworkRegistry")
+	defer SetDefaultBroker("")
+	listener, err := Listen("mqtt", "/rpc")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer listener.Close()
+	go func() {
+		stream, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer stream.Close()
+		io.Copy(stream, stream)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	stream, err := DialContext(ctx, memoryScheme, "TestNetworkRegistry/rpc")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer stream.Close()
+	if _, err := stream.Write([]byte("ping")); err != nil {
+		t.Error(err)
+		return
+	}
+	stream.(*ownedStream).CloseWrite()
+	reply, err := io.ReadAll(stream)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(reply) != "ping" {
+		t.Error("expected ping, got", string(reply))
+		return
+	}
+
+	if _, err := Dial("udp", "/rpc"); err == nil {
+		t.Error("expected unknown networks to fail")
+		return
+	}
+	RegisterNetwork("pipe", Network{Dial: func(ctx context.Context, address string) (net.Conn, error) {
+		client, _ := net.Pipe()
+		return client, nil
+	}})
+	pipe, err := Dial("pipe", "anything")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pipe.Close()
+	if _, err := Listen("pipe", "anything"); err == nil {
+		t.Error("expected listening on a dial only network to fail")
+		return
+	}
+}
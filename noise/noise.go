@@ -0,0 +1,190 @@
+// Package noise secures mqttconn streams with the Noise_XX_25519_ChaChaPoly_BLAKE2s handshake
+// both peers prove a static key, and the session keys are forward secret, a lighter alternative to TLS
+// for links between devices that know each other's public keys
+package noise
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/flynn/noise"
+)
+
+// maxMessageSize is the largest Noise message, including the authentication tag
+const maxMessageSize = 65535
+
+// tagSize is the size of the ChaChaPoly authentication tag
+const tagSize = 16
+
+var suite = noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2s)
+
+// Key is a Curve25519 key pair
+type Key = noise.DHKey
+
+// GenerateKey returns a new static key
+func GenerateKey() (Key, error) {
+	return suite.GenerateKeypair(rand.Reader)
+}
+
+// Config configures a handshake
+type Config struct {
+	// StaticKey identifies this peer
+	StaticKey Key
+	// VerifyPeer checks the static public key of the peer, the handshake fails if it returns an error
+	// nil accepts every peer, which only provides confidentiality against passive attackers
+	VerifyPeer func(publicKey []byte) error
+	// Prologue is data both peers have to agree on, like a protocol name, it is optional
+	Prologue []byte
+}
+
+// Conn is a net.Conn encrypting the stream it wraps
+type Conn struct {
+	net.Conn
+	peer []byte
+
+	readMu  sync.Mutex
+	recv    *noise.CipherState
+	pending []byte
+
+	writeMu sync.Mutex
+	send    *noise.CipherState
+}
+
+// Client performs the handshake as initiator over conn, usually a *mqttconn.StreamConn from DialStream
+// deadlines of conn apply to the handshake
+func Client(conn net.Conn, config *Config) (*Conn, error) {
+	return handshake(conn, config, true)
+}
+
+// Server performs the handshake as responder over conn, usually accepted from a mqttconn.StreamListener
+func Server(conn net.Conn, config *Config) (*Conn, error) {
+	return handshake(conn, config, false)
+}
+
+// handshake runs the three messages of XX: -> e, <- e ee s es, -> s se
+func handshake(conn net.Conn, config *Config, initiator bool) (*Conn, error) {
+	state, err := noise.NewHandshakeState(noise.Config{
+		CipherSuite:   suite,
+		Pattern:       noise.HandshakeXX,
+		Initiator:     initiator,
+		Prologue:      config.Prologue,
+		StaticKeypair: config.StaticKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var send, recv *noise.CipherState
+	verified := false
+	// the initiator writes the first and the last message
+	for i, write := 0, initiator; i < 3; i, write = i+1, !write {
+		var first, second *noise.CipherState
+		if write {
+			var message []byte
+			message, first, second, err = state.WriteMessage(nil, nil)
+			if err == nil {
+				err = writeMessage(conn, message)
+			}
+		} else {
+			var message []byte
+			message, err = readMessage(conn)
+			if err == nil {
+				_, first, second, err = state.ReadMessage(nil, message)
+			}
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		// check the peer as soon as it is known, before revealing our own static key
+		if !verified && state.PeerStatic() != nil && config.VerifyPeer != nil {
+			verified = true
+			if err := config.VerifyPeer(state.PeerStatic()); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		if first != nil {
+			send, recv = first, second
+			if !initiator {
+				send, recv = second, first
+			}
+		}
+	}
+	return &Conn{Conn: conn, peer: state.PeerStatic(), send: send, recv: recv}, nil
+}
+
+// writeMessage writes a length prefixed message
+func writeMessage(conn net.Conn, message []byte) error {
+	framed := make([]byte, 2+len(message))
+	binary.BigEndian.PutUint16(framed, uint16(len(message)))
+	copy(framed[2:], message)
+	_, err := conn.Write(framed)
+	return err
+}
+
+// readMessage reads a length prefixed message
+func readMessage(conn net.Conn) ([]byte, error) {
+	var size [2]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, err
+	}
+	message := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(conn, message); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return message, nil
+}
+
+// PeerStatic returns the static public key of the peer
+func (conn *Conn) PeerStatic() []byte {
+	return conn.peer
+}
+
+// Read implements net.Conn.Read
+func (conn *Conn) Read(p []byte) (int, error) {
+	conn.readMu.Lock()
+	defer conn.readMu.Unlock()
+	for len(conn.pending) == 0 {
+		message, err := readMessage(conn.Conn)
+		if err != nil {
+			return 0, err
+		}
+		conn.pending, err = conn.recv.Decrypt(message[:0], nil, message)
+		if err != nil {
+			return 0, errors.New("noise: message authentication failed")
+		}
+	}
+	n := copy(p, conn.pending)
+	conn.pending = conn.pending[n:]
+	return n, nil
+}
+
+// Write implements net.Conn.Write
+func (conn *Conn) Write(p []byte) (int, error) {
+	conn.writeMu.Lock()
+	defer conn.writeMu.Unlock()
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxMessageSize-tagSize {
+			chunk = chunk[:maxMessageSize-tagSize]
+		}
+		message, err := conn.send.Encrypt(nil, nil, chunk)
+		if err != nil {
+			return written, err
+		}
+		if err := writeMessage(conn.Conn, message); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
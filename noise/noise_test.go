@@ -0,0 +1,113 @@
+package noise
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	mqttconn "github.com/gyf304/go-mqttconn"
+)
+
+func TestNoise(t *testing.T) {
+	conn, err := mqttconn.DialMQTT("mqtt+memory://TestNoise")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	listener, err := mqttconn.ListenStream(conn, "streams")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer listener.Close()
+	clientKey, _ := GenerateKey()
+	serverKey, _ := GenerateKey()
+
+	payload := bytes.Repeat([]byte("noise"), 20000)
+	done := make(chan error, 1)
+	go func() {
+		stream, err := listener.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		secure, err := Server(stream, &Config{StaticKey: serverKey})
+		if err != nil {
+			done <- err
+			return
+		}
+		defer secure.Close()
+		if !bytes.Equal(secure.PeerStatic(), clientKey.Public) {
+			done <- errors.New("unexpected client key")
+			return
+		}
+		received, err := io.ReadAll(io.LimitReader(secure, int64(len(payload))))
+		if err == nil && !bytes.Equal(received, payload) {
+			err = errors.New("unexpected payload")
+		}
+		done <- err
+	}()
+
+	stream, err := mqttconn.DialStream(context.Background(), conn, "streams")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	secure, err := Client(stream, &Config{StaticKey: clientKey, VerifyPeer: func(publicKey []byte) error {
+		if !bytes.Equal(publicKey, serverKey.Public) {
+			return errors.New("unknown server")
+		}
+		return nil
+	}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer secure.Close()
+	if _, err := secure.Write(payload); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNoiseUnknownPeer(t *testing.T) {
+	conn, err := mqttconn.DialMQTT("mqtt+memory://TestNoiseUnknownPeer")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	listener, err := mqttconn.ListenStream(conn, "streams")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer listener.Close()
+	serverKey, _ := GenerateKey()
+	clientKey, _ := GenerateKey()
+	go func() {
+		stream, err := listener.Accept()
+		if err == nil {
+			Server(stream, &Config{StaticKey: serverKey})
+		}
+	}()
+
+	stream, err := mqttconn.DialStream(context.Background(), conn, "streams")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	rejected := errors.New("unknown server")
+	_, err = Client(stream, &Config{StaticKey: clientKey, VerifyPeer: func(publicKey []byte) error {
+		return rejected
+	}})
+	if err != rejected {
+		t.Error("expected the peer to be rejected, got", err)
+	}
+}
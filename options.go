@@ -0,0 +1,93 @@
+package mqttconn
+
+import (
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Option configures a MQTTConn, see DialMQTT and CreateMQTTConn
+type Option func(*MQTTConn)
+
+// WithManualAck disables paho's automatic acknowledgement of received messages
+// messages returned by ReadMsg have to be settled with Message.Ack or Message.Nack,
+// giving at-least-once processing. Read and ReadFrom acknowledge on return.
+// When wrapping a client with CreateMQTTConn, it must be created with
+// mqtt.ClientOptions.SetAutoAckDisabled(true)
+func WithManualAck() Option {
+	return func(conn *MQTTConn) {
+		conn.clientOptions = append(conn.clientOptions, func(opts *mqtt.ClientOptions) {
+			opts.SetAutoAckDisabled(true)
+		})
+	}
+}
+
+// WithMaxPacketSize limits published packets to size bytes, the maximum packet size of the broker
+// brokers disconnect clients sending larger packets, WriteTo returns ErrPayloadTooLarge instead
+func WithMaxPacketSize(size int) Option {
+	return func(conn *MQTTConn) {
+		conn.maxPacketSize = size
+	}
+}
+
+// WithStrictDefaultTopic makes Write, and WriteBatch for messages without a Topic, fail with ErrNoDefaultTopic
+// while no default topic is set, by the dial url or SetDefaultTopic, instead of publishing to the empty topic
+func WithStrictDefaultTopic() Option {
+	return func(conn *MQTTConn) {
+		conn.strictDefaultTopic = true
+	}
+}
+
+// WithUnsubscribeOnClose makes Close unsubscribe every filter before disconnecting
+// by default subscriptions of a persistent session are left intact, so the broker keeps queueing for the next session
+func WithUnsubscribeOnClose() Option {
+	return func(conn *MQTTConn) {
+		conn.unsubscribeOnClose = true
+	}
+}
+
+// WithMessageTTL discards received messages that waited for a read longer than ttl,
+// stale messages are acknowledged and counted in Stats.Expired
+func WithMessageTTL(ttl time.Duration) Option {
+	return func(conn *MQTTConn) {
+		conn.messageTTL = ttl
+	}
+}
+
+// WithFairReads makes ReadFrom take turns between subscriptions with messages waiting,
+// so a chatty topic can't hold back the messages of quiet ones. Messages of one subscription stay in order,
+// priorities of SubscribePriority still apply first
+func WithFairReads() Option {
+	return func(conn *MQTTConn) {
+		conn.fairReads = true
+	}
+}
+
+// WithLocalEcho queues a copy of every successful WriteTo for ReadFrom, with Message.Local set,
+// as if it was received from the broker. Copies skip the codecs and don't wait for room in the read queue,
+// with WithCoalescing they are queued once the coalesced message is published.
+// A conn subscribed to the topic written also receives the message from the broker, MQTT 3.1.1 can't prevent that
+func WithLocalEcho() Option {
+	return func(conn *MQTTConn) {
+		conn.localEcho = true
+	}
+}
+
+// WithSendTime stamps messages with the time they are written, which readers get as Message.Sent.
+// The time travels as MQTT 5 user property sent-at with WithMQTT5, or as header in the envelope of WithEnvelope,
+// and takes about 40 bytes. Empty payloads, which clear retained messages, are not stamped
+func WithSendTime() Option {
+	return func(conn *MQTTConn) {
+		conn.sendTime = true
+	}
+}
+
+// WithWill arms a last will and testament, published by the broker if the connection is lost
+// without a clean disconnect. An empty retained will removes the retained message of topic
+func WithWill(topic string, payload []byte, qos byte, retained bool) Option {
+	return func(conn *MQTTConn) {
+		conn.clientOptions = append(conn.clientOptions, func(opts *mqtt.ClientOptions) {
+			opts.SetBinaryWill(topic, payload, qos, retained)
+		})
+	}
+}
@@ -0,0 +1,353 @@
+package mqttconn
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OutboxEntry is a write committed to an OutboxStore
+type OutboxEntry struct {
+	// ID is assigned by the store, entries are published in ID order
+	ID       uint64    `json:"id"`
+	Topic    string    `json:"topic"`
+	Payload  []byte    `json:"payload"`
+	QoS      byte      `json:"qos"`
+	Retained bool      `json:"retained"`
+	Created  time.Time `json:"created"`
+}
+
+// OutboxStore persists the entries of an Outbox, it must be safe for concurrent use
+type OutboxStore interface {
+	// Append durably stores entry, setting its ID larger than the IDs of the entries before it
+	Append(entry *OutboxEntry) error
+	// Pending returns up to limit stored entries with the smallest IDs, in ID order
+	Pending(limit int) ([]OutboxEntry, error)
+	// Delete removes the entry with id, once it is published
+	Delete(id uint64) error
+}
+
+// OutboxConfig configures StartOutbox
+type OutboxConfig struct {
+	// Retry sets the waits between attempts to publish an entry, the zero value means DefaultRetryPolicy
+	// MaxAttempts and Retryable are ignored, entries are retried until they are published
+	Retry RetryPolicy
+	// OnError, if set, is called with every failed attempt to publish an entry
+	OnError func(entry OutboxEntry, err error)
+}
+
+// outboxBatch is the number of entries the sender loads from the store at once
+const outboxBatch = 64
+
+// Outbox commits writes to a store before publishing them in the background, so they survive crashes and outages
+// entries are published in order and deleted once the broker acknowledged them, an entry may be published again
+// if the process stops in between, use QoS 1 and deduplicate on the receiving side, e.g. with an Inbox
+type Outbox struct {
+	conn   *MQTTConn
+	store  OutboxStore
+	config OutboxConfig
+
+	wake     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	stopped  chan struct{}
+
+	mu      sync.Mutex
+	emptied chan struct{}
+}
+
+// StartOutbox starts publishing the entries of store on conn, including those left by an earlier process
+func StartOutbox(conn *MQTTConn, store OutboxStore, config OutboxConfig) *Outbox {
+	if config.Retry.InitialBackoff == 0 {
+		config.Retry = DefaultRetryPolicy
+	}
+	outbox := &Outbox{
+		conn:    conn,
+		store:   store,
+		config:  config,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	conn.goLabeled(outbox.run)
+	return outbox
+}
+
+// Write commits payload for topic to the store, it returns once the entry is stored, not published
+func (outbox *Outbox) Write(topic string, payload []byte, qos byte, retained bool) error {
+	if err := validateTopic(topic); err != nil {
+		return err
+	}
+	select {
+	case <-outbox.done:
+		return ErrClosed
+	default:
+	}
+	entry := &OutboxEntry{
+		Topic:    topic,
+		Payload:  payload,
+		QoS:      qos,
+		Retained: retained,
+		Created:  outbox.conn.clock.Now(),
+	}
+	if err := outbox.store.Append(entry); err != nil {
+		return err
+	}
+	select {
+	case outbox.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Flush waits until the store has no entries left or ctx is done
+func (outbox *Outbox) Flush(ctx context.Context) error {
+	for {
+		outbox.mu.Lock()
+		if outbox.emptied == nil {
+			outbox.emptied = make(chan struct{})
+		}
+		emptied := outbox.emptied
+		outbox.mu.Unlock()
+		pending, err := outbox.store.Pending(1)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+		select {
+		case <-emptied:
+		case <-outbox.stopped:
+			return ErrClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// signalEmpty wakes Flush after the sender found the store empty
+func (outbox *Outbox) signalEmpty() {
+	outbox.mu.Lock()
+	defer outbox.mu.Unlock()
+	if outbox.emptied != nil {
+		close(outbox.emptied)
+		outbox.emptied = nil
+	}
+}
+
+func (outbox *Outbox) run() {
+	defer close(outbox.stopped)
+	for attempt := 1; ; attempt++ {
+		entries, err := outbox.store.Pending(outboxBatch)
+		if err != nil {
+			// reported without an entry, then loading is retried
+			if outbox.config.OnError != nil {
+				outbox.config.OnError(OutboxEntry{}, err)
+			}
+			if !outbox.sleep(attempt) {
+				return
+			}
+			continue
+		}
+		attempt = 0
+		for _, entry := range entries {
+			if !outbox.publish(entry) {
+				return
+			}
+		}
+		if len(entries) == 0 {
+			outbox.signalEmpty()
+			select {
+			case <-outbox.wake:
+			case <-outbox.done:
+				return
+			}
+		}
+	}
+}
+
+// sleep waits for the backoff before retry number attempt, it returns false if the outbox was closed first
+func (outbox *Outbox) sleep(attempt int) bool {
+	timer := outbox.conn.clock.NewTimer(outbox.config.Retry.backoff(attempt))
+	select {
+	case <-timer.C():
+		return true
+	case <-outbox.done:
+		timer.Stop()
+		return false
+	}
+}
+
+// publish publishes entry until it succeeds and deletes it, it returns false if the outbox was closed first
+func (outbox *Outbox) publish(entry OutboxEntry) bool {
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-outbox.done:
+			return false
+		default:
+		}
+		err := outbox.conn.publishWithRetry(&outgoing{
+			topic:    entry.Topic,
+			qos:      entry.QoS,
+			retained: entry.Retained,
+			payload:  entry.Payload,
+		})
+		if err == nil {
+			err = outbox.store.Delete(entry.ID)
+		}
+		if err == nil {
+			return true
+		}
+		if outbox.config.OnError != nil {
+			outbox.config.OnError(entry, err)
+		}
+		if !outbox.sleep(attempt) {
+			return false
+		}
+	}
+}
+
+// Close stops publishing, entries not published yet stay in the store for the next StartOutbox
+func (outbox *Outbox) Close() error {
+	outbox.stopOnce.Do(func() {
+		close(outbox.done)
+	})
+	<-outbox.stopped
+	return nil
+}
+
+// FileOutboxStore is an OutboxStore keeping each entry in a file of a directory
+type FileOutboxStore struct {
+	dir string
+
+	mu     sync.Mutex
+	nextID uint64
+}
+
+// outboxFileSuffix is the suffix of entry files, which are named after the zero padded ID
+const outboxFileSuffix = ".entry"
+
+// NewFileOutboxStore opens the store in dir, creating the directory if needed
+func NewFileOutboxStore(dir string) (*FileOutboxStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	store := &FileOutboxStore{dir: dir, nextID: 1}
+	ids, err := store.ids()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) > 0 {
+		store.nextID = ids[len(ids)-1] + 1
+	}
+	return store, nil
+}
+
+// ids returns the IDs of the stored entries in order
+func (store *FileOutboxStore) ids() ([]uint64, error) {
+	files, err := os.ReadDir(store.dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint64
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasSuffix(name, outboxFileSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, outboxFileSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func (store *FileOutboxStore) path(id uint64) string {
+	return filepath.Join(store.dir, fmt.Sprintf("%020d%s", id, outboxFileSuffix))
+}
+
+// Append implements OutboxStore, the entry is synced to disk before it returns
+func (store *FileOutboxStore) Append(entry *OutboxEntry) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	entry.ID = store.nextID
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	// written under a name Pending ignores, then renamed, so a crash never leaves a partial entry
+	tmp := store.path(entry.ID) + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, store.path(entry.ID))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if dir, err := os.Open(store.dir); err == nil {
+		// persists the rename, not supported everywhere
+		dir.Sync()
+		dir.Close()
+	}
+	store.nextID++
+	return nil
+}
+
+// Pending implements OutboxStore
+func (store *FileOutboxStore) Pending(limit int) ([]OutboxEntry, error) {
+	ids, err := store.ids()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	entries := make([]OutboxEntry, 0, len(ids))
+	for _, id := range ids {
+		data, err := os.ReadFile(store.path(id))
+		if os.IsNotExist(err) {
+			// deleted since listing
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var entry OutboxEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Delete implements OutboxStore
+func (store *FileOutboxStore) Delete(id uint64) error {
+	err := os.Remove(store.path(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
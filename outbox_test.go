@@ -0,0 +1,123 @@
+package mqttconn
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestOutbox(t *testing.T) {
+	dir, err := os.MkdirTemp("", "outbox")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	reader, err := DialMQTT("mqtt+memory://TestOutbox/t")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer reader.Close()
+	conn, err := DialMQTT("mqtt+memory://TestOutbox")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+
+	// entries written while offline wait in the store
+	store, err := NewFileOutboxStore(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, s := range []string{"a", "b"} {
+		if err := store.Append(&OutboxEntry{Topic: "t", Payload: []byte(s), QoS: 1}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	// a restarted process opens the store again and sends them first
+	store, err = NewFileOutboxStore(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	outbox := StartOutbox(conn, store, OutboxConfig{})
+	defer outbox.Close()
+	if err := outbox.Write("t", []byte("c"), 1, false); err != nil {
+		t.Error(err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := outbox.Flush(ctx); err != nil {
+		t.Error(err)
+		return
+	}
+	reader.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 16)
+	for _, expected := range []string{"a", "b", "c"} {
+		n, err := reader.Read(buf)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(buf[:n]) != expected {
+			t.Error("expected", expected, "got", string(buf[:n]))
+			return
+		}
+	}
+	if pending, err := store.Pending(10); err != nil || len(pending) != 0 {
+		t.Error("expected the store to be empty, got", pending, err)
+	}
+}
+
+func TestOutboxRetry(t *testing.T) {
+	dir, err := os.MkdirTemp("", "outbox")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	conn, err := DialMQTT("mqtt+memory://TestOutboxRetry")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	store, err := NewFileOutboxStore(dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	failed := make(chan error, 1)
+	outbox := StartOutbox(conn, store, OutboxConfig{
+		Retry: RetryPolicy{InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond, Multiplier: 2},
+		OnError: func(entry OutboxEntry, err error) {
+			select {
+			case failed <- err:
+			default:
+			}
+		},
+	})
+	defer outbox.Close()
+	conn.Pause()
+	if err := outbox.Write("t", []byte("a"), 1, false); err != nil {
+		t.Error(err)
+		return
+	}
+	<-failed
+	if err := conn.Resume(); err != nil {
+		t.Error(err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := outbox.Flush(ctx); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,69 @@
+package mqttconn
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// WithPacing limits publishing to bytesPerSecond payload bytes, spreading bursts of writes over time
+// up to burst bytes are published without delay after a pause. Writes wait for their turn,
+// failing with a TimeoutError if that is after the write deadline. A bytesPerSecond that isn't positive disables pacing
+func WithPacing(bytesPerSecond, burst int) Option {
+	return func(conn *MQTTConn) {
+		if bytesPerSecond <= 0 {
+			conn.pacer = nil
+			return
+		}
+		conn.pacer = &pacer{
+			rate:   float64(bytesPerSecond),
+			burst:  float64(burst),
+			tokens: float64(burst),
+		}
+	}
+}
+
+// pacer is a token bucket of bytes, writers reserve the bytes they are about to publish
+type pacer struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes size bytes from the bucket, returning how long to wait before sending them
+// nothing is taken if the wait ends after deadline, zero means no deadline
+func (pacer *pacer) reserve(size int, now time.Time, deadline time.Time) (time.Duration, bool) {
+	pacer.mu.Lock()
+	defer pacer.mu.Unlock()
+	if !pacer.last.IsZero() {
+		pacer.tokens += now.Sub(pacer.last).Seconds() * pacer.rate
+		if pacer.tokens > pacer.burst {
+			pacer.tokens = pacer.burst
+		}
+	}
+	pacer.last = now
+	var wait time.Duration
+	if remaining := pacer.tokens - float64(size); remaining < 0 {
+		wait = time.Duration(-remaining / pacer.rate * float64(time.Second))
+	}
+	if !deadline.IsZero() && now.Add(wait).After(deadline) {
+		return 0, false
+	}
+	pacer.tokens -= float64(size)
+	return wait, true
+}
+
+// wait blocks until size bytes may be published
+func (pacer *pacer) wait(clock Clock, size int, deadline time.Time) error {
+	wait, ok := pacer.reserve(size, clock.Now(), deadline)
+	if !ok {
+		return &TimeoutError{errors.New("publish timed out waiting for pacing")}
+	}
+	if wait > 0 {
+		sleep(clock, wait)
+	}
+	return nil
+}
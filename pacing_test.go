@@ -0,0 +1,78 @@
+package mqttconn
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPacing(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	conn, err := DialMQTT("mqtt+memory://TestPacing", WithClock(clock), WithPacing(100, 200))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	conn.SetDefaultTopic("t")
+
+	// the burst goes out at once
+	for i := 0; i < 2; i++ {
+		if _, err := conn.Write(make([]byte, 100)); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	written := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(make([]byte, 50))
+		written <- err
+	}()
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-written:
+		t.Error("expected the write to wait for pacing")
+		return
+	case <-time.After(10 * time.Millisecond):
+	}
+	clock.Advance(500 * time.Millisecond)
+	if err := <-written; err != nil {
+		t.Error(err)
+		return
+	}
+
+	// a write that can't go out before the deadline fails without using the budget
+	conn.SetWriteDeadline(clock.Now().Add(100 * time.Millisecond))
+	_, err = conn.Write(make([]byte, 50))
+	if timeoutErr, ok := err.(*TimeoutError); !ok || !timeoutErr.Timeout() {
+		t.Error("expected a timeout, got", err)
+		return
+	}
+	conn.SetWriteDeadline(time.Time{})
+	clock.Advance(500 * time.Millisecond)
+	if _, err := conn.Write(make([]byte, 50)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPacingWithoutRate(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	conn, err := DialMQTT("mqtt+memory://TestPacingWithoutRate", WithClock(clock), WithPacing(0, 10))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	conn.SetDefaultTopic("t")
+	// without a rate writes go out at once instead of waiting forever
+	for i := 0; i < 2; i++ {
+		if _, err := conn.Write(make([]byte, 100)); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if clock.Timers() != 0 {
+		t.Error("expected no pacing, got", clock.Timers(), "timers")
+	}
+}
@@ -0,0 +1,147 @@
+package mqttconn
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+// packetMagic starts the header of a PacketConn packet
+const packetMagic = 0x51a7
+
+// PacketConn is a net.PacketConn for protocols that answer the address a packet came from, like QUIC
+// every packet carries the inbox topic of its sender, which ReadFrom returns as address,
+// so peers are addressed by a stable TopicAddr. Use it with quic-go as quic.Transport{Conn: packetConn}.
+// QUIC retransmits on its own, so conn is best dialed with QoS 0
+type PacketConn struct {
+	conn   *MQTTConn
+	inbox  string
+	header []byte
+}
+
+// NewPacketConn subscribes conn to inbox and returns a PacketConn receiving on it
+// the PacketConn takes over reading from conn and closes it on Close
+func NewPacketConn(conn *MQTTConn, inbox string) (*PacketConn, error) {
+	if err := validateTopic(inbox); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Subscribe(inbox, conn.defaults().qos); err != nil {
+		return nil, err
+	}
+	// the magic, the length of the inbox, the inbox and the check
+	size := headerMagicSize + 2 + len(inbox) + headerCheckSize
+	header := newHeader(packetMagic, size, size)
+	binary.BigEndian.PutUint16(header[2:], uint16(len(inbox)))
+	copy(header[4:], inbox)
+	sealHeader(header)
+	return &PacketConn{conn: conn, inbox: inbox, header: header}, nil
+}
+
+// parsePacket splits a packet into the inbox of its sender and the data, ok is false if it has no header
+func parsePacket(payload []byte) (from string, data []byte, ok bool) {
+	if len(payload) < headerMagicSize+2 {
+		return "", nil, false
+	}
+	size := int(binary.BigEndian.Uint16(payload[2:]))
+	if !hasHeader(payload, packetMagic, headerMagicSize+2+size+headerCheckSize) {
+		return "", nil, false
+	}
+	return string(payload[4 : 4+size]), payload[4+size+headerCheckSize:], true
+}
+
+// ReadFrom implements net.PacketConn.ReadFrom, addr is the inbox of the sender
+// messages without the header of a PacketConn are skipped
+func (conn *PacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		msg, err := conn.conn.queue.next(context.Background(), conn.conn.readDeadline, true)
+		if err != nil {
+			return 0, nil, err
+		}
+		msg.Ack()
+		if from, data, ok := parsePacket(msg.Payload); ok {
+			return copy(p, data), TopicAddr(from), nil
+		}
+	}
+}
+
+// ReadBatch reads up to len(msgs) packets like MQTTConn.ReadBatch, with Topic set to the inbox of the sender
+// it returns at least one packet unless the read fails
+func (conn *PacketConn) ReadBatch(msgs []Message) (int, error) {
+	for {
+		n, err := conn.conn.ReadBatch(msgs)
+		if err != nil {
+			return 0, err
+		}
+		valid := 0
+		for i := range msgs[:n] {
+			if from, data, ok := parsePacket(msgs[i].Payload); ok {
+				msgs[valid] = Message{
+					Topic:         from,
+					Payload:       data,
+					QoS:           msgs[i].QoS,
+					Retained:      msgs[i].Retained,
+					Duplicate:     msgs[i].Duplicate,
+					MessageID:     msgs[i].MessageID,
+					Received:      msgs[i].Received,
+					Sent:          msgs[i].Sent,
+					MatchedFilter: msgs[i].MatchedFilter,
+					Local:         msgs[i].Local,
+					ContentType:   msgs[i].ContentType,
+					CorrelationID: msgs[i].CorrelationID,
+					Headers:       msgs[i].Headers,
+				}
+				valid++
+			}
+		}
+		if valid > 0 {
+			return valid, nil
+		}
+	}
+}
+
+// WriteTo implements net.PacketConn.WriteTo, addr is the inbox of the receiver
+func (conn *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	packet := make([]byte, 0, len(conn.header)+len(p))
+	packet = append(append(packet, conn.header...), p...)
+	if _, err := conn.conn.WriteTo(packet, addr); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close implements net.PacketConn.Close, closing the MQTTConn
+func (conn *PacketConn) Close() error {
+	return conn.conn.Close()
+}
+
+// LocalAddr implements net.PacketConn.LocalAddr, it is the inbox
+func (conn *PacketConn) LocalAddr() net.Addr {
+	return TopicAddr(conn.inbox)
+}
+
+// SetDeadline implements net.PacketConn.SetDeadline
+func (conn *PacketConn) SetDeadline(t time.Time) error {
+	return conn.conn.SetDeadline(t)
+}
+
+// SetReadDeadline implements net.PacketConn.SetReadDeadline
+func (conn *PacketConn) SetReadDeadline(t time.Time) error {
+	return conn.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline implements net.PacketConn.SetWriteDeadline
+func (conn *PacketConn) SetWriteDeadline(t time.Time) error {
+	return conn.conn.SetWriteDeadline(t)
+}
+
+// SetReadBuffer does nothing, there is no socket buffer to size
+// quic-go calls it and warns if it is missing
+func (conn *PacketConn) SetReadBuffer(bytes int) error {
+	return nil
+}
+
+// SetWriteBuffer does nothing, there is no socket buffer to size
+func (conn *PacketConn) SetWriteBuffer(bytes int) error {
+	return nil
+}
@@ -0,0 +1,65 @@
+package mqttconn
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPacketConn(t *testing.T) {
+	dial := func(inbox string) *PacketConn {
+		conn, err := DialMQTT("mqtt+memory://TestPacketConn")
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		packetConn, err := NewPacketConn(conn, inbox)
+		if err != nil {
+			conn.Close()
+			t.Error(err)
+			return nil
+		}
+		packetConn.SetReadDeadline(time.Now().Add(time.Second))
+		return packetConn
+	}
+	client := dial("peers/client")
+	if client == nil {
+		return
+	}
+	defer client.Close()
+	server := dial("peers/server")
+	if server == nil {
+		return
+	}
+	defer server.Close()
+
+	// a message without the header is skipped
+	client.conn.WriteTo([]byte("stray"), TopicAddr("peers/server"))
+	// and so is one that starts like a header
+	client.conn.WriteTo([]byte{0x51, 0xa7, 0, 1, 'x', 0, 0, 0, 0, 'y'}, TopicAddr("peers/server"))
+	if _, err := client.WriteTo([]byte("hello"), server.LocalAddr()); err != nil {
+		t.Error(err)
+		return
+	}
+	buf := make([]byte, 16)
+	n, addr, err := server.ReadFrom(buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(buf[:n]) != "hello" || addr != client.LocalAddr() {
+		t.Error("unexpected packet", string(buf[:n]), "from", addr)
+		return
+	}
+	server.WriteTo([]byte("one"), addr)
+	server.WriteTo([]byte("two"), addr)
+	time.Sleep(10 * time.Millisecond)
+	msgs := make([]Message, 4)
+	n, err = client.ReadBatch(msgs)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if n != 2 || string(msgs[0].Payload) != "one" || string(msgs[1].Payload) != "two" || msgs[0].Topic != "peers/server" {
+		t.Error("unexpected batch", msgs[:n])
+	}
+}
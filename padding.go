@@ -0,0 +1,70 @@
+package mqttconn
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+const (
+	// paddingMagic starts the padding header of a payload
+	paddingMagic = 0x9d21
+	// paddingHeaderSize is the magic, the length of the payload and the check
+	paddingHeaderSize = headerMagicSize + 4 + headerCheckSize
+)
+
+// WithPadding pads payloads written with zeros to the smallest of buckets they fit in, so their size
+// only tells which bucket they fall into. Payloads larger than every bucket are padded to a multiple of the largest.
+// Put WithPadding before WithEncryption, so the padding gets encrypted too.
+// Buckets that aren't positive are ignored, without any only the header is added
+func WithPadding(buckets ...int) Option {
+	var sorted []int
+	for _, bucket := range buckets {
+		if bucket > 0 {
+			sorted = append(sorted, bucket)
+		}
+	}
+	sort.Ints(sorted)
+	return func(conn *MQTTConn) {
+		conn.codecs = append(conn.codecs, padder(sorted))
+	}
+}
+
+// padder pads payloads to the bucket sizes it holds, sorted ascending
+type padder []int
+
+// size returns the padded size of n bytes
+func (buckets padder) size(n int) int {
+	if len(buckets) == 0 {
+		return n
+	}
+	for _, bucket := range buckets {
+		if n <= bucket {
+			return bucket
+		}
+	}
+	largest := buckets[len(buckets)-1]
+	return (n + largest - 1) / largest * largest
+}
+
+// encode implements payloadCodec
+func (buckets padder) encode(topic string, payload []byte) ([][]byte, error) {
+	size := buckets.size(paddingHeaderSize + len(payload))
+	header := newHeader(paddingMagic, paddingHeaderSize, size)
+	binary.BigEndian.PutUint32(header[2:], uint32(len(payload)))
+	sealHeader(header)
+	padded := append(header, payload...)
+	return [][]byte{padded[:size]}, nil
+}
+
+// decode implements payloadCodec
+func (buckets padder) decode(msg *Message) []*Message {
+	if !hasHeader(msg.Payload, paddingMagic, paddingHeaderSize) {
+		return []*Message{msg}
+	}
+	size := binary.BigEndian.Uint32(msg.Payload[2:])
+	if uint64(size) > uint64(len(msg.Payload)-paddingHeaderSize) {
+		return []*Message{msg}
+	}
+	msg.Payload = msg.Payload[paddingHeaderSize : paddingHeaderSize+int(size)]
+	return []*Message{msg}
+}
@@ -0,0 +1,72 @@
+package mqttconn
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPadding(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestPadding/t", WithPadding(256, 64))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	conn.queue = newMessageQueue(0, conn.clock)
+
+	for _, test := range []struct {
+		size, padded int
+	}{{0, 0}, {1, 64}, {54, 64}, {55, 256}, {600, 768}} {
+		encoded, err := conn.encodePayload("t", make([]byte, test.size))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(encoded[0]) != test.padded {
+			t.Error("expected", test.size, "bytes padded to", test.padded, "got", len(encoded[0]))
+			return
+		}
+	}
+
+	payloads := [][]byte{[]byte("short"), bytes.Repeat([]byte("long"), 100)}
+	for _, payload := range payloads {
+		if _, err := conn.Write(payload); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	conn.Client.Publish("t", 0, false, []byte("unpadded")).Wait()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1024)
+	for _, expected := range append(payloads, []byte("unpadded")) {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !bytes.Equal(buf[:n], expected) {
+			t.Error("expected", len(expected), "bytes, got", n)
+			return
+		}
+	}
+}
+
+func TestPaddingBuckets(t *testing.T) {
+	for _, buckets := range [][]int{nil, {0}, {-64, 0}} {
+		conn := newMQTTConn([]Option{WithPadding(buckets...)})
+		encoded, err := conn.encodePayload("t", []byte("x"))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(encoded[0]) != paddingHeaderSize+1 {
+			t.Error("expected only the header with buckets", buckets, "got", len(encoded[0]), "bytes")
+			return
+		}
+	}
+	conn := newMQTTConn([]Option{WithPadding(-1, 32, 0)})
+	if encoded, _ := conn.encodePayload("t", []byte("x")); len(encoded[0]) != 32 {
+		t.Error("expected 32 bytes, got", len(encoded[0]))
+	}
+}
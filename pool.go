@@ -0,0 +1,170 @@
+package mqttconn
+
+import (
+	"hash/fnv"
+	"net"
+	"sort"
+	"strconv"
+	"sync/atomic"
+)
+
+// PoolBalance selects how a Pool spreads writes over its conns
+type PoolBalance int
+
+// pool balancing modes
+const (
+	// BalanceRoundRobin writes to the connected conns in turn, messages of one topic may be reordered
+	BalanceRoundRobin PoolBalance = iota
+	// BalanceTopic writes every topic through the same conn, chosen by hashing the topic,
+	// so messages of a topic stay in order
+	BalanceTopic
+	// BalanceConsistent places topics on a consistent hash ring of the conns, so every topic has one conn
+	// like with BalanceTopic, but while that conn is disconnected its topics move to the next conns on the ring
+	// and the topics of the other conns stay put. Moved topics return when the conn reconnects,
+	// messages written around the move may be reordered
+	BalanceConsistent
+)
+
+// ringReplicas is the number of points of every conn on the hash ring, more points spread topics more evenly
+const ringReplicas = 64
+
+// ringPoint is a point of a conn on the hash ring
+type ringPoint struct {
+	hash uint32
+	conn int
+}
+
+// PoolStats are the counters of a Pool
+type PoolStats struct {
+	// Writes counts the writes of every conn, in the order of Pool.Conns
+	Writes []int64
+	// Connected tells which conns are currently connected
+	Connected []bool
+	// Rerouted counts writes of BalanceConsistent sent to another conn because the conn of the topic was disconnected
+	Rerouted int64
+}
+
+// Pool publishes over several broker connections, since a single connection caps the throughput to large brokers
+// it is meant for publishing, every conn reads on its own
+type Pool struct {
+	conns    []*MQTTConn
+	balance  PoolBalance
+	next     uint32
+	ring     []ringPoint
+	writes   []int64
+	rerouted int64
+}
+
+// NewPool creates a pool of size conns opened by dial, like
+//
+//	mqttconn.NewPool(4, mqttconn.BalanceTopic, func() (*mqttconn.MQTTConn, error) {
+//		return mqttconn.DialMQTT("mqtt://broker")
+//	})
+func NewPool(size int, balance PoolBalance, dial func() (*MQTTConn, error)) (*Pool, error) {
+	pool := &Pool{balance: balance}
+	for i := 0; i < size; i++ {
+		conn, err := dial()
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+		pool.conns = append(pool.conns, conn)
+	}
+	pool.writes = make([]int64, size)
+	if balance == BalanceConsistent {
+		for i := range pool.conns {
+			for replica := 0; replica < ringReplicas; replica++ {
+				pool.ring = append(pool.ring, ringPoint{hash: hashTopic(strconv.Itoa(i) + "/" + strconv.Itoa(replica)), conn: i})
+			}
+		}
+		sort.Slice(pool.ring, func(i, j int) bool {
+			return pool.ring[i].hash < pool.ring[j].hash
+		})
+	}
+	return pool, nil
+}
+
+// hashTopic hashes a topic for BalanceTopic and BalanceConsistent
+func hashTopic(topic string) uint32 {
+	hash := fnv.New32a()
+	hash.Write([]byte(topic))
+	return hash.Sum32()
+}
+
+// Conns returns the conns of the pool
+func (pool *Pool) Conns() []*MQTTConn {
+	return pool.conns
+}
+
+// pick returns the index of the conn to write topic with
+func (pool *Pool) pick(topic string) int {
+	switch pool.balance {
+	case BalanceTopic:
+		return int(hashTopic(topic) % uint32(len(pool.conns)))
+	case BalanceConsistent:
+		return pool.pickRing(topic)
+	}
+	start := atomic.AddUint32(&pool.next, 1)
+	for i := range pool.conns {
+		index := int((start + uint32(i)) % uint32(len(pool.conns)))
+		if pool.conns[index].IsConnected() {
+			return index
+		}
+	}
+	// none is connected, the write reports it
+	return int(start % uint32(len(pool.conns)))
+}
+
+// pickRing returns the first connected conn at or after the hash of topic on the ring
+func (pool *Pool) pickRing(topic string) int {
+	hash := hashTopic(topic)
+	start := sort.Search(len(pool.ring), func(i int) bool {
+		return pool.ring[i].hash >= hash
+	})
+	home := pool.ring[start%len(pool.ring)].conn
+	for i := range pool.ring {
+		index := pool.ring[(start+i)%len(pool.ring)].conn
+		if pool.conns[index].IsConnected() {
+			if index != home {
+				atomic.AddInt64(&pool.rerouted, 1)
+			}
+			return index
+		}
+	}
+	return home
+}
+
+// WriteTo implements net.PacketConn.WriteTo, publishing through one of the conns
+func (pool *Pool) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if len(pool.conns) == 0 {
+		return 0, ErrClosed
+	}
+	index := pool.pick(addr.String())
+	atomic.AddInt64(&pool.writes[index], 1)
+	return pool.conns[index].WriteTo(b, addr)
+}
+
+// Stats returns the counters of the pool
+func (pool *Pool) Stats() PoolStats {
+	stats := PoolStats{
+		Writes:    make([]int64, len(pool.conns)),
+		Connected: make([]bool, len(pool.conns)),
+		Rerouted:  atomic.LoadInt64(&pool.rerouted),
+	}
+	for i, conn := range pool.conns {
+		stats.Writes[i] = atomic.LoadInt64(&pool.writes[i])
+		stats.Connected[i] = conn.IsConnected()
+	}
+	return stats
+}
+
+// Close closes every conn of the pool
+func (pool *Pool) Close() error {
+	var err error
+	for _, conn := range pool.conns {
+		if closeErr := conn.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+package mqttconn
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestPool(t *testing.T) {
+	for _, balance := range []PoolBalance{BalanceRoundRobin, BalanceTopic} {
+		pool, err := NewPool(3, balance, func() (*MQTTConn, error) {
+			return DialMQTT("mqtt+memory://TestPool")
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for i := 0; i < 30; i++ {
+			if _, err := pool.WriteTo([]byte("payload"), TopicAddr("topic"+strconv.Itoa(i%3))); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		used := 0
+		for _, conn := range pool.Conns() {
+			if published := conn.Stats().MessagesSent; published > 0 {
+				used++
+				if balance == BalanceTopic && published%10 != 0 {
+					t.Error("expected every topic to stick to one conn, got", published, "messages")
+					return
+				}
+			}
+		}
+		if balance == BalanceRoundRobin && used != 3 {
+			t.Error("expected writes on all 3 conns, got", used)
+			return
+		}
+		pool.Close()
+	}
+}
+
+func TestPoolConsistent(t *testing.T) {
+	pool, err := NewPool(4, BalanceConsistent, func() (*MQTTConn, error) {
+		return DialMQTT("mqtt+memory://TestPoolConsistent")
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer pool.Close()
+	home := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		topic := "topic" + strconv.Itoa(i)
+		home[topic] = pool.pick(topic)
+		if again := pool.pick(topic); again != home[topic] {
+			t.Error("expected", topic, "to stay on conn", home[topic], "got", again)
+			return
+		}
+	}
+	pool.Conns()[0].Client.Disconnect(0)
+	moved := 0
+	for topic, index := range home {
+		picked := pool.pick(topic)
+		if picked == 0 {
+			t.Error("expected", topic, "to leave the disconnected conn")
+			return
+		}
+		if picked != index {
+			moved++
+			if index != 0 {
+				t.Error("expected", topic, "of a connected conn to stay put")
+				return
+			}
+		}
+	}
+	stats := pool.Stats()
+	if moved == 0 || stats.Rerouted != int64(moved) || stats.Connected[0] {
+		t.Error("expected the topics of conn 0 to be rerouted, got", moved, stats)
+		return
+	}
+}
@@ -0,0 +1,77 @@
+package mqttconn
+
+// Priority orders messages of different subscriptions waiting to be read
+type Priority int
+
+// priorities, see SubscribePriority
+const (
+	PriorityLow Priority = iota - 1
+	PriorityNormal
+	PriorityHigh
+)
+
+// priorityWeights are the shares of the priorities when messages of all of them are waiting,
+// so lower priorities slow down but never starve
+var priorityWeights = [...]int{PriorityLow + 1: 1, PriorityNormal + 1: 2, PriorityHigh + 1: 4}
+
+// SubscribePriority subscribes like Subscribe, reading messages of higher priority subscriptions first
+// while messages of several priorities are waiting, reads are shared 4:2:1 between high, normal and low
+func (conn *MQTTConn) SubscribePriority(topic string, qos int, priority Priority) (*Subscription, error) {
+	if priority < PriorityLow || priority > PriorityHigh {
+		priority = PriorityNormal
+	}
+	return conn.subscribe(topic, qos, func(msg *Message) {
+		msg.priority = priority
+		conn.deliver(msg)
+	})
+}
+
+// pick returns the index of the message to read next, by smooth weighted round robin over the priorities
+// the scheduler only advances if commit is set, so peeking returns the message that is read next
+// must be called with mu held on a non-empty queue
+func (q *messageQueue) pick(commit bool) int {
+	first := [len(priorityWeights)]int{-1, -1, -1}
+	for i := len(q.msgs) - 1; i >= 0; i-- {
+		first[q.msgs[i].priority+1] = i
+	}
+	credits := q.credits
+	total := 0
+	chosen := -1
+	for level, index := range first {
+		if index < 0 {
+			continue
+		}
+		total += priorityWeights[level]
+		credits[level] += priorityWeights[level]
+		if chosen < 0 || credits[level] > credits[chosen] {
+			chosen = level
+		}
+	}
+	if commit {
+		credits[chosen] -= total
+		q.credits = credits
+	}
+	if q.fair {
+		return q.pickFair(Priority(chosen-1), commit)
+	}
+	return first[chosen]
+}
+
+// pickFair returns the index of the oldest message of priority from the least recently read subscription
+// must be called with mu held on a queue holding a message of priority
+func (q *messageQueue) pickFair(priority Priority, commit bool) int {
+	chosen := -1
+	for i, msg := range q.msgs {
+		if msg.priority == priority && (chosen < 0 || q.served[msg.MatchedFilter] < q.served[q.msgs[chosen].MatchedFilter]) {
+			chosen = i
+		}
+	}
+	if commit {
+		if q.served == nil {
+			q.served = make(map[string]uint64)
+		}
+		q.tick++
+		q.served[q.msgs[chosen].MatchedFilter] = q.tick
+	}
+	return chosen
+}
@@ -0,0 +1,38 @@
+package mqttconn
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPriorityQueue(t *testing.T) {
+	q := newMessageQueue(0, realClock{})
+	for i := 0; i < 8; i++ {
+		q.push(&Message{Topic: "low", priority: PriorityLow})
+		q.push(&Message{Topic: "normal"})
+		q.push(&Message{Topic: "high", priority: PriorityHigh})
+	}
+	peeked, err := q.next(context.Background(), time.Time{}, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	counts := make(map[string]int)
+	for i := 0; i < 7; i++ {
+		msg, err := q.next(context.Background(), time.Time{}, true)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if i == 0 && msg != peeked {
+			t.Error("expected peeked message to be read first")
+			return
+		}
+		counts[msg.Topic]++
+	}
+	if counts["high"] != 4 || counts["normal"] != 2 || counts["low"] != 1 {
+		t.Error("expected 4:2:1 shares, got", counts)
+		return
+	}
+}
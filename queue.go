@@ -0,0 +1,212 @@
+package mqttconn
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// messageQueue is a bounded FIFO of received messages
+// unlike a channel, the head of the queue can be inspected without removing it
+type messageQueue struct {
+	mu       sync.Mutex
+	msgs     []*Message
+	capacity int
+	closed   bool
+	changed  chan struct{}
+	clock    Clock
+	// credits of the priorities, see pick
+	credits [len(priorityWeights)]int
+	// fair takes turns between subscriptions, served is when each filter was last read, see pickFair
+	fair   bool
+	served map[string]uint64
+	tick   uint64
+	// ttl discards messages waiting longer, zero means forever
+	ttl      time.Duration
+	onExpire func(*Message)
+	// discarded are the messages dropped or expired while mu is held, reported by unlock
+	discarded []discard
+}
+
+// discard is a message the queue dropped, because its buffer was full or it expired
+type discard struct {
+	msg     *Message
+	expired bool
+}
+
+func newMessageQueue(capacity int, clock Clock) *messageQueue {
+	return &messageQueue{
+		capacity: capacity,
+		changed:  make(chan struct{}),
+		clock:    clock,
+	}
+}
+
+// unlock releases mu and then reports the discarded messages, so the callbacks may use the conn
+func (q *messageQueue) unlock() {
+	pending := q.discarded
+	q.discarded = nil
+	q.mu.Unlock()
+	for _, item := range pending {
+		if !item.expired {
+			item.msg.buffer.drop(item.msg)
+		} else if q.onExpire != nil {
+			q.onExpire(item.msg)
+		}
+	}
+}
+
+// signal wakes up everyone waiting on the queue, must be called with mu held
+func (q *messageQueue) signal() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// push appends a message, blocking while the queue, or the subscription buffer of the message, is full
+// returns false if the queue is closed
+func (q *messageQueue) push(msg *Message) bool {
+	q.mu.Lock()
+	defer q.unlock()
+	for !q.closed {
+		ok, dropped := q.makeRoom(msg)
+		if dropped {
+			return true
+		}
+		if ok {
+			break
+		}
+		changed := q.changed
+		q.unlock()
+		<-changed
+		q.mu.Lock()
+	}
+	if q.closed {
+		return false
+	}
+	q.msgs = append(q.msgs, msg)
+	q.signal()
+	return true
+}
+
+// pushFront puts a message at the head of the queue, ignoring capacity
+// it is used for redelivery and must never block the reader
+func (q *messageQueue) pushFront(msg *Message) {
+	q.mu.Lock()
+	defer q.unlock()
+	if q.closed {
+		return
+	}
+	q.msgs = append([]*Message{msg}, q.msgs...)
+	q.signal()
+}
+
+// pushBack appends a message ignoring capacity, for messages the writer of the conn must not wait for
+func (q *messageQueue) pushBack(msg *Message) {
+	q.mu.Lock()
+	defer q.unlock()
+	if q.closed {
+		return
+	}
+	q.msgs = append(q.msgs, msg)
+	q.signal()
+}
+
+// next waits for the message at the head of the queue, removing it if remove is set
+// it gives up when ctx is done or deadline (if non-zero) passes
+func (q *messageQueue) next(ctx context.Context, deadline time.Time, remove bool) (*Message, error) {
+	timer := newDeadlineTimer(q.clock, deadline)
+	defer timer.stop()
+	if timer.passed() {
+		return nil, &TimeoutError{errors.New("read timed out")}
+	}
+	q.mu.Lock()
+	defer q.unlock()
+	for q.expire(); len(q.msgs) == 0 || q.closed; q.expire() {
+		if q.closed {
+			return nil, ErrClosed
+		}
+		changed := q.changed
+		q.unlock()
+		select {
+		case <-changed:
+		case <-timer.C():
+			if !timer.expired() {
+				break
+			}
+			q.mu.Lock()
+			return nil, &TimeoutError{errors.New("read timed out")}
+		case <-ctx.Done():
+			q.mu.Lock()
+			return nil, ctx.Err()
+		}
+		q.mu.Lock()
+	}
+	i := q.pick(remove)
+	msg := q.msgs[i]
+	if remove {
+		if i == 0 {
+			q.msgs[0] = nil
+			q.msgs = q.msgs[1:]
+		} else {
+			q.msgs = append(q.msgs[:i], q.msgs[i+1:]...)
+		}
+		q.signal()
+	}
+	return msg, nil
+}
+
+// take removes up to max messages without waiting
+func (q *messageQueue) take(max int) []*Message {
+	q.mu.Lock()
+	defer q.unlock()
+	if q.closed || max <= 0 {
+		return nil
+	}
+	q.expire()
+	var msgs []*Message
+	for len(msgs) < max && len(q.msgs) > 0 {
+		i := q.pick(true)
+		msgs = append(msgs, q.msgs[i])
+		q.msgs = append(q.msgs[:i], q.msgs[i+1:]...)
+	}
+	if len(msgs) > 0 {
+		q.signal()
+	}
+	return msgs
+}
+
+// expire drops messages older than the ttl, must be called with mu held
+func (q *messageQueue) expire() {
+	if q.ttl <= 0 {
+		return
+	}
+	now := q.clock.Now()
+	kept := q.msgs[:0]
+	for _, msg := range q.msgs {
+		if now.Sub(msg.Received) < q.ttl {
+			kept = append(kept, msg)
+		} else {
+			q.discarded = append(q.discarded, discard{msg: msg, expired: true})
+		}
+	}
+	if len(kept) == len(q.msgs) {
+		return
+	}
+	for i := len(kept); i < len(q.msgs); i++ {
+		q.msgs[i] = nil
+	}
+	q.msgs = kept
+	q.signal()
+}
+
+// close wakes up all waiters, pending messages are no longer readable
+func (q *messageQueue) close() {
+	q.mu.Lock()
+	defer q.unlock()
+	if !q.closed {
+		q.closed = true
+		q.signal()
+	}
+}
@@ -0,0 +1,129 @@
+package mqttconn
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestQueuePeek(t *testing.T) {
+	q := newMessageQueue(2, realClock{})
+	q.push(&Message{Topic: "a", Payload: []byte("1")})
+	q.push(&Message{Topic: "b", Payload: []byte("2")})
+	peeked, err := q.next(context.Background(), time.Time{}, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	read, err := q.next(context.Background(), time.Time{}, true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if peeked != read {
+		t.Error("expected peeked message", peeked.Topic, "got", read.Topic)
+		return
+	}
+	read, err = q.next(context.Background(), time.Time{}, true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if read.Topic != "b" {
+		t.Error("expected topic b, got", read.Topic)
+		return
+	}
+}
+
+func TestQueueTimeout(t *testing.T) {
+	q := newMessageQueue(2, realClock{})
+	_, err := q.next(context.Background(), time.Now().Add(10*time.Millisecond), true)
+	if err == nil {
+		t.Error("expected timeout error")
+		return
+	}
+	if timeoutErr, ok := err.(*TimeoutError); !ok || !timeoutErr.Timeout() {
+		t.Error("expected timeout error, got", err)
+		return
+	}
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Error("expected timeout error to be os.ErrDeadlineExceeded")
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = q.next(ctx, time.Time{}, false)
+	if err != context.Canceled {
+		t.Error("expected context.Canceled, got", err)
+		return
+	}
+	q.close()
+	if q.push(&Message{}) {
+		t.Error("expected push to fail after close")
+		return
+	}
+}
+
+func TestQueueTTL(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	q := newMessageQueue(0, clock)
+	q.ttl = time.Minute
+	var expired []*Message
+	q.onExpire = func(msg *Message) {
+		expired = append(expired, msg)
+	}
+	q.push(&Message{Topic: "stale", Received: clock.Now()})
+	clock.Advance(30 * time.Second)
+	q.push(&Message{Topic: "fresh", Received: clock.Now()})
+	clock.Advance(30 * time.Second)
+	msg, err := q.next(context.Background(), time.Time{}, true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if msg.Topic != "fresh" || len(expired) != 1 || expired[0].Topic != "stale" {
+		t.Error("expected stale message to expire, read", msg.Topic)
+		return
+	}
+}
+
+func TestQueueFair(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestQueueFair", WithFairReads())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	conn.queue = newMessageQueue(0, conn.clock)
+	conn.queue.fair = true
+	for _, topic := range []string{"chatty", "quiet"} {
+		if _, err := conn.Subscribe(topic, 0); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	for i := 0; i < 4; i++ {
+		conn.WriteTo([]byte("chatty"), TopicAddr("chatty"))
+	}
+	conn.WriteTo([]byte("quiet1"), TopicAddr("quiet"))
+	conn.WriteTo([]byte("quiet2"), TopicAddr("quiet"))
+	for conn.Stats().MessagesReceived < 6 {
+		time.Sleep(time.Millisecond)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 16)
+	for _, expected := range []string{"chatty", "quiet1", "chatty", "quiet2", "chatty", "chatty"} {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(buf[:n]) != expected {
+			t.Error("expected", expected, "got", string(buf[:n]))
+			return
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package mqttconn
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// QuotaPolicy controls the bandwidth accounting of WithQuota
+type QuotaPolicy struct {
+	// Interval is the accounting period, e.g. 24 hours for a daily data plan,
+	// zero makes the life of the conn a single period, which Throttle can't wait out
+	Interval time.Duration
+	// Limit is the number of payload bytes that may be sent per interval, zero means no limit
+	Limit int64
+	// Throttle makes writes over the limit wait for the next interval instead of failing with ErrQuotaExceeded
+	// writes still fail with a TimeoutError if that is after the write deadline
+	Throttle bool
+	// OnInterval is called with the usage of every interval that ended, if it is not nil
+	OnInterval func(usage Usage)
+}
+
+// Usage is the traffic of a MQTTConn during a quota interval
+type Usage struct {
+	Start         time.Time
+	BytesSent     int64
+	BytesReceived int64
+}
+
+// WithQuota counts payload bytes sent and received per interval, see Usage, and limits the bytes sent
+// bytes count when publishing is attempted, so failed and retried publishes use the quota too
+func WithQuota(policy QuotaPolicy) Option {
+	return func(conn *MQTTConn) {
+		conn.quota = &quota{policy: policy}
+	}
+}
+
+// Usage returns the traffic of the current quota interval, it is zero without WithQuota
+func (conn *MQTTConn) Usage() Usage {
+	if conn.quota == nil {
+		return Usage{}
+	}
+	usage, ended := conn.quota.current(conn.clock.Now())
+	conn.quota.report(ended)
+	return usage
+}
+
+// quota holds the usage of the current interval
+type quota struct {
+	policy QuotaPolicy
+
+	mu    sync.Mutex
+	usage Usage
+}
+
+// roll starts the interval containing now, returning the usage of the interval that ended, if any
+// the caller must hold quota.mu
+func (quota *quota) roll(now time.Time) *Usage {
+	if quota.usage.Start.IsZero() {
+		quota.usage.Start = now
+		return nil
+	}
+	elapsed := now.Sub(quota.usage.Start)
+	if quota.policy.Interval <= 0 || elapsed < quota.policy.Interval {
+		return nil
+	}
+	ended := quota.usage
+	quota.usage = Usage{Start: ended.Start.Add(elapsed - elapsed%quota.policy.Interval)}
+	return &ended
+}
+
+// current returns the usage of the interval containing now
+func (quota *quota) current(now time.Time) (Usage, *Usage) {
+	quota.mu.Lock()
+	defer quota.mu.Unlock()
+	ended := quota.roll(now)
+	return quota.usage, ended
+}
+
+// report passes the usage of an interval that ended to OnInterval
+func (quota *quota) report(ended *Usage) {
+	if ended != nil && quota.policy.OnInterval != nil {
+		quota.policy.OnInterval(*ended)
+	}
+}
+
+// received counts size bytes received
+func (quota *quota) received(now time.Time, size int) {
+	quota.mu.Lock()
+	ended := quota.roll(now)
+	quota.usage.BytesReceived += int64(size)
+	quota.mu.Unlock()
+	quota.report(ended)
+}
+
+// reserve counts size bytes sent if they fit in the limit, otherwise it returns when the next interval starts
+func (quota *quota) reserve(now time.Time, size int) (bool, time.Time, *Usage) {
+	quota.mu.Lock()
+	defer quota.mu.Unlock()
+	ended := quota.roll(now)
+	if quota.policy.Limit > 0 && quota.usage.BytesSent+int64(size) > quota.policy.Limit {
+		return false, quota.usage.Start.Add(quota.policy.Interval), ended
+	}
+	quota.usage.BytesSent += int64(size)
+	return true, time.Time{}, ended
+}
+
+// take waits until size bytes may be sent, zero deadline means no deadline
+func (quota *quota) take(clock Clock, size int, deadline time.Time) error {
+	for {
+		now := clock.Now()
+		ok, next, ended := quota.reserve(now, size)
+		quota.report(ended)
+		if ok {
+			return nil
+		}
+		if !quota.policy.Throttle || quota.policy.Interval <= 0 || int64(size) > quota.policy.Limit {
+			return ErrQuotaExceeded
+		}
+		if !deadline.IsZero() && next.After(deadline) {
+			return &TimeoutError{errors.New("publish timed out waiting for quota")}
+		}
+		sleep(clock, next.Sub(now))
+	}
+}
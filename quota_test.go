@@ -0,0 +1,112 @@
+package mqttconn
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuota(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	intervals := make(chan Usage, 1)
+	conn, err := DialMQTT("mqtt+memory://TestQuota/t", WithClock(clock), WithQuota(QuotaPolicy{
+		Interval:   time.Hour,
+		Limit:      150,
+		OnInterval: func(usage Usage) { intervals <- usage },
+	}))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write(make([]byte, 100)); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := conn.Write(make([]byte, 100)); err != ErrQuotaExceeded {
+		t.Error("expected ErrQuotaExceeded, got", err)
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 200)); err != nil {
+		t.Error(err)
+		return
+	}
+	if usage := conn.Usage(); usage.BytesSent != 100 || usage.BytesReceived != 100 {
+		t.Error("unexpected usage", usage)
+		return
+	}
+	clock.Advance(time.Hour)
+	if _, err := conn.Write(make([]byte, 100)); err != nil {
+		t.Error(err)
+		return
+	}
+	if usage := <-intervals; !usage.Start.Equal(time.Unix(0, 0)) || usage.BytesSent != 100 {
+		t.Error("unexpected usage of the first interval", usage)
+	}
+}
+
+func TestQuotaThrottle(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	conn, err := DialMQTT("mqtt+memory://TestQuotaThrottle", WithClock(clock), WithQuota(QuotaPolicy{
+		Interval: time.Hour,
+		Limit:    100,
+		Throttle: true,
+	}))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	conn.SetDefaultTopic("t")
+	if _, err := conn.Write(make([]byte, 100)); err != nil {
+		t.Error(err)
+		return
+	}
+	written := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(make([]byte, 50))
+		written <- err
+	}()
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-written:
+		t.Error("expected the write to wait for the next interval")
+		return
+	case <-time.After(10 * time.Millisecond):
+	}
+	clock.Advance(time.Hour)
+	if err := <-written; err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := conn.Write(make([]byte, 200)); err != ErrQuotaExceeded {
+		t.Error("expected ErrQuotaExceeded for a write over the limit, got", err)
+	}
+}
+
+func TestQuotaWithoutInterval(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	conn, err := DialMQTT("mqtt+memory://TestQuotaWithoutInterval", WithClock(clock), WithQuota(QuotaPolicy{
+		Limit:    150,
+		Throttle: true,
+	}))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.WriteTo(make([]byte, 100), TopicAddr("t")); err != nil {
+		t.Error(err)
+		return
+	}
+	clock.Advance(24 * time.Hour)
+	if _, err := conn.WriteTo(make([]byte, 100), TopicAddr("t")); err != ErrQuotaExceeded {
+		t.Error("expected ErrQuotaExceeded, got", err)
+		return
+	}
+	if usage := conn.Usage(); usage.BytesSent != 100 || !usage.Start.Equal(time.Unix(0, 0)) {
+		t.Error("expected a single interval, got", usage)
+	}
+}
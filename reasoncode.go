@@ -0,0 +1,190 @@
+package mqttconn
+
+import (
+	"fmt"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// ReasonCode is a MQTT 5 reason code
+// MQTT 3.1.1 return codes are translated to their MQTT 5 equivalent, so applications only deal with one set
+type ReasonCode byte
+
+// reason codes for failures
+const (
+	ReasonUnspecifiedError                  ReasonCode = 0x80
+	ReasonMalformedPacket                   ReasonCode = 0x81
+	ReasonProtocolError                     ReasonCode = 0x82
+	ReasonImplementationSpecificError       ReasonCode = 0x83
+	ReasonUnsupportedProtocolVersion        ReasonCode = 0x84
+	ReasonClientIdentifierNotValid          ReasonCode = 0x85
+	ReasonBadUserNameOrPassword             ReasonCode = 0x86
+	ReasonNotAuthorized                     ReasonCode = 0x87
+	ReasonServerUnavailable                 ReasonCode = 0x88
+	ReasonServerBusy                        ReasonCode = 0x89
+	ReasonBanned                            ReasonCode = 0x8A
+	ReasonBadAuthenticationMethod           ReasonCode = 0x8C
+	ReasonKeepAliveTimeout                  ReasonCode = 0x8D
+	ReasonTopicFilterInvalid                ReasonCode = 0x8F
+	ReasonTopicNameInvalid                  ReasonCode = 0x90
+	ReasonPacketIdentifierInUse             ReasonCode = 0x91
+	ReasonPacketTooLarge                    ReasonCode = 0x95
+	ReasonQuotaExceeded                     ReasonCode = 0x97
+	ReasonPayloadFormatInvalid              ReasonCode = 0x99
+	ReasonRetainNotSupported                ReasonCode = 0x9A
+	ReasonQoSNotSupported                   ReasonCode = 0x9B
+	ReasonUseAnotherServer                  ReasonCode = 0x9C
+	ReasonServerMoved                       ReasonCode = 0x9D
+	ReasonSharedSubscriptionsNotSupported   ReasonCode = 0x9E
+	ReasonConnectionRateExceeded            ReasonCode = 0x9F
+	ReasonSubscriptionIDsNotSupported       ReasonCode = 0xA1
+	ReasonWildcardSubscriptionsNotSupported ReasonCode = 0xA2
+)
+
+var reasonCodeNames = map[ReasonCode]string{
+	ReasonUnspecifiedError:                  "unspecified error",
+	ReasonMalformedPacket:                   "malformed packet",
+	ReasonProtocolError:                     "protocol error",
+	ReasonImplementationSpecificError:       "implementation specific error",
+	ReasonUnsupportedProtocolVersion:        "unsupported protocol version",
+	ReasonClientIdentifierNotValid:          "client identifier not valid",
+	ReasonBadUserNameOrPassword:             "bad user name or password",
+	ReasonNotAuthorized:                     "not authorized",
+	ReasonServerUnavailable:                 "server unavailable",
+	ReasonServerBusy:                        "server busy",
+	ReasonBanned:                            "banned",
+	ReasonBadAuthenticationMethod:           "bad authentication method",
+	ReasonKeepAliveTimeout:                  "keep alive timeout",
+	ReasonTopicFilterInvalid:                "topic filter invalid",
+	ReasonTopicNameInvalid:                  "topic name invalid",
+	ReasonPacketIdentifierInUse:             "packet identifier in use",
+	ReasonPacketTooLarge:                    "packet too large",
+	ReasonQuotaExceeded:                     "quota exceeded",
+	ReasonPayloadFormatInvalid:              "payload format invalid",
+	ReasonRetainNotSupported:                "retain not supported",
+	ReasonQoSNotSupported:                   "QoS not supported",
+	ReasonUseAnotherServer:                  "use another server",
+	ReasonServerMoved:                       "server moved",
+	ReasonSharedSubscriptionsNotSupported:   "shared subscriptions not supported",
+	ReasonConnectionRateExceeded:            "connection rate exceeded",
+	ReasonSubscriptionIDsNotSupported:       "subscription identifiers not supported",
+	ReasonWildcardSubscriptionsNotSupported: "wildcard subscriptions not supported",
+}
+
+// String returns the description of the reason code from the MQTT 5 specification
+func (code ReasonCode) String() string {
+	name, ok := reasonCodeNames[code]
+	if !ok {
+		return fmt.Sprintf("reason code 0x%02X", byte(code))
+	}
+	return name
+}
+
+// v3ConnackCodes translates MQTT 3.1.1 CONNACK return codes
+var v3ConnackCodes = map[byte]ReasonCode{
+	0x01: ReasonUnsupportedProtocolVersion,
+	0x02: ReasonClientIdentifierNotValid,
+	0x03: ReasonServerUnavailable,
+	0x04: ReasonBadUserNameOrPassword,
+	0x05: ReasonNotAuthorized,
+}
+
+// ReasonCodeError is returned when the broker rejects a connect, subscribe or publish,
+// with WithMQTT5 it is also passed to the connection lost handler when the broker disconnects the client
+type ReasonCodeError struct {
+	// Op is the rejected operation: "connect", "subscribe", "publish" or "disconnect"
+	Op string
+	// Topic is the topic or filter for subscribe and publish
+	Topic string
+	Code  ReasonCode
+	// Reason is the reason string sent by a MQTT 5 broker, if any
+	Reason string
+	// Err is the error reported by the client library
+	Err error
+}
+
+func (err *ReasonCodeError) Error() string {
+	message := err.Op + " failed: " + err.Code.String()
+	if err.Topic != "" {
+		message = err.Op + " " + err.Topic + " failed: " + err.Code.String()
+	}
+	if err.Reason != "" {
+		message += ": " + err.Reason
+	}
+	return message
+}
+
+// Unwrap returns the error reported by the client library
+func (err *ReasonCodeError) Unwrap() error {
+	return err.Err
+}
+
+// reasonCodeClasses groups reason codes so callers can tell retrying apart from alerting a human
+var reasonCodeClasses = map[ReasonCode]*Error{
+	ReasonBadUserNameOrPassword:       ErrBadCredentials,
+	ReasonBadAuthenticationMethod:     ErrBadCredentials,
+	ReasonNotAuthorized:               ErrNotAuthorized,
+	ReasonBanned:                      ErrNotAuthorized,
+	ReasonServerUnavailable:           ErrServerUnavailable,
+	ReasonServerBusy:                  ErrServerUnavailable,
+	ReasonUseAnotherServer:            ErrServerUnavailable,
+	ReasonServerMoved:                 ErrServerUnavailable,
+	ReasonConnectionRateExceeded:      ErrServerUnavailable,
+	ReasonUnsupportedProtocolVersion:  ErrProtocol,
+	ReasonClientIdentifierNotValid:    ErrProtocol,
+	ReasonMalformedPacket:             ErrProtocol,
+	ReasonProtocolError:               ErrProtocol,
+	ReasonImplementationSpecificError: ErrProtocol,
+}
+
+// Is makes the error match ErrBadCredentials, ErrNotAuthorized, ErrServerUnavailable or ErrProtocol
+// depending on the reason code, so errors.Is classifies a failed DialMQTT
+func (err *ReasonCodeError) Is(target error) bool {
+	class, ok := reasonCodeClasses[err.Code]
+	return ok && target == class
+}
+
+// Timeout implements net.Error.Timeout, it is true for a broker disconnecting a client that missed its keep alive
+func (err *ReasonCodeError) Timeout() bool {
+	return err.Code == ReasonKeepAliveTimeout
+}
+
+// Temporary implements net.Error.Temporary, it is true if the broker may accept a retry later
+func (err *ReasonCodeError) Temporary() bool {
+	return reasonCodeClasses[err.Code] == ErrServerUnavailable
+}
+
+// connectError converts a failed connect token
+func connectError(token mqtt.Token) error {
+	err := token.Error()
+	if err == nil {
+		return nil
+	}
+	connectToken, ok := token.(*mqtt.ConnectToken)
+	if !ok {
+		return err
+	}
+	code, ok := v3ConnackCodes[connectToken.ReturnCode()]
+	if !ok {
+		return err
+	}
+	return &ReasonCodeError{Op: "connect", Code: code, Err: err}
+}
+
+// subscribeError converts a failed subscribe token
+// MQTT 3.1.1 brokers reject a subscription by granting QoS 0x80
+func subscribeError(token mqtt.Token) error {
+	if err := token.Error(); err != nil {
+		return err
+	}
+	subscribeToken, ok := token.(subscribeResult)
+	if !ok {
+		return nil
+	}
+	for filter, qos := range subscribeToken.Result() {
+		if qos >= byte(ReasonUnspecifiedError) {
+			return &ReasonCodeError{Op: "subscribe", Topic: filter, Code: ReasonCode(qos)}
+		}
+	}
+	return nil
+}
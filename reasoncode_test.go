@@ -0,0 +1,58 @@
+package mqttconn
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReasonCodeError(t *testing.T) {
+	var err error = &ReasonCodeError{Op: "subscribe", Topic: "a/b", Code: ReasonQuotaExceeded, Reason: "slow down"}
+	if err.Error() != "subscribe a/b failed: quota exceeded: slow down" {
+		t.Error("unexpected error message", err.Error())
+		return
+	}
+	var reasonErr *ReasonCodeError
+	if !errors.As(err, &reasonErr) || reasonErr.Code != 0x97 {
+		t.Error("expected reason code 0x97")
+		return
+	}
+	if ReasonCode(0x42).String() != "reason code 0x42" {
+		t.Error("unexpected unknown reason code description", ReasonCode(0x42).String())
+		return
+	}
+}
+
+func TestReasonCodeErrorClass(t *testing.T) {
+	for code, class := range map[ReasonCode]error{
+		ReasonBadUserNameOrPassword:      ErrBadCredentials,
+		ReasonNotAuthorized:              ErrNotAuthorized,
+		ReasonServerUnavailable:          ErrServerUnavailable,
+		ReasonUnsupportedProtocolVersion: ErrProtocol,
+	} {
+		var err error = &ReasonCodeError{Op: "connect", Code: code}
+		if !errors.Is(err, class) {
+			t.Error("expected", code, "to match", class)
+			return
+		}
+		if errors.Is(err, ErrClosed) {
+			t.Error("expected", code, "not to match", ErrClosed)
+			return
+		}
+	}
+	if !(&ReasonCodeError{Code: ReasonServerBusy}).Temporary() {
+		t.Error("expected server busy to be temporary")
+		return
+	}
+	if (&ReasonCodeError{Code: ReasonBadUserNameOrPassword}).Temporary() {
+		t.Error("expected bad credentials not to be temporary")
+		return
+	}
+	if !(&ReasonCodeError{Code: ReasonKeepAliveTimeout}).Timeout() || (&ReasonCodeError{Code: ReasonServerBusy}).Timeout() {
+		t.Error("expected only the keep alive timeout to be a timeout")
+		return
+	}
+	if errors.Is(&ReasonCodeError{Code: ReasonQuotaExceeded}, ErrProtocol) {
+		t.Error("expected quota exceeded not to be classified")
+		return
+	}
+}
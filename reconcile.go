@@ -0,0 +1,120 @@
+package mqttconn
+
+// SubOptions are the options of a subscription set with SetSubscriptions
+type SubOptions struct {
+	QoS int
+}
+
+// SetSubscriptions makes subs the subscriptions read with ReadFrom, replacing those made before
+// with Subscribe and SetSubscriptions. New filters and filters with a changed QoS are subscribed with a single
+// SUBSCRIBE, filters missing from subs are unsubscribed with a single UNSUBSCRIBE, filters that stay
+// are left alone. If the broker rejects any filter, none of the changes are made and a *ReasonCodeError is returned
+func (conn *MQTTConn) SetSubscriptions(subs map[string]SubOptions) error {
+	for filter := range subs {
+		if err := validateFilter(filter); err != nil {
+			return err
+		}
+	}
+	conn.subscribeMu.Lock()
+	defer conn.subscribeMu.Unlock()
+	conn.subsMu.Lock()
+	current := make(map[string]*localSubscription, len(conn.subscribed))
+	for filter, sub := range conn.subscribed {
+		current[filter] = sub
+	}
+	conn.subsMu.Unlock()
+
+	added := make(map[string]*localSubscription)
+	filters := make(map[string]byte)
+	// previousQoS is the broker QoS of filters already subscribed, restored if the broker rejects the changes
+	previousQoS := make(map[string]byte)
+	for filter, options := range subs {
+		if previous := current[filter]; previous != nil && previous.requested == byte(options.QoS) {
+			continue
+		}
+		conn.subsMu.Lock()
+		if shared, ok := conn.subscriptions[filter]; ok {
+			previousQoS[filter] = shared.qos
+		}
+		conn.subsMu.Unlock()
+		sub, qos := conn.addLocal(filter, byte(options.QoS), conn.deliver)
+		added[filter] = sub
+		filters[conn.remoteTopic(filter)] = qos
+		conn.Client.AddRoute(conn.remoteTopic(filter), conn.dispatcher(filter))
+	}
+	if len(filters) > 0 {
+		token := conn.Client.SubscribeMultiple(filters, nil)
+		token.Wait()
+		if err := subscribeError(token); err != nil {
+			// undo the filters the broker accepted
+			var accepted []*localSubscription
+			for _, sub := range added {
+				accepted = append(accepted, sub)
+			}
+			conn.unsubscribeLocal(accepted)
+			conn.restoreQoS(previousQoS, filters)
+			return err
+		}
+		for filter, sub := range added {
+			remote := conn.remoteTopic(filter)
+			sub.granted = grantedQoS(token, remote, filters[remote])
+		}
+	}
+
+	var removed []*localSubscription
+	conn.subsMu.Lock()
+	for filter, sub := range added {
+		if previous := conn.subscribed[filter]; previous != nil {
+			removed = append(removed, previous)
+		}
+		conn.subscribed[filter] = sub
+	}
+	for filter, sub := range current {
+		if _, ok := subs[filter]; !ok && conn.subscribed[filter] == sub {
+			delete(conn.subscribed, filter)
+			removed = append(removed, sub)
+		}
+	}
+	conn.subsMu.Unlock()
+	return conn.unsubscribeLocal(removed)
+}
+
+// restoreQoS sets filters back to their previous QoS after a rejected SUBSCRIBE, subscribing those
+// requested at another QoS again, so the broker doesn't keep a change that was not made
+// must be called with subscribeMu held
+func (conn *MQTTConn) restoreQoS(previousQoS map[string]byte, requested map[string]byte) {
+	restore := make(map[string]byte)
+	conn.subsMu.Lock()
+	for filter, qos := range previousQoS {
+		if shared, ok := conn.subscriptions[filter]; ok {
+			shared.qos = qos
+		}
+		if remote := conn.remoteTopic(filter); requested[remote] != qos {
+			restore[remote] = qos
+		}
+	}
+	conn.subsMu.Unlock()
+	if len(restore) > 0 {
+		conn.Client.SubscribeMultiple(restore, nil).Wait()
+	}
+}
+
+// unsubscribeLocal removes consumers, unsubscribing the filters left without one in a single UNSUBSCRIBE
+// must be called with subscribeMu held
+func (conn *MQTTConn) unsubscribeLocal(subs []*localSubscription) error {
+	var filters []string
+	for _, sub := range subs {
+		if sub.queue != nil {
+			sub.queue.close()
+		}
+		if conn.removeLocal(sub) {
+			filters = append(filters, conn.remoteTopic(sub.filter))
+		}
+	}
+	if len(filters) == 0 {
+		return nil
+	}
+	token := conn.Client.Unsubscribe(filters...)
+	token.Wait()
+	return token.Error()
+}
@@ -0,0 +1,103 @@
+package mqttconn
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// batchRecorder records the filters of every SUBSCRIBE and UNSUBSCRIBE
+type batchRecorder struct {
+	mqtt.Client
+	subscribes   []map[string]byte
+	unsubscribes [][]string
+	reject       bool
+}
+
+func (client *batchRecorder) SubscribeMultiple(filters map[string]byte, callback mqtt.MessageHandler) mqtt.Token {
+	client.subscribes = append(client.subscribes, filters)
+	if client.reject {
+		return &memoryToken{errors.New("rejected")}
+	}
+	return &mqtt.DummyToken{}
+}
+
+func (client *batchRecorder) Unsubscribe(topics ...string) mqtt.Token {
+	sorted := append([]string(nil), topics...)
+	sort.Strings(sorted)
+	client.unsubscribes = append(client.unsubscribes, sorted)
+	return &mqtt.DummyToken{}
+}
+
+func (client *batchRecorder) AddRoute(topic string, callback mqtt.MessageHandler) {}
+
+func (client *batchRecorder) Disconnect(quiesce uint) {}
+
+func TestSetSubscriptions(t *testing.T) {
+	client := &batchRecorder{}
+	conn, _ := CreateMQTTConn(client)
+	defer conn.Close()
+
+	steps := []struct {
+		subs        map[string]SubOptions
+		subscribe   map[string]byte
+		unsubscribe []string
+	}{
+		{map[string]SubOptions{"a": {QoS: 1}, "b": {}}, map[string]byte{"a": 1, "b": 0}, nil},
+		// a stays, b changes QoS, c is new
+		{map[string]SubOptions{"a": {QoS: 1}, "b": {QoS: 1}, "c": {}}, map[string]byte{"b": 1, "c": 0}, nil},
+		{map[string]SubOptions{"c": {}}, nil, []string{"a", "b"}},
+		{map[string]SubOptions{"c": {}}, nil, nil},
+	}
+	for i, step := range steps {
+		client.subscribes, client.unsubscribes = nil, nil
+		if err := conn.SetSubscriptions(step.subs); err != nil {
+			t.Error(err)
+			return
+		}
+		var subscribe map[string]byte
+		if len(client.subscribes) > 0 {
+			subscribe = client.subscribes[0]
+		}
+		var unsubscribe []string
+		if len(client.unsubscribes) > 0 {
+			unsubscribe = client.unsubscribes[0]
+		}
+		if len(client.subscribes) > 1 || len(client.unsubscribes) > 1 ||
+			!reflect.DeepEqual(subscribe, step.subscribe) || !reflect.DeepEqual(unsubscribe, step.unsubscribe) {
+			t.Error("step", i, "expected", step.subscribe, step.unsubscribe, "got", client.subscribes, client.unsubscribes)
+			return
+		}
+	}
+
+	// a rejected subscription changes nothing
+	client.reject = true
+	if err := conn.SetSubscriptions(map[string]SubOptions{"d": {}}); err == nil {
+		t.Error("expected the rejection")
+		return
+	}
+	conn.subsMu.Lock()
+	_, c := conn.subscribed["c"]
+	_, d := conn.subscriptions["d"]
+	conn.subsMu.Unlock()
+	if !c || d {
+		t.Error("expected only c to stay subscribed")
+		return
+	}
+
+	// a rejected QoS change subscribes the previous QoS again
+	client.subscribes = nil
+	if err := conn.SetSubscriptions(map[string]SubOptions{"c": {QoS: 2}}); err == nil {
+		t.Error("expected the rejection")
+		return
+	}
+	conn.subsMu.Lock()
+	qos := conn.subscriptions["c"].qos
+	conn.subsMu.Unlock()
+	if len(client.subscribes) != 2 || !reflect.DeepEqual(client.subscribes[1], map[string]byte{"c": 0}) || qos != 0 {
+		t.Error("expected c to be subscribed with QoS 0 again, got", client.subscribes, qos)
+	}
+}
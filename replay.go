@@ -0,0 +1,100 @@
+package mqttconn
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// replayMagic starts the replay header of a payload
+	replayMagic = 0x7e
+	// replayHeaderSize is the magic, the timestamp and the nonce
+	replayHeaderSize = 1 + 8 + 16
+)
+
+// WithReplayProtection stamps messages written with a timestamp and a random nonce and drops messages read
+// that are older than window, or whose nonce was already seen, so captured messages can't be published again.
+// Dropped messages go to onReplay. The clocks of writers and readers have to agree within window.
+// The header alone can be forged, use WithSigning after WithReplayProtection so the signature covers it
+func WithReplayProtection(window time.Duration, onReplay func(msg *Message, err error)) Option {
+	return func(conn *MQTTConn) {
+		conn.codecs = append(conn.codecs, &replayFilter{
+			conn:     conn,
+			window:   window,
+			onReplay: onReplay,
+			seen:     make(map[[16]byte]time.Time),
+		})
+	}
+}
+
+// replayFilter stamps outgoing and filters incoming payloads
+type replayFilter struct {
+	conn     *MQTTConn
+	window   time.Duration
+	onReplay func(*Message, error)
+
+	mu     sync.Mutex
+	seen   map[[16]byte]time.Time
+	pruned time.Time
+}
+
+// encode implements payloadCodec
+func (filter *replayFilter) encode(topic string, payload []byte) ([][]byte, error) {
+	stamped := make([]byte, replayHeaderSize+len(payload))
+	stamped[0] = replayMagic
+	binary.BigEndian.PutUint64(stamped[1:], uint64(filter.conn.clock.Now().UnixNano()))
+	if _, err := rand.Read(stamped[9:replayHeaderSize]); err != nil {
+		return nil, err
+	}
+	copy(stamped[replayHeaderSize:], payload)
+	return [][]byte{stamped}, nil
+}
+
+// decode implements payloadCodec
+func (filter *replayFilter) decode(msg *Message) []*Message {
+	if err := filter.check(msg); err != nil {
+		filter.conn.dropped(msg, DropRejected)
+		if filter.onReplay != nil {
+			filter.onReplay(msg, err)
+		} else if filter.conn.deadLetterTopic != "" {
+			filter.conn.deadLetterAsync(msg, err.Error())
+		}
+		return nil
+	}
+	return []*Message{msg}
+}
+
+// check verifies the header of msg and strips it
+func (filter *replayFilter) check(msg *Message) error {
+	if len(msg.Payload) < replayHeaderSize || msg.Payload[0] != replayMagic {
+		return errors.Wrap(ErrReplayed, "no replay header")
+	}
+	now := filter.conn.clock.Now()
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64(msg.Payload[1:])))
+	if sent.Before(now.Add(-filter.window)) || sent.After(now.Add(filter.window)) {
+		return errors.Wrap(ErrReplayed, "outside the replay window")
+	}
+	var nonce [16]byte
+	copy(nonce[:], msg.Payload[9:])
+	filter.mu.Lock()
+	defer filter.mu.Unlock()
+	// nonces of messages outside the window can go, the timestamp rejects those
+	if now.Sub(filter.pruned) > filter.window {
+		for seenNonce, seenSent := range filter.seen {
+			if seenSent.Before(now.Add(-filter.window)) {
+				delete(filter.seen, seenNonce)
+			}
+		}
+		filter.pruned = now
+	}
+	if _, ok := filter.seen[nonce]; ok {
+		return ErrReplayed
+	}
+	filter.seen[nonce] = sent
+	msg.Payload = msg.Payload[replayHeaderSize:]
+	return nil
+}
@@ -0,0 +1,71 @@
+package mqttconn
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReplayProtection(t *testing.T) {
+	clock := NewManualClock(time.Unix(1000, 0))
+	replayed := make(chan error, 4)
+	keys := map[string][]byte{"k": []byte("secret")}
+	reader, err := DialMQTT("mqtt+memory://TestReplayProtection/t", WithClock(clock),
+		WithReplayProtection(time.Minute, func(msg *Message, err error) {
+			replayed <- err
+		}),
+		WithSigning("k", keys, nil))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer reader.Close()
+	writer, err := DialMQTT("mqtt+memory://TestReplayProtection", WithClock(clock),
+		WithReplayProtection(time.Minute, nil), WithSigning("k", keys, nil))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer writer.Close()
+	writer.SetDefaultTopic("t")
+
+	reader.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 16)
+	expect := func(expected string) bool {
+		n, err := reader.Read(buf)
+		if err != nil {
+			t.Error(err)
+			return false
+		}
+		if string(buf[:n]) != expected {
+			t.Error("expected", expected, "got", string(buf[:n]))
+			return false
+		}
+		return true
+	}
+
+	captured, _ := writer.encodePayload("t", []byte("captured"))
+	writer.Client.Publish("t", 0, false, captured[0]).Wait()
+	if !expect("captured") {
+		return
+	}
+	// the same message again
+	writer.Client.Publish("t", 0, false, captured[0]).Wait()
+	writer.Write([]byte("fresh"))
+	if !expect("fresh") {
+		return
+	}
+	// and after it left the window
+	clock.Advance(2 * time.Minute)
+	writer.Client.Publish("t", 0, false, captured[0]).Wait()
+	writer.Write([]byte("later"))
+	if !expect("later") {
+		return
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-replayed; !errors.Is(err, ErrReplayed) {
+			t.Error("expected ErrReplayed, got", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package mqttconn
+
+import (
+	"context"
+	"time"
+)
+
+// retainedWait is how long ReadRetained waits for the broker to send a retained message
+// brokers send retained messages right after the SUBACK, so silence means there is none
+const retainedWait = time.Second
+
+// ReadRetained fetches the current retained message of topic
+// it subscribes, waits for the retained message and unsubscribes again, returning nil if there is none.
+// A subscription of the conn to topic is shared and keeps receiving, see subscribeLocal
+func (conn *MQTTConn) ReadRetained(ctx context.Context, topic string) ([]byte, error) {
+	if err := validateTopic(topic); err != nil {
+		return nil, err
+	}
+	sub, err := conn.subscribeQueue(topic, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer sub.close()
+
+	deadline := conn.clock.Now().Add(retainedWait)
+	for {
+		msg, err := sub.queue.next(ctx, deadline, true)
+		if err != nil {
+			if _, ok := err.(*TimeoutError); ok {
+				return nil, nil
+			}
+			return nil, err
+		}
+		// messages published after subscribing are not the retained state
+		if msg.Retained {
+			return msg.Payload, nil
+		}
+	}
+}
+
+// SnapshotRetained fetches the current retained messages of every topic matching filter, keyed by topic
+// it subscribes, collects the retained messages until they stop coming and unsubscribes again
+func (conn *MQTTConn) SnapshotRetained(ctx context.Context, filter string) (map[string][]byte, error) {
+	if err := validateFilter(filter); err != nil {
+		return nil, err
+	}
+	sub, err := conn.subscribeQueue(filter, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer sub.close()
+	snapshot := make(map[string][]byte)
+	err = conn.loadRetained(ctx, sub, func(msg *Message) {
+		// messages published after subscribing are not the retained state
+		if msg.Retained && len(msg.Payload) > 0 {
+			snapshot[msg.Topic] = msg.Payload
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// loadRetained hands the messages of sub to handle until the retained messages stop coming,
+// which is retainedWait without any or a quarter of it after the last one.
+// MQTT, version 5 included, has no marker for the last retained message of a subscription
+func (conn *MQTTConn) loadRetained(ctx context.Context, sub *localSubscription, handle func(*Message)) error {
+	deadline := conn.clock.Now().Add(retainedWait)
+	for {
+		msg, err := sub.queue.next(ctx, deadline, true)
+		if err != nil {
+			if _, ok := err.(*TimeoutError); ok {
+				return nil
+			}
+			return err
+		}
+		handle(msg)
+		deadline = conn.clock.Now().Add(retainedWait / 4)
+	}
+}
@@ -0,0 +1,91 @@
+package mqttconn
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReadRetained(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestReadRetained")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	conn.Client.Publish("device/state", 1, true, "on").Wait()
+	payload, err := conn.ReadRetained(context.Background(), "device/state")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(payload) != "on" {
+		t.Error("expected retained payload on, got", string(payload))
+		return
+	}
+	payload, err = conn.ReadRetained(context.Background(), "device/other")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if payload != nil {
+		t.Error("expected no retained payload, got", string(payload))
+		return
+	}
+}
+
+func TestReadRetainedWhileSubscribed(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestReadRetainedWhileSubscribed/device/state")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	conn.Client.Publish("device/state", 1, true, "on").Wait()
+	payload, err := conn.ReadRetained(context.Background(), "device/state")
+	if err != nil || string(payload) != "on" {
+		t.Error("expected retained payload on, got", string(payload), err)
+		return
+	}
+	// the subscription of the conn outlives the one of ReadRetained
+	conn.Client.Publish("device/state", 1, false, "off").Wait()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 16)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(buf[:n]) == "off" {
+			return
+		}
+	}
+}
+
+func TestSnapshotRetained(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestSnapshotRetained")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	conn.Client.Publish("devices/a/state", 1, true, "on").Wait()
+	conn.Client.Publish("devices/b/state", 1, true, "off").Wait()
+	conn.Client.Publish("other/state", 1, true, "on").Wait()
+	snapshot, err := conn.SnapshotRetained(context.Background(), "devices/+/state")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(snapshot) != 2 || string(snapshot["devices/a/state"]) != "on" || string(snapshot["devices/b/state"]) != "off" {
+		t.Error("unexpected snapshot", snapshot)
+		return
+	}
+	conn.subsMu.Lock()
+	subscribed := len(conn.subscriptions)
+	conn.subsMu.Unlock()
+	if subscribed != 0 {
+		t.Error("expected the filter to be unsubscribed")
+	}
+}
@@ -0,0 +1,104 @@
+package mqttconn
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy controls how WriteTo retries failed publishes
+type RetryPolicy struct {
+	// MaxAttempts is the total number of publish attempts, including the first one
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between retries, zero means an hour
+	MaxBackoff time.Duration
+	// Multiplier grows the backoff after every retry, values below 1 are treated as 1
+	Multiplier float64
+	// Jitter randomizes each backoff by up to this fraction, e.g. 0.2 for ±20%
+	Jitter float64
+	// Retryable decides if an error is worth retrying, nil means DefaultRetryable
+	Retryable func(error) bool
+}
+
+// DefaultRetryPolicy retries up to 5 times, starting at 100ms and doubling up to 5s
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// DefaultRetryable retries every error except timeouts, since the write deadline already passed,
+// ErrCircuitOpen and ErrQuotaExceeded
+func DefaultRetryable(err error) bool {
+	if err == ErrCircuitOpen || err == ErrQuotaExceeded {
+		return false
+	}
+	var timeoutErr interface{ Timeout() bool }
+	if errors.As(err, &timeoutErr) && timeoutErr.Timeout() {
+		return false
+	}
+	return true
+}
+
+// WithRetryPolicy makes WriteTo retry transient publish failures such as
+// token errors or the client not being connected
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(conn *MQTTConn) {
+		conn.retryPolicy = &policy
+	}
+}
+
+// maxRetryBackoff caps the wait between retries of a RetryPolicy without MaxBackoff
+const maxRetryBackoff = time.Hour
+
+// backoff returns the wait before retry number attempt (starting at 1)
+func (policy *RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := policy.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	limit := float64(policy.MaxBackoff)
+	if limit <= 0 {
+		limit = float64(maxRetryBackoff)
+	}
+	backoff := float64(policy.InitialBackoff)
+	for i := 1; i < attempt && backoff <= limit; i++ {
+		backoff *= multiplier
+	}
+	if backoff > limit {
+		backoff = limit
+	}
+	if policy.Jitter > 0 {
+		backoff *= 1 + policy.Jitter*(rand.Float64()*2-1)
+	}
+	return time.Duration(backoff)
+}
+
+// publishWithRetry publishes according to the retry policy, if any
+func (conn *MQTTConn) publishWithRetry(out *outgoing) error {
+	policy := conn.retryPolicy
+	if policy == nil {
+		return conn.publish(out)
+	}
+	retryable := policy.Retryable
+	if retryable == nil {
+		retryable = DefaultRetryable
+	}
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = conn.publish(out)
+		if err == nil || attempt >= policy.MaxAttempts || !retryable(err) {
+			return err
+		}
+		backoff := policy.backoff(attempt)
+		if !out.deadline.IsZero() && conn.clock.Now().Add(backoff).After(out.deadline) {
+			return &TimeoutError{errors.Wrap(err, "publish timed out while retrying")}
+		}
+		sleep(conn.clock, backoff)
+	}
+}
@@ -0,0 +1,86 @@
+package mqttconn
+
+import (
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/pkg/errors"
+)
+
+// flakyClient is a mqtt.Client whose first publishes fail
+type flakyClient struct {
+	mqtt.Client
+	failures int
+	attempts int
+}
+
+func (client *flakyClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	client.attempts++
+	if client.attempts <= client.failures {
+		return &failedToken{mqtt.ErrNotConnected}
+	}
+	return &mqtt.DummyToken{}
+}
+
+// failedToken is a completed mqtt.Token carrying an error
+type failedToken struct {
+	err error
+}
+
+func (token *failedToken) Wait() bool                     { return true }
+func (token *failedToken) WaitTimeout(time.Duration) bool { return true }
+func (token *failedToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+func (token *failedToken) Error() error { return token.err }
+
+func TestRetryPolicy(t *testing.T) {
+	client := &flakyClient{failures: 2}
+	conn, _ := CreateMQTTConn(client, WithRetryPolicy(RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		Multiplier:     2,
+	}))
+	_, err := conn.WriteTo([]byte("test"), TopicAddr("test"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if client.attempts != 3 {
+		t.Error("expected 3 attempts, got", client.attempts)
+		return
+	}
+	client = &flakyClient{failures: 5}
+	conn, _ = CreateMQTTConn(client, WithRetryPolicy(RetryPolicy{
+		MaxAttempts: 2,
+		Retryable:   func(err error) bool { return errors.Is(err, mqtt.ErrNotConnected) },
+	}))
+	_, err = conn.WriteTo([]byte("test"), TopicAddr("test"))
+	if err != ErrNotConnected {
+		t.Error("expected ErrNotConnected, got", err)
+		return
+	}
+	if client.attempts != 2 {
+		t.Error("expected 2 attempts, got", client.attempts)
+		return
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 3 * time.Second, Multiplier: 2}
+	expected := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}
+	for i, backoff := range expected {
+		if policy.backoff(i+1) != backoff {
+			t.Error("expected backoff", backoff, "got", policy.backoff(i+1))
+			return
+		}
+	}
+	// without MaxBackoff the backoff doesn't overflow
+	policy = RetryPolicy{InitialBackoff: time.Second, Multiplier: 10}
+	if backoff := policy.backoff(1000); backoff != maxRetryBackoff {
+		t.Error("expected backoff", maxRetryBackoff, "got", backoff)
+	}
+}
@@ -0,0 +1,86 @@
+package mqttconn
+
+import (
+	"regexp"
+	"strings"
+)
+
+// RewriteRule maps topics used by the application to topics on the broker and back
+// Outgoing rewrites published topics and subscribed filters, Incoming rewrites topics of received messages,
+// both report if the rule applied
+type RewriteRule struct {
+	Outgoing func(topic string) (string, bool)
+	Incoming func(topic string) (string, bool)
+}
+
+// PrefixRewrite swaps the topic prefix local used by the application with remote on the broker
+func PrefixRewrite(local, remote string) RewriteRule {
+	swap := func(from, to string) func(string) (string, bool) {
+		return func(topic string) (string, bool) {
+			if !strings.HasPrefix(topic, from) {
+				return topic, false
+			}
+			return to + topic[len(from):], true
+		}
+	}
+	return RewriteRule{
+		Outgoing: swap(local, remote),
+		Incoming: swap(remote, local),
+	}
+}
+
+// RegexpRewrite rewrites outgoing topics matching outgoing with outgoingTemplate,
+// and incoming topics matching incoming with incomingTemplate, templates are expanded like regexp.Regexp.Expand
+// either regexp may be nil to leave that direction alone
+func RegexpRewrite(outgoing *regexp.Regexp, outgoingTemplate string, incoming *regexp.Regexp, incomingTemplate string) RewriteRule {
+	replace := func(pattern *regexp.Regexp, template string) func(string) (string, bool) {
+		if pattern == nil {
+			return nil
+		}
+		return func(topic string) (string, bool) {
+			match := pattern.FindStringSubmatchIndex(topic)
+			if match == nil {
+				return topic, false
+			}
+			return string(pattern.ExpandString(nil, template, topic, match)), true
+		}
+	}
+	return RewriteRule{
+		Outgoing: replace(outgoing, outgoingTemplate),
+		Incoming: replace(incoming, incomingTemplate),
+	}
+}
+
+// WithTopicRewrite applies the first matching rule to every topic, so the same code runs against different topic hierarchies
+// the application only sees its own topics, wills set with WithWill are not rewritten
+func WithTopicRewrite(rules ...RewriteRule) Option {
+	return func(conn *MQTTConn) {
+		conn.rewrites = append(conn.rewrites, rules...)
+	}
+}
+
+// remoteTopic returns the broker topic or filter for topic
+func (conn *MQTTConn) remoteTopic(topic string) string {
+	for _, rule := range conn.rewrites {
+		if rule.Outgoing == nil {
+			continue
+		}
+		if rewritten, ok := rule.Outgoing(topic); ok {
+			return rewritten
+		}
+	}
+	return topic
+}
+
+// localTopic returns the application topic for the broker topic
+func (conn *MQTTConn) localTopic(topic string) string {
+	for _, rule := range conn.rewrites {
+		if rule.Incoming == nil {
+			continue
+		}
+		if rewritten, ok := rule.Incoming(topic); ok {
+			return rewritten
+		}
+	}
+	return topic
+}
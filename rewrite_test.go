@@ -0,0 +1,57 @@
+package mqttconn
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestTopicRewrite(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestTopicRewrite/devices/1", WithTopicRewrite(
+		PrefixRewrite("devices/", "tenants/a/devices/"),
+		RegexpRewrite(regexp.MustCompile(`^events/(.*)$`), "tenants/a/$1/events", regexp.MustCompile(`^tenants/a/(.*)/events$`), "events/$1"),
+	))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	raw, err := DialMQTT("mqtt+memory://TestTopicRewrite")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer raw.Close()
+	raw.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = raw.Subscribe("tenants/#", 0); err != nil {
+		t.Error(err)
+		return
+	}
+
+	conn.Write([]byte("a"))
+	conn.WriteTo([]byte("b"), TopicAddr("events/x"))
+	for _, expected := range []string{"tenants/a/devices/1", "tenants/a/x/events"} {
+		buf := make([]byte, 1)
+		_, addr, err := raw.ReadFrom(buf)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if addr.String() != expected {
+			t.Error("expected", expected, "got", addr)
+			return
+		}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1)
+	_, addr, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if addr.String() != "devices/1" {
+		t.Error("expected devices/1, got", addr)
+		return
+	}
+}
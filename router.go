@@ -0,0 +1,278 @@
+package mqttconn
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// Request is a message routed to a Handler
+type Request struct {
+	*Message
+	// Pattern is the filter of the route that matched
+	Pattern string
+	// Params are the topic levels matched by the + wildcards of Pattern in order,
+	// followed by the rest of the topic if Pattern ends with #
+	Params []string
+
+	ctx context.Context
+	// detached is set by middleware taking over finishing the request, see Concurrent
+	detached bool
+	finish   func()
+}
+
+// done acknowledges the message and ends the request
+func (req *Request) done() {
+	if req.finish != nil {
+		req.finish()
+		return
+	}
+	req.Message.Ack()
+}
+
+// Context returns the context passed to Router.Serve
+func (req *Request) Context() context.Context {
+	return req.ctx
+}
+
+// Handler handles messages routed by a Router
+type Handler interface {
+	ServeMQTT(req *Request)
+}
+
+// HandlerFunc adapts a function to a Handler
+type HandlerFunc func(req *Request)
+
+// ServeMQTT implements Handler
+func (f HandlerFunc) ServeMQTT(req *Request) {
+	f(req)
+}
+
+// Router dispatches messages to the handler of the most specific matching topic filter, like http.ServeMux
+// a literal level is more specific than +, which is more specific than #. The zero value is ready to use
+type Router struct {
+	// QoS is the QoS of the subscriptions made by Serve
+	QoS int
+	// NotFound handles messages matching no route, nil acknowledges and drops them
+	NotFound Handler
+
+	mu          sync.RWMutex
+	routes      map[string]Handler
+	middlewares []Middleware
+	// shutdown is closed by Shutdown, serving and inflight count running Serve calls and requests
+	shutdown   chan struct{}
+	isShutdown bool
+	serving    sync.WaitGroup
+	inflight   sync.WaitGroup
+}
+
+// NewRouter creates a Router
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle registers handler for the topic filter pattern, replacing an earlier one
+// routes added while serving take effect once Serve is called again
+func (router *Router) Handle(pattern string, handler Handler) {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	if router.routes == nil {
+		router.routes = make(map[string]Handler)
+	}
+	router.routes[pattern] = handler
+}
+
+// HandleFunc registers a handler function for pattern
+func (router *Router) HandleFunc(pattern string, handler func(req *Request)) {
+	router.Handle(pattern, HandlerFunc(handler))
+}
+
+// Serve subscribes conn to every pattern and handles messages read from conn one at a time, in order,
+// until ctx is done or conn is closed. Messages are acknowledged once their handler returns.
+// Patterns that overlap without one containing the other, like a/+/c and a/b/+, make the broker
+// send matching messages twice. The subscriptions are removed when Serve returns.
+// After Shutdown, Serve returns ErrRouterClosed
+func (router *Router) Serve(ctx context.Context, conn *MQTTConn) error {
+	router.mu.Lock()
+	if router.isShutdown {
+		router.mu.Unlock()
+		return ErrRouterClosed
+	}
+	if router.shutdown == nil {
+		router.shutdown = make(chan struct{})
+	}
+	shutdown := router.shutdown
+	router.serving.Add(1)
+	defer router.serving.Done()
+	patterns := make([]string, 0, len(router.routes))
+	for pattern := range router.routes {
+		covered := false
+		for other := range router.routes {
+			if other != pattern && coversFilter(other, pattern) {
+				covered = true
+				break
+			}
+		}
+		// brokers send a message once per matching subscription, so only the widest filters are subscribed
+		if !covered {
+			patterns = append(patterns, pattern)
+		}
+	}
+	router.mu.Unlock()
+	defer conn.Unsubscribe(patterns...)
+	for _, pattern := range patterns {
+		if _, err := conn.Subscribe(pattern, router.QoS); err != nil {
+			return err
+		}
+	}
+	readCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-shutdown:
+			cancel()
+		case <-readCtx.Done():
+		}
+	}()
+	for {
+		msg, err := conn.ReadMsg(readCtx)
+		if err != nil {
+			select {
+			case <-shutdown:
+				return ErrRouterClosed
+			default:
+				return err
+			}
+		}
+		router.inflight.Add(1)
+		req := &Request{Message: msg, ctx: ctx}
+		req.finish = func() {
+			msg.Ack()
+			router.inflight.Done()
+		}
+		router.ServeMQTT(req)
+		if !req.detached {
+			req.done()
+		}
+	}
+}
+
+// Shutdown stops every Serve from reading further messages and removes their subscriptions,
+// then waits for the handlers still running until ctx is done. Unread messages are left unacknowledged,
+// so brokers keeping the session deliver them again. Serve returns ErrRouterClosed after Shutdown
+func (router *Router) Shutdown(ctx context.Context) error {
+	router.mu.Lock()
+	if !router.isShutdown {
+		router.isShutdown = true
+		if router.shutdown == nil {
+			router.shutdown = make(chan struct{})
+		}
+		close(router.shutdown)
+	}
+	router.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		router.serving.Wait()
+		router.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ServeMQTT implements Handler by routing req, so routers can be nested
+// req gets the Pattern and Params of the route
+func (router *Router) ServeMQTT(req *Request) {
+	pattern, handler := router.Match(req.Topic)
+	if handler == nil {
+		handler = router.NotFound
+	}
+	if handler == nil {
+		return
+	}
+	router.mu.RLock()
+	for i := len(router.middlewares) - 1; i >= 0; i-- {
+		handler = router.middlewares[i](handler)
+	}
+	router.mu.RUnlock()
+	req.Pattern = pattern
+	req.Params = topicParams(pattern, req.Topic)
+	handler.ServeMQTT(req)
+}
+
+// Match returns the most specific route for topic, or a nil Handler
+func (router *Router) Match(topic string) (pattern string, handler Handler) {
+	router.mu.RLock()
+	defer router.mu.RUnlock()
+	for candidate, candidateHandler := range router.routes {
+		if !MatchTopic(candidate, topic) {
+			continue
+		}
+		if handler == nil || moreSpecific(candidate, pattern) {
+			pattern, handler = candidate, candidateHandler
+		}
+	}
+	return pattern, handler
+}
+
+// moreSpecific reports if filter a is more specific than filter b, both matching the same topic
+func moreSpecific(a, b string) bool {
+	aLevels, bLevels := strings.Split(a, "/"), strings.Split(b, "/")
+	rank := func(level string) int {
+		switch level {
+		case "#":
+			return 0
+		case "+":
+			return 1
+		}
+		return 2
+	}
+	for i := 0; i < len(aLevels) && i < len(bLevels); i++ {
+		if ra, rb := rank(aLevels[i]), rank(bLevels[i]); ra != rb {
+			return ra > rb
+		}
+	}
+	if len(aLevels) != len(bLevels) {
+		return len(aLevels) > len(bLevels)
+	}
+	return a < b
+}
+
+// coversFilter reports if every topic matching filter b also matches filter a
+func coversFilter(a, b string) bool {
+	aLevels, bLevels := strings.Split(a, "/"), strings.Split(b, "/")
+	for i, level := range aLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(bLevels) || bLevels[i] == "#" || (level != "+" && level != bLevels[i]) {
+			return false
+		}
+	}
+	return len(aLevels) == len(bLevels)
+}
+
+// topicParams returns the parts of topic matched by the wildcards of filter
+func topicParams(filter, topic string) []string {
+	if filter == "" {
+		return nil
+	}
+	var params []string
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range strings.Split(filter, "/") {
+		switch {
+		case level == "#":
+			if i < len(topicLevels) {
+				return append(params, strings.Join(topicLevels[i:], "/"))
+			}
+			return append(params, "")
+		case level == "+" && i < len(topicLevels):
+			params = append(params, topicLevels[i])
+		}
+	}
+	return params
+}
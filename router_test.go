@@ -0,0 +1,135 @@
+package mqttconn
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouter(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestRouter")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	handled := make(chan string, 8)
+	router := NewRouter()
+	handle := func(name string) func(*Request) {
+		return func(req *Request) {
+			handled <- name + " " + strings.Join(req.Params, ",")
+		}
+	}
+	router.HandleFunc("devices/+/telemetry", handle("telemetry"))
+	router.HandleFunc("devices/#", handle("devices"))
+	router.HandleFunc("devices/special/telemetry", handle("special"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() {
+		served <- router.Serve(ctx, conn)
+	}()
+	// devices/# covers the other routes
+	for subscribed := 0; subscribed < 1; time.Sleep(time.Millisecond) {
+		conn.subsMu.Lock()
+		subscribed = len(conn.subscribed)
+		conn.subsMu.Unlock()
+	}
+	for _, topic := range []string{"devices/1/telemetry", "devices/special/telemetry", "devices/1/status/battery"} {
+		conn.WriteTo([]byte("x"), TopicAddr(topic))
+	}
+	for _, expected := range []string{"telemetry 1", "special ", "devices 1/status/battery"} {
+		select {
+		case got := <-handled:
+			if got != expected {
+				t.Error("expected", expected, "got", got)
+				return
+			}
+		case <-time.After(time.Second):
+			t.Error("expected", expected)
+			return
+		}
+	}
+	cancel()
+	if err = <-served; err != context.Canceled {
+		t.Error("expected context.Canceled, got", err)
+		return
+	}
+}
+
+func TestCoversFilter(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"a/#", "a/+/c", true},
+		{"a/+/c", "a/b/c", true},
+		{"a/+/c", "a/#", false},
+		{"a/b/+", "a/+/c", false},
+		{"#", "a", true},
+		{"a/+", "a/b/c", false},
+	}
+	for _, c := range cases {
+		if coversFilter(c.a, c.b) != c.expected {
+			t.Error(c.a, "covers", c.b, "expected", c.expected)
+			return
+		}
+	}
+}
+
+func TestRouterShutdown(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestRouterShutdown")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	finished := make(chan struct{})
+	router := NewRouter()
+	router.HandleFunc("jobs", func(req *Request) {
+		close(started)
+		<-release
+		close(finished)
+	})
+	served := make(chan error, 1)
+	go func() {
+		served <- router.Serve(context.Background(), conn)
+	}()
+	for subscribed := 0; subscribed < 1; time.Sleep(time.Millisecond) {
+		conn.subsMu.Lock()
+		subscribed = len(conn.subscribed)
+		conn.subsMu.Unlock()
+	}
+	conn.WriteTo([]byte("x"), TopicAddr("jobs"))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err = router.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Error("expected shutdown to wait for the handler, got", err)
+		return
+	}
+	close(release)
+	if err = router.Shutdown(context.Background()); err != nil {
+		t.Error(err)
+		return
+	}
+	select {
+	case <-finished:
+	default:
+		t.Error("expected the handler to finish before Shutdown returns")
+		return
+	}
+	if err = <-served; err != ErrRouterClosed {
+		t.Error("expected ErrRouterClosed, got", err)
+		return
+	}
+	if len(conn.subscribed) != 0 {
+		t.Error("expected subscriptions to be removed")
+		return
+	}
+}
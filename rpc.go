@@ -0,0 +1,61 @@
+package mqttconn
+
+import (
+	"context"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+)
+
+// RPCCodec is the net/rpc wire format of ServeRPC and DialRPC
+type RPCCodec int
+
+// rpc codecs
+const (
+	// RPCGob is the gob encoding of rpc.ServeConn and rpc.NewClient
+	RPCGob RPCCodec = iota
+	// RPCJSON is the JSON-RPC 1.0 encoding of the jsonrpc package
+	RPCJSON
+)
+
+// ServeRPC exposes the exported methods of rcvr, like rpc.Register, to DialRPC clients of prefix
+// every client gets a stream from ListenStream, streams are served in the background
+// until the returned listener is closed
+func ServeRPC(conn *MQTTConn, prefix string, rcvr interface{}, codec RPCCodec) (*StreamListener, error) {
+	server := rpc.NewServer()
+	if err := server.Register(rcvr); err != nil {
+		return nil, err
+	}
+	listener, err := ListenStream(conn, prefix)
+	if err != nil {
+		return nil, err
+	}
+	conn.goLabeled(func() {
+		for {
+			stream, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.goLabeled(func() {
+				if codec == RPCJSON {
+					server.ServeCodec(jsonrpc.NewServerCodec(stream))
+				} else {
+					server.ServeConn(stream)
+				}
+			})
+		}
+	})
+	return listener, nil
+}
+
+// DialRPC opens a stream to the ServeRPC of prefix and returns a rpc.Client calling over it
+// closing the client closes the stream, the conn stays open
+func DialRPC(ctx context.Context, conn *MQTTConn, prefix string, codec RPCCodec) (*rpc.Client, error) {
+	stream, err := DialStream(ctx, conn, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if codec == RPCJSON {
+		return jsonrpc.NewClient(stream), nil
+	}
+	return rpc.NewClient(stream), nil
+}
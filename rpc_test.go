@@ -0,0 +1,55 @@
+package mqttconn
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Arith is a net/rpc service
+type Arith struct{}
+
+// Args are the operands of Arith
+type Args struct {
+	A, B int
+}
+
+// Multiply returns A * B
+func (Arith) Multiply(args *Args, reply *int) error {
+	*reply = args.A * args.B
+	return nil
+}
+
+func TestRPC(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestRPC")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	for codec, prefix := range map[RPCCodec]string{RPCGob: "arith/gob", RPCJSON: "arith/json"} {
+		listener, err := ServeRPC(conn, prefix, Arith{}, codec)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer listener.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		client, err := DialRPC(ctx, conn, prefix, codec)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer client.Close()
+		var product int
+		if err := client.Call("Arith.Multiply", &Args{A: 6, B: 7}, &product); err != nil {
+			t.Error(err)
+			return
+		}
+		if product != 42 {
+			t.Error("expected 42, got", product)
+			return
+		}
+	}
+}
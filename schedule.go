@@ -0,0 +1,212 @@
+package mqttconn
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ScheduledWrite is a write held by WriteAt until its send time
+type ScheduledWrite struct {
+	Topic   string    `json:"topic"`
+	Payload []byte    `json:"payload"`
+	At      time.Time `json:"at"`
+}
+
+// WithScheduleFile keeps the writes scheduled by WriteAt in the file at path, so they survive restarts
+// writes still pending in the file are scheduled again when the conn is dialed, those overdue are sent right away
+func WithScheduleFile(path string) Option {
+	return func(conn *MQTTConn) {
+		conn.scheduler.path = path
+	}
+}
+
+// WriteAt schedules p to be written to addr at t, like WriteTo but in the background
+// failures, including the conn being closed before t, are only reported to Hooks.OnPublish or dropped.
+// Send times are checked against the wall clock too, so schedules hold while the system sleeps
+func (conn *MQTTConn) WriteAt(p []byte, addr net.Addr, t time.Time) error {
+	if addr.Network() != TopicAddr("").Network() {
+		return ErrAddrInvalid
+	}
+	if atomic.LoadInt32(&conn.closed) != 0 {
+		return ErrClosed
+	}
+	if err := validateTopic(addr.String()); err != nil {
+		return err
+	}
+	write := &ScheduledWrite{
+		Topic:   addr.String(),
+		Payload: append([]byte(nil), p...),
+		At:      t,
+	}
+	if err := conn.scheduler.add(write); err != nil {
+		return err
+	}
+	conn.scheduler.start(conn)
+	return nil
+}
+
+// Scheduled returns the writes scheduled by WriteAt that are not sent yet, ordered by send time
+func (conn *MQTTConn) Scheduled() []ScheduledWrite {
+	conn.scheduler.mu.Lock()
+	defer conn.scheduler.mu.Unlock()
+	writes := make([]ScheduledWrite, 0, len(conn.scheduler.pending))
+	for _, write := range conn.scheduler.pending {
+		writes = append(writes, *write)
+	}
+	return writes
+}
+
+// scheduler holds the writes of WriteAt, ordered by send time
+type scheduler struct {
+	path string
+
+	mu      sync.Mutex
+	pending []*ScheduledWrite
+
+	wake      chan struct{}
+	stopped   chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{
+		wake:    make(chan struct{}, 1),
+		stopped: make(chan struct{}),
+	}
+}
+
+// load reads the pending writes of the schedule file, a missing file has none
+func (scheduler *scheduler) load() error {
+	data, err := os.ReadFile(scheduler.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var writes []*ScheduledWrite
+	if err := json.Unmarshal(data, &writes); err != nil {
+		return err
+	}
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	for _, write := range writes {
+		scheduler.insert(write)
+	}
+	return nil
+}
+
+// save writes the pending writes to the schedule file, replacing it atomically, the caller must hold scheduler.mu
+func (scheduler *scheduler) save() error {
+	if scheduler.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(scheduler.pending)
+	if err != nil {
+		return err
+	}
+	tmp := scheduler.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, scheduler.path)
+}
+
+// insert adds write after the pending writes due at the same time or earlier, the caller must hold scheduler.mu
+func (scheduler *scheduler) insert(write *ScheduledWrite) {
+	i := sort.Search(len(scheduler.pending), func(i int) bool {
+		return scheduler.pending[i].At.After(write.At)
+	})
+	scheduler.pending = append(scheduler.pending, nil)
+	copy(scheduler.pending[i+1:], scheduler.pending[i:])
+	scheduler.pending[i] = write
+}
+
+// add schedules write and wakes the sender in case it is due before the others
+func (scheduler *scheduler) add(write *ScheduledWrite) error {
+	scheduler.mu.Lock()
+	scheduler.insert(write)
+	if err := scheduler.save(); err != nil {
+		scheduler.remove(write)
+		scheduler.mu.Unlock()
+		return err
+	}
+	scheduler.mu.Unlock()
+	select {
+	case scheduler.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// remove drops write from the pending writes, the caller must hold scheduler.mu
+func (scheduler *scheduler) remove(write *ScheduledWrite) {
+	for i, pending := range scheduler.pending {
+		if pending == write {
+			scheduler.pending = append(scheduler.pending[:i], scheduler.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// next returns the earliest pending write, nil if there is none
+func (scheduler *scheduler) next() *ScheduledWrite {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	if len(scheduler.pending) == 0 {
+		return nil
+	}
+	return scheduler.pending[0]
+}
+
+// start sends the pending writes of conn when they are due, until stop is called
+func (scheduler *scheduler) start(conn *MQTTConn) {
+	scheduler.startOnce.Do(func() {
+		conn.goLabeled(func() {
+			scheduler.run(conn)
+		})
+	})
+}
+
+func (scheduler *scheduler) run(conn *MQTTConn) {
+	for {
+		write := scheduler.next()
+		var timer *deadlineTimer
+		if write != nil {
+			timer = newDeadlineTimer(conn.clock, write.At)
+			if timer.passed() {
+				timer.stop()
+				// the write stays in the file until it is sent, a crash in between sends it again
+				conn.WriteTo(write.Payload, TopicAddr(write.Topic))
+				scheduler.mu.Lock()
+				scheduler.remove(write)
+				scheduler.save()
+				scheduler.mu.Unlock()
+				continue
+			}
+		} else {
+			timer = newDeadlineTimer(conn.clock, time.Time{})
+		}
+		select {
+		case <-timer.C():
+		case <-scheduler.wake:
+		case <-scheduler.stopped:
+			timer.stop()
+			return
+		}
+		timer.stop()
+	}
+}
+
+// stop ends sending, the pending writes stay in the schedule file
+func (scheduler *scheduler) stop() {
+	scheduler.stopOnce.Do(func() {
+		close(scheduler.stopped)
+	})
+}
@@ -0,0 +1,86 @@
+package mqttconn
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteAt(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	conn, err := DialMQTT("mqtt+memory://TestWriteAt/t", WithClock(clock))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	if err := conn.WriteAt([]byte("later"), TopicAddr("t"), clock.Now().Add(2*time.Hour)); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := conn.WriteAt([]byte("sooner"), TopicAddr("t"), clock.Now().Add(time.Hour)); err != nil {
+		t.Error(err)
+		return
+	}
+	if scheduled := conn.Scheduled(); len(scheduled) != 2 || string(scheduled[0].Payload) != "sooner" {
+		t.Error("unexpected schedule", scheduled)
+		return
+	}
+	time.Sleep(10 * time.Millisecond)
+	if sent := conn.Stats().MessagesSent; sent != 0 {
+		t.Error("expected nothing to be sent before the send time, sent", sent)
+		return
+	}
+	buf := make([]byte, 16)
+	for _, expected := range []string{"sooner", "later"} {
+		for clock.Timers() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		clock.Advance(time.Hour)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(buf[:n]) != expected {
+			t.Error("expected", expected, "got", string(buf[:n]))
+			return
+		}
+	}
+}
+
+func TestScheduleFile(t *testing.T) {
+	dir, err := os.MkdirTemp("", "schedule")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "schedule.json")
+	conn, err := DialMQTT("mqtt+memory://TestScheduleFile", WithScheduleFile(path))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := conn.WriteAt([]byte("hello"), TopicAddr("t"), time.Now().Add(-time.Second).Round(0)); err != nil {
+		t.Error(err)
+		return
+	}
+	conn.Close()
+
+	// a conn dialed later sends the write that was left in the file
+	restarted, err := DialMQTT("mqtt+memory://TestScheduleFile/t", WithScheduleFile(path))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer restarted.Close()
+	for deadline := time.Now().Add(time.Second); len(restarted.Scheduled()) > 0; {
+		if time.Now().After(deadline) {
+			t.Error("expected the overdue write to be sent")
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,103 @@
+package mqttconn
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Validator checks a payload, returning why it is invalid
+type Validator func(payload []byte) error
+
+// JSONValidator accepts JSON payloads that decode into the value returned by schema without unknown fields,
+// so a Go struct acts as the schema, for example JSONValidator(func() interface{} { return new(Reading) })
+func JSONValidator(schema func() interface{}) Validator {
+	return func(payload []byte) error {
+		decoder := json.NewDecoder(bytes.NewReader(payload))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(schema()); err != nil {
+			return err
+		}
+		if decoder.More() {
+			return fmt.Errorf("trailing data after JSON value")
+		}
+		return nil
+	}
+}
+
+// SchemaError is returned by WriteTo for payloads rejected by a Validator
+type SchemaError struct {
+	Topic string
+	Err   error
+}
+
+func (err *SchemaError) Error() string {
+	return "invalid payload for " + err.Topic + ": " + err.Err.Error()
+}
+
+// Unwrap returns the error of the Validator
+func (err *SchemaError) Unwrap() error {
+	return err.Err
+}
+
+// schemaRule validates the payloads of topics matching filter
+type schemaRule struct {
+	filter    string
+	validate  Validator
+	onInvalid func(*Message, error)
+}
+
+// WithSchema validates payloads written to and read from topics matching filter
+// writes of invalid payloads fail with a *SchemaError, invalid received messages go to onInvalid.
+// The first rule matching a topic applies
+func WithSchema(filter string, validate Validator, onInvalid func(msg *Message, err error)) Option {
+	return func(conn *MQTTConn) {
+		conn.schemas = append(conn.schemas, schemaRule{
+			filter:    filter,
+			validate:  validate,
+			onInvalid: onInvalid,
+		})
+	}
+}
+
+// schema returns the rule for topic, if any
+func (conn *MQTTConn) schema(topic string) *schemaRule {
+	for i := range conn.schemas {
+		if MatchTopic(conn.schemas[i].filter, topic) {
+			return &conn.schemas[i]
+		}
+	}
+	return nil
+}
+
+// validOutgoing checks a payload about to be written to topic
+func (conn *MQTTConn) validOutgoing(topic string, payload []byte) error {
+	rule := conn.schema(topic)
+	if rule == nil {
+		return nil
+	}
+	if err := rule.validate(payload); err != nil {
+		return &SchemaError{Topic: topic, Err: err}
+	}
+	return nil
+}
+
+// validIncoming checks a received message, diverting it if invalid
+func (conn *MQTTConn) validIncoming(msg *Message) bool {
+	rule := conn.schema(msg.Topic)
+	if rule == nil {
+		return true
+	}
+	err := rule.validate(msg.Payload)
+	if err == nil {
+		return true
+	}
+	conn.dropped(msg, DropInvalid)
+	if rule.onInvalid != nil {
+		rule.onInvalid(msg, err)
+	} else if conn.deadLetterTopic != "" {
+		conn.deadLetterAsync(msg, "invalid payload: "+err.Error())
+	}
+	msg.Ack()
+	return false
+}
@@ -0,0 +1,60 @@
+package mqttconn
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSchema(t *testing.T) {
+	type reading struct {
+		Temperature float64 `json:"temperature"`
+	}
+	validate := JSONValidator(func() interface{} { return new(reading) })
+	invalid := make(chan *Message, 1)
+	reader, err := DialMQTT("mqtt+memory://TestSchema/sensors/1", WithSchema("sensors/+", validate, func(msg *Message, err error) {
+		invalid <- msg
+	}))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer reader.Close()
+	writer, err := DialMQTT("mqtt+memory://TestSchema/sensors/1", WithSchema("sensors/+", validate, nil))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer writer.Close()
+
+	_, err = writer.Write([]byte(`{"humidity":40}`))
+	var schemaErr *SchemaError
+	if !errors.As(err, &schemaErr) || schemaErr.Topic != "sensors/1" {
+		t.Error("expected SchemaError, got", err)
+		return
+	}
+	writer.Client.Publish("sensors/1", 0, false, []byte(`not json`)).Wait()
+	writer.Write([]byte(`{"temperature":20}`))
+
+	select {
+	case msg := <-invalid:
+		if string(msg.Payload) != "not json" {
+			t.Error("expected invalid message, got", string(msg.Payload))
+			return
+		}
+	case <-time.After(time.Second):
+		t.Error("expected invalid message callback")
+		return
+	}
+	reader.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 64)
+	n, err := reader.Read(buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(buf[:n]) != `{"temperature":20}` {
+		t.Error("expected valid message, got", string(buf[:n]))
+		return
+	}
+}
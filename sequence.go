@@ -0,0 +1,93 @@
+package mqttconn
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// sequenceMagic starts the sequence header of a payload
+	sequenceMagic = 0x5e9c
+	// sequenceHeaderSize is the magic, the sender id, the sequence number and the check
+	sequenceHeaderSize = headerMagicSize + 8 + 8 + headerCheckSize
+)
+
+// WithSequencing stamps messages written with a per topic sequence number and checks it on messages read
+// onGap, if not nil, is called when a received sequence number is not the expected one,
+// received > expected means received - expected messages were lost, received < expected is a duplicate or reordered message.
+// The sequence travels in a 22 byte header in front of the payload, both ends need WithSequencing
+func WithSequencing(onGap func(topic string, expected, received uint64)) Option {
+	return func(conn *MQTTConn) {
+		sequencer := &sequencer{
+			conn:   conn,
+			onGap:  onGap,
+			sent:   make(map[string]uint64),
+			latest: make(map[sequenceSource]uint64),
+		}
+		rand.Read(sequencer.id[:])
+		conn.codecs = append(conn.codecs, sequencer)
+	}
+}
+
+// sequenceSource identifies the sequence of one sender on one topic
+type sequenceSource struct {
+	topic  string
+	sender [8]byte
+}
+
+// sequencer numbers outgoing messages and tracks incoming sequences
+type sequencer struct {
+	conn  *MQTTConn
+	id    [8]byte
+	onGap func(topic string, expected, received uint64)
+
+	mu     sync.Mutex
+	sent   map[string]uint64
+	latest map[sequenceSource]uint64
+}
+
+// encode implements payloadCodec
+func (sequencer *sequencer) encode(topic string, payload []byte) ([][]byte, error) {
+	sequencer.mu.Lock()
+	sequencer.sent[topic]++
+	seq := sequencer.sent[topic]
+	sequencer.mu.Unlock()
+	header := newHeader(sequenceMagic, sequenceHeaderSize, sequenceHeaderSize+len(payload))
+	copy(header[2:], sequencer.id[:])
+	binary.BigEndian.PutUint64(header[10:], seq)
+	sealHeader(header)
+	return [][]byte{append(header, payload...)}, nil
+}
+
+// decode implements payloadCodec
+func (sequencer *sequencer) decode(msg *Message) []*Message {
+	if !hasHeader(msg.Payload, sequenceMagic, sequenceHeaderSize) {
+		return []*Message{msg}
+	}
+	source := sequenceSource{topic: msg.Topic}
+	copy(source.sender[:], msg.Payload[2:])
+	seq := binary.BigEndian.Uint64(msg.Payload[10:])
+	msg.Payload = msg.Payload[sequenceHeaderSize:]
+	sequencer.mu.Lock()
+	latest, seen := sequencer.latest[source]
+	if !seen || seq > latest {
+		sequencer.latest[source] = seq
+	}
+	sequencer.mu.Unlock()
+	// the first message of a sender sets the baseline, the reader may have joined late
+	if !seen || seq == latest+1 {
+		return []*Message{msg}
+	}
+	stats := &sequencer.conn.stats
+	if seq > latest {
+		atomic.AddInt64(&stats.sequenceGaps, int64(seq-latest-1))
+	} else {
+		atomic.AddInt64(&stats.sequenceDuplicates, 1)
+	}
+	if sequencer.onGap != nil {
+		sequencer.onGap(msg.Topic, latest+1, seq)
+	}
+	return []*Message{msg}
+}
@@ -0,0 +1,85 @@
+package mqttconn
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSequencing(t *testing.T) {
+	type gap struct {
+		expected, received uint64
+	}
+	gaps := make(chan gap, 4)
+	reader, err := DialMQTT("mqtt+memory://TestSequencing/t", WithSequencing(func(topic string, expected, received uint64) {
+		gaps <- gap{expected, received}
+	}))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer reader.Close()
+	writer, err := DialMQTT("mqtt+memory://TestSequencing", WithSequencing(nil))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer writer.Close()
+	writer.SetDefaultTopic("t")
+
+	writer.Write([]byte("1"))
+	writer.Write([]byte("2"))
+	// lose the third message
+	lost, _ := writer.encodePayload("t", []byte("3"))
+	writer.Write([]byte("4"))
+	// and duplicate it later
+	writer.Client.Publish("t", 0, false, lost[0]).Wait()
+
+	reader.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 16)
+	for _, expected := range []string{"1", "2", "4", "3"} {
+		n, err := reader.Read(buf)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(buf[:n]) != expected {
+			t.Error("expected", expected, "got", string(buf[:n]))
+			return
+		}
+	}
+	if g := <-gaps; g != (gap{3, 4}) {
+		t.Error("expected gap before 4, got", g)
+		return
+	}
+	if g := <-gaps; g != (gap{5, 3}) {
+		t.Error("expected late 3, got", g)
+		return
+	}
+	if stats := reader.Stats(); stats.SequenceGaps != 1 || stats.SequenceDuplicates != 1 {
+		t.Error("unexpected", stats)
+		return
+	}
+}
+
+func TestSequencingHeaderCheck(t *testing.T) {
+	reader, err := DialMQTT("mqtt+memory://TestSequencingHeaderCheck/t", WithSequencing(nil))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer reader.Close()
+	// a plain payload that starts like a header
+	plain := append([]byte{0x5e, 0x9c}, bytes.Repeat([]byte{1}, 30)...)
+	reader.Client.Publish("t", 0, false, plain).Wait()
+	reader.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 64)
+	n, err := reader.Read(buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !bytes.Equal(buf[:n], plain) {
+		t.Error("expected the payload unchanged, got", buf[:n])
+	}
+}
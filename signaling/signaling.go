@@ -0,0 +1,303 @@
+// Package signaling exchanges WebRTC offers, answers and ICE candidates between peers through a broker
+// every peer has an id and receives on the topic prefix/<id>, a Session correlates the messages
+// of one connection attempt, so a peer can negotiate with many others at once.
+// The SDP and candidates are passed through as is, to be used with any WebRTC implementation
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	mqttconn "github.com/gyf304/go-mqttconn"
+)
+
+// candidateBuffer is the number of remote candidates a Session holds until they are received
+const candidateBuffer = 64
+
+// offerBuffer is the number of offers a Peer holds until they are accepted
+const offerBuffer = 16
+
+var (
+	// ErrHangup is returned when the remote peer closed the session
+	ErrHangup = errors.New("signaling: session closed by remote peer")
+	// ErrPeerInvalid is returned for peer ids containing / or wildcards
+	ErrPeerInvalid = errors.New("signaling: invalid peer id")
+)
+
+// Candidate is an ICE candidate, in the JSON form of RTCIceCandidateInit like webrtc.ICECandidateInit
+type Candidate struct {
+	Candidate        string  `json:"candidate"`
+	SDPMid           *string `json:"sdpMid,omitempty"`
+	SDPMLineIndex    *uint16 `json:"sdpMLineIndex,omitempty"`
+	UsernameFragment *string `json:"usernameFragment,omitempty"`
+}
+
+// signal is the message exchanged between peers
+type signal struct {
+	Type      string     `json:"type"`
+	From      string     `json:"from"`
+	Session   string     `json:"session"`
+	SDP       string     `json:"sdp,omitempty"`
+	Candidate *Candidate `json:"candidate,omitempty"`
+}
+
+// signal types
+const (
+	typeOffer     = "offer"
+	typeAnswer    = "answer"
+	typeCandidate = "candidate"
+	typeBye       = "bye"
+)
+
+// Peer sends and receives signals for one peer id
+type Peer struct {
+	conn   *mqttconn.MQTTConn
+	prefix string
+	id     string
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+	offers   chan *Session
+}
+
+// NewPeer subscribes conn to prefix/id and returns a Peer receiving on it
+// the Peer takes over reading from conn, conn stays open on Close
+func NewPeer(conn *mqttconn.MQTTConn, prefix, id string) (*Peer, error) {
+	if !validPeer(id) {
+		return nil, ErrPeerInvalid
+	}
+	if _, err := conn.Subscribe(prefix+"/"+id, 1); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	peer := &Peer{
+		conn:     conn,
+		prefix:   prefix,
+		id:       id,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		sessions: make(map[string]*Session),
+		offers:   make(chan *Session, offerBuffer),
+	}
+	go peer.run(ctx)
+	return peer, nil
+}
+
+// validPeer checks a peer id
+func validPeer(id string) bool {
+	return id != "" && !strings.ContainsAny(id, "/+#")
+}
+
+// ID returns the id of the peer
+func (peer *Peer) ID() string {
+	return peer.id
+}
+
+// run receives signals until ctx is done
+func (peer *Peer) run(ctx context.Context) {
+	defer close(peer.done)
+	for {
+		msg, err := peer.conn.ReadMsg(ctx)
+		if err != nil {
+			return
+		}
+		msg.Ack()
+		var received signal
+		if json.Unmarshal(msg.Payload, &received) != nil || !validPeer(received.From) || received.Session == "" {
+			continue
+		}
+		peer.handle(&received)
+	}
+}
+
+// handle routes a received signal to its session
+// sends never block, signals that don't fit are dropped like lost packets
+func (peer *Peer) handle(received *signal) {
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	key := received.From + "/" + received.Session
+	session := peer.sessions[key]
+	switch received.Type {
+	case typeOffer:
+		if session != nil {
+			return
+		}
+		session = peer.newSession(received.From, received.Session)
+		session.offer = received.SDP
+		select {
+		case peer.offers <- session:
+			peer.sessions[key] = session
+		default:
+		}
+	case typeAnswer:
+		if session == nil {
+			return
+		}
+		select {
+		case session.answer <- received.SDP:
+		default:
+		}
+	case typeCandidate:
+		if session == nil || received.Candidate == nil {
+			return
+		}
+		select {
+		case session.candidates <- *received.Candidate:
+		default:
+		}
+	case typeBye:
+		if session != nil {
+			session.hangup()
+		}
+	}
+}
+
+// newSession creates a session with remote, must be called with mu held
+func (peer *Peer) newSession(remote, id string) *Session {
+	return &Session{
+		peer:       peer,
+		remote:     remote,
+		id:         id,
+		answer:     make(chan string, 1),
+		candidates: make(chan Candidate, candidateBuffer),
+		closed:     make(chan struct{}),
+	}
+}
+
+// send publishes a signal to the peer remote
+func (peer *Peer) send(remote string, sent *signal) error {
+	sent.From = peer.id
+	payload, err := json.Marshal(sent)
+	if err != nil {
+		return err
+	}
+	_, err = peer.conn.WriteBatch([]mqttconn.Message{{Topic: peer.prefix + "/" + remote, Payload: payload, QoS: 1}})
+	return err
+}
+
+// Offer starts a session with the peer remote by sending it an offer, wait for the answer with WaitAnswer
+func (peer *Peer) Offer(remote, sdp string) (*Session, error) {
+	if !validPeer(remote) {
+		return nil, ErrPeerInvalid
+	}
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+	peer.mu.Lock()
+	session := peer.newSession(remote, id.String())
+	peer.sessions[remote+"/"+session.id] = session
+	peer.mu.Unlock()
+	if err := peer.send(remote, &signal{Type: typeOffer, Session: session.id, SDP: sdp}); err != nil {
+		session.forget()
+		return nil, err
+	}
+	return session, nil
+}
+
+// Accept waits for an offer from another peer until ctx is done, the offer is in Session.Offer
+func (peer *Peer) Accept(ctx context.Context) (*Session, error) {
+	select {
+	case session := <-peer.offers:
+		return session, nil
+	case <-peer.done:
+		return nil, mqttconn.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close stops receiving signals, sessions are not closed
+func (peer *Peer) Close() error {
+	peer.cancel()
+	<-peer.done
+	return peer.conn.Unsubscribe(peer.prefix + "/" + peer.id)
+}
+
+// Session is one offer and answer exchange with a remote peer
+type Session struct {
+	peer   *Peer
+	remote string
+	id     string
+	offer  string
+
+	answer     chan string
+	candidates chan Candidate
+	closed     chan struct{}
+	closeOnce  sync.Once
+}
+
+// ID returns the id correlating the signals of the session
+func (session *Session) ID() string {
+	return session.id
+}
+
+// Remote returns the id of the remote peer
+func (session *Session) Remote() string {
+	return session.remote
+}
+
+// Offer returns the SDP of the offer of a session from Accept
+func (session *Session) Offer() string {
+	return session.offer
+}
+
+// Answer sends the SDP answer to the offer of a session from Accept
+func (session *Session) Answer(sdp string) error {
+	return session.peer.send(session.remote, &signal{Type: typeAnswer, Session: session.id, SDP: sdp})
+}
+
+// WaitAnswer waits for the SDP answer to the offer of a session from Offer until ctx is done
+func (session *Session) WaitAnswer(ctx context.Context) (string, error) {
+	select {
+	case sdp := <-session.answer:
+		return sdp, nil
+	case <-session.closed:
+		return "", ErrHangup
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// AddCandidate sends a local ICE candidate to the remote peer, for trickle ICE
+func (session *Session) AddCandidate(candidate Candidate) error {
+	return session.peer.send(session.remote, &signal{Type: typeCandidate, Session: session.id, Candidate: &candidate})
+}
+
+// Candidates returns the ICE candidates of the remote peer, the channel is closed when the session is closed
+func (session *Session) Candidates() <-chan Candidate {
+	return session.candidates
+}
+
+// Done is closed when the session is closed by either peer
+func (session *Session) Done() <-chan struct{} {
+	return session.closed
+}
+
+// Close ends the session and tells the remote peer, once the connection is up signaling is no longer needed
+func (session *Session) Close() error {
+	session.forget()
+	return session.peer.send(session.remote, &signal{Type: typeBye, Session: session.id})
+}
+
+// forget closes the session locally
+func (session *Session) forget() {
+	session.peer.mu.Lock()
+	defer session.peer.mu.Unlock()
+	session.hangup()
+}
+
+// hangup closes the channels of the session and removes it, must be called with the peer mu held
+func (session *Session) hangup() {
+	session.closeOnce.Do(func() {
+		delete(session.peer.sessions, session.remote+"/"+session.id)
+		close(session.candidates)
+		close(session.closed)
+	})
+}
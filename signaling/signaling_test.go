@@ -0,0 +1,100 @@
+package signaling
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mqttconn "github.com/gyf304/go-mqttconn"
+)
+
+func TestSignaling(t *testing.T) {
+	conn, err := mqttconn.DialMQTT("mqtt+memory://TestSignaling")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	alice, err := NewPeer(conn, "signal", "alice")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer alice.Close()
+	bobConn, err := mqttconn.DialMQTT("mqtt+memory://TestSignaling")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer bobConn.Close()
+	bob, err := NewPeer(bobConn, "signal", "bob")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer bob.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	offer, err := alice.Offer("bob", "v=0 offer")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	incoming, err := bob.Accept(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if incoming.Remote() != "alice" || incoming.Offer() != "v=0 offer" || incoming.ID() != offer.ID() {
+		t.Error("unexpected offer", incoming.Remote(), incoming.Offer())
+		return
+	}
+	if err := incoming.Answer("v=0 answer"); err != nil {
+		t.Error(err)
+		return
+	}
+	mid := "0"
+	if err := incoming.AddCandidate(Candidate{Candidate: "candidate:1 1 udp 1 10.0.0.1 5000 typ host", SDPMid: &mid}); err != nil {
+		t.Error(err)
+		return
+	}
+	answer, err := offer.WaitAnswer(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if answer != "v=0 answer" {
+		t.Error("expected the answer, got", answer)
+		return
+	}
+	select {
+	case candidate := <-offer.Candidates():
+		if candidate.SDPMid == nil || *candidate.SDPMid != "0" {
+			t.Error("unexpected candidate", candidate)
+			return
+		}
+	case <-ctx.Done():
+		t.Error("expected a candidate")
+		return
+	}
+
+	if err := offer.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+	select {
+	case <-incoming.Done():
+	case <-ctx.Done():
+		t.Error("expected the remote peer to see the session closed")
+		return
+	}
+	if _, ok := <-incoming.Candidates(); ok {
+		t.Error("expected the candidates to be closed")
+		return
+	}
+	if _, err := alice.Offer("bob/other", ""); err != ErrPeerInvalid {
+		t.Error("expected ErrPeerInvalid, got", err)
+		return
+	}
+}
@@ -0,0 +1,105 @@
+package mqttconn
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// signatureSize is the size of the HMAC-SHA256 at the end of a signed payload
+const signatureSize = sha256.Size
+
+// WithSigning appends a HMAC-SHA256 to messages written, using the key keyID of keys, and verifies messages read
+// against the key they name, so a broker that isn't trusted can't alter messages unnoticed.
+// The MAC covers the topic, the key id and the payload, messages not signed with one of keys go to onTampered
+func WithSigning(keyID string, keys map[string][]byte, onTampered func(msg *Message, err error)) Option {
+	return func(conn *MQTTConn) {
+		signer := &signer{
+			conn:       conn,
+			keyID:      keyID,
+			keys:       make(map[string][]byte, len(keys)),
+			onTampered: onTampered,
+		}
+		for id, key := range keys {
+			signer.keys[id] = key
+		}
+		conn.codecs = append(conn.codecs, signer)
+	}
+}
+
+// signer signs outgoing and verifies incoming payloads
+type signer struct {
+	conn       *MQTTConn
+	keyID      string
+	keys       map[string][]byte
+	onTampered func(*Message, error)
+}
+
+// mac returns the MAC of payload on topic with the key id
+func (signer *signer) mac(id string, topic string, payload []byte) ([]byte, error) {
+	key, ok := signer.keys[id]
+	if !ok {
+		return nil, errors.Wrap(ErrSignatureInvalid, "unknown key "+id)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(binary.AppendUvarint(nil, uint64(len(topic))))
+	mac.Write([]byte(topic))
+	mac.Write([]byte{byte(len(id))})
+	mac.Write([]byte(id))
+	mac.Write(payload)
+	return mac.Sum(nil), nil
+}
+
+// encode implements payloadCodec, the payload is followed by the key id, its length and the MAC
+func (signer *signer) encode(topic string, payload []byte) ([][]byte, error) {
+	if len(signer.keyID) > 255 {
+		return nil, errors.Wrap(ErrSignatureInvalid, "key id too long")
+	}
+	mac, err := signer.mac(signer.keyID, topic, payload)
+	if err != nil {
+		return nil, err
+	}
+	signed := make([]byte, 0, len(payload)+len(signer.keyID)+1+signatureSize)
+	signed = append(signed, payload...)
+	signed = append(signed, signer.keyID...)
+	signed = append(signed, byte(len(signer.keyID)))
+	return [][]byte{append(signed, mac...)}, nil
+}
+
+// decode implements payloadCodec
+func (signer *signer) decode(msg *Message) []*Message {
+	if err := signer.verify(msg); err != nil {
+		signer.conn.dropped(msg, DropRejected)
+		if signer.onTampered != nil {
+			signer.onTampered(msg, err)
+		} else if signer.conn.deadLetterTopic != "" {
+			signer.conn.deadLetterAsync(msg, err.Error())
+		}
+		return nil
+	}
+	return []*Message{msg}
+}
+
+// verify checks the signature of msg and strips it
+func (signer *signer) verify(msg *Message) error {
+	if len(msg.Payload) < 1+signatureSize {
+		return errors.Wrap(ErrSignatureInvalid, "not signed")
+	}
+	end := len(msg.Payload) - signatureSize
+	idSize := int(msg.Payload[end-1])
+	if end-1-idSize < 0 {
+		return errors.Wrap(ErrSignatureInvalid, "not signed")
+	}
+	payload := msg.Payload[:end-1-idSize]
+	mac, err := signer.mac(string(msg.Payload[end-1-idSize:end-1]), msg.Topic, payload)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(mac, msg.Payload[end:]) {
+		return ErrSignatureInvalid
+	}
+	msg.Payload = payload
+	return nil
+}
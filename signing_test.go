@@ -0,0 +1,53 @@
+package mqttconn
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSigning(t *testing.T) {
+	tampered := make(chan error, 4)
+	keys := map[string][]byte{"k1": []byte("secret one"), "k2": []byte("secret two")}
+	reader, err := DialMQTT("mqtt+memory://TestSigning/t", WithSigning("k1", keys, func(msg *Message, err error) {
+		tampered <- err
+	}))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer reader.Close()
+	writer, err := DialMQTT("mqtt+memory://TestSigning", WithSigning("k2", keys, nil))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer writer.Close()
+	writer.SetDefaultTopic("t")
+
+	signed, _ := writer.encodePayload("t", []byte("altered"))
+	signed[0][0] = 'A'
+	writer.Client.Publish("t", 0, false, signed[0]).Wait()
+	writer.Client.Publish("t", 0, false, []byte("unsigned")).Wait()
+	unknown, _ := (&signer{keyID: "k3", keys: map[string][]byte{"k3": []byte("other")}}).encode("t", []byte("unknown"))
+	writer.Client.Publish("t", 0, false, unknown[0]).Wait()
+	writer.Write([]byte("genuine"))
+
+	reader.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 16)
+	n, err := reader.Read(buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(buf[:n]) != "genuine" {
+		t.Error("expected genuine, got", string(buf[:n]))
+		return
+	}
+	for i := 0; i < 3; i++ {
+		if err := <-tampered; !errors.Is(err, ErrSignatureInvalid) {
+			t.Error("expected ErrSignatureInvalid, got", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,35 @@
+// Package spiffe authenticates mqttconn connections with SPIFFE workload identities
+package spiffe
+
+import (
+	"context"
+	"io"
+
+	mqttconn "github.com/gyf304/go-mqttconn"
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+// WithX509Source offers the X.509 SVID of source as client certificate and only accepts brokers presenting brokerID,
+// verified against bundles. Certificates are taken from source on every handshake,
+// so renewed SVIDs are used from the next reconnect on
+func WithX509Source(source x509svid.Source, bundles x509bundle.Source, brokerID spiffeid.ID) mqttconn.Option {
+	return mqttconn.WithTLSConfig(tlsconfig.MTLSClientConfig(source, bundles, tlsconfig.AuthorizeID(brokerID)))
+}
+
+// WithWorkloadAPI fetches SVIDs and bundles from the SPIFFE Workload API for mTLS to the broker with the SPIFFE ID brokerID
+// the returned io.Closer stops watching for renewed SVIDs, close it after the conn
+func WithWorkloadAPI(ctx context.Context, brokerID string, options ...workloadapi.X509SourceOption) (mqttconn.Option, io.Closer, error) {
+	id, err := spiffeid.FromString(brokerID)
+	if err != nil {
+		return nil, nil, err
+	}
+	source, err := workloadapi.NewX509Source(ctx, options...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return WithX509Source(source, source, id), source, nil
+}
@@ -0,0 +1,24 @@
+package spiffe
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+func TestWithWorkloadAPI(t *testing.T) {
+	if _, _, err := WithWorkloadAPI(context.Background(), "not a spiffe id"); err == nil {
+		t.Error("expected invalid SPIFFE ID to fail")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, _, err := WithWorkloadAPI(ctx, "spiffe://example.org/broker",
+		workloadapi.WithClientOptions(workloadapi.WithAddr("unix:///nonexistent/agent.sock")))
+	if err == nil {
+		t.Error("expected an unreachable Workload API to fail")
+		return
+	}
+}
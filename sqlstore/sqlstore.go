@@ -0,0 +1,132 @@
+// Package sqlstore keeps mqttconn outbox entries and inbox keys in a SQL database through database/sql,
+// it is written for SQLite, e.g. with github.com/mattn/go-sqlite3 or modernc.org/sqlite, and imports no driver
+package sqlstore
+
+import (
+	"database/sql"
+	"time"
+
+	mqttconn "github.com/gyf304/go-mqttconn"
+)
+
+// Store is a mqttconn.OutboxStore in a table of a SQL database
+type Store struct {
+	db *sql.DB
+
+	appendQuery  string
+	pendingQuery string
+	deleteQuery  string
+}
+
+var _ mqttconn.OutboxStore = (*Store)(nil)
+
+// New creates table in db if it doesn't exist, table is not quoted and must be a plain identifier
+func New(db *sql.DB, table string) (*Store, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS ` + table + ` (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		topic TEXT NOT NULL,
+		payload BLOB,
+		qos INTEGER NOT NULL,
+		retained INTEGER NOT NULL,
+		created INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{
+		db:           db,
+		appendQuery:  `INSERT INTO ` + table + ` (topic, payload, qos, retained, created) VALUES (?, ?, ?, ?, ?)`,
+		pendingQuery: `SELECT id, topic, payload, qos, retained, created FROM ` + table + ` ORDER BY id LIMIT ?`,
+		deleteQuery:  `DELETE FROM ` + table + ` WHERE id = ?`,
+	}, nil
+}
+
+// Append implements mqttconn.OutboxStore
+func (store *Store) Append(entry *mqttconn.OutboxEntry) error {
+	result, err := store.db.Exec(store.appendQuery, entry.Topic, entry.Payload, entry.QoS, entry.Retained, entry.Created.UnixNano())
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	entry.ID = uint64(id)
+	return nil
+}
+
+// Pending implements mqttconn.OutboxStore
+func (store *Store) Pending(limit int) ([]mqttconn.OutboxEntry, error) {
+	rows, err := store.db.Query(store.pendingQuery, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []mqttconn.OutboxEntry
+	for rows.Next() {
+		var entry mqttconn.OutboxEntry
+		var created int64
+		if err := rows.Scan(&entry.ID, &entry.Topic, &entry.Payload, &entry.QoS, &entry.Retained, &created); err != nil {
+			return nil, err
+		}
+		entry.Created = time.Unix(0, created)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// Delete implements mqttconn.OutboxStore
+func (store *Store) Delete(id uint64) error {
+	_, err := store.db.Exec(store.deleteQuery, id)
+	return err
+}
+
+// InboxStore is a mqttconn.InboxStore in a table of a SQL database
+type InboxStore struct {
+	db *sql.DB
+
+	processedQuery string
+	recordQuery    string
+	pruneQuery     string
+}
+
+var _ mqttconn.InboxStore = (*InboxStore)(nil)
+
+// NewInbox creates table in db if it doesn't exist, table is not quoted and must be a plain identifier
+func NewInbox(db *sql.DB, table string) (*InboxStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS ` + table + ` (
+		key TEXT PRIMARY KEY,
+		recorded INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &InboxStore{
+		db:             db,
+		processedQuery: `SELECT 1 FROM ` + table + ` WHERE key = ?`,
+		recordQuery:    `INSERT OR REPLACE INTO ` + table + ` (key, recorded) VALUES (?, ?)`,
+		pruneQuery:     `DELETE FROM ` + table + ` WHERE recorded < ?`,
+	}, nil
+}
+
+// Processed implements mqttconn.InboxStore
+func (store *InboxStore) Processed(key string) (bool, error) {
+	var one int
+	err := store.db.QueryRow(store.processedQuery, key).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Record implements mqttconn.InboxStore
+func (store *InboxStore) Record(key string, t time.Time) error {
+	_, err := store.db.Exec(store.recordQuery, key, t.UnixNano())
+	return err
+}
+
+// Prune implements mqttconn.InboxStore
+func (store *InboxStore) Prune(before time.Time) error {
+	_, err := store.db.Exec(store.pruneQuery, before.UnixNano())
+	return err
+}
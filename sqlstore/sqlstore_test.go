@@ -0,0 +1,83 @@
+//go:build cgo
+
+package sqlstore
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	mqttconn "github.com/gyf304/go-mqttconn"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestStore(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+	// each connection of :memory: is a separate database
+	db.SetMaxOpenConns(1)
+	store, err := New(db, "outbox")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, payload := range []string{"a", "b", "c"} {
+		if err := store.Append(&mqttconn.OutboxEntry{Topic: "t", Payload: []byte(payload), QoS: 1}); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if err := store.Delete(1); err != nil {
+		t.Error(err)
+		return
+	}
+	entries, err := store.Pending(1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(entries) != 1 || entries[0].ID != 2 || string(entries[0].Payload) != "b" || entries[0].QoS != 1 {
+		t.Error("unexpected pending entries", entries)
+	}
+}
+
+func TestInboxStore(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer db.Close()
+	store, err := NewInbox(db, "inbox")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	now := time.Now()
+	if err := store.Record("old", now.Add(-time.Hour)); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := store.Record("new", now); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := store.Prune(now.Add(-time.Minute)); err != nil {
+		t.Error(err)
+		return
+	}
+	for key, expected := range map[string]bool{"old": false, "new": true, "unknown": false} {
+		processed, err := store.Processed(key)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if processed != expected {
+			t.Error("expected", key, "processed to be", expected)
+		}
+	}
+}
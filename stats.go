@@ -0,0 +1,110 @@
+package mqttconn
+
+import (
+	"expvar"
+	"sync"
+	"sync/atomic"
+)
+
+// Stats are the counters of a MQTTConn since it was created
+type Stats struct {
+	MessagesReceived int64 `json:"messages_received"`
+	BytesReceived    int64 `json:"bytes_received"`
+	MessagesSent     int64 `json:"messages_sent"`
+	BytesSent        int64 `json:"bytes_sent"`
+	// PublishErrors counts failed publish attempts, including retried ones
+	PublishErrors int64 `json:"publish_errors"`
+	// Duplicates counts messages dropped by WithDedup
+	Duplicates int64 `json:"duplicates"`
+	// Expired counts messages dropped by WithMessageTTL
+	Expired int64 `json:"expired"`
+	// SequenceGaps counts messages missing from sequences, see WithSequencing
+	SequenceGaps int64 `json:"sequence_gaps"`
+	// SequenceDuplicates counts duplicate and reordered messages of sequences
+	SequenceDuplicates int64 `json:"sequence_duplicates"`
+	// ReorderTimeouts counts messages held back by WithFaultInjection and released without a next message
+	ReorderTimeouts int64 `json:"reorder_timeouts"`
+}
+
+// connStats holds the counters, updated atomically
+type connStats struct {
+	messagesReceived   int64
+	bytesReceived      int64
+	messagesSent       int64
+	bytesSent          int64
+	publishErrors      int64
+	duplicates         int64
+	expired            int64
+	sequenceGaps       int64
+	sequenceDuplicates int64
+	reorderTimeouts    int64
+}
+
+// received counts a received message of size bytes
+func (stats *connStats) received(size int) {
+	atomic.AddInt64(&stats.messagesReceived, 1)
+	atomic.AddInt64(&stats.bytesReceived, int64(size))
+}
+
+// published counts a publish attempt of size bytes
+func (stats *connStats) published(size int, err error) {
+	if err != nil {
+		atomic.AddInt64(&stats.publishErrors, 1)
+		return
+	}
+	atomic.AddInt64(&stats.messagesSent, 1)
+	atomic.AddInt64(&stats.bytesSent, int64(size))
+}
+
+// Stats returns a snapshot of the counters
+func (conn *MQTTConn) Stats() Stats {
+	return Stats{
+		MessagesReceived:   atomic.LoadInt64(&conn.stats.messagesReceived),
+		BytesReceived:      atomic.LoadInt64(&conn.stats.bytesReceived),
+		MessagesSent:       atomic.LoadInt64(&conn.stats.messagesSent),
+		BytesSent:          atomic.LoadInt64(&conn.stats.bytesSent),
+		PublishErrors:      atomic.LoadInt64(&conn.stats.publishErrors),
+		Duplicates:         atomic.LoadInt64(&conn.stats.duplicates),
+		Expired:            atomic.LoadInt64(&conn.stats.expired),
+		SequenceGaps:       atomic.LoadInt64(&conn.stats.sequenceGaps),
+		SequenceDuplicates: atomic.LoadInt64(&conn.stats.sequenceDuplicates),
+		ReorderTimeouts:    atomic.LoadInt64(&conn.stats.reorderTimeouts),
+	}
+}
+
+var (
+	expvarMu    sync.Mutex
+	expvarConns = make(map[string]*MQTTConn)
+)
+
+// WithExpvar publishes Stats with expvar under name, so /debug/vars includes them
+// expvar names can't be removed, a later conn using the same name takes over and a closed conn shows null
+// names already published by someone else are left alone
+func WithExpvar(name string) Option {
+	return func(conn *MQTTConn) {
+		conn.expvarName = name
+		expvarMu.Lock()
+		defer expvarMu.Unlock()
+		if _, ok := expvarConns[name]; !ok && expvar.Get(name) == nil {
+			expvar.Publish(name, expvar.Func(func() interface{} {
+				expvarMu.Lock()
+				conn := expvarConns[name]
+				expvarMu.Unlock()
+				if conn == nil {
+					return nil
+				}
+				return conn.Stats()
+			}))
+		}
+		expvarConns[name] = conn
+	}
+}
+
+// unpublishExpvar stops showing conn under name
+func unpublishExpvar(name string, conn *MQTTConn) {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	if expvarConns[name] == conn {
+		expvarConns[name] = nil
+	}
+}
@@ -0,0 +1,41 @@
+package mqttconn
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestStats/topic", WithExpvar("TestStats"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.Write([]byte("hello"))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = conn.Read(make([]byte, 16)); err != nil {
+		t.Error(err)
+		return
+	}
+	stats := conn.Stats()
+	if stats.MessagesSent != 1 || stats.BytesSent != 5 || stats.MessagesReceived != 1 || stats.BytesReceived != 5 {
+		t.Error("unexpected", stats)
+		return
+	}
+	var published Stats
+	if err = json.Unmarshal([]byte(expvar.Get("TestStats").String()), &published); err != nil {
+		t.Error(err)
+		return
+	}
+	if published != stats {
+		t.Error("expected", stats, "got", published)
+		return
+	}
+	conn.Close()
+	if value := expvar.Get("TestStats").String(); value != "null" {
+		t.Error("expected null after close, got", value)
+		return
+	}
+}
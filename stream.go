@@ -0,0 +1,490 @@
+package mqttconn
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// stream frame types, every frame starts with the type and a big endian uint32 sequence number
+const (
+	streamData byte = iota
+	streamAccept
+	streamFin
+	// streamCompressed carries data compressed with the compression negotiated for the stream
+	streamCompressed
+	// streamCredit grants a blob writer the frames before its sequence number, see OpenWriter
+	streamCredit
+)
+
+const (
+	streamHeaderSize = 5
+	// streamChunkSize is the maximum data carried by one frame
+	streamChunkSize = 32 * 1024
+)
+
+// StreamConn is a net.Conn carrying an ordered byte stream over a pair of topics
+// frames are published with QoS 1 and sequenced, so duplicates are dropped and reordering is undone
+// many StreamConns can share one MQTTConn, closing a StreamConn leaves the MQTTConn open
+type StreamConn struct {
+	conn       *MQTTConn
+	readTopic  string
+	writeTopic string
+	sub        *localSubscription
+	queue      *messageQueue
+
+	writeMu       sync.Mutex
+	writeSeq      uint32
+	writeDeadline time.Time
+	writeClosed   bool
+	compression   string
+	// window is the flow control of OpenWriter, nil for other streams
+	window *blobWindow
+
+	readMu       sync.Mutex
+	readSeq      uint32
+	pending      map[uint32][]byte
+	buf          []byte
+	readDeadline time.Time
+	accepted     bool
+	eof          bool
+	readClosed   int32
+	// granting is set for OpenReader, which grants credit on grantTopic, the topic of the frames plus /credit
+	granting   bool
+	grantTopic string
+
+	closeOnce     sync.Once
+	closeReadOnce sync.Once
+}
+
+// NewStreamConn creates a stream reading from readTopic and writing to writeTopic
+// the peer has to use the same topics swapped
+func NewStreamConn(conn *MQTTConn, readTopic, writeTopic string) (*StreamConn, error) {
+	sub, err := conn.subscribeQueue(readTopic, 1)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamConn{
+		conn:       conn,
+		readTopic:  readTopic,
+		writeTopic: writeTopic,
+		sub:        sub,
+		queue:      sub.queue,
+		pending:    make(map[uint32][]byte),
+	}, nil
+}
+
+// streamCompression compresses and decompresses the data of stream frames
+type streamCompression struct {
+	writer func(w io.Writer) (io.WriteCloser, error)
+	reader func(r io.Reader) (io.ReadCloser, error)
+}
+
+// streamCompressions are the compressions WithStreamCompression accepts
+var streamCompressions = map[string]streamCompression{
+	"gzip": {
+		writer: func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		},
+		reader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+	"deflate": {
+		writer: func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, flate.DefaultCompression)
+		},
+		reader: func(r io.Reader) (io.ReadCloser, error) {
+			return flate.NewReader(r), nil
+		},
+	},
+}
+
+// WithStreamCompression sets the compressions streams of the conn support, most preferred first,
+// "gzip" and "deflate" are known, other names are ignored.
+// DialStream offers them to the listener, which picks the first of its own it was offered,
+// so every stream uses the best compression both peers support, or none.
+// Every frame is compressed on its own, frames that don't get smaller are sent as they are.
+// Listeners and dialers without compressions fall back to plain streams
+func WithStreamCompression(names ...string) Option {
+	return func(conn *MQTTConn) {
+		conn.streamCompression = nil
+		for _, name := range names {
+			if _, ok := streamCompressions[name]; ok {
+				conn.streamCompression = append(conn.streamCompression, name)
+			}
+		}
+	}
+}
+
+// Compression returns the compression negotiated for the stream, "" for none
+func (stream *StreamConn) Compression() string {
+	return stream.compression
+}
+
+// compress returns data compressed, or nil if compressing doesn't make it smaller
+func (stream *StreamConn) compress(data []byte) []byte {
+	compression, ok := streamCompressions[stream.compression]
+	if !ok {
+		return nil
+	}
+	var buf bytes.Buffer
+	w, err := compression.writer(&buf)
+	if err != nil {
+		return nil
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil
+	}
+	if err = w.Close(); err != nil || buf.Len() >= len(data) {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// decompress returns the data of a streamCompressed frame
+func (stream *StreamConn) decompress(data []byte) ([]byte, error) {
+	compression, ok := streamCompressions[stream.compression]
+	if !ok {
+		return nil, ErrFrameInvalid
+	}
+	r, err := compression.reader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(ErrFrameInvalid, err.Error())
+	}
+	defer r.Close()
+	// frames never carry more than streamChunkSize, a larger frame is not from a peer
+	decompressed, err := io.ReadAll(io.LimitReader(r, streamChunkSize+1))
+	if err != nil {
+		return nil, errors.Wrap(ErrFrameInvalid, err.Error())
+	}
+	if len(decompressed) > streamChunkSize {
+		return nil, ErrFrameInvalid
+	}
+	return decompressed, nil
+}
+
+// containsString reports whether s is one of list
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// writeFrame publishes a frame
+func (stream *StreamConn) writeFrame(frameType byte, data []byte, deadline time.Time) error {
+	frame := make([]byte, streamHeaderSize+len(data))
+	frame[0] = frameType
+	binary.BigEndian.PutUint32(frame[1:], stream.writeSeq)
+	copy(frame[streamHeaderSize:], data)
+	err := stream.conn.publishWithRetry(&outgoing{
+		topic:    stream.writeTopic,
+		qos:      1,
+		payload:  frame,
+		deadline: deadline,
+	})
+	if err != nil {
+		return err
+	}
+	stream.writeSeq++
+	return nil
+}
+
+// Write implements net.Conn.Write, splitting p into frames as needed
+func (stream *StreamConn) Write(p []byte) (int, error) {
+	stream.writeMu.Lock()
+	defer stream.writeMu.Unlock()
+	if stream.writeClosed {
+		return 0, ErrClosed
+	}
+	n := 0
+	for n < len(p) {
+		chunk := p[n:]
+		if len(chunk) > streamChunkSize {
+			chunk = chunk[:streamChunkSize]
+		}
+		if err := stream.awaitCredit(stream.writeDeadline); err != nil {
+			return n, err
+		}
+		var err error
+		if compressed := stream.compress(chunk); compressed != nil {
+			err = stream.writeFrame(streamCompressed, compressed, stream.writeDeadline)
+		} else {
+			err = stream.writeFrame(streamData, chunk, stream.writeDeadline)
+		}
+		if err != nil {
+			return n, err
+		}
+		n += len(chunk)
+	}
+	return n, nil
+}
+
+// nextFrame handles the next frame in sequence, must be called with readMu held
+func (stream *StreamConn) nextFrame(ctx context.Context, deadline time.Time) error {
+	frame, ok := stream.pending[stream.readSeq]
+	for !ok {
+		msg, err := stream.queue.next(ctx, deadline, true)
+		if err != nil {
+			return err
+		}
+		if len(msg.Payload) < streamHeaderSize || msg.Payload[0] == streamCredit {
+			continue
+		}
+		if stream.granting {
+			stream.grantTopic = msg.Topic + blobCreditSuffix
+		}
+		seq := binary.BigEndian.Uint32(msg.Payload[1:])
+		if int32(seq-stream.readSeq) < 0 {
+			// duplicate of a frame already handled
+			continue
+		}
+		stream.pending[seq] = msg.Payload
+		frame, ok = stream.pending[stream.readSeq]
+	}
+	delete(stream.pending, stream.readSeq)
+	stream.readSeq++
+	stream.grant()
+	switch frame[0] {
+	case streamData:
+		stream.buf = append(stream.buf, frame[streamHeaderSize:]...)
+	case streamCompressed:
+		data, err := stream.decompress(frame[streamHeaderSize:])
+		if err != nil {
+			return err
+		}
+		stream.buf = append(stream.buf, data...)
+	case streamAccept:
+		stream.accepted = true
+		stream.compression = string(frame[streamHeaderSize:])
+	case streamFin:
+		stream.eof = true
+	}
+	return nil
+}
+
+// Read implements net.Conn.Read, it returns io.EOF once the peer closed and all data is drained
+func (stream *StreamConn) Read(p []byte) (int, error) {
+	stream.readMu.Lock()
+	defer stream.readMu.Unlock()
+	if atomic.LoadInt32(&stream.readClosed) != 0 {
+		return 0, io.EOF
+	}
+	for len(stream.buf) == 0 {
+		if stream.eof {
+			return 0, io.EOF
+		}
+		err := stream.nextFrame(context.Background(), stream.readDeadline)
+		if err != nil {
+			if atomic.LoadInt32(&stream.readClosed) != 0 {
+				return 0, io.EOF
+			}
+			return 0, err
+		}
+	}
+	n := copy(p, stream.buf)
+	stream.buf = stream.buf[n:]
+	return n, nil
+}
+
+// Close implements net.Conn.Close, the peer reads io.EOF after draining
+func (stream *StreamConn) Close() error {
+	var err error
+	stream.closeOnce.Do(func() {
+		err = stream.CloseWrite()
+		if closeErr := stream.CloseRead(); err == nil {
+			err = closeErr
+		}
+	})
+	return err
+}
+
+// CloseWrite shuts down the writing side like *net.TCPConn.CloseWrite, the peer reads io.EOF after draining
+// the stream stays readable
+func (stream *StreamConn) CloseWrite() error {
+	if stream.window != nil {
+		// wakes up a Write waiting for credit
+		stream.window.close()
+	}
+	stream.writeMu.Lock()
+	defer stream.writeMu.Unlock()
+	if stream.writeClosed {
+		return nil
+	}
+	stream.writeClosed = true
+	return stream.writeFrame(streamFin, nil, stream.writeDeadline)
+}
+
+// CloseRead shuts down the reading side like *net.TCPConn.CloseRead, unsubscribing from the read topic
+// Read returns io.EOF from then on, data the peer still sends is lost. The stream stays writable
+func (stream *StreamConn) CloseRead() error {
+	var err error
+	stream.closeReadOnce.Do(func() {
+		atomic.StoreInt32(&stream.readClosed, 1)
+		if stream.sub == nil {
+			// write only, see OpenWriter
+			return
+		}
+		err = stream.sub.close()
+	})
+	return err
+}
+
+// LocalAddr implements net.Conn.LocalAddr
+func (stream *StreamConn) LocalAddr() net.Addr {
+	return TopicAddr(stream.readTopic)
+}
+
+// RemoteAddr implements net.Conn.RemoteAddr
+func (stream *StreamConn) RemoteAddr() net.Addr {
+	return TopicAddr(stream.writeTopic)
+}
+
+// SetDeadline implements net.Conn.SetDeadline
+func (stream *StreamConn) SetDeadline(t time.Time) error {
+	stream.SetReadDeadline(t)
+	stream.SetWriteDeadline(t)
+	return nil
+}
+
+// SetReadDeadline implements net.Conn.SetReadDeadline
+// it only applies to Reads started afterwards
+func (stream *StreamConn) SetReadDeadline(t time.Time) error {
+	stream.readMu.Lock()
+	stream.readDeadline = t
+	stream.readMu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline implements net.Conn.SetWriteDeadline
+// it only applies to Writes started afterwards
+func (stream *StreamConn) SetWriteDeadline(t time.Time) error {
+	stream.writeMu.Lock()
+	stream.writeDeadline = t
+	stream.writeMu.Unlock()
+	return nil
+}
+
+// StreamListener accepts streams dialed with DialStream, like net.Listener
+// streams of a listener with prefix use the topics prefix/listen, prefix/<id>/up and prefix/<id>/down
+type StreamListener struct {
+	conn   *MQTTConn
+	prefix string
+	sub    *localSubscription
+}
+
+// ListenStream listens for streams under prefix
+func ListenStream(conn *MQTTConn, prefix string) (*StreamListener, error) {
+	sub, err := conn.subscribeQueue(prefix+"/listen", 1)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamListener{
+		conn:   conn,
+		prefix: prefix,
+		sub:    sub,
+	}, nil
+}
+
+// Accept implements net.Listener.Accept
+func (listener *StreamListener) Accept() (net.Conn, error) {
+	for {
+		msg, err := listener.sub.queue.next(context.Background(), time.Time{}, true)
+		if err != nil {
+			return nil, err
+		}
+		request := strings.SplitN(string(msg.Payload), "\n", 2)
+		id := request[0]
+		if id == "" || strings.ContainsAny(id, "/+#") {
+			continue
+		}
+		stream, err := NewStreamConn(listener.conn, listener.prefix+"/"+id+"/up", listener.prefix+"/"+id+"/down")
+		if err != nil {
+			return nil, err
+		}
+		var offered []string
+		if len(request) > 1 {
+			offered = strings.Split(request[1], ",")
+		}
+		for _, name := range listener.conn.streamCompression {
+			if containsString(offered, name) {
+				stream.compression = name
+				break
+			}
+		}
+		stream.writeMu.Lock()
+		err = stream.writeFrame(streamAccept, []byte(stream.compression), time.Time{})
+		stream.writeMu.Unlock()
+		if err != nil {
+			stream.Close()
+			return nil, err
+		}
+		return stream, nil
+	}
+}
+
+// Close implements net.Listener.Close, streams already accepted stay open
+func (listener *StreamListener) Close() error {
+	return listener.sub.close()
+}
+
+// Addr implements net.Listener.Addr
+func (listener *StreamListener) Addr() net.Addr {
+	return TopicAddr(listener.prefix)
+}
+
+// DialStream opens a stream to the StreamListener listening under prefix
+// it waits for the listener to accept until ctx is done.
+// The stream id is published to prefix/listen, followed by a newline and the comma separated
+// WithStreamCompression names if there are any
+func DialStream(ctx context.Context, conn *MQTTConn, prefix string) (*StreamConn, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+	stream, err := NewStreamConn(conn, prefix+"/"+id.String()+"/down", prefix+"/"+id.String()+"/up")
+	if err != nil {
+		return nil, err
+	}
+	request := []byte(id.String())
+	if len(conn.streamCompression) > 0 {
+		request = append(request, '\n')
+		request = append(request, strings.Join(conn.streamCompression, ",")...)
+	}
+	if conn.audit != nil {
+		conn.audit.record(conn.clock.Now(), auditOut, conn.remoteTopic(prefix+"/listen"), 1, false, request)
+	}
+	token := conn.Client.Publish(conn.remoteTopic(prefix+"/listen"), 1, false, request)
+	token.Wait()
+	if err = token.Error(); err != nil {
+		stream.Close()
+		return nil, err
+	}
+	stream.readMu.Lock()
+	for !stream.accepted && err == nil {
+		err = stream.nextFrame(ctx, time.Time{})
+	}
+	stream.readMu.Unlock()
+	if err == nil && stream.compression != "" && !containsString(conn.streamCompression, stream.compression) {
+		err = ErrFrameInvalid
+	}
+	if err != nil {
+		stream.Close()
+		return nil, errors.Wrap(err, "stream not accepted")
+	}
+	return stream, nil
+}
@@ -0,0 +1,255 @@
+package mqttconn
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestStream")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	listener, err := ListenStream(conn, "streams")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer listener.Close()
+	expected := bytes.Repeat([]byte("0123456789"), 10000)
+	accepted := make(chan []byte, 1)
+	go func() {
+		stream, err := listener.Accept()
+		if err != nil {
+			accepted <- nil
+			return
+		}
+		received := make([]byte, len(expected))
+		io.ReadFull(stream, received)
+		stream.Write([]byte("done"))
+		stream.Close()
+		accepted <- received
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	stream, err := DialStream(ctx, conn, "streams")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = stream.Write(expected)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if received := <-accepted; !bytes.Equal(received, expected) {
+		t.Error("expected", len(expected), "bytes, got", len(received))
+		return
+	}
+	reply, err := io.ReadAll(stream)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(reply) != "done" {
+		t.Error("expected reply done, got", string(reply))
+		return
+	}
+	stream.Close()
+}
+
+func TestStreamReorder(t *testing.T) {
+	stream := &StreamConn{
+		queue:   newMessageQueue(0, realClock{}),
+		pending: make(map[uint32][]byte),
+	}
+	for _, frame := range [][]byte{
+		{streamData, 0, 0, 0, 1, 'b'},
+		{streamData, 0, 0, 0, 0, 'a'},
+		{streamData, 0, 0, 0, 0, 'a'},
+		{streamFin, 0, 0, 0, 2},
+	} {
+		stream.queue.push(&Message{Payload: frame})
+	}
+	received, err := io.ReadAll(stream)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(received) != "ab" {
+		t.Error("expected ab, got", string(received))
+		return
+	}
+}
+
+func TestStreamHalfClose(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestStreamHalfClose")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	listener, err := ListenStream(conn, "streams")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer listener.Close()
+	served := make(chan error, 1)
+	go func() {
+		stream, err := listener.Accept()
+		if err != nil {
+			served <- err
+			return
+		}
+		defer stream.Close()
+		request, err := io.ReadAll(stream)
+		if err != nil {
+			served <- err
+			return
+		}
+		_, err = stream.Write(append([]byte("echo "), request...))
+		served <- err
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	stream, err := DialStream(ctx, conn, "streams")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer stream.Close()
+	if _, err := stream.Write([]byte("hello")); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := stream.CloseWrite(); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := stream.Write([]byte("more")); err != ErrClosed {
+		t.Error("expected ErrClosed after CloseWrite, got", err)
+		return
+	}
+	reply, err := io.ReadAll(stream)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(reply) != "echo hello" {
+		t.Error("expected reply echo hello, got", string(reply))
+		return
+	}
+	if err := <-served; err != nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestStreamCloseRead(t *testing.T) {
+	stream := &StreamConn{
+		queue:   newMessageQueue(0, realClock{}),
+		pending: make(map[uint32][]byte),
+	}
+	stream.queue.push(&Message{Payload: []byte{streamData, 0, 0, 0, 0, 'a'}})
+	done := make(chan error, 1)
+	go func() {
+		_, err := stream.Read(make([]byte, 1))
+		_, err = stream.Read(make([]byte, 1))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := stream.CloseRead(); err != nil {
+		t.Error(err)
+		return
+	}
+	stream.queue.close()
+	if err := <-done; err != io.EOF {
+		t.Error("expected io.EOF after CloseRead, got", err)
+		return
+	}
+	if _, err := stream.Read(make([]byte, 1)); err != io.EOF {
+		t.Error("expected io.EOF after CloseRead, got", err)
+		return
+	}
+}
+
+func TestStreamCompression(t *testing.T) {
+	server, err := DialMQTT("mqtt+memory://TestStreamCompression", WithStreamCompression("deflate", "gzip"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer server.Close()
+	listener, err := ListenStream(server, "streams")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			stream, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(stream, stream)
+				stream.Close()
+			}()
+		}
+	}()
+
+	expected := bytes.Repeat([]byte("0123456789"), 10000)
+	for _, test := range []struct {
+		compressions []string
+		expected     string
+	}{
+		{[]string{"gzip"}, "gzip"},
+		{[]string{"gzip", "deflate"}, "deflate"},
+		{[]string{"zstd"}, ""},
+		{nil, ""},
+	} {
+		client, err := DialMQTT("mqtt+memory://TestStreamCompression", WithStreamCompression(test.compressions...))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer client.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		stream, err := DialStream(ctx, client, "streams")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if stream.Compression() != test.expected {
+			t.Error("expected compression", test.expected, "for", test.compressions, "got", stream.Compression())
+		}
+		go func() {
+			stream.Write(expected)
+			stream.CloseWrite()
+		}()
+		stream.SetReadDeadline(time.Now().Add(time.Second))
+		received, err := io.ReadAll(stream)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !bytes.Equal(received, expected) {
+			t.Error("expected", len(expected), "bytes, got", len(received))
+		}
+		sent := client.Stats().BytesSent
+		if test.expected != "" && sent >= int64(len(expected)) {
+			t.Error("expected compressed frames, sent", sent, "bytes")
+		}
+		stream.Close()
+	}
+}
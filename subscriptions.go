@@ -0,0 +1,254 @@
+package mqttconn
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// brokerSubscription is a subscription held with the broker, shared by every local consumer of its filter
+// paho keeps a single handler per filter, so consumers can't subscribe to the same filter independently
+type brokerSubscription struct {
+	qos       byte
+	consumers map[*localSubscription]struct{}
+}
+
+// localSubscription is one consumer of a broker subscription
+type localSubscription struct {
+	conn    *MQTTConn
+	filter  string
+	deliver func(*Message)
+	// queue is set for consumers created by subscribeQueue
+	queue *messageQueue
+	// requested and granted are the QoS asked for and the QoS the broker granted
+	requested byte
+	granted   byte
+}
+
+// subscribeLocal adds a consumer for filter, subscribing with the broker
+// every new consumer resubscribes, which makes the broker resend retained messages,
+// so existing consumers of the filter may see retained messages again
+func (conn *MQTTConn) subscribeLocal(filter string, qos byte, deliver func(*Message)) (*localSubscription, error) {
+	if err := validateFilter(filter); err != nil {
+		return nil, err
+	}
+	conn.subscribeMu.Lock()
+	defer conn.subscribeMu.Unlock()
+	sub, qos := conn.addLocal(filter, qos, deliver)
+	token := conn.Client.Subscribe(conn.remoteTopic(filter), qos, conn.dispatcher(filter))
+	token.Wait()
+	if err := subscribeError(token); err != nil {
+		conn.removeLocal(sub)
+		return nil, err
+	}
+	sub.granted = grantedQoS(token, conn.remoteTopic(filter), qos)
+	return sub, nil
+}
+
+// addLocal registers a consumer for filter without subscribing with the broker,
+// returning the QoS to subscribe with. Must be called with subscribeMu held
+func (conn *MQTTConn) addLocal(filter string, qos byte, deliver func(*Message)) (*localSubscription, byte) {
+	sub := &localSubscription{
+		conn:      conn,
+		filter:    filter,
+		deliver:   deliver,
+		requested: qos,
+	}
+	conn.subsMu.Lock()
+	shared, ok := conn.subscriptions[filter]
+	if !ok {
+		shared = &brokerSubscription{consumers: make(map[*localSubscription]struct{})}
+		conn.subscriptions[filter] = shared
+	}
+	if qos > shared.qos {
+		shared.qos = qos
+	}
+	qos = shared.qos
+	// register before subscribing so the consumer gets the retained messages
+	shared.consumers[sub] = struct{}{}
+	conn.subsMu.Unlock()
+	return sub, qos
+}
+
+// subscribeResult is a subscribe token telling the SUBACK reason codes by filter,
+// *mqtt.SubscribeToken and the token of the MQTT 5 client
+type subscribeResult interface {
+	Result() map[string]byte
+}
+
+// grantedQoS returns the QoS granted to filter by a subscribe token, requested if the client doesn't tell
+func grantedQoS(token mqtt.Token, filter string, requested byte) byte {
+	if subscribeToken, ok := token.(subscribeResult); ok {
+		if qos, ok := subscribeToken.Result()[filter]; ok {
+			return qos
+		}
+	}
+	return requested
+}
+
+// subscribeQueue adds a consumer for filter with a dedicated queue that bypasses ReadFrom
+func (conn *MQTTConn) subscribeQueue(filter string, qos byte) (*localSubscription, error) {
+	return conn.subscribeQueueCapacity(filter, qos, 0)
+}
+
+// subscribeQueueCapacity is subscribeQueue with a queue of capacity messages, zero means unbounded
+// delivery blocks while the queue is full
+func (conn *MQTTConn) subscribeQueueCapacity(filter string, qos byte, capacity int) (*localSubscription, error) {
+	queue := newMessageQueue(capacity, conn.clock)
+	sub, err := conn.subscribeLocal(filter, qos, func(msg *Message) {
+		queue.push(msg)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sub.queue = queue
+	return sub, nil
+}
+
+// dispatcher returns the paho handler for filter, handing messages to every consumer
+func (conn *MQTTConn) dispatcher(filter string) mqtt.MessageHandler {
+	return func(client mqtt.Client, msg mqtt.Message) {
+		conn.subsMu.Lock()
+		shared := conn.subscriptions[filter]
+		var consumers []*localSubscription
+		if shared != nil {
+			consumers = make([]*localSubscription, 0, len(shared.consumers))
+			for sub := range shared.consumers {
+				consumers = append(consumers, sub)
+			}
+		}
+		conn.subsMu.Unlock()
+		for _, sub := range consumers {
+			if conn.workers == nil {
+				sub.deliver(conn.newMessage(msg, filter))
+				continue
+			}
+			sub := sub
+			conn.workers.submit(conn, func() {
+				sub.deliver(conn.newMessage(msg, filter))
+			})
+		}
+	}
+}
+
+// removeLocal removes a consumer and reports if it was the last one of its filter
+func (conn *MQTTConn) removeLocal(sub *localSubscription) bool {
+	conn.subsMu.Lock()
+	defer conn.subsMu.Unlock()
+	shared, ok := conn.subscriptions[sub.filter]
+	if !ok {
+		return false
+	}
+	delete(shared.consumers, sub)
+	if len(shared.consumers) > 0 {
+		return false
+	}
+	delete(conn.subscriptions, sub.filter)
+	return true
+}
+
+// resubscribe subscribes every filter with the broker again, after a new session started
+func (conn *MQTTConn) resubscribe() error {
+	conn.subscribeMu.Lock()
+	defer conn.subscribeMu.Unlock()
+	conn.subsMu.Lock()
+	qos := make(map[string]byte, len(conn.subscriptions))
+	for filter, shared := range conn.subscriptions {
+		qos[filter] = shared.qos
+	}
+	conn.subsMu.Unlock()
+	var err error
+	for filter, filterQoS := range qos {
+		token := conn.Client.Subscribe(conn.remoteTopic(filter), filterQoS, conn.dispatcher(filter))
+		token.Wait()
+		if subscribeErr := subscribeError(token); err == nil {
+			err = subscribeErr
+		}
+	}
+	return err
+}
+
+// unsubscribeAll drops every broker subscription
+func (conn *MQTTConn) unsubscribeAll() error {
+	conn.subscribeMu.Lock()
+	defer conn.subscribeMu.Unlock()
+	conn.subsMu.Lock()
+	filters := make([]string, 0, len(conn.subscriptions))
+	for filter := range conn.subscriptions {
+		filters = append(filters, conn.remoteTopic(filter))
+	}
+	conn.subscriptions = make(map[string]*brokerSubscription)
+	conn.subscribed = make(map[string]*localSubscription)
+	conn.subsMu.Unlock()
+	if len(filters) == 0 {
+		return nil
+	}
+	token := conn.Client.Unsubscribe(filters...)
+	token.Wait()
+	return token.Error()
+}
+
+// close removes the consumer, unsubscribing with the broker if it was the last one
+func (sub *localSubscription) close() error {
+	conn := sub.conn
+	conn.subscribeMu.Lock()
+	defer conn.subscribeMu.Unlock()
+	return conn.unsubscribeLocal([]*localSubscription{sub})
+}
+
+// Subscription is a subscription made with Subscribe, SubscribePriority or SubscribeBuffered
+type Subscription struct {
+	conn  *MQTTConn
+	topic string
+	local *localSubscription
+	// messages and last are the number and arrival time of the messages received
+	messages int64
+	lastMu   sync.Mutex
+	last     time.Time
+}
+
+// received counts a message that arrived at t
+func (subscription *Subscription) received(t time.Time) {
+	atomic.AddInt64(&subscription.messages, 1)
+	subscription.lastMu.Lock()
+	subscription.last = t
+	subscription.lastMu.Unlock()
+}
+
+// Topic returns the topic filter subscribed to
+func (subscription *Subscription) Topic() string {
+	return subscription.topic
+}
+
+// GrantedQoS returns the QoS the broker granted, which may be lower than requested
+// the subscription shares the QoS of other subscriptions on its topic, the highest requested
+func (subscription *Subscription) GrantedQoS() byte {
+	return subscription.local.granted
+}
+
+// MessageCount returns the number of messages received on the subscription
+// dropped messages count, messages received before the broker confirmed the subscription too
+func (subscription *Subscription) MessageCount() int64 {
+	return atomic.LoadInt64(&subscription.messages)
+}
+
+// LastMessageAt returns when the latest message was received, zero if none was
+// it keeps the monotonic clock reading, so time.Since is not affected by clock changes
+func (subscription *Subscription) LastMessageAt() time.Time {
+	subscription.lastMu.Lock()
+	defer subscription.lastMu.Unlock()
+	return subscription.last
+}
+
+// Close unsubscribes, unless the subscription was already replaced by subscribing to its topic again
+func (subscription *Subscription) Close() error {
+	conn := subscription.conn
+	conn.subsMu.Lock()
+	if conn.subscribed[subscription.topic] == subscription.local {
+		delete(conn.subscribed, subscription.topic)
+	}
+	conn.subsMu.Unlock()
+	return subscription.local.close()
+}
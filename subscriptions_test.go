@@ -0,0 +1,133 @@
+package mqttconn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestSharedSubscription(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestSharedSubscription")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	first, err := conn.subscribeQueue("shared/#", 1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	second, err := conn.subscribeQueue("shared/#", 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	first.close()
+	conn.Client.Publish("shared/topic", 1, false, "test").Wait()
+	msg, err := second.queue.next(context.Background(), time.Now().Add(time.Second), true)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if msg.Topic != "shared/topic" || msg.QoS != 1 || msg.MatchedFilter != "shared/#" {
+		t.Error("expected QoS 1 message on shared/topic matching shared/#, got", msg.Topic, msg.QoS, msg.MatchedFilter)
+		return
+	}
+	second.close()
+	if len(conn.subscriptions) != 0 {
+		t.Error("expected no subscriptions left")
+		return
+	}
+}
+
+func TestUnsubscribeOnClose(t *testing.T) {
+	for _, unsubscribe := range []bool{false, true} {
+		client := &subscriptionRecorder{}
+		var options []Option
+		if unsubscribe {
+			options = append(options, WithUnsubscribeOnClose())
+		}
+		conn, _ := CreateMQTTConn(client, options...)
+		if _, err := conn.Subscribe("a", 1); err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+		if unsubscribe != (len(client.unsubscribed) == 1 && client.unsubscribed[0] == "a") {
+			t.Error("unsubscribe on close", unsubscribe, "got unsubscribed", client.unsubscribed)
+			return
+		}
+	}
+}
+
+// subscriptionRecorder records unsubscribed filters
+type subscriptionRecorder struct {
+	mqtt.Client
+	unsubscribed []string
+}
+
+func (client *subscriptionRecorder) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	return &mqtt.DummyToken{}
+}
+
+func (client *subscriptionRecorder) Unsubscribe(topics ...string) mqtt.Token {
+	client.unsubscribed = append(client.unsubscribed, topics...)
+	return &mqtt.DummyToken{}
+}
+
+func (client *subscriptionRecorder) Disconnect(quiesce uint) {}
+
+func TestSubscriptionHandle(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestSubscriptionHandle")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	subscription, err := conn.Subscribe("handle", 1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if subscription.Topic() != "handle" || subscription.GrantedQoS() != 1 || !subscription.LastMessageAt().IsZero() {
+		t.Error("unexpected new subscription", subscription.Topic(), subscription.GrantedQoS(), subscription.LastMessageAt())
+		return
+	}
+	conn.WriteTo([]byte("one"), TopicAddr("handle"))
+	conn.WriteTo([]byte("two"), TopicAddr("handle"))
+	for subscription.MessageCount() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	if subscription.LastMessageAt().IsZero() {
+		t.Error("expected the time of the last message")
+		return
+	}
+	// a replaced subscription doesn't unsubscribe its successor
+	replacement, err := conn.Subscribe("handle", 1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	subscription.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for i := 0; i < 2; i++ {
+		if _, _, err := conn.ReadFrom(make([]byte, 16)); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	conn.WriteTo([]byte("three"), TopicAddr("handle"))
+	for replacement.MessageCount() < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	replacement.Close()
+	conn.subsMu.Lock()
+	remaining := len(conn.subscriptions)
+	conn.subsMu.Unlock()
+	if remaining != 0 {
+		t.Error("expected no subscriptions left, got", remaining)
+	}
+}
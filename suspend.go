@@ -0,0 +1,182 @@
+package mqttconn
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultPauseBuffer is the number of writes Pause holds without WithPauseBuffer
+const defaultPauseBuffer = 1024
+
+// suspendState holds the writes made while the conn is paused
+type suspendState struct {
+	mu     sync.Mutex
+	paused bool
+	held   []*outgoing
+	limit  int
+	// resumeMu serializes Resume
+	resumeMu sync.Mutex
+}
+
+// WithPauseBuffer sets the number of writes held while the conn is paused, 1024 by default
+func WithPauseBuffer(limit int) Option {
+	return func(conn *MQTTConn) {
+		conn.suspend.limit = limit
+	}
+}
+
+// Pause disconnects from the broker before the device sleeps, so no keepalive is missed,
+// and holds Write, WriteTo and WriteBatch in memory until Resume. Writes over the WithPauseBuffer limit fail
+// with ErrPauseBufferFull, subscribing fails with ErrNotConnected
+func (conn *MQTTConn) Pause() error {
+	if atomic.LoadInt32(&conn.closed) != 0 {
+		return ErrClosed
+	}
+	conn.Flush()
+	conn.suspend.mu.Lock()
+	if conn.suspend.paused {
+		conn.suspend.mu.Unlock()
+		return nil
+	}
+	conn.suspend.paused = true
+	conn.suspend.mu.Unlock()
+	conn.Client.Disconnect(250)
+	return nil
+}
+
+// Paused reports whether the conn is paused
+func (conn *MQTTConn) Paused() bool {
+	conn.suspend.mu.Lock()
+	defer conn.suspend.mu.Unlock()
+	return conn.suspend.paused
+}
+
+// Resume connects again after Pause, renews the subscriptions and publishes the held writes in order
+// writes stay held until the backlog is sent. On an error the conn stays paused, Resume can be retried
+func (conn *MQTTConn) Resume() error {
+	if atomic.LoadInt32(&conn.closed) != 0 {
+		return ErrClosed
+	}
+	conn.suspend.resumeMu.Lock()
+	defer conn.suspend.resumeMu.Unlock()
+	if !conn.Paused() {
+		return nil
+	}
+	atomic.StoreInt32(&conn.connectionLost, 0)
+	token := conn.Client.Connect()
+	token.Wait()
+	err := connectError(token)
+	if err == nil {
+		err = conn.resubscribe()
+	}
+	if hooks := conn.loadHooks(); hooks.OnReconnect != nil {
+		hooks.OnReconnect(err)
+	}
+	if err != nil {
+		return err
+	}
+	for {
+		conn.suspend.mu.Lock()
+		if len(conn.suspend.held) == 0 {
+			conn.suspend.held = nil
+			conn.suspend.paused = false
+			conn.suspend.mu.Unlock()
+			return nil
+		}
+		out := conn.suspend.held[0]
+		conn.suspend.mu.Unlock()
+		if err := conn.publishWithRetry(out); err != nil {
+			return err
+		}
+		conn.suspend.mu.Lock()
+		conn.suspend.held = conn.suspend.held[1:]
+		conn.suspend.mu.Unlock()
+	}
+}
+
+// hold keeps the payloads written to topic while the conn is paused, it reports whether they were held
+func (conn *MQTTConn) hold(topic string, payloads [][]byte, qos byte, retained bool, metadata *Message) (bool, error) {
+	conn.suspend.mu.Lock()
+	defer conn.suspend.mu.Unlock()
+	if !conn.suspend.paused {
+		return false, nil
+	}
+	limit := conn.suspend.limit
+	if limit == 0 {
+		limit = defaultPauseBuffer
+	}
+	if len(conn.suspend.held)+len(payloads) > limit {
+		return true, ErrPauseBufferFull
+	}
+	for _, payload := range payloads {
+		conn.suspend.held = append(conn.suspend.held, &outgoing{
+			topic:    topic,
+			qos:      qos,
+			retained: retained,
+			payload:  payload,
+			metadata: metadata,
+		})
+	}
+	return true, nil
+}
+
+// WithWakeDetection checks every interval whether the process was suspended, seeing a gap of twice the interval
+// on the monotonic or the wall clock. The conn reconnects right away then, unless it is paused,
+// instead of waiting for a keepalive to time out, and onWake is called with the gap if it is not nil
+func WithWakeDetection(interval time.Duration, onWake func(conn *MQTTConn, gap time.Duration)) Option {
+	return func(conn *MQTTConn) {
+		conn.wake = &wakeDetector{
+			interval: interval,
+			onWake:   onWake,
+			stopped:  make(chan struct{}),
+		}
+	}
+}
+
+// wakeDetector watches for gaps in the scheduling of a MQTTConn
+type wakeDetector struct {
+	interval time.Duration
+	onWake   func(conn *MQTTConn, gap time.Duration)
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// watch checks for gaps until stop is called
+func (wake *wakeDetector) watch(conn *MQTTConn) {
+	conn.goLabeled(func() {
+		last := conn.clock.Now()
+		for {
+			timer := conn.clock.NewTimer(wake.interval)
+			select {
+			case <-timer.C():
+			case <-wake.stopped:
+				timer.Stop()
+				return
+			}
+			now := conn.clock.Now()
+			// monotonic time stops while some systems sleep, the wall clock doesn't
+			gap := now.Sub(last)
+			if wall := now.Round(0).Sub(last.Round(0)); wall > gap {
+				gap = wall
+			}
+			last = now
+			if gap < 2*wake.interval {
+				continue
+			}
+			if !conn.Paused() {
+				conn.Reconnect()
+			}
+			if wake.onWake != nil {
+				wake.onWake(conn, gap)
+			}
+		}
+	})
+}
+
+// stop ends watching
+func (wake *wakeDetector) stop() {
+	wake.stopOnce.Do(func() {
+		close(wake.stopped)
+	})
+}
@@ -0,0 +1,96 @@
+package mqttconn
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestPause(t *testing.T) {
+	reader, err := DialMQTT("mqtt+memory://TestPause/t")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer reader.Close()
+	conn, err := DialMQTT("mqtt+memory://TestPause/t", WithPauseBuffer(2))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	if err := conn.Pause(); err != nil {
+		t.Error(err)
+		return
+	}
+	if !conn.Paused() {
+		t.Error("expected the conn to be paused")
+		return
+	}
+	for _, s := range []string{"a", "b"} {
+		if _, err := conn.Write([]byte(s)); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if _, err := conn.Write([]byte("c")); err != ErrPauseBufferFull {
+		t.Error("expected ErrPauseBufferFull, got", err)
+		return
+	}
+	if err := conn.Resume(); err != nil {
+		t.Error(err)
+		return
+	}
+	if conn.Paused() {
+		t.Error("expected the conn to be resumed")
+		return
+	}
+	reader.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 16)
+	for _, expected := range []string{"a", "b"} {
+		n, err := reader.Read(buf)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(buf[:n]) != expected {
+			t.Error("expected", expected, "got", string(buf[:n]))
+			return
+		}
+	}
+}
+
+func TestWakeDetection(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	gaps := make(chan time.Duration, 1)
+	conn, err := DialMQTT("mqtt+memory://TestWakeDetection/t", WithClock(clock), WithWakeDetection(time.Second, func(conn *MQTTConn, gap time.Duration) {
+		gaps <- gap
+	}))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	for clock.Timers() == 0 {
+		runtime.Gosched()
+	}
+	clock.Advance(time.Second)
+	for clock.Timers() == 0 {
+		runtime.Gosched()
+	}
+	select {
+	case gap := <-gaps:
+		t.Error("expected no wake on schedule, got gap", gap)
+		return
+	default:
+	}
+	// the device sleeps for an hour
+	clock.Advance(time.Hour)
+	if gap := <-gaps; gap < time.Hour {
+		t.Error("expected a gap of an hour, got", gap)
+		return
+	}
+	if !conn.Client.IsConnected() {
+		t.Error("expected the conn to reconnect")
+	}
+}
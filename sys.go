@@ -0,0 +1,82 @@
+package mqttconn
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// brokerStatsQuiet is how long BrokerStats waits for more $SYS messages before returning
+const brokerStatsQuiet = 500 * time.Millisecond
+
+// BrokerStats is a snapshot of the standard $SYS counters of a broker
+// counters the broker doesn't publish are left zero, every value received is kept in Raw
+type BrokerStats struct {
+	Version          string
+	Uptime           time.Duration
+	ClientsConnected int64
+	ClientsTotal     int64
+	Subscriptions    int64
+	MessagesReceived int64
+	MessagesSent     int64
+	// MessagesReceivedPerSecond and MessagesSentPerSecond are the one minute load averages
+	MessagesReceivedPerSecond float64
+	MessagesSentPerSecond     float64
+	// Raw maps each $SYS topic to its value
+	Raw map[string]string
+}
+
+// BrokerStats subscribes to $SYS/#, collects the counters until the broker stops sending them
+// and unsubscribes. Brokers not allowing access to $SYS either reject the subscription,
+// returning a *ReasonCodeError, or send nothing, in which case ctx.Err() is returned once ctx is done
+func (conn *MQTTConn) BrokerStats(ctx context.Context) (*BrokerStats, error) {
+	sub, err := conn.subscribeQueue("$SYS/#", 0)
+	if err != nil {
+		return nil, err
+	}
+	defer sub.close()
+
+	stats := &BrokerStats{Raw: make(map[string]string)}
+	var deadline time.Time
+	for {
+		msg, err := sub.queue.next(ctx, deadline, true)
+		if err != nil {
+			if len(stats.Raw) > 0 {
+				break
+			}
+			return nil, err
+		}
+		stats.Raw[msg.Topic] = string(msg.Payload)
+		deadline = conn.clock.Now().Add(brokerStatsQuiet)
+	}
+	stats.parse()
+	return stats, nil
+}
+
+// parse fills the typed fields from Raw, using mosquitto's topic names,
+// which most brokers publishing $SYS follow
+func (stats *BrokerStats) parse() {
+	integer := func(topics ...string) int64 {
+		for _, topic := range topics {
+			if value, ok := stats.Raw[topic]; ok {
+				n, _ := strconv.ParseInt(strings.Fields(value + " 0")[0], 10, 64)
+				return n
+			}
+		}
+		return 0
+	}
+	perMinute := func(topic string) float64 {
+		n, _ := strconv.ParseFloat(strings.TrimSpace(stats.Raw[topic]), 64)
+		return n / 60
+	}
+	stats.Version = stats.Raw["$SYS/broker/version"]
+	stats.Uptime = time.Duration(integer("$SYS/broker/uptime")) * time.Second
+	stats.ClientsConnected = integer("$SYS/broker/clients/connected", "$SYS/broker/clients/active")
+	stats.ClientsTotal = integer("$SYS/broker/clients/total")
+	stats.Subscriptions = integer("$SYS/broker/subscriptions/count")
+	stats.MessagesReceived = integer("$SYS/broker/messages/received")
+	stats.MessagesSent = integer("$SYS/broker/messages/sent")
+	stats.MessagesReceivedPerSecond = perMinute("$SYS/broker/load/messages/received/1min")
+	stats.MessagesSentPerSecond = perMinute("$SYS/broker/load/messages/sent/1min")
+}
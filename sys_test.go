@@ -0,0 +1,39 @@
+package mqttconn
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBrokerStats(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestBrokerStats")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	for topic, value := range map[string]string{
+		"$SYS/broker/uptime":                      "3600 seconds",
+		"$SYS/broker/clients/connected":           "12",
+		"$SYS/broker/load/messages/received/1min": "120.0",
+		"$SYS/broker/version":                     "mosquitto version 2.0.18",
+	} {
+		conn.Client.Publish(topic, 0, true, value).Wait()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stats, err := conn.BrokerStats(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if stats.Uptime != time.Hour || stats.ClientsConnected != 12 || stats.MessagesReceivedPerSecond != 2 {
+		t.Error("unexpected stats", stats)
+		return
+	}
+	if stats.Version != "mosquitto version 2.0.18" {
+		t.Error("unexpected version", stats.Version)
+		return
+	}
+}
@@ -0,0 +1,123 @@
+package mqttconn
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// TopicTemplate is a topic with {name} parameters, like site/{site}/dev/{id}/cmd, see ParseTopicTemplate
+type TopicTemplate struct {
+	template string
+	// literals surround the parameters, there is one more literal than there are parameters
+	literals []string
+	params   []string
+}
+
+// ParseTopicTemplate compiles template, parameter names are letters, digits, - and _
+// a parameter may share its level with literal text, like dev-{id}, but the literal text can't have wildcards
+func ParseTopicTemplate(template string) (*TopicTemplate, error) {
+	compiled := &TopicTemplate{template: template}
+	rest := template
+	for {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			break
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, errors.Wrap(ErrTopicInvalid, "unclosed template parameter")
+		}
+		name := rest[open+1 : open+end]
+		if !validParamName(name) {
+			return nil, errors.Wrap(ErrTopicInvalid, "invalid template parameter {"+name+"}")
+		}
+		compiled.literals = append(compiled.literals, rest[:open])
+		compiled.params = append(compiled.params, name)
+		rest = rest[open+end+1:]
+	}
+	compiled.literals = append(compiled.literals, rest)
+	for _, literal := range compiled.literals {
+		if strings.ContainsAny(literal, "}+#") {
+			return nil, errors.Wrap(ErrTopicInvalid, "invalid template "+template)
+		}
+	}
+	return compiled, nil
+}
+
+func validParamName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the template as parsed
+func (template *TopicTemplate) String() string {
+	return template.template
+}
+
+// Expand returns the topic with the parameters replaced by params, which must have a value for every parameter
+// and nothing else. Values can't be empty or contain /, + or #, so each fills exactly the place of its parameter
+func (template *TopicTemplate) Expand(params map[string]string) (string, error) {
+	var topic strings.Builder
+	for i, literal := range template.literals {
+		topic.WriteString(literal)
+		if i == len(template.params) {
+			break
+		}
+		name := template.params[i]
+		value, ok := params[name]
+		if !ok {
+			return "", errors.Wrap(ErrTopicInvalid, "missing template parameter "+name)
+		}
+		if value == "" || strings.ContainsAny(value, "/+#") {
+			return "", errors.Wrap(ErrTopicInvalid, "invalid value of template parameter "+name)
+		}
+		topic.WriteString(value)
+	}
+	for name := range params {
+		if !template.has(name) {
+			return "", errors.Wrap(ErrTopicInvalid, "unknown template parameter "+name)
+		}
+	}
+	if err := validateTopic(topic.String()); err != nil {
+		return "", err
+	}
+	return topic.String(), nil
+}
+
+// has reports whether the template has a parameter called name
+func (template *TopicTemplate) has(name string) bool {
+	for _, param := range template.params {
+		if param == name {
+			return true
+		}
+	}
+	return false
+}
+
+// WithTopicTemplate sets the topic template WriteTemplate publishes to
+func WithTopicTemplate(template *TopicTemplate) Option {
+	return func(conn *MQTTConn) {
+		conn.topicTemplate = template
+	}
+}
+
+// WriteTemplate publishes payload like WriteTo, to the topic template of WithTopicTemplate expanded with params
+// it fails with ErrNoTopicTemplate without a template
+func (conn *MQTTConn) WriteTemplate(params map[string]string, payload []byte) (int, error) {
+	if conn.topicTemplate == nil {
+		return 0, ErrNoTopicTemplate
+	}
+	topic, err := conn.topicTemplate.Expand(params)
+	if err != nil {
+		return 0, err
+	}
+	return conn.WriteTo(payload, TopicAddr(topic))
+}
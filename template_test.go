@@ -0,0 +1,75 @@
+package mqttconn
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTopicTemplate(t *testing.T) {
+	template, err := ParseTopicTemplate("site/{site}/dev-{id}/cmd")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	topic, err := template.Expand(map[string]string{"site": "berlin", "id": "7"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if topic != "site/berlin/dev-7/cmd" {
+		t.Error("unexpected topic", topic)
+		return
+	}
+	for _, params := range []map[string]string{
+		{"site": "berlin"},
+		{"site": "berlin", "id": "7", "extra": "x"},
+		{"site": "a/b", "id": "7"},
+		{"site": "+", "id": "7"},
+		{"site": "", "id": "7"},
+	} {
+		if _, err := template.Expand(params); !errors.Is(err, ErrTopicInvalid) {
+			t.Error("expected ErrTopicInvalid for", params, "got", err)
+			return
+		}
+	}
+	for _, invalid := range []string{"site/{site", "site/{}", "site/{a b}", "site/+/{id}", "site/}"} {
+		if _, err := ParseTopicTemplate(invalid); !errors.Is(err, ErrTopicInvalid) {
+			t.Error("expected ErrTopicInvalid for", invalid, "got", err)
+			return
+		}
+	}
+}
+
+func TestWriteTemplate(t *testing.T) {
+	template, err := ParseTopicTemplate("dev/{id}/cmd")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn, err := DialMQTT("mqtt+memory://TestWriteTemplate", WithTopicTemplate(template))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Subscribe("dev/+/cmd", 0); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := conn.WriteTemplate(map[string]string{"id": "42"}, []byte("reboot")); err != nil {
+		t.Error(err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	msg, err := conn.ReadMsg(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if msg.Topic != "dev/42/cmd" || string(msg.Payload) != "reboot" {
+		t.Error("unexpected message", string(msg.Payload), "on", msg.Topic)
+	}
+}
@@ -0,0 +1,109 @@
+package tiny
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// fakeBroker serves one client on conn, sending publishes back if the client subscribed to the topic
+// the user "bad" is refused with return code 4
+func fakeBroker(conn net.Conn) {
+	defer conn.Close()
+	broker := &Conn{transport: conn}
+	subscribed := make(map[string]bool)
+	for {
+		header, body, err := readPacket(conn, 1<<20)
+		if err != nil {
+			return
+		}
+		switch header & 0xf0 {
+		case packetConnect:
+			code := byte(0)
+			if len(body) > 10 && body[7]&0x80 != 0 {
+				clientIDLength := int(binary.BigEndian.Uint16(body[10:]))
+				username := body[12+clientIDLength+2:]
+				if string(username[:3]) == "bad" {
+					code = 4
+				}
+			}
+			broker.writePacket(packetConnack, []byte{0, code}, time.Time{})
+		case packetSubscribe & 0xf0:
+			topicLength := int(binary.BigEndian.Uint16(body[2:]))
+			subscribed[string(body[4:4+topicLength])] = true
+			broker.writePacket(packetSuback, append(body[:2:2], body[4+topicLength]), time.Time{})
+		case packetPublish:
+			topicLength := int(binary.BigEndian.Uint16(body))
+			if subscribed[string(body[2:2+topicLength])] {
+				// forwarded with QoS 1, so the client has to acknowledge
+				forward := append(append([]byte{}, body[:2+topicLength]...), 0, 1)
+				broker.writePacket(packetPublish|0x02, append(forward, body[2+topicLength:]...), time.Time{})
+			}
+		case packetPingreq:
+			broker.writePacket(packetPingresp, nil, time.Time{})
+		case packetDisconnect:
+			return
+		}
+	}
+}
+
+func TestConn(t *testing.T) {
+	client, server := net.Pipe()
+	go fakeBroker(server)
+	conn, err := Connect(client, Config{Topic: "tiny/test", QoS: 1})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("hello"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 16)
+	n, addr, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(buf[:n]) != "hello" || addr.String() != "tiny/test" {
+		t.Error("unexpected message", string(buf[:n]), "from", addr)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, _, err = conn.ReadFrom(buf)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Error("expected a timeout, got", err)
+	}
+}
+
+func TestConnRefused(t *testing.T) {
+	client, server := net.Pipe()
+	go fakeBroker(server)
+	_, err := Connect(client, Config{Username: "bad", Password: "password"})
+	if err != ConnectError(4) {
+		t.Error("expected bad user name or password, got", err)
+	}
+}
+
+func TestConnClosed(t *testing.T) {
+	client, server := net.Pipe()
+	go fakeBroker(server)
+	conn, err := Connect(client, Config{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.Close()
+	if _, err = conn.WriteTo([]byte("hello"), Addr("tiny/test")); err != ErrClosed {
+		t.Error("expected ErrClosed, got", err)
+	}
+	if _, err = conn.Write([]byte("hello")); err != ErrNoDefaultTopic {
+		t.Error("expected ErrNoDefaultTopic, got", err)
+	}
+}
@@ -0,0 +1,44 @@
+package mqttconn
+
+import (
+	"crypto/tls"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// WithTLSConfig sets the TLS configuration of mqtts:// and wss:// connections, config is cloned
+func WithTLSConfig(config *tls.Config) Option {
+	return func(conn *MQTTConn) {
+		conn.clientOptions = append(conn.clientOptions, func(opts *mqtt.ClientOptions) {
+			opts.SetTLSConfig(config.Clone())
+		})
+	}
+}
+
+// WithServerName verifies the broker certificate for name and sends it with SNI, instead of the host of the url
+// this is needed when dialing by IP address or through a tunnel
+func WithServerName(name string) Option {
+	return func(conn *MQTTConn) {
+		conn.clientOptions = append(conn.clientOptions, func(opts *mqtt.ClientOptions) {
+			clientTLSConfig(opts).ServerName = name
+		})
+	}
+}
+
+// WithALPN offers the application protocols protocols during the TLS handshake,
+// for brokers sharing port 443 with other services, "mqtt" is the protocol name registered for MQTT
+func WithALPN(protocols ...string) Option {
+	return func(conn *MQTTConn) {
+		conn.clientOptions = append(conn.clientOptions, func(opts *mqtt.ClientOptions) {
+			clientTLSConfig(opts).NextProtos = protocols
+		})
+	}
+}
+
+// clientTLSConfig returns the TLS configuration of opts, creating one if needed
+func clientTLSConfig(opts *mqtt.ClientOptions) *tls.Config {
+	if opts.TLSConfig == nil {
+		opts.SetTLSConfig(&tls.Config{})
+	}
+	return opts.TLSConfig
+}
@@ -0,0 +1,26 @@
+package mqttconn
+
+import (
+	"crypto/tls"
+	"testing"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestTLSOptions(t *testing.T) {
+	base := &tls.Config{MinVersion: tls.VersionTLS12}
+	conn := newMQTTConn([]Option{WithTLSConfig(base), WithServerName("broker.example.com"), WithALPN("mqtt")})
+	opts := mqtt.NewClientOptions()
+	for _, configure := range conn.clientOptions {
+		configure(opts)
+	}
+	config := opts.TLSConfig
+	if config.ServerName != "broker.example.com" || len(config.NextProtos) != 1 || config.MinVersion != tls.VersionTLS12 {
+		t.Error("unexpected TLS config", config.ServerName, config.NextProtos, config.MinVersion)
+		return
+	}
+	if base.ServerName != "" {
+		t.Error("expected the base config to be left alone")
+		return
+	}
+}
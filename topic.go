@@ -0,0 +1,77 @@
+package mqttconn
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// MatchTopic reports if topic matches filter, which may contain + and # wildcards, following the MQTT rules
+func MatchTopic(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	// wildcards at the first level don't match system topics like $SYS
+	if strings.HasPrefix(topic, "$") && (filterLevels[0] == "+" || filterLevels[0] == "#") {
+		return false
+	}
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
+
+// maxPacketSize is the largest remaining length of a MQTT packet
+const maxPacketSize = 268435455
+
+// maxPayloadSize returns the largest payload that can be published to topic
+// packetSize limits the whole packet, zero means the protocol limit
+func maxPayloadSize(packetSize int, topic string) int {
+	remaining := maxPacketSize
+	if packetSize > 0 {
+		// packet type and remaining length, which takes one byte per 7 bits
+		for n := 1; n <= 4; n++ {
+			remaining = packetSize - 1 - n
+			if remaining < 1<<(7*n) {
+				break
+			}
+		}
+		if remaining > maxPacketSize {
+			remaining = maxPacketSize
+		}
+	}
+	// topic length prefix, topic and packet identifier
+	return remaining - 2 - len(topic) - 2
+}
+
+// validateTopic checks a topic name used for publishing
+// empty topics are left for the broker to reject, for compatibility
+func validateTopic(topic string) error {
+	if len(topic) > 65535 || strings.ContainsAny(topic, "+#\x00") || !utf8.ValidString(topic) {
+		return ErrTopicInvalid
+	}
+	return nil
+}
+
+// validateFilter checks a topic filter used for subscribing
+func validateFilter(filter string) error {
+	if filter == "" || len(filter) > 65535 || strings.ContainsRune(filter, 0) || !utf8.ValidString(filter) {
+		return ErrTopicInvalid
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.Contains(level, "#") && (level != "#" || i != len(levels)-1) {
+			return ErrTopicInvalid
+		}
+		if strings.Contains(level, "+") && level != "+" {
+			return ErrTopicInvalid
+		}
+	}
+	return nil
+}
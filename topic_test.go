@@ -0,0 +1,79 @@
+package mqttconn
+
+import (
+	"testing"
+)
+
+func TestMatchTopic(t *testing.T) {
+	cases := []struct {
+		filter  string
+		topic   string
+		matches bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/+/c", "a/b/c", true},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"#", "a/b", true},
+		{"+/b", "a/b", true},
+		{"#", "$SYS/uptime", false},
+		{"$SYS/#", "$SYS/uptime", true},
+		{"a/b/c", "a/b", false},
+	}
+	for _, c := range cases {
+		if MatchTopic(c.filter, c.topic) != c.matches {
+			t.Error("expected MatchTopic", c.filter, c.topic, "to be", c.matches)
+		}
+	}
+}
+
+func TestValidateTopic(t *testing.T) {
+	for _, topic := range []string{"a/+", "a/#", "a\x00b"} {
+		if validateTopic(topic) != ErrTopicInvalid {
+			t.Error("expected topic", topic, "to be invalid")
+		}
+	}
+	for _, filter := range []string{"", "a/#/b", "a/b#", "a+/b"} {
+		if validateFilter(filter) != ErrTopicInvalid {
+			t.Error("expected filter", filter, "to be invalid")
+		}
+	}
+	for _, filter := range []string{"a/b", "a/+/b", "#", "+/#"} {
+		if validateFilter(filter) != nil {
+			t.Error("expected filter", filter, "to be valid")
+		}
+	}
+}
+
+func TestMaxPayloadSize(t *testing.T) {
+	cases := []struct {
+		packetSize int
+		expected   int
+	}{
+		{0, 268435455 - 7},
+		{100, 100 - 2 - 7},
+		{128, 128 - 2 - 7},
+		{130, 130 - 3 - 7},
+		{1 << 30, 268435455 - 7},
+	}
+	for _, c := range cases {
+		if size := maxPayloadSize(c.packetSize, "a/b"); size != c.expected {
+			t.Error("packet size", c.packetSize, "expected", c.expected, "got", size)
+			return
+		}
+	}
+	conn := newMQTTConn([]Option{WithMaxPacketSize(100)})
+	conn.Client = &publishRecorder{}
+	conn.SetDefaultTopic("a/b")
+	if _, err := conn.Write(make([]byte, conn.MaxPayloadSize()+1)); err != ErrPayloadTooLarge {
+		t.Error("expected ErrPayloadTooLarge, got", err)
+		return
+	}
+	if _, err := conn.Write(make([]byte, conn.MaxPayloadSize())); err != nil {
+		t.Error(err)
+		return
+	}
+}
@@ -0,0 +1,17 @@
+module github.com/gyf304/go-mqttconn/vault
+
+go 1.24.0
+
+require github.com/gyf304/go-mqttconn v0.0.0
+
+require (
+	github.com/eclipse/paho.golang v0.23.0 // indirect
+	github.com/eclipse/paho.mqtt.golang v1.5.1 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/gorilla/websocket v1.5.3 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	golang.org/x/net v0.44.0 // indirect
+	golang.org/x/sync v0.17.0 // indirect
+)
+
+replace github.com/gyf304/go-mqttconn => ../
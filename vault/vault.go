@@ -0,0 +1,186 @@
+// Package vault fetches mqttconn credentials from HashiCorp Vault
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	mqttconn "github.com/gyf304/go-mqttconn"
+)
+
+// Provider reads a username and password from a Vault secret, for mqttconn.WithCredentialsProvider
+// it works with dynamic secrets engines and with KV version 1 and 2. The credentials are cached
+// for the lease duration of the secret, once it runs out the conn reconnects with a fresh secret
+type Provider struct {
+	// Address of the Vault server, e.g. https://vault:8200
+	Address string
+	// Token authenticates with Vault
+	Token string
+	// Path of the secret, e.g. database/creds/mqtt or secret/data/mqtt
+	Path string
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
+
+	mu     sync.Mutex
+	cached mqttconn.Credentials
+}
+
+var _ mqttconn.CredentialsProvider = (*Provider)(nil)
+
+// Credentials implements mqttconn.CredentialsProvider
+func (provider *Provider) Credentials(ctx context.Context) (mqttconn.Credentials, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	if provider.cached.Username != "" && (provider.cached.Expiry.IsZero() || time.Now().Before(provider.cached.Expiry)) {
+		return provider.cached, nil
+	}
+	var secret struct {
+		LeaseDuration int64 `json:"lease_duration"`
+		Data          struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+			// Data is set by KV version 2
+			Data *struct {
+				Username string `json:"username"`
+				Password string `json:"password"`
+			} `json:"data"`
+		} `json:"data"`
+	}
+	fetched := time.Now()
+	if err := request(ctx, provider.HTTPClient, http.MethodGet, provider.Address, provider.Token, provider.Path, nil, &secret); err != nil {
+		return mqttconn.Credentials{}, err
+	}
+	credentials := mqttconn.Credentials{Username: secret.Data.Username, Password: secret.Data.Password}
+	if secret.Data.Data != nil {
+		credentials = mqttconn.Credentials{Username: secret.Data.Data.Username, Password: secret.Data.Data.Password}
+	}
+	if credentials.Username == "" {
+		return mqttconn.Credentials{}, fmt.Errorf("vault: no username in %s", provider.Path)
+	}
+	if secret.LeaseDuration > 0 {
+		credentials.Expiry = fetched.Add(time.Duration(secret.LeaseDuration) * time.Second)
+	}
+	provider.cached = credentials
+	return credentials, nil
+}
+
+// CertificateProvider issues client certificates from a Vault PKI secrets engine
+// certificates are cached until they expire, pass GetClientCertificate to mqttconn.WithTLSConfig.
+// Like every TLS setting, a new certificate is only presented from the next connect on
+type CertificateProvider struct {
+	// Address of the Vault server, e.g. https://vault:8200
+	Address string
+	// Token authenticates with Vault
+	Token string
+	// Path of the PKI issue endpoint, e.g. pki/issue/mqtt
+	Path string
+	// CommonName of the issued certificates
+	CommonName string
+	// TTL requested for the certificates, the role default if zero
+	TTL time.Duration
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
+
+	mu     sync.Mutex
+	cached *tls.Certificate
+	expiry time.Time
+}
+
+// TLSConfig returns a tls.Config presenting the issued certificates, for mqttconn.WithTLSConfig
+func (provider *CertificateProvider) TLSConfig() *tls.Config {
+	return &tls.Config{GetClientCertificate: provider.GetClientCertificate}
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate
+func (provider *CertificateProvider) GetClientCertificate(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	ctx := context.Background()
+	if info != nil {
+		ctx = info.Context()
+	}
+	return provider.Certificate(ctx)
+}
+
+// Certificate returns the cached certificate, or issues a new one if it expired
+func (provider *CertificateProvider) Certificate(ctx context.Context) (*tls.Certificate, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	if provider.cached != nil && time.Now().Before(provider.expiry) {
+		return provider.cached, nil
+	}
+	body := map[string]string{"common_name": provider.CommonName}
+	if provider.TTL > 0 {
+		body["ttl"] = provider.TTL.String()
+	}
+	var secret struct {
+		Data struct {
+			Certificate string   `json:"certificate"`
+			CAChain     []string `json:"ca_chain"`
+			PrivateKey  string   `json:"private_key"`
+			Expiration  int64    `json:"expiration"`
+		} `json:"data"`
+	}
+	if err := request(ctx, provider.HTTPClient, http.MethodPost, provider.Address, provider.Token, provider.Path, body, &secret); err != nil {
+		return nil, err
+	}
+	chain := strings.Join(append([]string{secret.Data.Certificate}, secret.Data.CAChain...), "\n")
+	certificate, err := tls.X509KeyPair([]byte(chain), []byte(secret.Data.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+	provider.cached = &certificate
+	provider.expiry = time.Unix(secret.Data.Expiration, 0)
+	return provider.cached, nil
+}
+
+// request calls the Vault HTTP API and decodes the response into out
+func request(ctx context.Context, client *http.Client, method, address, token, path string, body interface{}, out interface{}) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	var reader *bytes.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	url := strings.TrimSuffix(address, "/") + "/v1/" + strings.TrimPrefix(path, "/")
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Errors []string `json:"errors"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		if len(failure.Errors) > 0 {
+			return fmt.Errorf("vault: %s: %s", resp.Status, strings.Join(failure.Errors, ", "))
+		}
+		return fmt.Errorf("vault: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return errors.New("vault: invalid response: " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package vault
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProvider(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" || r.URL.Path != "/v1/database/creds/mqtt" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		atomic.AddInt32(&calls, 1)
+		w.Write([]byte(`{"lease_duration":3600,"data":{"username":"user","password":"secret"}}`))
+	}))
+	defer server.Close()
+
+	provider := &Provider{Address: server.URL, Token: "token", Path: "database/creds/mqtt"}
+	for i := 0; i < 2; i++ {
+		credentials, err := provider.Credentials(context.Background())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if credentials.Username != "user" || credentials.Password != "secret" {
+			t.Error("unexpected credentials", credentials)
+			return
+		}
+		if time.Until(credentials.Expiry) < 59*time.Minute {
+			t.Error("expected the lease duration as expiry, got", credentials.Expiry)
+			return
+		}
+	}
+	if calls != 1 {
+		t.Error("expected cached credentials, got", calls, "requests")
+		return
+	}
+
+	denied := &Provider{Address: server.URL, Token: "wrong", Path: "database/creds/mqtt"}
+	if _, err := denied.Credentials(context.Background()); err == nil {
+		t.Error("expected permission denied")
+	}
+}
+
+func TestProviderKV2(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"lease_duration":0,"data":{"data":{"username":"user","password":"secret"},"metadata":{}}}`))
+	}))
+	defer server.Close()
+
+	provider := &Provider{Address: server.URL, Token: "token", Path: "secret/data/mqtt"}
+	credentials, err := provider.Credentials(context.Background())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if credentials.Username != "user" || credentials.Password != "secret" || !credentials.Expiry.IsZero() {
+		t.Error("unexpected credentials", credentials)
+	}
+}
+
+func TestCertificateProvider(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotAfter:     time.Now().Add(time.Hour),
+	}, &x509.Certificate{SerialNumber: big.NewInt(1)}, &key.PublicKey, key)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.Method != http.MethodPost || body["common_name"] != "client" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		atomic.AddInt32(&calls, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+			"certificate": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+			"private_key": string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+			"expiration":  time.Now().Add(time.Hour).Unix(),
+		}})
+	}))
+	defer server.Close()
+
+	provider := &CertificateProvider{Address: server.URL, Token: "token", Path: "pki/issue/mqtt", CommonName: "client"}
+	for i := 0; i < 2; i++ {
+		certificate, err := provider.GetClientCertificate(nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(certificate.Certificate) != 1 {
+			t.Error("expected the issued certificate")
+			return
+		}
+	}
+	if calls != 1 {
+		t.Error("expected a cached certificate, got", calls, "requests")
+	}
+}
@@ -0,0 +1,104 @@
+package mqttconn
+
+import (
+	"net"
+	"time"
+)
+
+// View writes through a MQTTConn with defaults of its own, it is made by WithDefaults
+// reads, subscriptions and deadlines are those of the conn, so are ReadFrom and Close: closing a view closes the conn
+type View struct {
+	conn     *MQTTConn
+	defaults writeDefaults
+}
+
+var _ net.PacketConn = (*View)(nil)
+
+// WithDefaults returns a view of conn whose Write publishes to topic with qos and retain,
+// sharing the client and the subscriptions of conn. The defaults of conn are left unchanged,
+// the view takes the content type and headers conn has at the time
+func (conn *MQTTConn) WithDefaults(topic string, qos int, retain bool) *View {
+	defaults := conn.defaults()
+	defaults.topic, defaults.topicSet = topic, topic != ""
+	defaults.qos, defaults.retain = qos, retain
+	return &View{conn: conn, defaults: defaults}
+}
+
+// WithDefaults returns another view of the same conn
+func (view *View) WithDefaults(topic string, qos int, retain bool) *View {
+	return view.conn.WithDefaults(topic, qos, retain)
+}
+
+// Conn returns the conn of the view
+func (view *View) Conn() *MQTTConn {
+	return view.conn
+}
+
+// MaxPayloadSize is MQTTConn.MaxPayloadSize for the topic of the view
+func (view *View) MaxPayloadSize() int {
+	return view.conn.payloadLimit(view.defaults.topic)
+}
+
+// Write publishes p to the topic of the view
+func (view *View) Write(p []byte) (int, error) {
+	addr, err := view.conn.defaultAddr(view.defaults)
+	if err != nil {
+		return 0, err
+	}
+	return view.conn.writeTo(p, addr, view.defaults)
+}
+
+// WriteTo implements net.PacketConn.WriteTo with the QoS and retain flag of the view
+func (view *View) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return view.conn.writeTo(b, addr, view.defaults)
+}
+
+// WriteToVec is MQTTConn.WriteToVec with the QoS and retain flag of the view
+func (view *View) WriteToVec(bufs [][]byte, addr net.Addr) (int, error) {
+	return view.conn.writeToVec(bufs, addr, view.defaults)
+}
+
+// WriteBatch is MQTTConn.WriteBatch, messages without a Topic go to the topic of the view
+func (view *View) WriteBatch(msgs []Message) (int, error) {
+	return view.conn.writeBatch(msgs, view.defaults)
+}
+
+// Read implements net.PacketConn.Read, reading from the conn
+func (view *View) Read(p []byte) (int, error) {
+	return view.conn.Read(p)
+}
+
+// ReadFrom implements net.PacketConn.ReadFrom, reading from the conn
+func (view *View) ReadFrom(p []byte) (int, net.Addr, error) {
+	return view.conn.ReadFrom(p)
+}
+
+// Close implements net.PacketConn.Close, closing the conn
+func (view *View) Close() error {
+	return view.conn.Close()
+}
+
+// LocalAddr implements net.PacketConn.LocalAddr
+func (view *View) LocalAddr() net.Addr {
+	return view.conn.LocalAddr()
+}
+
+// RemoteAddr returns the topic of the view
+func (view *View) RemoteAddr() net.Addr {
+	return TopicAddr(view.defaults.topic)
+}
+
+// SetDeadline implements net.PacketConn.SetDeadline, setting the deadlines of the conn
+func (view *View) SetDeadline(t time.Time) error {
+	return view.conn.SetDeadline(t)
+}
+
+// SetReadDeadline implements net.PacketConn.SetReadDeadline, setting the read deadline of the conn
+func (view *View) SetReadDeadline(t time.Time) error {
+	return view.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline implements net.PacketConn.SetWriteDeadline, setting the write deadline of the conn
+func (view *View) SetWriteDeadline(t time.Time) error {
+	return view.conn.SetWriteDeadline(t)
+}
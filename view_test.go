@@ -0,0 +1,55 @@
+package mqttconn
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWithDefaults(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestWithDefaults/a")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Subscribe("b", 0); err != nil {
+		t.Error(err)
+		return
+	}
+	view := conn.WithDefaults("b", 1, true)
+	if _, err := view.Write([]byte("to b")); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := conn.Write([]byte("to a")); err != nil {
+		t.Error(err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, expected := range []struct{ topic, payload string }{{"b", "to b"}, {"a", "to a"}} {
+		msg, err := conn.ReadMsg(ctx)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if msg.Topic != expected.topic || string(msg.Payload) != expected.payload {
+			t.Error("expected", expected.payload, "on", expected.topic, "got", string(msg.Payload), "on", msg.Topic)
+			return
+		}
+	}
+	if view.RemoteAddr().String() != "b" || conn.RemoteAddr().String() != "a" {
+		t.Error("expected the view to keep its own default topic")
+		return
+	}
+	// the retain flag of the view is used, a later subscriber gets the message
+	payload, err := conn.ReadRetained(ctx, "b")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(payload) != "to b" {
+		t.Error("expected a retained message on b, got", string(payload))
+	}
+}
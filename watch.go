@@ -0,0 +1,73 @@
+package mqttconn
+
+import (
+	"bytes"
+	"context"
+	"time"
+)
+
+// WatchEvent is a change of a topic seen by Watch
+type WatchEvent struct {
+	Topic   string
+	Payload []byte
+	// Deleted is set when the retained message of the topic was removed, Payload is empty then
+	Deleted bool
+}
+
+// Watch returns the retained state of the topics matching filter, like SnapshotRetained, and a channel
+// receiving the changes made after it. The channel is closed once ctx is done or the conn is closed,
+// which also ends the subscription. Messages repeating the current payload of a topic, such as retained
+// messages sent again after a reconnect, are left out
+func (conn *MQTTConn) Watch(ctx context.Context, filter string) (map[string][]byte, <-chan WatchEvent, error) {
+	if err := validateFilter(filter); err != nil {
+		return nil, nil, err
+	}
+	sub, err := conn.subscribeQueue(filter, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+	current := make(map[string][]byte)
+	// live messages arriving among the retained ones are applied too, the snapshot is the state at its end
+	err = conn.loadRetained(ctx, sub, func(msg *Message) {
+		if len(msg.Payload) == 0 {
+			delete(current, msg.Topic)
+		} else {
+			current[msg.Topic] = msg.Payload
+		}
+	})
+	if err != nil {
+		sub.close()
+		return nil, nil, err
+	}
+	snapshot := make(map[string][]byte, len(current))
+	for topic, payload := range current {
+		snapshot[topic] = payload
+	}
+	events := make(chan WatchEvent, 16)
+	conn.goLabeled(func() {
+		defer close(events)
+		defer sub.close()
+		for {
+			msg, err := sub.queue.next(ctx, time.Time{}, true)
+			if err != nil {
+				return
+			}
+			previous, existed := current[msg.Topic]
+			deleted := len(msg.Payload) == 0
+			if !existed && deleted || existed && !deleted && bytes.Equal(previous, msg.Payload) {
+				continue
+			}
+			if deleted {
+				delete(current, msg.Topic)
+			} else {
+				current[msg.Topic] = msg.Payload
+			}
+			select {
+			case events <- WatchEvent{Topic: msg.Topic, Payload: msg.Payload, Deleted: deleted}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+	return snapshot, events, nil
+}
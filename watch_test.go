@@ -0,0 +1,41 @@
+package mqttconn
+
+import (
+	"context"
+	"testing"
+)
+
+func TestWatch(t *testing.T) {
+	conn, err := DialMQTT("mqtt+memory://TestWatch")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	conn.Client.Publish("lights/a", 1, true, "on").Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	snapshot, events, err := conn.Watch(ctx, "lights/+")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(snapshot) != 1 || string(snapshot["lights/a"]) != "on" {
+		t.Error("unexpected snapshot", snapshot)
+		return
+	}
+	// repeating the current state is not a change
+	conn.Client.Publish("lights/a", 1, true, "on").Wait()
+	conn.Client.Publish("lights/b", 1, true, "off").Wait()
+	conn.Client.Publish("lights/a", 1, true, "").Wait()
+	for _, expected := range []WatchEvent{{Topic: "lights/b", Payload: []byte("off")}, {Topic: "lights/a", Payload: []byte{}, Deleted: true}} {
+		event := <-events
+		if event.Topic != expected.Topic || string(event.Payload) != string(expected.Payload) || event.Deleted != expected.Deleted {
+			t.Error("expected", expected, "got", event)
+			return
+		}
+	}
+	cancel()
+	for range events {
+	}
+}
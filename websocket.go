@@ -0,0 +1,9 @@
+package mqttconn
+
+import (
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// openWebSocket replaces paho's websocket dialer on platforms where it can't work,
+// see websocket_js.go. nil means paho's own dialer is used
+var openWebSocket mqtt.OpenConnectionFunc
@@ -0,0 +1,192 @@
+//go:build js && wasm
+
+package mqttconn
+
+import (
+	"net"
+	"net/url"
+	"sync"
+	"syscall/js"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	openWebSocket = openBrowserWebSocket
+}
+
+// openBrowserWebSocket connects using the browser's WebSocket, since there are no raw sockets in js/wasm
+func openBrowserWebSocket(uri *url.URL, options mqtt.ClientOptions) (net.Conn, error) {
+	conn := &browserWebSocket{
+		addr:    webSocketAddr(uri.String()),
+		changed: make(chan struct{}),
+	}
+	conn.ws = js.Global().Get("WebSocket").New(uri.String(), "mqtt")
+	conn.ws.Set("binaryType", "arraybuffer")
+	conn.listen("open", func(event js.Value) {
+		conn.update(func() { conn.open = true })
+	})
+	conn.listen("message", func(event js.Value) {
+		data := js.Global().Get("Uint8Array").New(event.Get("data"))
+		b := make([]byte, data.Length())
+		js.CopyBytesToGo(b, data)
+		conn.update(func() { conn.buf = append(conn.buf, b...) })
+	})
+	conn.listen("error", func(event js.Value) {
+		conn.update(func() { conn.err = errors.New("websocket error") })
+	})
+	conn.listen("close", func(event js.Value) {
+		conn.update(func() { conn.closed = true })
+	})
+
+	timeout := options.ConnectTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	conn.mu.Lock()
+	for !conn.open {
+		if conn.closed || conn.err != nil {
+			conn.mu.Unlock()
+			conn.Close()
+			return nil, errors.New("websocket connection failed")
+		}
+		changed := conn.changed
+		conn.mu.Unlock()
+		select {
+		case <-changed:
+		case <-timer.C:
+			conn.Close()
+			return nil, errors.New("websocket connection timed out")
+		}
+		conn.mu.Lock()
+	}
+	conn.mu.Unlock()
+	return conn, nil
+}
+
+// browserWebSocket is a net.Conn over a browser WebSocket
+type browserWebSocket struct {
+	ws        js.Value
+	addr      webSocketAddr
+	listeners []js.Func
+
+	mu           sync.Mutex
+	open         bool
+	closed       bool
+	err          error
+	buf          []byte
+	changed      chan struct{}
+	readDeadline time.Time
+}
+
+// listen registers a handler for a WebSocket event
+func (conn *browserWebSocket) listen(event string, handler func(js.Value)) {
+	listener := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		handler(args[0])
+		return nil
+	})
+	conn.listeners = append(conn.listeners, listener)
+	conn.ws.Call("addEventListener", event, listener)
+}
+
+// update changes state under the lock and wakes up waiters
+func (conn *browserWebSocket) update(change func()) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	change()
+	close(conn.changed)
+	conn.changed = make(chan struct{})
+}
+
+func (conn *browserWebSocket) Read(p []byte) (int, error) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	for len(conn.buf) == 0 {
+		if conn.closed || conn.err != nil {
+			return 0, errors.New("websocket closed")
+		}
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !conn.readDeadline.IsZero() {
+			waitTime := conn.readDeadline.Sub(time.Now())
+			if waitTime <= 0 {
+				return 0, &TimeoutError{errors.New("read timed out")}
+			}
+			timer = time.NewTimer(waitTime)
+			timeout = timer.C
+		}
+		changed := conn.changed
+		conn.mu.Unlock()
+		select {
+		case <-changed:
+		case <-timeout:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		conn.mu.Lock()
+	}
+	n := copy(p, conn.buf)
+	conn.buf = conn.buf[n:]
+	return n, nil
+}
+
+func (conn *browserWebSocket) Write(p []byte) (int, error) {
+	conn.mu.Lock()
+	closed := conn.closed
+	conn.mu.Unlock()
+	if closed {
+		return 0, errors.New("websocket closed")
+	}
+	data := js.Global().Get("Uint8Array").New(len(p))
+	js.CopyBytesToJS(data, p)
+	conn.ws.Call("send", data)
+	return len(p), nil
+}
+
+func (conn *browserWebSocket) Close() error {
+	conn.ws.Call("close")
+	conn.update(func() { conn.closed = true })
+	for _, listener := range conn.listeners {
+		listener.Release()
+	}
+	conn.listeners = nil
+	return nil
+}
+
+func (conn *browserWebSocket) LocalAddr() net.Addr {
+	return webSocketAddr("")
+}
+
+func (conn *browserWebSocket) RemoteAddr() net.Addr {
+	return conn.addr
+}
+
+func (conn *browserWebSocket) SetDeadline(t time.Time) error {
+	return conn.SetReadDeadline(t)
+}
+
+func (conn *browserWebSocket) SetReadDeadline(t time.Time) error {
+	conn.update(func() { conn.readDeadline = t })
+	return nil
+}
+
+// SetWriteDeadline is a no-op, WebSocket.send never blocks
+func (conn *browserWebSocket) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// webSocketAddr is the url of a websocket conforming to the net.Addr interface
+type webSocketAddr string
+
+func (addr webSocketAddr) Network() string {
+	return "websocket"
+}
+
+func (addr webSocketAddr) String() string {
+	return string(addr)
+}